@@ -0,0 +1,107 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigName is looked up in the user's home directory when --config is not given
+const defaultConfigName = ".bozobackup.yaml"
+
+// defaultConfigPath returns ~/.bozobackup.yaml, or "" if the home directory is unknown
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, defaultConfigName)
+}
+
+// expandHome replaces a leading "~/" with the user's home directory
+// Config values are not expanded by a shell, so paths like "~/DCIM" need this
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// applyConfigFile fills in flags from a YAML config file whose keys are flag names, e.g.
+//
+//	src: ~/DCIM
+//	dest: ~/backup_photos
+//	incremental: true
+//
+// Flags given on the command line always win. A missing default config file is not an error,
+// but a missing file passed explicitly with --config is
+func applyConfigFile(cmd *cobra.Command, path string, explicit bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil
+		}
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil {
+			// Keys for other subcommands (e.g. "src" while running verify) are fine, typos are not
+			if cmd.Root().Flags().Lookup(key) == nil && !subcommandHasFlag(cmd.Root(), key) {
+				return fmt.Errorf("unknown setting %q in config file %s", key, path)
+			}
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setFlagFromConfig(flag, value); err != nil {
+			return fmt.Errorf("invalid value for %q in config file %s: %w", key, path, err)
+		}
+	}
+	return nil
+}
+
+// subcommandHasFlag reports whether any subcommand defines the named flag
+func subcommandHasFlag(root *cobra.Command, name string) bool {
+	for _, sub := range root.Commands() {
+		if sub.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// setFlagFromConfig sets a flag from a decoded YAML value; lists set repeatable flags once per item
+func setFlagFromConfig(flag *pflag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if err := flag.Value.Set(expandHome(fmt.Sprint(item))); err != nil {
+				return err
+			}
+		}
+		flag.Changed = true
+		return nil
+	}
+	if err := flag.Value.Set(expandHome(fmt.Sprint(value))); err != nil {
+		return err
+	}
+	flag.Changed = true
+	return nil
+}
@@ -0,0 +1,415 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveTarGz stores each date folder as one compressed tarball (--archive tar.gz)
+const ArchiveTarGz = "tar.gz"
+
+// archiveSuffix ends the name of every monthly archive
+const archiveSuffix = ".tar.gz"
+
+// Files stored in an archive are recorded as <date folder>.tar.gz/<name>, e.g.
+// backup/2024-02.tar.gz/IMG_0001.jpg, so the database, reports, and duplicate
+// detection keep working on one path per file
+
+// destPathIn returns where a file named name is stored for a date folder
+func (d *destination) destPathIn(destDateDir, name string) string {
+	if d.archiveFormat == ArchiveTarGz {
+		return filepath.Join(destDateDir+archiveSuffix, name)
+	}
+	return filepath.Join(destDateDir, name)
+}
+
+// splitArchiveMember splits a recorded path into its archive and member name
+// ok is false for loose files. The member name uses forward slashes, as tar entries do
+func splitArchiveMember(path string) (archive, member string, ok bool) {
+	marker := archiveSuffix + string(filepath.Separator)
+	i := strings.LastIndex(path, marker)
+	if i < 0 {
+		return "", "", false
+	}
+	return path[:i+len(archiveSuffix)], filepath.ToSlash(path[i+len(marker):]), true
+}
+
+// destParentDir is the folder that must exist before a file can be stored at dest
+func destParentDir(dest string) string {
+	if archive, _, ok := splitArchiveMember(dest); ok {
+		return filepath.Dir(archive)
+	}
+	return filepath.Dir(dest)
+}
+
+// statDest is fs.Stat that also finds files stored inside an archive
+func (d *destination) statDest(dest string) (os.FileInfo, error) {
+	archive, member, ok := splitArchiveMember(dest)
+	if !ok {
+		return d.fs.Stat(dest)
+	}
+	entry, found, err := d.openTarArchive(archive).member(member)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &os.PathError{Op: "stat", Path: dest, Err: os.ErrNotExist}
+	}
+	return destFileInfo{name: member, size: entry.size, mode: 0644, modTime: entry.modTime}, nil
+}
+
+// storeFile copies src to dest, appending it to its archive when dest is an archive member
+// and converting HEIC photos stored as JPEG. Returns the content hash of src
+// Plain copies are retried (--copy-retries); archive appends are not, as a failed one may be partly written
+// atime is the source's access time from when it was found, before evaluation read it; the copy
+// gets it (zero takes the current one)
+func (r *backupRun) storeFile(ctx context.Context, src, dest, algo string, atime time.Time) (string, error) {
+	if r.isHEICConversion(src, dest) {
+		return r.storeConvertedHEIC(ctx, src, dest, algo, atime)
+	}
+	archive, member, ok := splitArchiveMember(dest)
+	if !ok {
+		return r.retryCopy(ctx, src, func() (string, error) {
+			return r.copyFileWithHash(ctx, src, dest, algo, atime, src)
+		})
+	}
+	return r.openTarArchive(archive).append(ctx, src, member, algo)
+}
+
+// archiveMember is what the index knows about one stored file
+type archiveMember struct {
+	size    int64
+	modTime time.Time
+}
+
+// tarArchive serializes appends to one monthly archive and indexes its members and their hashes
+// Each append is its own gzip member holding tar entries without an end-of-archive marker;
+// concatenated, they read as one .tar.gz with tar, gzip, and every archive manager.
+// The archive is decompressed at most once per run for its names and once per hash algorithm for
+// their hashes; appends add to both indexes
+type tarArchive struct {
+	path string
+	d    *destination // Where the archive is, for --file-mode, --verify-copy, --fsync, and --rate-limit
+	// readMu is held while the archive is decompressed to fill an index, so concurrent lookups
+	// wait for one read instead of each making their own; appends don't wait for it
+	readMu sync.Mutex
+	// mu serializes appends and guards everything below
+	mu       sync.Mutex
+	members  map[string]archiveMember     // nil until load
+	hashes   map[string]map[string]string // algo -> member -> hash, filled by hashMember and append
+	appended int                          // appends started, so a hash read that overlapped one is redone
+}
+
+// openTarArchive returns the shared handle for an archive path
+func (d *destination) openTarArchive(path string) *tarArchive {
+	d.archivesMu.Lock()
+	defer d.archivesMu.Unlock()
+	a, found := d.archives[path]
+	if !found {
+		a = &tarArchive{path: path, d: d}
+		d.archives[path] = a
+	}
+	return a
+}
+
+// member looks up one stored file, reading the archive's names first if this run hasn't yet
+func (a *tarArchive) member(name string) (archiveMember, bool, error) {
+	if err := a.load(); err != nil {
+		return archiveMember{}, false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, found := a.members[name]
+	return entry, found, nil
+}
+
+// load reads the member names of an existing archive once per run; a missing archive is empty
+// The whole archive is decompressed, so the first file looked up in a large month costs a full
+// read. No append can run before the names are loaded, so the read can't overlap one
+func (a *tarArchive) load() error {
+	a.readMu.Lock()
+	defer a.readMu.Unlock()
+	a.mu.Lock()
+	loaded := a.members != nil
+	a.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	members := make(map[string]archiveMember)
+	err := readTarGz(a.path, func(header *tar.Header, _ io.Reader) error {
+		members[header.Name] = archiveMember{size: header.Size, modTime: header.ModTime}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not read archive %s: %w", a.path, err)
+	}
+	a.mu.Lock()
+	a.members = members
+	a.mu.Unlock()
+	return nil
+}
+
+// append adds src to the archive as member, hashing it on the way in
+// A failed or cancelled append is cut off again, so the archive never keeps a partial entry
+func (a *tarArchive) append(ctx context.Context, src, member, algo string) (string, error) {
+	if err := a.load(); err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.appended++
+	if _, taken := a.members[member]; taken {
+		return "", fmt.Errorf("%s already exists in %s", member, a.path)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file %s: %w", src, err)
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source file %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive %s: %w", a.path, err)
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return "", fmt.Errorf("failed to stat archive %s: %w", a.path, err)
+	}
+	start := info.Size()
+	// A new archive gets --file-mode; it holds many sources, so --preserve-owner doesn't apply
+	if start == 0 && a.d.fileMode != 0 {
+		if err := out.Chmod(a.d.fileMode); err != nil {
+			out.Close()
+			return "", fmt.Errorf("failed to set permissions on archive %s: %w", a.path, err)
+		}
+	}
+
+	hash, err := writeTarMember(ctx, out, in, srcInfo, member, algo, a.d.limiter)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err == nil && a.d.verify {
+		err = verifyTarMember(a.path, start, member, hash, algo)
+	}
+	if err != nil {
+		out.Truncate(start)
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to close archive %s: %w", a.path, err)
+	}
+	if start == 0 {
+		if err := a.d.syncDestDir(a.path); err != nil {
+			return "", err
+		}
+	}
+	a.members[member] = archiveMember{size: srcInfo.Size(), modTime: srcInfo.ModTime()}
+	// Only this algorithm's hash is known; an index for another one would miss the new member
+	for cachedAlgo, hashes := range a.hashes {
+		if cachedAlgo == algo {
+			hashes[member] = hash
+		} else {
+			delete(a.hashes, cachedAlgo)
+		}
+	}
+	return hash, nil
+}
+
+// writeTarMember writes one gzip member holding the tar entry for src, throttled by limiter if set
+func writeTarMember(ctx context.Context, out io.Writer, in io.Reader, srcInfo os.FileInfo, member, algo string, limiter *rateLimiter) (string, error) {
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	header := &tar.Header{
+		Name:    member,
+		Mode:    0644,
+		Size:    srcInfo.Size(),
+		ModTime: srcInfo.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return "", fmt.Errorf("failed to write archive entry: %w", err)
+	}
+
+	buf := make([]byte, 1024*1024)
+	w := io.MultiWriter(tw, hasher)
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			if limiter != nil {
+				if err := limiter.wait(ctx, n); err != nil {
+					return "", err
+				}
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("failed to write to archive: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("failed to read from source file: %w", readErr)
+		}
+	}
+
+	// Flush pads the entry without the end-of-archive marker, so later appends stay readable
+	if err := tw.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write to archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to write to archive: %w", err)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// verifyTarMember re-reads the entry appended at offset start (--verify-copy)
+func verifyTarMember(path string, start int64, member, hash, algo string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to re-read archive for verification: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to re-read archive for verification: %w", err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to re-read archive for verification: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil || header.Name != member {
+		return fmt.Errorf("copy verification failed: %s is not readable from %s", member, path)
+	}
+	writtenHash, err := hashReader(tr, algo)
+	if err != nil {
+		return fmt.Errorf("failed to re-read archive for verification: %w", err)
+	}
+	if writtenHash != hash {
+		return fmt.Errorf("copy verification failed: archived file hashes to %s, source to %s", writtenHash, hash)
+	}
+	return nil
+}
+
+// readTarGz calls fn for every entry of a (possibly appended-to) .tar.gz, in order
+func readTarGz(path string, fn func(header *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// hashMember hashes one stored file without extracting it
+// The first lookup per algorithm hashes the whole archive, so verifying a month reads it once;
+// if a name was stored twice, the last copy wins, as it does when the archive is extracted.
+// The read doesn't hold mu, so appends go on meanwhile; one that overlapped it means reading again
+func (a *tarArchive) hashMember(member, algo string) (string, error) {
+	if hash, cached, found := a.cachedHash(member, algo); cached {
+		return foundHash(hash, found, a.path, member)
+	}
+	a.readMu.Lock()
+	defer a.readMu.Unlock()
+	for {
+		// Another lookup may have read the archive while this one waited
+		if hash, cached, found := a.cachedHash(member, algo); cached {
+			return foundHash(hash, found, a.path, member)
+		}
+		a.mu.Lock()
+		appended := a.appended
+		a.mu.Unlock()
+
+		hashes := make(map[string]string)
+		err := readTarGz(a.path, func(header *tar.Header, r io.Reader) error {
+			h, err := hashReader(r, algo)
+			if err != nil {
+				return err
+			}
+			hashes[header.Name] = h
+			return nil
+		})
+
+		a.mu.Lock()
+		if a.appended != appended {
+			a.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			a.mu.Unlock()
+			return "", err
+		}
+		if a.hashes == nil {
+			a.hashes = make(map[string]map[string]string)
+		}
+		a.hashes[algo] = hashes
+		hash, found := hashes[member]
+		a.mu.Unlock()
+		return foundHash(hash, found, a.path, member)
+	}
+}
+
+// cachedHash looks member up in the index for algo; cached is false if there is none yet
+func (a *tarArchive) cachedHash(member, algo string) (hash string, cached, found bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	hashes, cached := a.hashes[algo]
+	if !cached {
+		return "", false, false
+	}
+	hash, found = hashes[member]
+	return hash, true, found
+}
+
+// foundHash returns a hash looked up in an archive, or a not-exist error for a missing member
+func foundHash(hash string, found bool, archive, member string) (string, error) {
+	if !found {
+		return "", &os.PathError{Op: "open", Path: filepath.Join(archive, filepath.FromSlash(member)), Err: os.ErrNotExist}
+	}
+	return hash, nil
+}
@@ -0,0 +1,102 @@
+// backupbozo tests for storing files in monthly archives
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestArchiveNameCollision stores two different files with one name in the same archive, as a
+// camera reusing IMG_0001.jpg would, with each --collision-mode that keeps both
+func TestArchiveNameCollision(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.jpg")
+	second := filepath.Join(dir, "second.jpg")
+	os.WriteFile(first, []byte("first photo"), 0644)
+	os.WriteFile(second, []byte("second photo, same name"), 0644)
+	firstHash, _ := HashFile(first, hashSHA256)
+	secondHash, _ := HashFile(second, hashSHA256)
+
+	db, err := OpenDatabase(filepath.Join(dir, "backupbozo.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	for _, mode := range []string{CollisionRename, collisionSourceSubdir} {
+		t.Run(mode, func(t *testing.T) {
+			r := &backupRun{destination: localDestination(), opts: BackupOptions{CollisionMode: mode}, collisionSubdir: "phone"}
+			r.archiveFormat = ArchiveTarGz
+			destDateDir := filepath.Join(t.TempDir(), "2024-02")
+			dest := r.destPathIn(destDateDir, "IMG_0001.jpg")
+			archive, member, _ := splitArchiveMember(dest)
+			ctx := context.Background()
+
+			if _, err := r.storeFile(ctx, first, dest, hashSHA256, time.Time{}); err != nil {
+				t.Fatalf("store first: %v", err)
+			}
+			if _, err := r.statDest(dest); err != nil {
+				t.Fatalf("stored member not found: %v", err)
+			}
+			if hash, err := r.hashDestFile(dest, hashSHA256); err != nil || hash != firstHash {
+				t.Fatalf("expected hash %s, got %s (%v)", firstHash, hash, err)
+			}
+			if _, err := r.openTarArchive(archive).append(ctx, second, member, hashSHA256); err == nil {
+				t.Fatal("a taken member name was appended again")
+			}
+
+			bi := NewBatchInserter(db, make(map[string]string), hashSHA256, "test", 0, log.Default())
+			placed, state := r.placeCollision(dest, secondHash, bi)
+			if state != StateCopied || placed == dest {
+				t.Fatalf("collision placed at %s with state %v", placed, state)
+			}
+			if _, err := r.storeFile(ctx, second, placed, hashSHA256, time.Time{}); err != nil {
+				t.Fatalf("store second: %v", err)
+			}
+
+			// Both hashes come from the index, which the append kept up to date
+			a := r.openTarArchive(archive)
+			if _, cached, found := a.cachedHash(mustMember(t, placed), hashSHA256); !cached || !found {
+				t.Error("append did not add the new member to the hash index")
+			}
+			if hash, err := r.hashDestFile(placed, hashSHA256); err != nil || hash != secondHash {
+				t.Errorf("expected hash %s, got %s (%v)", secondHash, hash, err)
+			}
+			if hash, err := r.hashDestFile(dest, hashSHA256); err != nil || hash != firstHash {
+				t.Errorf("original member changed: expected hash %s, got %s (%v)", firstHash, hash, err)
+			}
+
+			// Entry names use forward slashes, so the archive extracts the same everywhere
+			var names []string
+			if err := readTarGz(archive, func(header *tar.Header, _ io.Reader) error {
+				names = append(names, header.Name)
+				return nil
+			}); err != nil {
+				t.Fatalf("read archive: %v", err)
+			}
+			want := []string{"IMG_0001.jpg", mustMember(t, placed)}
+			if mode == collisionSourceSubdir && want[1] != "phone/IMG_0001.jpg" {
+				t.Errorf("expected the source subfolder member phone/IMG_0001.jpg, got %s", want[1])
+			}
+			if len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+				t.Errorf("expected entries %v, got %v", want, names)
+			}
+		})
+	}
+}
+
+// mustMember returns the member name of a path inside an archive
+func mustMember(t *testing.T, path string) string {
+	t.Helper()
+	_, member, ok := splitArchiveMember(path)
+	if !ok {
+		t.Fatalf("%s is not inside an archive", path)
+	}
+	return member
+}
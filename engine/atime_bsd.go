@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd
+
+package engine
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the last access time of a file (macOS/BSD implementation)
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(stat.Atimespec.Sec), int64(stat.Atimespec.Nsec))
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package engine
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the last access time of a file (Linux implementation)
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+	}
+	return info.ModTime()
+}
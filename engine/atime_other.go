@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package engine
+
+import (
+	"os"
+	"time"
+)
+
+// fileAccessTime falls back to the modification time where access time isn't exposed
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
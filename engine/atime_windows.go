@@ -0,0 +1,17 @@
+//go:build windows
+
+package engine
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the last access time of a file (Windows implementation)
+func fileAccessTime(info os.FileInfo) time.Time {
+	if attrs, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, attrs.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
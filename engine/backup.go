@@ -0,0 +1,897 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// checkDirExists returns an error unless path is an existing local directory
+func checkDirExists(path string, label string) error {
+	if IsRemoteDest(path) {
+		return fmt.Errorf("%s '%s' is remote; only backup destinations can be on sftp://", label, path)
+	}
+	return checkDirExistsOn(localFS{}, path, label)
+}
+
+// checkDirExistsOn is checkDirExists for a directory on the given filesystem
+func checkDirExistsOn(fsys DestFS, path string, label string) error {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s directory '%s' does not exist: %v", label, path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s path '%s' is not a directory", label, path)
+	}
+	return nil
+}
+
+// CheckPaths returns an error if the source and a local destination are the same folder or one is
+// inside the other: the walk would pick up the copies the backup just made and copy them again
+// Symlinks are resolved first, so a link into the destination is caught too
+func CheckPaths(srcDir, destDir string, fsys DestFS) error {
+	if _, isLocal := fsys.(localFS); !isLocal || IsZipFile(srcDir) {
+		return nil
+	}
+	src, dest := resolvedPath(srcDir), resolvedPath(destDir)
+	switch {
+	case src == dest:
+		return fmt.Errorf("source and destination are the same folder: %s", src)
+	case pathContains(src, dest):
+		return fmt.Errorf("destination '%s' is inside the source '%s'; back up to a folder outside it", dest, src)
+	case pathContains(dest, src):
+		return fmt.Errorf("source '%s' is inside the destination '%s'; back up to a folder outside it", src, dest)
+	}
+	return nil
+}
+
+// resolvedPath returns path as an absolute path with symlinks resolved, as far as that works
+func resolvedPath(path string) string {
+	abs := sourceKey(path)
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// pathContains reports whether path is below dir (both absolute and cleaned)
+func pathContains(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// SpaceReserve is free space the backup must leave on the destination (--reserve)
+// Either an absolute size or a percentage of the destination disk
+type SpaceReserve struct {
+	Bytes   uint64
+	Percent float64
+}
+
+// resolve returns the reserve in bytes for the destination disk
+func (r SpaceReserve) resolve(fsys DestFS, destDir string) (uint64, error) {
+	if r.Percent <= 0 {
+		return r.Bytes, nil
+	}
+	total, err := fsys.TotalSpace(destDir)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(float64(total) * r.Percent / 100), nil
+}
+
+// backupRun is one backup in progress: its options, its destination, and what its phases hand
+// on to each other
+type backupRun struct {
+	*destination
+	opts BackupOptions
+	log  *EventLog
+
+	registry    *metadata.ExtractorRegistry
+	extensions  map[string]bool // Backed up extensions
+	quickDedupe map[string]bool // Extensions checked by name, size, and mtime instead of by hash (--hash-only-videos)
+	heic        *heicConverter  // Converter for --convert-heic-to-jpeg
+
+	// stop cancels the run (and a watch session it belongs to), like Ctrl+C
+	stop context.CancelFunc
+
+	// Source paths mapped to the extension their content calls for, for files whose own extension
+	// names another type (--check-content-type); filled from the planning results and only read afterwards
+	mislabeledTypes map[string]string
+
+	// Every file extracted from a source .zip mapped to the entry it came from, shown as
+	// <archive>.zip/<entry>, and to the zip's own path; filled before files are processed
+	zipSources  map[string]string
+	zipArchives map[string]string
+
+	// Stored paths that only --known-db backups have: such a duplicate is skipped, never linked,
+	// since it may be on another drive. Filled before files are processed
+	knownDBPaths map[string]bool
+
+	// Source paths mapped to the date chosen for their content (--prefer-date); filled before
+	// files are processed and only read afterwards
+	preferredDates map[string]preferredDate
+
+	// The subfolder this run's source gets with --collision-mode source-subdir
+	collisionSubdir string
+}
+
+// newBackupRun checks opts and sets up a run for them; stop cancels the run's context
+func newBackupRun(opts BackupOptions, stop context.CancelFunc) (*backupRun, error) {
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	extensions, err := extensionSet(opts.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extensions: %w", err)
+	}
+	registry, err := metadata.NewExtractorRegistryFor(opts.DateSource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date source: %w", err)
+	}
+	registry.SetFFprobeTimeout(opts.FFprobeTimeout)
+	registry.SetTimezone(opts.Timezone)
+
+	dest := newDestination(opts.DestFS)
+	dest.archiveFormat = opts.Archive
+	dest.verify = opts.VerifyCopies
+	dest.fsync = opts.Fsync
+	dest.dirMode = opts.DirMode
+	dest.fileMode = opts.FileMode
+	dest.preserveOwner = opts.PreserveOwner
+	dest.console = opts.Verbosity.warnings()
+	if opts.RateLimit > 0 {
+		dest.limiter = newRateLimiter(opts.RateLimit)
+	}
+
+	r := &backupRun{
+		destination:     dest,
+		opts:            opts,
+		log:             opts.EventLog,
+		registry:        registry,
+		extensions:      extensions,
+		quickDedupe:     make(map[string]bool),
+		stop:            stop,
+		mislabeledTypes: make(map[string]string),
+		zipSources:      make(map[string]string),
+		zipArchives:     make(map[string]string),
+		knownDBPaths:    make(map[string]bool),
+		preferredDates:  make(map[string]preferredDate),
+	}
+	if opts.HashOnlyVideos {
+		r.quickDedupe = nonVideoExtensions(extensions)
+	}
+	if opts.ConvertHEIC {
+		if r.heic = findHEICConverter(); r.heic == nil {
+			return nil, fmt.Errorf("HEIC conversion needs 'heif-convert' (libheif) or 'magick' (ImageMagick) in PATH")
+		}
+	}
+	return r, nil
+}
+
+// newBatchInserter creates the run's batch inserter, loading the indexes its options need and
+// merging in the files stored in other backups (--known-db), which count as duplicates too
+func (r *backupRun) newBatchInserter(db *sql.DB, hashToPath map[string]string, runID string) *BatchInserter {
+	bi := NewBatchInserter(db, hashToPath, r.opts.HashAlgo, runID, r.opts.BatchSize, r.console)
+	bi.zipSources = r.zipSources
+	if len(r.quickDedupe) > 0 {
+		bi.quickIndex = loadQuickIndex(db, r.console)
+	}
+	if r.opts.ChecksumSample > 0 {
+		bi.sampleIndex = loadSampleIndex(db, r.console)
+		bi.unsampled = loadUnsampledSizes(db, r.opts.HashAlgo, r.opts.ChecksumSample, r.console)
+	}
+	mergeKnownDatabases(r.opts.KnownDBs, r.opts.HashAlgo, bi.hashToPath, bi.quickIndex, bi.sizes, bi.unsampled, r.knownDBPaths, r.console)
+	return bi
+}
+
+// spaceBuffer is free space left over on top of what a run is estimated to write (100MB safety buffer)
+const spaceBuffer = uint64(1024 * 1024 * 100)
+
+// Backup is the main backup routine: scans, checks, copies, and reports
+// Cancelling ctx stops it safely (Ctrl+C in the CLI), writing a partial report
+// Returns a nil result and no error if the run stopped before processing files (interrupted
+// planning, no space, not confirmed); options that don't check out, a missing source or
+// destination, and a database that is locked or can't be opened are errors
+func Backup(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	r, err := newBackupRun(opts, stop)
+	if err != nil {
+		return nil, err
+	}
+	return r.backup(ctx)
+}
+
+// backup runs the backup set up by newBackupRun
+func (r *backupRun) backup(ctx context.Context) (runResult *BackupResult, err error) {
+	opts := r.opts
+	srcDir, destDir, dbPath, reportPath := opts.SrcDir, opts.DestDir, opts.DBPath, opts.ReportPath
+	incremental, workers, move, layout, formats := opts.Incremental, opts.Workers, opts.Move, opts.Layout, opts.Formats
+	hashAlgo, dedupeMode, manifest, reserve := opts.HashAlgo, opts.DedupeMode, opts.Manifest, opts.Reserve
+	since, until, minSize, maxSize := opts.Since, opts.Until, opts.MinSize, opts.MaxSize
+	excludes, followSymlinks, maxDepth, ignoreHidden := opts.Excludes, opts.FollowSymlinks, opts.MaxDepth, opts.IgnoreHidden
+	verbosity := opts.Verbosity
+
+	if IsZipFile(srcDir) {
+		err = checkZipExists(srcDir)
+	} else {
+		err = checkDirExists(srcDir, "source")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDirExistsOn(r.fs, destDir, "destination"); err != nil {
+		return nil, err
+	}
+	if err := CheckPaths(srcDir, destDir, r.fs); err != nil {
+		return nil, err
+	}
+	if opts.ScratchDir != "" {
+		if err := checkDirExists(opts.ScratchDir, "temp"); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MirrorDir != "" {
+		if err := checkDirExists(opts.MirrorDir, "mirror"); err != nil {
+			return nil, err
+		}
+		if err := checkMirrorPath(opts.MirrorDir, srcDir, destDir, r.fs); err != nil {
+			return nil, err
+		}
+	}
+	// Source paths are recorded in full, so a stored file can be traced back to where it came from
+	srcDir = sourceKey(srcDir)
+	r.collisionSubdir = sourceSubdirName(srcDir)
+	destDir = absDestDir(destDir, r.isLocal())
+
+	// Two runs against the same database would corrupt each other's records and folders
+	// An in-memory database (--no-db) belongs to this run alone
+	if opts.NoDB {
+		dbPath = memoryDBPath
+		// SHA256SUMS is rebuilt from the database, so this run's files would replace the list
+		manifest = false
+	} else {
+		lock, err := acquireRunLock(dbPath, opts.ForceLock, r.log, verbosity)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.release()
+	}
+
+	// Both worker pools need at least one worker or they never drain their job queues
+	if workers <= 0 {
+		workers = 1 // Fallback to single-threaded if invalid worker count
+	}
+
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := useDestDir(db, destDir, r.isLocal()); err != nil {
+		return nil, err
+	}
+
+	// Every record written by this run is tagged so the run can be listed and rolled back
+	runID := time.Now().Format(runIDLayout)
+	r.log.Info("backup started: run=%s src=%s dest=%s incremental=%t hash=%s layout=%s workers=%d move=%t",
+		runID, srcDir, destDir, incremental, hashAlgo, layout, workers, move)
+
+	// Load existing hashes into memory for fast duplicate detection
+	// Only hashes made with the same algorithm are comparable
+	hashToPath := loadExistingHashes(db, hashAlgo, r.console)
+
+	// Create batch inserter for efficient database writes
+	batchInserter := r.newBatchInserter(db, hashToPath, runID)
+	defer func() {
+		// Use context-aware flush with a short timeout for cleanup
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		batchInserter.FlushWithContext(flushCtx)
+	}()
+
+	if resumed := batchInserter.ResumedCount(); resumed > 0 {
+		if verbosity.showSummary() {
+			color.New(color.FgYellow).Printf("Resuming interrupted backup: %d files already processed will be skipped\n", resumed)
+		}
+		r.log.Info("resuming interrupted backup: %d files already processed", resumed)
+	}
+
+	startTime := time.Now()
+
+	// A run that stops before processing files is notified too, with the reason it stopped
+	var stopReason string
+	defer func() {
+		if runResult == nil && err == nil {
+			r.notifyStopped(time.Since(startTime), srcDir, destDir, stopReason, ctx.Err() != nil)
+		}
+	}()
+
+	var minMtime int64 = 0
+	var lastBackupTime time.Time
+	if incremental {
+		var err error
+		lastBackupTime, err = sourceMark(db, srcDir, r.scanKey())
+		if err != nil {
+			r.console.Printf("Warning: Could not read last backup time, scanning every file: %v", err)
+			lastBackupTime = time.Time{}
+		} else if !lastBackupTime.IsZero() {
+			minMtime = lastBackupTime.Unix()
+		}
+	}
+	filter := FileFilter{
+		Incremental: incremental,
+		MinMtime:    minMtime,
+		Retry:       batchInserter.Retries(),
+		Since:       since,
+		Until:       until,
+		MinSize:     minSize,
+		MaxSize:     maxSize,
+	}
+
+	// Scan all files in source directory
+	files, excludedFiles, walkErrors := getAllFiles(srcDir, excludes, followSymlinks, maxDepth, ignoreHidden, verbosity)
+	var only map[string]bool
+	if opts.Only != nil {
+		only = make(map[string]bool, len(opts.Only))
+		for _, path := range opts.Only {
+			only[path] = true
+		}
+		files = onlyListedZips(files, only)
+	}
+	// Zips in the source (or a zip given as the source) are backed up by their contents
+	files, cleanupZips, zipErrors := r.expandZipSources(files, filter)
+	defer cleanupZips()
+	walkErrors = append(walkErrors, zipErrors...)
+	files = r.pairLivePhotos(r.attachSidecars(files))
+	if only != nil {
+		files = r.onlyFiles(files, only)
+	}
+	for _, walkErr := range walkErrors {
+		r.log.Error("walk error: %v", walkErr)
+	}
+	r.log.Info("found %d files in source (%d excluded)", len(files), len(excludedFiles))
+
+	// PHASE 1: Planning phase - fast evaluation without hash computation
+	if verbosity.showPhases() {
+		fmt.Println()
+		color.New(color.FgCyan, color.Bold).Printf("📋 Planning Phase\n")
+		fmt.Printf("   Scanning %d files from source directory...\n", len(files))
+	}
+	planningBar := progressbar.NewOptions(
+		len(files),
+		progressbar.OptionSetVisibility(verbosity.showProgressBars() && opts.Progress == nil),
+		progressbar.OptionSetDescription("Planning"),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionClearOnFinish(),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[blue]=[reset]",
+			SaucerHead:    "[blue]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+
+	var estimatedTotalSize int64
+	var filesToCopy int
+
+	// Fast parallel planning evaluation (no hash computation)
+	planningProgress := newProgressTracker(planningBar, opts.Progress, PhasePlanning, len(files))
+	var index *planningIndex
+	if opts.SkipExistingByHash {
+		index = newPlanningIndex(batchInserter)
+	}
+	planningResults := r.evaluateFilesForPlanningParallel(ctx, files, destDir, layout, planningProgress, filter, index, workers)
+
+	// Check for cancellation after planning
+	if ctx.Err() != nil {
+		if verbosity.showSummary() {
+			fmt.Printf("\nBackup planning interrupted\n")
+			fmt.Printf("No files were processed. Restart to begin backup.\n")
+		}
+		r.log.Warn("interrupted during planning, no files were processed")
+		stopReason = "interrupted during planning, no files were processed"
+		return nil, nil
+	}
+
+	// Aggregate planning results
+	// The copy progress bar counts bytes, so a few large videos don't make its ETA meaningless
+	plannedBytes := make([]int64, len(files))
+	for i, planResult := range planningResults {
+		if planResult.ContentExt != "" {
+			r.mislabeledTypes[files[i].Path] = planResult.ContentExt
+		}
+		if planResult.ShouldCopy {
+			estimatedTotalSize += planResult.Size
+			filesToCopy++
+			plannedBytes[i] = planResult.Size
+		}
+	}
+
+	// Check available disk space
+	availableSpace, err := r.fs.FreeSpace(destDir)
+	if err != nil {
+		if verbosity.showSummary() {
+			color.New(color.FgRed, color.Bold).Printf("Error checking disk space: %v\n", err)
+		}
+		r.log.Error("could not check disk space: %v", err)
+		stopReason = fmt.Sprintf("could not check disk space: %v", err)
+		return nil, nil
+	}
+
+	// Space check with clear abort/continue decision
+	reserveBytes, err := reserve.resolve(r.fs, destDir)
+	if err != nil {
+		if verbosity.showSummary() {
+			color.New(color.FgRed, color.Bold).Printf("Error checking disk size for --reserve: %v\n", err)
+		}
+		r.log.Error("could not check disk size for --reserve: %v", err)
+		stopReason = fmt.Sprintf("could not check disk size for --reserve: %v", err)
+		return nil, nil
+	}
+	requiredSpace := uint64(estimatedTotalSize) + spaceBuffer + reserveBytes
+
+	if verbosity.showPhases() {
+		fmt.Println()
+		color.New(color.FgBlue, color.Bold).Printf("💾 Space Analysis\n")
+		color.New(color.FgCyan).Printf("   Files found in source: %d\n", len(files))
+		color.New(color.FgYellow).Printf("   Files estimated for copy: %d\n", filesToCopy)
+		color.New(color.FgMagenta).Printf("   Estimated copy size: %.2f GB\n", float64(estimatedTotalSize)/(1024*1024*1024))
+		color.New(color.FgGreen).Printf("   Available disk space: %.2f GB\n", float64(availableSpace)/(1024*1024*1024))
+		if reserveBytes > 0 {
+			color.New(color.FgBlue).Printf("   Reserved free space: %.2f GB\n", float64(reserveBytes)/(1024*1024*1024))
+		}
+		color.New(color.FgBlue).Printf("   Required (with buffer): %.2f GB\n", float64(requiredSpace)/(1024*1024*1024))
+	}
+
+	if availableSpace < requiredSpace {
+		if verbosity.showSummary() {
+			color.New(color.FgRed, color.Bold).Printf("\n❌ INSUFFICIENT DISK SPACE\n")
+			fmt.Printf("Need %.2f GB but only %.2f GB available.\n",
+				float64(requiredSpace)/(1024*1024*1024),
+				float64(availableSpace)/(1024*1024*1024))
+			if reserveBytes > 0 {
+				fmt.Printf("This includes %.2f GB of free space reserved with --reserve; nothing was copied.\n",
+					float64(reserveBytes)/(1024*1024*1024))
+			}
+			fmt.Printf("Please free up space or use a different destination.\n")
+		}
+		r.log.Error("insufficient disk space: need %d bytes, %d available", requiredSpace, availableSpace)
+		stopReason = fmt.Sprintf("insufficient disk space: need %d bytes, %d available", requiredSpace, availableSpace)
+		return nil, nil
+	}
+
+	// The mirror needs room for the same files, without --reserve (it only applies to the destination)
+	if opts.MirrorDir != "" {
+		mirrorSpace, err := getFreeSpace(opts.MirrorDir)
+		if err != nil {
+			if verbosity.showSummary() {
+				color.New(color.FgRed, color.Bold).Printf("Error checking mirror disk space: %v\n", err)
+			}
+			r.log.Error("could not check mirror disk space: %v", err)
+			stopReason = fmt.Sprintf("could not check mirror disk space: %v", err)
+			return nil, nil
+		}
+		mirrorRequired := uint64(estimatedTotalSize) + spaceBuffer
+		if verbosity.showPhases() {
+			color.New(color.FgGreen).Printf("   Available mirror space: %.2f GB\n", float64(mirrorSpace)/(1024*1024*1024))
+		}
+		if mirrorSpace < mirrorRequired {
+			if verbosity.showSummary() {
+				color.New(color.FgRed, color.Bold).Printf("\n❌ INSUFFICIENT DISK SPACE ON THE MIRROR\n")
+				fmt.Printf("Need %.2f GB in %s but only %.2f GB available.\n",
+					float64(mirrorRequired)/(1024*1024*1024), opts.MirrorDir, float64(mirrorSpace)/(1024*1024*1024))
+				fmt.Printf("Please free up space or use a different mirror. Nothing was copied.\n")
+			}
+			r.log.Error("insufficient mirror disk space: need %d bytes, %d available", mirrorRequired, mirrorSpace)
+			stopReason = fmt.Sprintf("insufficient mirror disk space: need %d bytes, %d available", mirrorRequired, mirrorSpace)
+			return nil, nil
+		}
+	}
+
+	if verbosity.showPhases() {
+		color.New(color.FgGreen, color.Bold).Printf("   ✅ Sufficient disk space available\n")
+	}
+
+	// Safety net against a wrong source or destination: big runs need a yes first
+	threshold := opts.ConfirmThreshold
+	if threshold > 0 && filesToCopy > threshold && opts.Confirm != nil && !opts.Confirm(filesToCopy, estimatedTotalSize) {
+		if verbosity.showSummary() {
+			color.New(color.FgYellow).Printf("\nBackup cancelled. Nothing was copied.\n")
+		}
+		r.log.Warn("cancelled at confirmation: %d files to copy exceed --confirm-threshold %d", filesToCopy, threshold)
+		stopReason = fmt.Sprintf("cancelled at confirmation: %d files to copy exceed --confirm-threshold %d", filesToCopy, threshold)
+		return nil, nil
+	}
+
+	// Content found under several dates is placed by the one --prefer-date picks
+	if opts.PreferDate != "" {
+		if verbosity.showPhases() {
+			fmt.Println()
+			color.New(color.FgCyan, color.Bold).Printf("📅 Comparing dates of identical files (--prefer-date %s)...\n", opts.PreferDate)
+		}
+		r.resolvePreferredDates(ctx, files, batchInserter, filter, workers)
+		if ctx.Err() != nil {
+			if verbosity.showSummary() {
+				fmt.Printf("\nBackup interrupted before copying\n")
+			}
+			r.log.Warn("interrupted while comparing dates, no files were processed")
+			stopReason = "interrupted while comparing dates, no files were processed"
+			return nil, nil
+		}
+		if verbosity.showPhases() && len(r.preferredDates) > 0 {
+			fmt.Printf("   %d files will be placed by the date of an identical file\n", len(r.preferredDates))
+		}
+	}
+
+	// PHASE 2: Execution phase - actual processing with hash computation and copying
+	if verbosity.showPhases() {
+		fmt.Println()
+		color.New(color.FgGreen, color.Bold).Printf("🚀 Executing Backup\n")
+		fmt.Printf("   Processing %d files with %d workers...\n", len(files), workers)
+	}
+
+	// Files that planning expects to copy advance the bar by their size; when there is nothing
+	// to copy it counts files instead
+	execTotal, byBytes := int64(len(files)), estimatedTotalSize > 0
+	rateOption := progressbar.OptionShowIts()
+	if byBytes {
+		execTotal = estimatedTotalSize
+		rateOption = progressbar.OptionShowBytes(true) // Sizes and MB/s instead of files/s
+	}
+	execBar := progressbar.NewOptions64(
+		execTotal,
+		progressbar.OptionSetVisibility(verbosity.showProgressBars() && opts.Progress == nil),
+		progressbar.OptionShowCount(),
+		rateOption,
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionSetPredictTime(true), // ETA
+		progressbar.OptionSetElapsedTime(true), // Elapsed
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSpinnerType(14), // Use a spinner
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[green]=[reset]",
+			SaucerHead:    "[green]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+
+	// Parallel processing: use worker pool for concurrent file processing
+	execProgress := newProgressTracker(execBar, opts.Progress, PhaseCopying, len(files))
+	execProgress.byBytes = byBytes
+	results := r.processFilesParallel(ctx, files, plannedBytes, srcDir, destDir, layout, dedupeMode, execProgress, db, batchInserter, filter, workers)
+	// Excluded files and folders never reach the workers but still show up in the report
+	for _, file := range excludedFiles {
+		var size int64
+		if !file.Info.IsDir() {
+			size = file.Info.Size()
+		}
+		result := &FileResult{
+			Path:  file.Path,
+			State: StateSkippedExcluded,
+			Size:  size,
+		}
+		r.log.logFileResult(result)
+		verbosity.printFileResult(result)
+		results = append(results, result)
+	}
+	totalTime := time.Since(startTime)
+
+	// Read before results are relabelled
+	unfinished := r.unfinishedSources(files, results, walkErrors)
+
+	// Check for cancellation after execution phase
+	if ctx.Err() != nil {
+		// Generate partial report even when interrupted
+		r.relabelZipSources(results)
+		partialSummary := GenerateAccountingSummary(results, walkErrors)
+		partialSummary.MislabeledFixed = opts.ContentCheck == contentCheckFix
+
+		// Create interrupted report with different filename
+		interruptedReportPath := strings.Replace(reportPath, ".html", "_INTERRUPTED.html", 1)
+		if opts.ReportAppend {
+			// The rolling report marks the run as interrupted instead
+			interruptedReportPath = reportPath
+			r.appendHTMLReport(reportPath, partialSummary, totalTime, srcDir, destDir, true)
+		} else {
+			r.writeHTMLReport(interruptedReportPath, partialSummary, totalTime, srcDir, destDir, lastBackupTime, incremental, true)
+		}
+		if formats.JSON {
+			r.writeJSONReport(jsonReportPath(interruptedReportPath), partialSummary, totalTime, srcDir, destDir, incremental, true)
+		}
+		if formats.CSV {
+			r.writeCSVReport(csvReportPath(interruptedReportPath), partialSummary)
+		}
+
+		if verbosity.showSummary() {
+			fmt.Printf("\n📄 Partial backup report generated: %s\n", interruptedReportPath)
+			fmt.Printf("This shows what was processed before interruption.\n")
+		}
+		r.log.Warn("backup interrupted after %s: %d copied, %d skipped, %d duplicates, %d errors; report %s",
+			totalTime.Round(time.Second), partialSummary.Copied, partialSummary.Skipped, partialSummary.Duplicates, partialSummary.Errors, interruptedReportPath)
+		r.notifyCompletion(partialSummary, totalTime, srcDir, destDir, interruptedReportPath, true)
+		return &BackupResult{Summary: partialSummary, Duration: totalTime, ReportPath: interruptedReportPath, Interrupted: true, Unfinished: unfinished}, nil
+	}
+
+	// Only finish/clear the progress bar on successful completion
+	execBar.Finish()
+	if verbosity.showPhases() {
+		fmt.Println() // Add some space after progress bar
+	}
+
+	// The run completed, so the progress journal is no longer needed; only the paths to retry stay
+	retriesRecorded := false
+	if err := batchInserter.FlushWithContext(ctx); err == nil {
+		if err := resetJournal(db, nextRetries(batchInserter.Retries(), srcDir, opts.Only, unfinished)); err != nil {
+			r.console.Printf("Warning: Could not clear progress journal: %v", err)
+			r.log.Warn("could not clear progress journal: %v", err)
+		} else {
+			retriesRecorded = true
+		}
+	}
+
+	// Optional perceptual hash pass: flag re-saved copies of stored images, without skipping them
+	// It reads the sources, so it runs before --move deletes them and while files extracted from zips
+	// are still under their temp names
+	var nearDuplicates []NearDuplicate
+	if opts.NearDuplicates {
+		if verbosity.showPhases() {
+			fmt.Println()
+			color.New(color.FgCyan, color.Bold).Printf("👯 Looking for near-duplicate images...\n")
+		}
+		nearDuplicates = r.findNearDuplicates(ctx, db, results, workers)
+		for i, near := range nearDuplicates {
+			if entry, found := r.zipSources[near.Path]; found {
+				nearDuplicates[i].Path = entry
+			}
+			r.log.Warn("near-duplicate: %s looks like %s (distance %d)", nearDuplicates[i].Path, near.SimilarTo, near.Distance)
+		}
+	}
+
+	// Move mode: sources are only removed once their records are committed to the database
+	if move {
+		if err := batchInserter.FlushWithContext(ctx); err != nil {
+			if verbosity.showSummary() {
+				color.New(color.FgRed, color.Bold).Printf("Database write failed, keeping all source files: %v\n", err)
+			}
+			r.log.Error("database write failed, keeping all source files: %v", err)
+		} else {
+			r.removeMovedSources(results, hashAlgo)
+		}
+	}
+
+	// Extra copies of the same content in the source go once the kept copy's record is committed
+	if opts.PurgeSourceDuplicates {
+		if err := batchInserter.FlushWithContext(ctx); err != nil {
+			if verbosity.showSummary() {
+				color.New(color.FgRed, color.Bold).Printf("Database write failed, keeping all source duplicates: %v\n", err)
+			}
+			r.log.Error("database write failed, keeping all source duplicates: %v", err)
+		} else {
+			r.purgeDuplicateSources(results, hashAlgo)
+		}
+	}
+
+	// Refresh the SHA256SUMS manifest from the database (everything is flushed by now)
+	if manifest {
+		if count, err := r.writeManifest(db, destDir); err != nil {
+			r.console.Printf("Warning: Could not write %s: %v", manifestName, err)
+			r.log.Warn("could not write %s: %v", manifestName, err)
+		} else {
+			r.log.Info("wrote %s with %d files", manifestName, count)
+		}
+	}
+
+	// Generate perfect accounting summary from results (no manual counters!)
+	r.relabelZipSources(results)
+	summary := GenerateAccountingSummary(results, walkErrors)
+	summary.NearDuplicates = nearDuplicates
+	summary.MislabeledFixed = opts.ContentCheck == contentCheckFix
+
+	// Advance this source's incremental high-water mark to the start of the run. Files it failed on
+	// (errors, failed mirror copies) are picked up through their retry entries; files outside a
+	// --since/--until/size range have none, so such a run leaves the mark alone, and so does a watch
+	// batch: it only looked at the files it was given, while others may still be settling. A run that
+	// walks the source differently (excludes, extensions) keeps a mark of its own, see scanKey
+	if retriesRecorded && !filter.narrowed() && opts.Only == nil {
+		if err := recordSourceRun(db, srcDir, runID, r.scanKey(), startTime); err != nil {
+			r.console.Printf("Warning: Could not record backup time for incremental runs: %v", err)
+			r.log.Warn("could not record backup time for incremental runs: %v", err)
+		}
+	}
+
+	// Generate HTML report with perfectly consistent data
+	if opts.ReportAppend {
+		r.appendHTMLReport(reportPath, summary, totalTime, srcDir, destDir, false)
+	} else {
+		r.writeHTMLReport(reportPath, summary, totalTime, srcDir, destDir, lastBackupTime, incremental, false)
+	}
+	if formats.JSON {
+		r.writeJSONReport(jsonReportPath(reportPath), summary, totalTime, srcDir, destDir, incremental, false)
+	}
+	if formats.CSV {
+		r.writeCSVReport(csvReportPath(reportPath), summary)
+	}
+
+	// Print summary with bulletproof accounting
+	totalProcessed := len(files) + len(excludedFiles) + len(walkErrors)
+	r.log.Info("backup finished in %s: %d copied (%d bytes), %d skipped, %d duplicates, %d errors; report %s",
+		totalTime.Round(time.Second), summary.Copied, summary.TotalBytes, summary.Skipped, summary.Duplicates, summary.Errors, reportPath)
+	totalAccounted := summary.Copied + summary.Skipped + summary.Duplicates + summary.Errors
+	if verbosity.showSummary() {
+		r.printResults(summary, totalProcessed, totalAccounted, totalTime, reportPath, destDir, manifest)
+	}
+
+	r.notifyCompletion(summary, totalTime, srcDir, destDir, reportPath, false)
+	return &BackupResult{Summary: summary, Duration: totalTime, ReportPath: reportPath, Mismatch: totalAccounted != totalProcessed, Unfinished: unfinished}, nil
+}
+
+// removeMovedSources deletes the source of every verified copy for --move mode
+// Duplicates, skipped files, and errors never reach this point, so their sources are always kept
+func (r *backupRun) removeMovedSources(results []*FileResult, hashAlgo string) {
+	for _, result := range results {
+		// Files from a zip are temp copies; the zip itself is never deleted
+		if result == nil || result.State != StateCopied || r.fromZip(result.Path) {
+			continue
+		}
+		if err := r.removeVerifiedSource(result, hashAlgo); err != nil {
+			result.MoveError = err
+			r.log.Warn("kept source %s: %v", result.Path, err)
+			continue
+		}
+		result.SourceRemoved = true
+		r.log.Info("removed source %s", result.Path)
+
+		// The video half of a live photo goes with its still, under the same verification
+		if video := result.LiveVideo; video != nil && video.State == StateCopied {
+			moved := &FileResult{Path: video.Path, DestPath: video.DestPath, Hash: video.Hash, BytesCopied: video.Size}
+			if err := r.removeVerifiedSource(moved, hashAlgo); err != nil {
+				r.log.Warn("kept source %s: %v", video.Path, err)
+				continue
+			}
+			video.SourceRemoved = true
+			r.log.Info("removed source %s", video.Path)
+		}
+	}
+}
+
+// processFilesParallel processes files using a worker pool for concurrent execution
+// Maintains result ordering while achieving 4-8x performance improvement on multi-core systems
+// Uses in-memory hash set for fast duplicate detection and batch inserter for efficient writes
+// plannedBytes[i] is what files[i] adds to a progress bar counted in bytes
+func (r *backupRun) processFilesParallel(ctx context.Context, files []FileWithInfo, plannedBytes []int64, srcDir, destDir, layout, dedupeMode string, bar *progressTracker,
+	db *sql.DB, batchInserter *BatchInserter, filter FileFilter, workers int) []*FileResult {
+
+	// Channels for worker communication
+	type job struct {
+		index int // Preserve ordering
+		file  FileWithInfo
+	}
+
+	type resultWithIndex struct {
+		index  int
+		result *FileResult
+	}
+
+	jobs := make(chan job, workers*2)                // Buffered channel for work items
+	results := make(chan resultWithIndex, workers*2) // Buffered channel for results
+
+	// Start worker goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Process single file with hash set and batch inserter
+				result := r.processSingleFile(ctx, job.file, destDir, layout, dedupeMode, db, batchInserter, filter)
+
+				// Send result with index to maintain ordering
+				select {
+				case results <- resultWithIndex{index: job.index, result: result}:
+					// Update progress bar with current subdirectory relative to source
+					if relPath, err := filepath.Rel(srcDir, job.file.Path); err == nil {
+						dir := filepath.Dir(relPath)
+						if dir != "." && dir != "/" {
+							// Show the subdirectory being processed
+							bar.Describe(fmt.Sprintf("%s/", dir))
+						} else {
+							// File is in root source directory
+							bar.Describe("Processing root files")
+						}
+					} else {
+						bar.Describe("Processing files...")
+					}
+					bar.AddFile(plannedBytes[job.index])
+				case <-ctx.Done():
+					return // Context cancelled
+				}
+			}
+		}()
+	}
+
+	// Producer: send jobs to workers
+	go func() {
+		defer close(jobs)
+		for i, file := range files {
+			select {
+			case jobs <- job{index: i, file: file}:
+				// Job sent successfully
+			case <-ctx.Done():
+				return // Context cancelled
+			}
+		}
+	}()
+
+	// Collector: gather results and close channel when done
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collect results in ordered slice with context awareness
+	orderedResults := make([]*FileResult, len(files))
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				// Channel closed, all results collected
+				goto resultsComplete
+			}
+			orderedResults[result.index] = result.result
+			r.log.logFileResult(result.result)
+			r.opts.Verbosity.printFileResult(result.result)
+		case <-ctx.Done():
+			// Context cancelled, stop collecting results
+			if r.opts.Verbosity.showSummary() {
+				fmt.Printf("\n\nExecution phase interrupted\n")
+				fmt.Printf("Progress bar shows where we left off. You can restart to continue.\n")
+			}
+			goto resultsComplete
+		}
+	}
+resultsComplete:
+
+	return orderedResults
+}
+
+// processSingleFile handles the processing of a single file (extracted from the original loop)
+// Uses in-memory hash set for fast duplicate detection and batch inserter for efficient writes
+func (r *backupRun) processSingleFile(ctx context.Context, file FileWithInfo, destDir, layout, dedupeMode string, db *sql.DB, batchInserter *BatchInserter,
+	filter FileFilter) *FileResult {
+
+	// Create FileCandidate (uses cached os.FileInfo, no duplicate syscall)
+	candidate := &FileCandidate{
+		Path:       file.Path,
+		Info:       file.Info,
+		Extension:  metadata.NormalizeExt(file.Path),
+		DestDir:    destDir,
+		Layout:     layout,
+		DedupeMode: dedupeMode,
+		Sidecars:   file.Sidecars,
+		LiveVideo:  file.LiveVideo,
+	}
+
+	// Classify and process the file using hash set and batch inserter
+	result := r.classifyAndProcessFile(ctx, candidate, db, batchInserter, filter)
+
+	return result
+}
@@ -0,0 +1,106 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"sort"
+)
+
+// BurstGroup is the frames of one burst copied by a run
+type BurstGroup struct {
+	ID     string
+	Frames []CopiedFile // In capture order
+}
+
+// Bursts groups the copied files that belong to the same burst, largest burst first
+// A lone frame isn't listed: it is usually the one kept when a burst was thinned on the phone
+func (s *AccountingSummary) Bursts() []BurstGroup {
+	frames := make(map[string][]CopiedFile)
+	for _, copied := range s.CopiedFiles {
+		if copied.BurstID != "" {
+			frames[copied.BurstID] = append(frames[copied.BurstID], copied)
+		}
+	}
+
+	var groups []BurstGroup
+	for id, list := range frames {
+		if len(list) < 2 {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].Date.Equal(list[j].Date) {
+				return list[i].Date.Before(list[j].Date)
+			}
+			return list[i].Path < list[j].Path
+		})
+		groups = append(groups, BurstGroup{ID: id, Frames: list})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Frames) != len(groups[j].Frames) {
+			return len(groups[i].Frames) > len(groups[j].Frames)
+		}
+		return groups[i].Frames[0].Path < groups[j].Frames[0].Path
+	})
+	return groups
+}
+
+// shortBurstID shortens a burst UUID for display; the full one is in the database
+func shortBurstID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// writeBursts lists the bursts copied in this run with their frames, so they can be reviewed together
+func writeBursts(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
+	groups := summary.Bursts()
+	if len(groups) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Bursts (%d)</h2>
+        <p>These photos were shot as bursts. Each burst is listed once with all of its frames copied in this run, in the order they were taken. The burst identifier is stored in the database (burst_id) so its frames can be found together later.</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Burst</th>
+                        <th>Frames</th>
+                        <th>Taken</th>
+                        <th>Copied To</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(groups))
+
+	for _, group := range groups {
+		var frames string
+		for i, frame := range group.Frames {
+			if i > 0 {
+				frames += "<br>"
+			}
+			frames += fmt.Sprintf(`<a href="file://%s" title="%s">%s</a>`,
+				html.EscapeString(frame.DestPath), html.EscapeString(makeRelativePath(frame.Path, srcRoot)), html.EscapeString(makeRelativePath(frame.DestPath, destRoot)))
+		}
+		taken := ""
+		if first := group.Frames[0].Date; !first.IsZero() {
+			taken = first.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                        <td>%d</td>
+                        <td>%s</td>
+                        <td class="file-path">%s</td>
+                    </tr>`,
+			html.EscapeString(group.ID), html.EscapeString(shortBurstID(group.ID)), len(group.Frames), taken, frames)
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
@@ -0,0 +1,178 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Verbosity controls how much a backup prints to the console
+type Verbosity int
+
+const (
+	VerbositySilent  Verbosity = iota // Nothing; programs using the engine read BackupResult, Progress, and EventLog
+	VerbosityQuiet                    // Final summary and warnings only (--quiet)
+	VerbosityNormal                   // Phase headers and progress bars
+	VerbosityVerbose                  // One line per file instead of progress bars (--verbose)
+)
+
+// StdoutIsTerminal reports whether stdout is an interactive terminal rather than a file or pipe
+func StdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgressBars reports whether progress bars should be drawn
+// Bars redraw with carriage returns, which turns into garbage in log files, systemd, and CI
+func (v Verbosity) showProgressBars() bool {
+	return v == VerbosityNormal && StdoutIsTerminal()
+}
+
+// showPhases reports whether phase headers and space analysis should be printed
+func (v Verbosity) showPhases() bool {
+	return v > VerbosityQuiet
+}
+
+// showSummary reports whether final results, reports written, and why a run stopped should be printed
+func (v Verbosity) showSummary() bool {
+	return v != VerbositySilent
+}
+
+// warnings returns the logger for warnings printed to stderr; a silent run's go nowhere, they are
+// in its EventLog
+func (v Verbosity) warnings() *log.Logger {
+	if v == VerbositySilent {
+		return log.New(io.Discard, "", 0)
+	}
+	return log.Default()
+}
+
+// printResults prints the final results of a completed run and the reports it wrote
+func (r *backupRun) printResults(summary AccountingSummary, totalProcessed, totalAccounted int, totalTime time.Duration, reportPath, destDir string, manifest bool) {
+	fmt.Println()
+	color.New(color.FgMagenta, color.Bold).Printf("📊 Final Results\n")
+	color.New(color.FgGreen).Printf("   ✅ Copied: %d files\n", summary.Copied)
+	color.New(color.FgYellow).Printf("   ⏭️  Skipped: %d files\n", summary.Skipped)
+	color.New(color.FgBlue).Printf("   🔄 Duplicates: %d files\n", summary.Duplicates)
+	if summary.DuplicateBytes > 0 {
+		color.New(color.FgBlue).Printf("   💾 Space saved by dedup: %.2f MB\n", float64(summary.DuplicateBytes)/(1024*1024))
+	}
+	if r.opts.Move {
+		color.New(color.FgMagenta).Printf("   🗑️  Sources removed: %d files\n", len(summary.RemovedSources))
+	}
+	if r.opts.PurgeSourceDuplicates {
+		color.New(color.FgMagenta).Printf("   🧹 Source duplicates removed: %d files\n", len(summary.PurgedSources))
+	}
+	if summary.Errors > 0 {
+		color.New(color.FgRed).Printf("   ❌ Errors: %d files\n", summary.Errors)
+	} else {
+		color.New(color.FgGreen).Printf("   ❌ Errors: %d files\n", summary.Errors)
+	}
+	if len(summary.Mislabeled) > 0 {
+		color.New(color.FgYellow).Printf("   🏷️  Mislabeled: %d files (content doesn't match the extension; listed in the report)\n", len(summary.Mislabeled))
+	}
+	if summary.MirrorErrors > 0 {
+		color.New(color.FgRed).Printf("   🪞 Mirror copies failed: %d files (backed up, but not mirrored; listed in the report)\n", summary.MirrorErrors)
+	}
+	if len(summary.NearDuplicates) > 0 {
+		color.New(color.FgYellow).Printf("   👯 Near-duplicates: %d images (copied, but they look like stored images; listed in the report)\n", len(summary.NearDuplicates))
+	}
+	if len(summary.PermissionDenied) > 0 {
+		color.New(color.FgRed).Printf("   🔒 Permission denied: %d paths (check their permissions; listed in the report)\n", len(summary.PermissionDenied))
+	}
+	color.New(color.FgCyan).Printf("   📁 Total Processed: %d files\n", totalProcessed)
+	mbPerSec, filesPerSec := throughput(summary, totalTime)
+	color.New(color.FgCyan).Printf("   ⏱️  Time: %s (%.1f MB/s copied, %.1f files/s processed)\n", formatDuration(totalTime), mbPerSec, filesPerSec)
+	r.printExtensionStats(summary)
+
+	if totalAccounted == totalProcessed {
+		color.New(color.FgGreen, color.Bold).Printf("   ✔ All files accounted for!\n")
+	} else {
+		color.New(color.FgRed, color.Bold).Printf("   ✖ Mismatch! Accounted: %d, Processed: %d\n", totalAccounted, totalProcessed)
+	}
+
+	fmt.Println()
+	color.New(color.FgBlue, color.Bold).Printf("📄 Report Generated\n")
+	// Print clickable link to HTML report (file://...)
+	reportAbs, err := filepath.Abs(reportPath)
+	if err == nil {
+		link := fmt.Sprintf("file://%s", reportAbs)
+		// ANSI hyperlink: \x1b]8;;<url>\x1b\\<text>\x1b]8;;\x1b\\
+		ansiLink := fmt.Sprintf("\x1b]8;;%s\x1b\\%s\x1b]8;;\x1b\\", link, link)
+		color.New(color.FgCyan).Printf("   📄 HTML report: %s\n", ansiLink)
+	} else {
+		color.New(color.FgCyan).Printf("   📄 HTML report: %s\n", reportPath)
+	}
+	if r.opts.Formats.JSON {
+		color.New(color.FgCyan).Printf("   📄 JSON report: %s\n", jsonReportPath(reportPath))
+	}
+	if r.opts.Formats.CSV {
+		color.New(color.FgCyan).Printf("   📄 CSV report: %s\n", csvReportPath(reportPath))
+	}
+	if manifest {
+		color.New(color.FgCyan).Printf("   📄 Checksums: %s\n", filepath.Join(destDir, manifestName))
+	}
+}
+
+// printExtensionStats prints the per-extension breakdown under the final results, preceded by
+// the photo/video split with --separate-media
+func (r *backupRun) printExtensionStats(summary AccountingSummary) {
+	if r.opts.SeparateMedia {
+		printOutcomes("By media type", summary.mediaTypes(r.mediaFolderForExt))
+	}
+	printOutcomes("By extension", summary.Extensions())
+}
+
+// printOutcomes prints one line of copied, duplicate, skipped, and error counts per group
+func printOutcomes(title string, groups []*ExtensionStats) {
+	if len(groups) == 0 {
+		return
+	}
+	color.New(color.FgCyan).Printf("   📂 %s:\n", title)
+	for _, stats := range groups {
+		var parts []string
+		if stats.Copied > 0 {
+			parts = append(parts, fmt.Sprintf("%d copied (%s)", stats.Copied, FormatFileSize(stats.Bytes)))
+		}
+		if stats.Duplicates > 0 {
+			parts = append(parts, fmt.Sprintf("%d duplicates", stats.Duplicates))
+		}
+		if stats.Skipped > 0 {
+			parts = append(parts, fmt.Sprintf("%d skipped", stats.Skipped))
+		}
+		if stats.Errors > 0 {
+			parts = append(parts, fmt.Sprintf("%d errors", stats.Errors))
+		}
+		fmt.Printf("      %s: %s\n", stats.Extension, strings.Join(parts, ", "))
+	}
+}
+
+// printFileResult prints one line per processed file in --verbose mode
+func (v Verbosity) printFileResult(result *FileResult) {
+	if v != VerbosityVerbose || result == nil {
+		return
+	}
+	switch {
+	case result.Error != nil:
+		fmt.Printf("%s: %s: %v\n", result.State, result.Path, result.Error)
+	case result.State == StateCopied:
+		fmt.Printf("copied: %s -> %s\n", result.Path, result.DestPath)
+	case result.State == StateDuplicateHash:
+		fmt.Printf("duplicate: %s (same as %s)\n", result.Path, result.ExistingDuplicatePath)
+	default:
+		fmt.Printf("%s: %s\n", result.State, result.Path)
+	}
+	if note := liveVideoNote(result.LiveVideo); note != "" && result.Error == nil {
+		fmt.Printf("  %s\n", note)
+	}
+}
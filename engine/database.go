@@ -0,0 +1,904 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+
+	_ "modernc.org/sqlite"
+)
+
+// FileRecord represents a file record for batch insertion
+type FileRecord struct {
+	SrcPath  string
+	DestPath string
+	Hash     string
+	HashAlgo string
+	Size     int64
+	Mtime    int64
+	CopiedAt string
+	RunID    string
+	// DedupMethod records how the file was checked for duplicates before it was copied
+	DedupMethod string
+	// TakenAt is the date the file was filed under (Unix seconds), 0 when unknown
+	TakenAt int64
+	// Camera is the camera or device named in the file's metadata, "" if unknown
+	Camera string
+	// Location is where a photo was taken according to its EXIF GPS tags, nil if unknown
+	Location *metadata.Location
+	// BurstID is shared by every frame of one burst (see metadata.exifBurstID), "" if not a burst
+	BurstID string
+	// OrigExt is the source's extension when the file was stored converted (e.g. ".heic"), else ""
+	// Hash is then the hash of the original, not of the stored file
+	OrigExt string
+}
+
+// JournalEntry records a source file that was fully processed by a run that has not finished yet
+// Entries are keyed by source path and only trusted while size and mtime still match. A completed
+// run leaves only retry entries (State journalRetry) for the paths it failed on
+type JournalEntry struct {
+	SrcPath  string
+	DestPath string
+	Size     int64
+	Mtime    int64
+	State    string
+}
+
+// journalRetry is the state of a journal entry for a path the last completed run failed on; incremental
+// mode looks at it again even when it is older than the source's high-water mark
+const journalRetry = "retry"
+
+// HashCacheEntry remembers the hash of a source file as it was when last read
+// The hash is only reused while the file's size and mtime are unchanged
+type HashCacheEntry struct {
+	SrcPath string
+	Size    int64
+	Mtime   int64
+	Hash    string
+}
+
+// BatchInserter handles batch insertion of file records for performance
+type BatchInserter struct {
+	db          *sql.DB
+	hashToPath  map[string]string
+	hashAlgo    string // Algorithm used for every hash in this run
+	runID       string // Tags every record written by this run (see runs/rollback)
+	records     []FileRecord
+	journal     []JournalEntry            // Pending journal entries, committed with records
+	processed   map[string]JournalEntry   // Journal left by an interrupted run (read-only)
+	retries     map[string]bool           // Paths earlier runs failed on (read-only)
+	hashCache   map[string]HashCacheEntry // Source hashes from earlier runs (read-only once processing starts)
+	newHashes   []HashCacheEntry          // Pending hash cache entries, committed with records
+	claimed     map[string]bool           // Destination paths reserved by workers in this run
+	zipSources  map[string]string         // Files extracted from source zips -> their entry (see sourceLabel)
+	quickIndex  map[string]string         // Name+size+mtime -> destination, for --hash-only-videos
+	destRoot    string                    // Destination paths are stored relative to this (see destpaths.go)
+	sampleIndex map[string]string         // Sampled checksum -> destination, for --checksum-sample
+	unsampled   *sizeIndex                // Sizes of large stored files with no sampled checksum to match
+	newSamples  []SampleEntry             // Pending sampled checksums, committed with records
+	sizes       *sizeIndex                // Sizes of stored files, to skip hashing new content before its copy
+	console     *log.Logger               // Where load and flush failures are printed (see Verbosity.warnings)
+	mutex       sync.Mutex
+	batchSize   int
+}
+
+// DefaultBatchSize is how many files are recorded per database transaction (--batch-size)
+// Each commit makes the progress so far durable, so an interrupted or crashed run loses at most one batch
+const DefaultBatchSize = 500
+
+// NewBatchInserter creates a new batch inserter
+func NewBatchInserter(db *sql.DB, hashToPath map[string]string, hashAlgo, runID string, batchSize int, console *log.Logger) *BatchInserter {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	processed, retries := loadJournal(db, console)
+	return &BatchInserter{
+		db:         db,
+		hashToPath: hashToPath,
+		hashAlgo:   hashAlgo,
+		runID:      runID,
+		records:    make([]FileRecord, 0, batchSize),
+		journal:    make([]JournalEntry, 0, batchSize),
+		processed:  processed,
+		retries:    retries,
+		hashCache:  loadHashCache(db, hashAlgo, console),
+		newHashes:  make([]HashCacheEntry, 0, batchSize),
+		claimed:    make(map[string]bool),
+		sizes:      loadSizeIndex(db, hashAlgo, console),
+		destRoot:   destRootOf(db),
+		batchSize:  batchSize,
+		console:    console,
+	}
+}
+
+// AlreadyProcessed reports whether an interrupted run already finished this source file
+// The map is only read after construction, so no locking is needed
+func (bi *BatchInserter) AlreadyProcessed(path string, size, mtime int64) (JournalEntry, bool) {
+	entry, exists := bi.processed[bi.sourceLabel(path)]
+	if !exists || entry.Size != size || entry.Mtime != mtime {
+		return JournalEntry{}, false
+	}
+	return entry, true
+}
+
+// ResumedCount returns how many journal entries were left by an interrupted run
+func (bi *BatchInserter) ResumedCount() int {
+	return len(bi.processed)
+}
+
+// Retries returns the paths earlier runs failed on, for FileFilter.Retry
+func (bi *BatchInserter) Retries() map[string]bool {
+	return bi.retries
+}
+
+// MarkProcessed queues a journal entry for a finished source file
+// Entries are written in the same transaction as file records so a resumed run never
+// skips a copied file whose database record was lost
+func (bi *BatchInserter) MarkProcessed(src, dest string, size, mtime int64, state FileState) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+
+	bi.journal = append(bi.journal, JournalEntry{
+		SrcPath:  bi.sourceLabel(src),
+		DestPath: dest,
+		Size:     size,
+		Mtime:    mtime,
+		State:    state.String(),
+	})
+
+	if len(bi.journal) >= bi.batchSize {
+		bi.flushUnsafeWithContext(context.Background())
+	}
+}
+
+// CachedHash returns the hash cached for a source file if its size and mtime still match
+// The map is only written before workers start, so no locking is needed
+func (bi *BatchInserter) CachedHash(path string, size, mtime int64) (string, bool) {
+	entry, exists := bi.hashCache[bi.sourceLabel(path)]
+	if !exists || entry.Size != size || entry.Mtime != mtime {
+		return "", false
+	}
+	return entry.Hash, true
+}
+
+// StoredPaths returns the destination path of every file recorded in the database (and in
+// --known-db backups); it must be called before workers start adding records
+func (bi *BatchInserter) StoredPaths() map[string]bool {
+	paths := make(map[string]bool, len(bi.hashToPath))
+	for _, path := range bi.hashToPath {
+		paths[path] = true
+	}
+	for _, path := range bi.quickIndex {
+		paths[path] = true
+	}
+	return paths
+}
+
+// RememberHash makes a hash computed before processing (--prefer-date) available to CachedHash
+// and queues it like CacheHash; it must only be called before workers start reading the cache
+func (bi *BatchInserter) RememberHash(path string, size, mtime int64, hash string) {
+	bi.hashCache[bi.sourceLabel(path)] = HashCacheEntry{SrcPath: bi.sourceLabel(path), Size: size, Mtime: mtime, Hash: hash}
+	bi.CacheHash(path, size, mtime, hash)
+}
+
+// CacheHash queues a freshly computed source hash so later runs can skip re-reading the file
+func (bi *BatchInserter) CacheHash(path string, size, mtime int64, hash string) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+
+	bi.newHashes = append(bi.newHashes, HashCacheEntry{
+		SrcPath: bi.sourceLabel(path),
+		Size:    size,
+		Mtime:   mtime,
+		Hash:    hash,
+	})
+
+	if len(bi.newHashes) >= bi.batchSize {
+		bi.flushUnsafeWithContext(context.Background())
+	}
+}
+
+// ClaimDest reserves a destination path for one worker; false means another worker already has it
+// This keeps two same-named source files from being copied over each other in a single run
+func (bi *BatchInserter) ClaimDest(path string) bool {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	if bi.claimed[path] {
+		return false
+	}
+	bi.claimed[path] = true
+	return true
+}
+
+// Lookup returns the destination path already recorded for a hash, if any
+// Safe to call from multiple workers while other workers are adding records
+func (bi *BatchInserter) Lookup(hash string) (string, bool) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	existingPath, exists := bi.hashToPath[hash]
+	return existingPath, exists
+}
+
+// QuickLookup returns the destination of a backed up file with the same name, size, and mtime
+// Used instead of Lookup for extensions trusted by size and mtime (--hash-only-videos)
+func (bi *BatchInserter) QuickLookup(src string, size, mtime int64) (string, bool) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	existingPath, exists := bi.quickIndex[quickKey(src, size, mtime)]
+	return existingPath, exists
+}
+
+// SampleLookup returns the destination of a backed up file with the same sampled checksum
+// Used instead of Lookup for large files when --checksum-sample is set
+func (bi *BatchInserter) SampleLookup(sample string) (string, bool) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	existingPath, exists := bi.sampleIndex[sample]
+	return existingPath, exists
+}
+
+// MightBeStoredUnsampled reports whether a stored file of this size has no sampled checksum to
+// compare with, so a large file of this size has to be hashed in full to be checked against it
+func (bi *BatchInserter) MightBeStoredUnsampled(size int64) bool {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	return bi.unsampled != nil && bi.unsampled.mightMatch(size)
+}
+
+// MightBeStored reports whether a stored file has this size, so a file of this size may be a
+// duplicate and has to be hashed before it is copied
+func (bi *BatchInserter) MightBeStored(size int64) bool {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	return bi.sizes.mightMatch(size)
+}
+
+// RecordSample remembers the sampled checksum of a file just added with its full hash
+func (bi *BatchInserter) RecordSample(sample, hash, dest string) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+
+	bi.sampleIndex[sample] = dest
+	bi.newSamples = append(bi.newSamples, SampleEntry{Sample: sample, Hash: hash})
+}
+
+// Add adds a file record to the batch
+// origExt is the source's extension when the stored bytes are a conversion of it (HEIC stored as
+// JPEG), else ""; a file only renamed (--check-content-type fix) is stored as is and gets ""
+// If another worker already claimed the same hash, nothing is added and the existing
+// destination path is returned with added=false so the caller can treat it as a duplicate
+func (bi *BatchInserter) Add(src, dest, hash string, size, mtime int64, date time.Time, camera string, location *metadata.Location, burstID, dedupMethod, origExt string) (existingPath string, added bool) {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+
+	if existing, exists := bi.hashToPath[hash]; exists {
+		return existing, false
+	}
+
+	// Add to hash map immediately for duplicate detection
+	bi.hashToPath[hash] = dest
+	bi.sizes.sizes[size] = true
+	if bi.quickIndex != nil {
+		bi.quickIndex[quickKey(src, size, mtime)] = dest
+	}
+
+	// Add to batch; files extracted from a zip are recorded by their entry, not the temp file
+	bi.records = append(bi.records, FileRecord{
+		SrcPath:     bi.sourceLabel(src),
+		DestPath:    dest,
+		Hash:        hash,
+		HashAlgo:    bi.hashAlgo,
+		Size:        size,
+		Mtime:       mtime,
+		CopiedAt:    time.Now().Format(time.RFC3339),
+		RunID:       bi.runID,
+		DedupMethod: dedupMethod,
+		Camera:      camera,
+		Location:    location,
+		BurstID:     burstID,
+		OrigExt:     origExt,
+	})
+	if !date.IsZero() {
+		bi.records[len(bi.records)-1].TakenAt = date.Unix()
+	}
+
+	// Flush if batch is full
+	if len(bi.records) >= bi.batchSize {
+		bi.flushUnsafeWithContext(context.Background())
+	}
+	return dest, true
+}
+
+// Withdraw takes back a file added by this run, so it counts as never backed up
+// Its record is dropped from the pending batch, or deleted if the batch was already committed,
+// along with the journal entry and cached hash of its source
+func (bi *BatchInserter) Withdraw(src, dest, hash string, size, mtime int64) error {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+
+	if bi.hashToPath[hash] == dest {
+		delete(bi.hashToPath, hash)
+	}
+	if bi.quickIndex != nil && bi.quickIndex[quickKey(src, size, mtime)] == dest {
+		delete(bi.quickIndex, quickKey(src, size, mtime))
+	}
+	label := bi.sourceLabel(src)
+	bi.journal = slices.DeleteFunc(bi.journal, func(entry JournalEntry) bool { return entry.SrcPath == label })
+	bi.newHashes = slices.DeleteFunc(bi.newHashes, func(entry HashCacheEntry) bool { return entry.SrcPath == label })
+	for i, record := range bi.records {
+		if record.Hash == hash && record.DestPath == dest {
+			bi.records = append(bi.records[:i], bi.records[i+1:]...)
+			return nil
+		}
+	}
+
+	tx, err := bi.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM files WHERE hash = ? AND hash_algo = ? AND dest_path = ? AND run_id = ?", hash, bi.hashAlgo, relativeDestPath(bi.destRoot, dest), bi.runID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM journal WHERE src_path = ?", label); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM hash_cache WHERE src_path = ? AND hash_algo = ?", label, bi.hashAlgo); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Flush flushes any remaining records to the database
+func (bi *BatchInserter) Flush() {
+	bi.FlushWithContext(context.Background())
+}
+
+// FlushWithContext flushes any remaining records to the database with context cancellation support
+// Returns an error if the pending records could not be committed
+func (bi *BatchInserter) FlushWithContext(ctx context.Context) error {
+	bi.mutex.Lock()
+	defer bi.mutex.Unlock()
+	return bi.flushUnsafeWithContext(ctx)
+}
+
+// flushUnsafe flushes records without locking (caller must hold mutex)
+func (bi *BatchInserter) flushUnsafe() {
+	bi.flushUnsafeWithContext(context.Background())
+}
+
+// flushUnsafeWithContext flushes records without locking and with context cancellation support
+func (bi *BatchInserter) flushUnsafeWithContext(ctx context.Context) error {
+	if len(bi.records) == 0 && len(bi.journal) == 0 && len(bi.newHashes) == 0 {
+		return nil
+	}
+
+	// Check if context is already cancelled before starting
+	if ctx.Err() != nil {
+		bi.console.Printf("Batch insert: context cancelled, skipping flush")
+		return ctx.Err()
+	}
+
+	tx, err := bi.db.Begin()
+	if err != nil {
+		bi.console.Printf("Batch insert: failed to begin transaction: %v", err)
+		return err
+	}
+
+	// Check context after beginning transaction
+	if ctx.Err() != nil {
+		bi.console.Printf("Batch insert: context cancelled during transaction begin")
+		tx.Rollback()
+		return ctx.Err()
+	}
+
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO files (src_path, dest_path, hash, hash_algo, size, mtime, copied_at, run_id, dedup_method, taken_at, orig_ext, camera, latitude, longitude, burst_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		bi.console.Printf("Batch insert: failed to prepare statement: %v", err)
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	// Insert records with periodic context checks
+	for i, record := range bi.records {
+		// Check context every 100 records to avoid excessive overhead
+		if i%100 == 0 && ctx.Err() != nil {
+			bi.console.Printf("Batch insert: context cancelled during execution at record %d", i)
+			tx.Rollback()
+			return ctx.Err()
+		}
+
+		latitude, longitude := locationColumns(record.Location)
+		_, err := stmt.Exec(record.SrcPath, relativeDestPath(bi.destRoot, record.DestPath), record.Hash, record.HashAlgo, record.Size, record.Mtime, record.CopiedAt, record.RunID, record.DedupMethod, sql.NullInt64{Int64: record.TakenAt, Valid: record.TakenAt != 0}, sql.NullString{String: record.OrigExt, Valid: record.OrigExt != ""}, sql.NullString{String: record.Camera, Valid: record.Camera != ""}, latitude, longitude, sql.NullString{String: record.BurstID, Valid: record.BurstID != ""})
+		if err != nil {
+			bi.console.Printf("Batch insert: failed to execute statement: %v", err)
+		}
+	}
+
+	journalStmt, err := tx.Prepare("INSERT OR REPLACE INTO journal (src_path, dest_path, size, mtime, state) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		bi.console.Printf("Batch insert: failed to prepare journal statement: %v", err)
+		tx.Rollback()
+		return err
+	}
+	defer journalStmt.Close()
+
+	for _, entry := range bi.journal {
+		if _, err := journalStmt.Exec(entry.SrcPath, entry.DestPath, entry.Size, entry.Mtime, entry.State); err != nil {
+			bi.console.Printf("Batch insert: failed to write journal entry: %v", err)
+		}
+	}
+
+	cacheStmt, err := tx.Prepare("INSERT OR REPLACE INTO hash_cache (src_path, hash_algo, size, mtime, hash) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		bi.console.Printf("Batch insert: failed to prepare hash cache statement: %v", err)
+		tx.Rollback()
+		return err
+	}
+	defer cacheStmt.Close()
+
+	for _, entry := range bi.newHashes {
+		if _, err := cacheStmt.Exec(entry.SrcPath, bi.hashAlgo, entry.Size, entry.Mtime, entry.Hash); err != nil {
+			bi.console.Printf("Batch insert: failed to write hash cache entry: %v", err)
+		}
+	}
+
+	// Records are inserted above, so their sampled checksums can be attached in the same transaction
+	for _, entry := range bi.newSamples {
+		if _, err := tx.Exec("UPDATE files SET sample_hash = ? WHERE hash = ?", entry.Sample, entry.Hash); err != nil {
+			bi.console.Printf("Batch insert: failed to write sampled checksum: %v", err)
+		}
+	}
+
+	// Final context check before commit
+	if ctx.Err() != nil {
+		bi.console.Printf("Batch insert: context cancelled before commit")
+		tx.Rollback()
+		return ctx.Err()
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		bi.console.Printf("Batch insert: failed to commit transaction: %v", err)
+		tx.Rollback()
+		return err
+	}
+	bi.console.Printf("Batch inserted %d records", len(bi.records))
+
+	// Clear the batch
+	bi.records = bi.records[:0]
+	bi.journal = bi.journal[:0]
+	bi.newHashes = bi.newHashes[:0]
+	bi.newSamples = bi.newSamples[:0]
+	return nil
+}
+
+// memoryDBPath is the database --no-db uses; the shared cache lets every pooled connection see
+// the same data, which is gone once the run closes it
+const memoryDBPath = "file:backupbozo?mode=memory&cache=shared"
+
+// OpenDatabase opens a backup database, creating it or upgrading its schema as needed
+func OpenDatabase(dbPath string) (*sql.DB, error) {
+	dsn := dbPath
+	if dbPath != memoryDBPath {
+		dsn = databaseDSN(dbPath, "")
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+	// A newer build may have changed the meaning of what it stored: nothing is written here
+	if err := checkSchemaVersion(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't use database %s: %w", dbPath, err)
+	}
+	sqlStmt := `
+	CREATE TABLE IF NOT EXISTS files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		src_path TEXT,
+		dest_path TEXT,
+		hash TEXT UNIQUE,
+		hash_algo TEXT,
+		size INTEGER,
+		mtime INTEGER,
+		copied_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_hash ON files(hash);
+	CREATE TABLE IF NOT EXISTS journal (
+		src_path TEXT PRIMARY KEY,
+		dest_path TEXT,
+		size INTEGER,
+		mtime INTEGER,
+		state TEXT
+	);
+	CREATE TABLE IF NOT EXISTS hash_cache (
+		src_path TEXT,
+		hash_algo TEXT,
+		size INTEGER,
+		mtime INTEGER,
+		hash TEXT,
+		PRIMARY KEY (src_path, hash_algo)
+	);
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	);
+	CREATE TABLE IF NOT EXISTS manifest_cache (
+		dest_path TEXT PRIMARY KEY,
+		size INTEGER,
+		mtime INTEGER,
+		sha256 TEXT
+	);
+	CREATE TABLE IF NOT EXISTS source_runs (
+		src_dir TEXT,
+		run_id TEXT,
+		started_at TEXT,
+		scan_key TEXT,
+		PRIMARY KEY (src_dir, run_id)
+	);
+	`
+	_, err = db.Exec(sqlStmt)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize database schema: %w", err)
+	}
+
+	// Columns added since a database was created, and whatever later versions need
+	if err := migrateSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not upgrade database schema: %w", err)
+	}
+	return db, nil
+}
+
+// ensureColumn adds a column to an existing table if it is not already present
+func ensureColumn(db *sql.DB, table, column, columnType string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, ctype string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
+	return err
+}
+
+// loadExistingHashes loads all existing file hashes from the database into a map for O(1) lookup
+// This eliminates the need for per-file database queries during duplicate detection
+// Only hashes produced by hashAlgo are loaded; rows without an algorithm predate the column and are MD5
+func loadExistingHashes(db *sql.DB, hashAlgo string, console *log.Logger) map[string]string {
+	hashToPath := make(map[string]string)
+
+	rows, err := db.Query("SELECT hash, dest_path FROM files WHERE hash IS NOT NULL AND COALESCE(hash_algo, 'md5') = ?", hashAlgo)
+	if err != nil {
+		console.Printf("Warning: Could not load existing hashes: %v", err)
+		return hashToPath
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	for rows.Next() {
+		var hash, destPath string
+		if err := rows.Scan(&hash, &destPath); err != nil {
+			console.Printf("Warning: Error scanning hash and path: %v", err)
+			continue
+		}
+		hashToPath[hash] = resolveDestPath(root, destPath)
+	}
+
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating hashes: %v", err)
+	}
+
+	console.Printf("Loaded %d existing hashes into memory", len(hashToPath))
+	return hashToPath
+}
+
+// databaseDSN is the URI SQLite opens a database file by, with query (e.g. "mode=ro") as its
+// parameters. The path is made absolute and escaped: the driver splits its own parameters off at
+// the first "?", and SQLite reads "#" or "%" in a URI as syntax
+func databaseDSN(path, query string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // file:///C:/... on Windows
+	}
+	return (&url.URL{Scheme: "file", Path: path, RawQuery: query}).String()
+}
+
+// readOnlyDSN opens a database file read-only
+func readOnlyDSN(path string) string {
+	return databaseDSN(path, "mode=ro")
+}
+
+// OpenDatabaseReadOnly opens a backup database for reading, without creating or upgrading it
+func OpenDatabaseReadOnly(path string) (*sql.DB, error) {
+	return sql.Open("sqlite", readOnlyDSN(path))
+}
+
+// mergeKnownDatabases adds the hashes (and, for --hash-only-videos, names) stored in other
+// backups' databases so content already archived elsewhere counts as a duplicate
+// Entries from the primary database win; known databases are opened read-only and never written
+// Stored paths that only a known database has are added to knownDBPaths
+func mergeKnownDatabases(paths []string, hashAlgo string, hashToPath, quickIndex map[string]string, sizes, unsampled *sizeIndex, knownDBPaths map[string]bool, console *log.Logger) {
+	for _, path := range paths {
+		db, err := sql.Open("sqlite", readOnlyDSN(path))
+		if err != nil {
+			console.Printf("Warning: Could not open known database %s: %v", path, err)
+			continue
+		}
+		added := 0
+		for hash, dest := range loadExistingHashes(db, hashAlgo, console) {
+			if _, exists := hashToPath[hash]; !exists {
+				hashToPath[hash] = dest
+				knownDBPaths[dest] = true
+				added++
+			}
+		}
+		sizes.merge(db, hashAlgo, console)
+		if unsampled != nil {
+			unsampled.merge(db, hashAlgo, console) // Their sampled checksums aren't loaded, so every file counts
+		}
+		if quickIndex != nil {
+			for key, dest := range loadQuickIndex(db, console) {
+				if _, exists := quickIndex[key]; !exists {
+					quickIndex[key] = dest
+					knownDBPaths[dest] = true
+				}
+			}
+		}
+		db.Close()
+		console.Printf("Loaded %d hashes from known database %s", added, path)
+	}
+}
+
+// quickKey identifies a source file by name, size, and mtime for --hash-only-videos
+func quickKey(src string, size, mtime int64) string {
+	return fmt.Sprintf("%s|%d|%d", strings.ToLower(filepath.Base(src)), size, mtime)
+}
+
+// loadQuickIndex maps the name, size, and mtime of every backed up source file to its destination
+func loadQuickIndex(db *sql.DB, console *log.Logger) map[string]string {
+	index := make(map[string]string)
+
+	// Files recorded by index have no source, so their own name stands in for it
+	rows, err := db.Query("SELECT COALESCE(NULLIF(src_path, ''), dest_path), dest_path, size, mtime FROM files WHERE dest_path IS NOT NULL AND size IS NOT NULL AND mtime IS NOT NULL")
+	if err != nil {
+		console.Printf("Warning: Could not load backed up file names: %v", err)
+		return index
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	for rows.Next() {
+		var src, dest string
+		var size, mtime int64
+		if err := rows.Scan(&src, &dest, &size, &mtime); err != nil {
+			console.Printf("Warning: Error scanning backed up file: %v", err)
+			continue
+		}
+		index[quickKey(src, size, mtime)] = resolveDestPath(root, dest)
+	}
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating backed up files: %v", err)
+	}
+	return index
+}
+
+// loadJournal loads the progress journal left behind by an interrupted run
+func loadJournal(db *sql.DB, console *log.Logger) (processed map[string]JournalEntry, retries map[string]bool) {
+	processed = make(map[string]JournalEntry)
+	retries = make(map[string]bool)
+
+	rows, err := db.Query("SELECT src_path, dest_path, size, mtime, state FROM journal")
+	if err != nil {
+		console.Printf("Warning: Could not load progress journal: %v", err)
+		return processed, retries
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry JournalEntry
+		var destPath sql.NullString
+		if err := rows.Scan(&entry.SrcPath, &destPath, &entry.Size, &entry.Mtime, &entry.State); err != nil {
+			console.Printf("Warning: Error scanning journal entry: %v", err)
+			continue
+		}
+		entry.DestPath = destPath.String
+		if entry.State == journalRetry {
+			retries[entry.SrcPath] = true
+			continue
+		}
+		processed[entry.SrcPath] = entry
+	}
+
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating journal: %v", err)
+	}
+	return processed, retries
+}
+
+// loadHashCache loads cached source hashes made with hashAlgo, keyed by source path
+// Backed up files count too: their record's source path, size, and mtime vouch for the hash,
+// which covers files stored before the cache existed; a hash_cache entry wins over a record
+func loadHashCache(db *sql.DB, hashAlgo string, console *log.Logger) map[string]HashCacheEntry {
+	cache := make(map[string]HashCacheEntry)
+
+	rows, err := db.Query(`SELECT src_path, size, mtime, hash FROM files
+		WHERE src_path IS NOT NULL AND hash IS NOT NULL AND size IS NOT NULL AND mtime IS NOT NULL AND COALESCE(hash_algo, 'md5') = ?
+		UNION ALL
+		SELECT src_path, size, mtime, hash FROM hash_cache WHERE hash_algo = ?`, hashAlgo, hashAlgo)
+	if err != nil {
+		console.Printf("Warning: Could not load hash cache: %v", err)
+		return cache
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry HashCacheEntry
+		if err := rows.Scan(&entry.SrcPath, &entry.Size, &entry.Mtime, &entry.Hash); err != nil {
+			console.Printf("Warning: Error scanning hash cache entry: %v", err)
+			continue
+		}
+		cache[entry.SrcPath] = entry
+	}
+
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating hash cache: %v", err)
+	}
+	return cache
+}
+
+// resetJournal replaces the progress journal once a run completes without interruption with retry
+// entries for the paths it failed on, so the next incremental run looks at them again
+func resetJournal(db *sql.DB, retries []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM journal"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, path := range retries {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO journal (src_path, size, mtime, state) VALUES (?, 0, 0, ?)", path, journalRetry); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nextRetries lists the retry entries a completed run leaves: the paths it failed on, and the retries
+// of earlier runs it didn't look at (other sources sharing the database, or the rest of the source
+// when only some files were backed up, as in a watch batch)
+func nextRetries(previous map[string]bool, srcDir string, only []string, unfinished []string) []string {
+	looked := make(map[string]bool, len(only))
+	for _, path := range only {
+		looked[path] = true
+	}
+	retries := unfinished
+	for path := range previous {
+		inSource := path == srcDir || pathContains(srcDir, path)
+		if !inSource || (only != nil && !looked[path]) {
+			retries = append(retries, path)
+		}
+	}
+	return retries
+}
+
+// deleteFileRecords removes the database rows for the given content hashes in one transaction
+func deleteFileRecords(db *sql.DB, hashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("DELETE FROM files WHERE hash = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, hash := range hashes {
+		if _, err := stmt.Exec(hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// sourceKey is how a source folder is stored in source_runs: absolute and cleaned, so ./DCIM
+// and ~/DCIM given from different working directories still name the same folder
+func sourceKey(srcDir string) string {
+	if abs, err := filepath.Abs(srcDir); err == nil {
+		return abs
+	}
+	return filepath.Clean(srcDir)
+}
+
+// SourceBackupTime returns when the last complete backup of srcDir started, or zero if this
+// source was never fully backed up into this database. Incremental mode skips files older than
+// this, so each source keeps its own high-water mark even when several share a destination
+func SourceBackupTime(db *sql.DB, srcDir string) (time.Time, error) {
+	return latestSourceRun(db, "SELECT started_at FROM source_runs WHERE src_dir = ?", sourceKey(srcDir))
+}
+
+// sourceMark is SourceBackupTime for runs that walked the source the way scanKey describes; marks
+// from before scan keys were recorded match none, so such a source is scanned in full once
+func sourceMark(db *sql.DB, srcDir, scanKey string) (time.Time, error) {
+	return latestSourceRun(db, "SELECT started_at FROM source_runs WHERE src_dir = ? AND scan_key = ?", sourceKey(srcDir), scanKey)
+}
+
+// latestSourceRun returns the latest start time among the source_runs rows a query selects
+// Older builds stored times with the machine's UTC offset, which don't sort as strings across a
+// DST or time zone change, so every row is parsed and compared as a time
+func latestSourceRun(db *sql.DB, query string, args ...interface{}) (time.Time, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rows.Close()
+
+	var latest time.Time
+	for rows.Next() {
+		var startedAt sql.NullString
+		if err := rows.Scan(&startedAt); err != nil {
+			return time.Time{}, err
+		}
+		if !startedAt.Valid || startedAt.String == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, startedAt.String)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, rows.Err()
+}
+
+// recordSourceRun advances srcDir's high-water mark for runs with the same scanKey to the start
+// of a completed run. Times are stored in UTC
+func recordSourceRun(db *sql.DB, srcDir, runID, scanKey string, startedAt time.Time) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO source_runs (src_dir, run_id, started_at, scan_key) VALUES (?, ?, ?, ?)",
+		sourceKey(srcDir), runID, startedAt.UTC().Format(time.RFC3339), scanKey)
+	return err
+}
+
+// deleteSourceRuns forgets the high-water marks set by a run, so a rolled back source is rescanned
+func deleteSourceRuns(db *sql.DB, runID string) error {
+	_, err := db.Exec("DELETE FROM source_runs WHERE run_id = ?", runID)
+	return err
+}
@@ -0,0 +1,86 @@
+// backupbozo tests for opening backup databases
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDatabaseDSNSpecialCharacters opens databases whose paths contain URI syntax, both for writing
+// and read-only, and checks they are the file at that exact path
+func TestDatabaseDSNSpecialCharacters(t *testing.T) {
+	for _, name := range []string{"what?.db", "100%.db", "#1.db", "my backup.db", "a?b#c%d e.db"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			db, err := OpenDatabase(path)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			db.Close()
+			if _, err := os.Stat(path); err != nil {
+				t.Fatalf("database not created at %s: %v", path, err)
+			}
+
+			ro, err := OpenDatabaseReadOnly(path)
+			if err != nil {
+				t.Fatalf("open read-only: %v", err)
+			}
+			defer ro.Close()
+			version, err := readSchemaVersion(ro)
+			if err != nil {
+				t.Fatalf("read-only database %s: %v", path, err)
+			}
+			if version != schemaVersion {
+				t.Errorf("expected schema version %d, got %d", schemaVersion, version)
+			}
+			if _, err := ro.Exec("CREATE TABLE scratch (id INTEGER)"); err == nil {
+				t.Error("read-only database accepted a write")
+			}
+		})
+	}
+}
+
+// TestSourceMark records runs under different UTC offsets and scan keys and checks the mark is
+// the latest run in time, and only counts runs that walked the source the same way
+func TestSourceMark(t *testing.T) {
+	db, err := OpenDatabase(filepath.Join(t.TempDir(), "backupbozo.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	src := t.TempDir()
+
+	// Stored as an older build did: the later run sorts first as a string
+	earlier := time.Date(2026, 3, 8, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	later := time.Date(2026, 3, 8, 9, 0, 0, 0, time.UTC)
+	for i, started := range []time.Time{earlier, later} {
+		if _, err := db.Exec("INSERT INTO source_runs (src_dir, run_id, started_at, scan_key) VALUES (?, ?, ?, ?)",
+			sourceKey(src), fmt.Sprintf("run%d", i), started.Format(time.RFC3339), "all"); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	mark, err := sourceMark(db, src, "all")
+	if err != nil {
+		t.Fatalf("source mark: %v", err)
+	}
+	if !mark.Equal(later) {
+		t.Errorf("expected mark %v, got %v", later, mark)
+	}
+
+	// A run that left files out keeps its own mark
+	if err := recordSourceRun(db, src, "run2", "excluded", later.Add(time.Hour)); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if mark, err := sourceMark(db, src, "all"); err != nil || !mark.Equal(later) {
+		t.Errorf("expected mark %v for the full scan, got %v (%v)", later, mark, err)
+	}
+	if mark, err := sourceMark(db, src, "other"); err != nil || !mark.IsZero() {
+		t.Errorf("expected no mark for an unseen scan key, got %v (%v)", mark, err)
+	}
+	if last, err := SourceBackupTime(db, src); err != nil || !last.Equal(later.Add(time.Hour)) {
+		t.Errorf("expected last backup %v, got %v (%v)", later.Add(time.Hour), last, err)
+	}
+}
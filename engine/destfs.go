@@ -0,0 +1,174 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DestFS is every filesystem operation the backup performs on the destination
+// Sources, the database, and reports are always local; only the destination can be remote
+type DestFS interface {
+	Stat(path string) (os.FileInfo, error)
+	Lstat(path string) (os.FileInfo, error)
+	MkdirAll(path string) error
+	Open(path string) (io.ReadCloser, error)
+	Create(path string) (io.WriteCloser, error)
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	Chtimes(path string, atime, mtime time.Time) error
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, uid, gid int) error
+	Link(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	FreeSpace(path string) (uint64, error)
+	TotalSpace(path string) (uint64, error)
+}
+
+// LocalFS is a destination on a local or mounted disk, the default when BackupOptions.DestFS is nil
+func LocalFS() DestFS {
+	return localFS{}
+}
+
+// localFS is a destination on a local or mounted disk
+type localFS struct{}
+
+func (localFS) Stat(path string) (os.FileInfo, error)  { return os.Stat(path) }
+func (localFS) Lstat(path string) (os.FileInfo, error) { return os.Lstat(path) }
+func (localFS) MkdirAll(path string) error             { return os.MkdirAll(path, 0755) }
+func (localFS) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+func (localFS) Create(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+func (localFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+func (localFS) Remove(path string) error             { return os.Remove(path) }
+func (localFS) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+func (localFS) Chmod(path string, mode os.FileMode) error { return os.Chmod(path, mode) }
+func (localFS) Chown(path string, uid, gid int) error     { return os.Chown(path, uid, gid) }
+func (localFS) Link(oldname, newname string) error        { return os.Link(oldname, newname) }
+func (localFS) Symlink(oldname, newname string) error     { return os.Symlink(oldname, newname) }
+func (localFS) FreeSpace(path string) (uint64, error) {
+	return getFreeSpace(path)
+}
+func (localFS) TotalSpace(path string) (uint64, error) {
+	return getTotalSpace(path)
+}
+
+// destination is where a run stores files, with the settings every write there follows
+type destination struct {
+	fs            DestFS
+	archiveFormat string       // --archive mode; "" stores loose files
+	verify        bool         // Re-read every copy before it is renamed into place (--verify-copy)
+	fsync         bool         // Also sync the folder of every stored and created file (--fsync)
+	dirMode       os.FileMode  // Set on created folders (--dir-mode); 0 keeps 0755 less the umask
+	fileMode      os.FileMode  // Set on stored files (--file-mode); 0 keeps 0666 less the umask
+	preserveOwner bool         // Stored files get the owner and group of their source (--preserve-owner)
+	limiter       *rateLimiter // Throttles copies across all workers (--rate-limit); nil means unlimited
+	console       *log.Logger  // Where warnings are printed (see Verbosity.warnings)
+
+	// Archives this run has opened, so each one is read and appended to through one handle
+	archivesMu sync.Mutex
+	archives   map[string]*tarArchive
+}
+
+// newDestination returns a destination on fsys with default settings
+func newDestination(fsys DestFS) *destination {
+	return &destination{fs: fsys, console: log.Default(), archives: make(map[string]*tarArchive)}
+}
+
+// localDestination is a local destination for the commands that only read or prune a backup
+func localDestination() *destination {
+	return newDestination(localFS{})
+}
+
+// isLocal reports whether the destination is on a local or mounted disk
+func (d *destination) isLocal() bool {
+	_, local := d.fs.(localFS)
+	return local
+}
+
+// mkdirAll creates a destination folder, setting --dir-mode on each folder it creates (the umask
+// would otherwise clear bits of it). With --fsync the parent of each created folder is synced,
+// so the new folder is durable too
+func (d *destination) mkdirAll(path string) error {
+	if d.dirMode == 0 && !d.fsync {
+		return d.fs.MkdirAll(path)
+	}
+	var created []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := d.fs.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		created = append(created, dir)
+	}
+	if err := d.fs.MkdirAll(path); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if d.dirMode != 0 {
+			if err := d.fs.Chmod(dir, d.dirMode); err != nil {
+				return err
+			}
+		}
+		if d.fsync {
+			if err := syncDir(filepath.Dir(dir)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncDestDir syncs the folder holding a file just placed in the destination, with --fsync
+// The file itself is always synced before its rename, but without its folder a power loss could
+// still lose its name
+func (d *destination) syncDestDir(path string) error {
+	if !d.fsync {
+		return nil
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to sync folder %s: %w", filepath.Dir(path), err)
+	}
+	return nil
+}
+
+// hashDestFile computes the hash of a destination file's contents with the given algorithm
+func (d *destination) hashDestFile(path, algo string) (string, error) {
+	if archive, member, ok := splitArchiveMember(path); ok {
+		return d.openTarArchive(archive).hashMember(member, algo)
+	}
+	f, err := d.fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashReader(f, algo)
+	// A remote read only reports failure (e.g. a missing file) once the stream is closed
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		return "", closeErr
+	}
+	return hash, err
+}
+
+// destFileInfo is the os.FileInfo for a destination path with no file of its own (an archive member)
+type destFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi destFileInfo) Name() string       { return fi.name }
+func (fi destFileInfo) Size() int64        { return fi.size }
+func (fi destFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi destFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi destFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi destFileInfo) Sys() any           { return nil }
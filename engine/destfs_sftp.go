@@ -0,0 +1,295 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// remoteScheme marks a destination on another machine: sftp://[user@]host[:port]/path
+const remoteScheme = "sftp://"
+
+// IsRemoteDest reports whether a --dest value names a remote destination
+func IsRemoteDest(dest string) bool {
+	return strings.HasPrefix(dest, remoteScheme)
+}
+
+// SFTPFS is a destination on a remote host, reached over one SFTP session
+// The host must already be in ~/.ssh/known_hosts, and login uses the ssh agent or an unencrypted
+// key in ~/.ssh; there is no password prompt, so an unattended backup never blocks on one
+type SFTPFS struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	// The server's optional extensions; without them renames can't replace a file and copies
+	// can't be synced to its disk
+	posixRename bool
+	fsync       bool
+}
+
+// NewSFTPFS connects to an sftp:// destination, returning the filesystem and the remote directory
+// A path starting with /~/ is relative to the remote user's home directory
+func NewSFTPFS(dest string) (*SFTPFS, string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid remote destination %q: %w", dest, err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil, "", fmt.Errorf("remote destination %q has no host", dest)
+	}
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	if username == "" {
+		username = localUsername()
+	}
+	// Never let a destination read as an option to anything it is handed to
+	if strings.HasPrefix(host, "-") || strings.HasPrefix(username, "-") {
+		return nil, "", fmt.Errorf("remote destination %q: host and user can't start with '-'", dest)
+	}
+
+	dir := u.Path
+	switch {
+	case dir == "" || dir == "/~":
+		dir = "."
+	case strings.HasPrefix(dir, "/~/"):
+		dir = strings.TrimPrefix(dir, "/~/")
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(host, port)
+	config, err := sshClientConfig(username, addr)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not connect to %s: %w", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("could not start SFTP on %s: %w", addr, err)
+	}
+	fsys := &SFTPFS{conn: conn, client: client}
+	_, fsys.posixRename = client.HasExtension("posix-rename@openssh.com")
+	_, fsys.fsync = client.HasExtension("fsync@openssh.com")
+	return fsys, path.Clean(dir), nil
+}
+
+// localUsername is the login name used when a destination doesn't give one, like ssh does
+func localUsername() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	name := u.Username
+	if i := strings.LastIndex(name, `\`); i >= 0 { // Windows names are DOMAIN\user
+		name = name[i+1:]
+	}
+	return name
+}
+
+// sshClientConfig authenticates as username with the agent and the default keys in ~/.ssh, and
+// only accepts a host key already in ~/.ssh/known_hosts
+func sshClientConfig(username, addr string) (*ssh.ClientConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not find ~/.ssh: %w", err)
+	}
+	sshDir := filepath.Join(home, ".ssh")
+
+	hostKeys, err := knownhosts.New(filepath.Join(sshDir, "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts (connect once with ssh to add the host): %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" && runtime.GOOS != "windows" {
+		if agentConn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(sshDir, name))
+		if err != nil {
+			continue
+		}
+		// A key with a passphrase is only usable through the agent
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no ssh agent or unencrypted key in ~/.ssh to log in with")
+	}
+
+	return &ssh.ClientConfig{
+		User:              username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownHostKeyAlgorithms(hostKeys, addr),
+		Timeout:           30 * time.Second,
+	}, nil
+}
+
+// knownHostKeyAlgorithms lists the key types known_hosts holds for addr, so the server is asked for
+// a key that can be checked rather than its preferred one; nil (any type) for an unknown host
+func knownHostKeyAlgorithms(hostKeys ssh.HostKeyCallback, addr string) []string {
+	// Checking an all-zero key, which no host has, makes the callback report the keys it knows for the host
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(hostKeys(addr, &net.TCPAddr{IP: net.IPv4zero}, probe), &keyErr) {
+		return nil
+	}
+	var algos []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			// The same RSA key is offered under its SHA-2 signature names
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, known.Key.Type())
+		}
+	}
+	return algos
+}
+
+// RemoteStateDir is the local folder holding the database and reports for a remote destination,
+// since SQLite can't safely live on the far side of a network connection
+func RemoteStateDir(dest string) (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(dest, remoteScheme))
+	return filepath.Join(base, "backupbozo", name), nil
+}
+
+// Close ends the SFTP session and its connection
+func (s *SFTPFS) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
+
+// remotePath turns a destination path into the forward-slash form SFTP servers expect
+func remotePath(p string) string {
+	return filepath.ToSlash(p)
+}
+
+func (s *SFTPFS) Stat(p string) (os.FileInfo, error)  { return s.client.Stat(remotePath(p)) }
+func (s *SFTPFS) Lstat(p string) (os.FileInfo, error) { return s.client.Lstat(remotePath(p)) }
+
+func (s *SFTPFS) MkdirAll(p string) error {
+	return s.client.MkdirAll(path.Clean(remotePath(p)))
+}
+
+func (s *SFTPFS) Open(p string) (io.ReadCloser, error) {
+	return s.client.Open(remotePath(p))
+}
+
+// Create returns a file whose Sync flushes it to the server's disk, when the server supports that
+func (s *SFTPFS) Create(p string) (io.WriteCloser, error) {
+	f, err := s.client.OpenFile(remotePath(p), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, fsync: s.fsync}, nil
+}
+
+// Rename replaces newpath atomically where the server supports POSIX renames (OpenSSH does);
+// elsewhere an existing newpath makes it fail rather than be overwritten non-atomically
+func (s *SFTPFS) Rename(oldpath, newpath string) error {
+	if s.posixRename {
+		return s.client.PosixRename(remotePath(oldpath), remotePath(newpath))
+	}
+	return s.client.Rename(remotePath(oldpath), remotePath(newpath))
+}
+
+func (s *SFTPFS) Remove(p string) error { return s.client.Remove(remotePath(p)) }
+
+func (s *SFTPFS) Chtimes(p string, atime, mtime time.Time) error {
+	return s.client.Chtimes(remotePath(p), atime, mtime)
+}
+
+func (s *SFTPFS) Chmod(p string, mode os.FileMode) error {
+	return s.client.Chmod(remotePath(p), mode)
+}
+
+// Chown uses the numeric ids of the local source file; they only mean the same user where the
+// hosts share their accounts
+func (s *SFTPFS) Chown(p string, uid, gid int) error {
+	return s.client.Chown(remotePath(p), uid, gid)
+}
+
+func (s *SFTPFS) Link(oldname, newname string) error {
+	return s.client.Link(remotePath(oldname), remotePath(newname))
+}
+
+func (s *SFTPFS) Symlink(oldname, newname string) error {
+	return s.client.Symlink(remotePath(oldname), remotePath(newname))
+}
+
+// FreeSpace and TotalSpace need the statvfs@openssh.com extension
+func (s *SFTPFS) FreeSpace(p string) (uint64, error) {
+	stat, err := s.client.StatVFS(remotePath(p))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Bavail * stat.Frsize, nil
+}
+
+func (s *SFTPFS) TotalSpace(p string) (uint64, error) {
+	stat, err := s.client.StatVFS(remotePath(p))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Blocks * stat.Frsize, nil
+}
+
+// sftpFile is a remote file being written
+type sftpFile struct {
+	*sftp.File
+	fsync bool
+}
+
+// Sync flushes the file to the server's disk; a server without fsync@openssh.com can't be asked
+// to, and the file is only as durable as its write-back cache
+func (f *sftpFile) Sync() error {
+	if !f.fsync {
+		return nil
+	}
+	return f.File.Sync()
+}
@@ -0,0 +1,133 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Destination paths are stored relative to the backup folder (with / separators), so a backup on
+// a removable drive keeps working when it is mounted somewhere else or the folder is moved.
+// Databases written before that held the paths as given on the command line; they are rewritten
+// the first time they are opened with a destination (see prepareDestPaths)
+
+// Keys in the settings table
+const (
+	settingDestPaths = "dest_paths" // "relative" once stored destination paths are relative
+	settingDestRoot  = "dest_root"  // Where the destination was when the database was last used
+)
+
+// absDestDir returns a local destination as an absolute path; remote paths are already absolute
+func absDestDir(destDir string, local bool) string {
+	if !local {
+		return destDir
+	}
+	if abs, err := filepath.Abs(destDir); err == nil {
+		return abs
+	}
+	return filepath.Clean(destDir)
+}
+
+// prepareDestPaths makes destDir (absolute, see absDestDir) the root that stored destination
+// paths are resolved against, rewriting the paths of an older database to be relative first
+func prepareDestPaths(db *sql.DB, destDir string, local bool) error {
+	relative, err := dbSetting(db, settingDestPaths)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if relative == "" {
+		rows, err := tx.Query("SELECT id, dest_path FROM files WHERE dest_path IS NOT NULL AND dest_path != ''")
+		if err != nil {
+			return err
+		}
+		stored := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var path string
+			if err := rows.Scan(&id, &path); err != nil {
+				rows.Close()
+				return err
+			}
+			// Old paths were written relative to the working directory of the run
+			if local && !filepath.IsAbs(path) {
+				if abs, err := filepath.Abs(path); err == nil {
+					path = abs
+				}
+			}
+			stored[id] = relativeDestPath(destDir, path)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, path := range stored {
+			if _, err := tx.Exec("UPDATE files SET dest_path = ? WHERE id = ?", path, id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, 'relative')", settingDestPaths); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", settingDestRoot, destDir); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// useDestDir runs prepareDestPaths for a command, wrapping its error for the caller
+func useDestDir(db *sql.DB, destDir string, local bool) error {
+	if err := prepareDestPaths(db, destDir, local); err != nil {
+		return fmt.Errorf("could not update destination paths in the database: %w", err)
+	}
+	return nil
+}
+
+// dbSetting reads a value from the settings table, "" if it is not set
+func dbSetting(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// destRootOf returns the folder a database's destination paths are relative to, or "" when they
+// are stored as given (a known database from an older version, opened read-only)
+func destRootOf(db *sql.DB) string {
+	if relative, err := dbSetting(db, settingDestPaths); err != nil || relative == "" {
+		return ""
+	}
+	root, _ := dbSetting(db, settingDestRoot)
+	return root
+}
+
+// relativeDestPath turns a destination path into the form stored in the database
+// Paths outside the destination (which only older databases can hold) are kept whole
+func relativeDestPath(root, path string) string {
+	if root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resolveDestPath turns a stored destination path back into a path under root
+func resolveDestPath(root, stored string) string {
+	if root == "" || stored == "" || filepath.IsAbs(stored) || strings.HasPrefix(stored, "/") {
+		return stored
+	}
+	return filepath.Join(root, filepath.FromSlash(stored))
+}
@@ -0,0 +1,332 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/fatih/color"
+)
+
+// DiffStatus says which of two compared backups hold a file's content
+type DiffStatus string
+
+const (
+	DiffOnlyA DiffStatus = "only-a" // Content stored in backup A but not in B
+	DiffOnlyB DiffStatus = "only-b" // Content stored in backup B but not in A
+	DiffBoth  DiffStatus = "both"   // Content stored in both backups
+)
+
+// DiffResult is one piece of content found in either backup
+type DiffResult struct {
+	Hash     string
+	Status   DiffStatus
+	PathA    string // Where backup A stores it, "" when only in B
+	PathB    string // Where backup B stores it, "" when only in A
+	Size     int64
+	Camera   string
+	Location *metadata.Location
+}
+
+// DiffSummary collects diff results and per-status counts
+type DiffSummary struct {
+	Results    []DiffResult
+	OnlyA      int
+	OnlyB      int
+	Both       int
+	OnlyABytes int64
+	OnlyBBytes int64
+}
+
+// add records a result and updates the matching counters
+func (s *DiffSummary) add(result DiffResult) {
+	s.Results = append(s.Results, result)
+	switch result.Status {
+	case DiffOnlyA:
+		s.OnlyA++
+		s.OnlyABytes += result.Size
+	case DiffOnlyB:
+		s.OnlyB++
+		s.OnlyBBytes += result.Size
+	case DiffBoth:
+		s.Both++
+	}
+}
+
+// diffKey identifies content across databases; hashes made with different algorithms never match
+func diffKey(record FileRecord) string {
+	return record.HashAlgo + ":" + record.Hash
+}
+
+// diffRecords compares the contents of two backups by hash
+// Content stored more than once in one backup is listed once, at its first recorded path
+func diffRecords(recordsA, recordsB []FileRecord) DiffSummary {
+	inB := make(map[string]FileRecord, len(recordsB))
+	for _, record := range recordsB {
+		if _, seen := inB[diffKey(record)]; !seen {
+			inB[diffKey(record)] = record
+		}
+	}
+
+	var summary DiffSummary
+	seenA := make(map[string]bool, len(recordsA))
+	for _, record := range recordsA {
+		key := diffKey(record)
+		if seenA[key] {
+			continue
+		}
+		seenA[key] = true
+		result := DiffResult{Hash: record.Hash, Status: DiffOnlyA, PathA: record.DestPath, Size: record.Size, Camera: record.Camera, Location: record.Location}
+		if other, ok := inB[key]; ok {
+			result.Status, result.PathB = DiffBoth, other.DestPath
+		}
+		summary.add(result)
+	}
+	for _, record := range recordsB {
+		key := diffKey(record)
+		if seenA[key] {
+			continue
+		}
+		seenA[key] = true
+		summary.add(DiffResult{Hash: record.Hash, Status: DiffOnlyB, PathB: record.DestPath, Size: record.Size, Camera: record.Camera, Location: record.Location})
+	}
+
+	sort.SliceStable(summary.Results, func(i, j int) bool {
+		return diffDisplayPath(summary.Results[i]) < diffDisplayPath(summary.Results[j])
+	})
+	return summary
+}
+
+// diffDisplayPath is the path a result is listed under: A's copy when it has one
+func diffDisplayPath(result DiffResult) string {
+	if result.PathA != "" {
+		return result.PathA
+	}
+	return result.PathB
+}
+
+// hashAlgorithms returns the hash algorithms used by records
+func hashAlgorithms(records []FileRecord) map[string]bool {
+	algos := make(map[string]bool)
+	for _, record := range records {
+		algos[record.HashAlgo] = true
+	}
+	return algos
+}
+
+// DiffBackups compares the databases of two backup destinations and writes an HTML and a JSON report
+func DiffBackups(destA, dbPathA, destB, dbPathB, reportPath string) error {
+	destA, destB = absDestDir(destA, true), absDestDir(destB, true)
+	startTime := time.Now()
+
+	dbA, err := openReadOnlyDB(destA, dbPathA)
+	if err != nil {
+		return err
+	}
+	recordsA, err := loadRecordedFilesUnder(dbA, readOnlyDestRoot(dbA, destA))
+	dbA.Close()
+	if err != nil {
+		return fmt.Errorf("could not read database '%s': %w", dbPathA, err)
+	}
+	dbB, err := openReadOnlyDB(destB, dbPathB)
+	if err != nil {
+		return err
+	}
+	recordsB, err := loadRecordedFilesUnder(dbB, readOnlyDestRoot(dbB, destB))
+	dbB.Close()
+	if err != nil {
+		return fmt.Errorf("could not read database '%s': %w", dbPathB, err)
+	}
+
+	// Backups made with different --hash values have nothing comparable
+	algosA, algosB := hashAlgorithms(recordsA), hashAlgorithms(recordsB)
+	shared := false
+	for algo := range algosA {
+		shared = shared || algosB[algo]
+	}
+	if !shared && len(recordsA) > 0 && len(recordsB) > 0 {
+		log.Printf("Warning: The two backups use different hash algorithms, so no file can match; re-index one with the other's --hash")
+		color.New(color.FgYellow).Println("⚠️  The backups use different hash algorithms, so every file is listed as unique")
+	}
+
+	summary := diffRecords(recordsA, recordsB)
+	writeDiffReport(reportPath, summary, time.Since(startTime), destA, destB)
+	writeDiffJSONReport(jsonReportPath(reportPath), summary, destA, destB)
+
+	fmt.Println()
+	color.New(color.FgMagenta, color.Bold).Printf("📊 Backup Comparison\n")
+	fmt.Printf("   A: %s (%d files)\n", destA, len(recordsA))
+	fmt.Printf("   B: %s (%d files)\n", destB, len(recordsB))
+	color.New(color.FgGreen).Printf("   ✅ In both: %d files\n", summary.Both)
+	color.New(color.FgYellow).Printf("   🅰️  Only in A: %d files (%s)\n", summary.OnlyA, FormatFileSize(summary.OnlyABytes))
+	color.New(color.FgYellow).Printf("   🅱️  Only in B: %d files (%s)\n", summary.OnlyB, FormatFileSize(summary.OnlyBBytes))
+	color.New(color.FgCyan).Printf("   📄 Diff report: %s\n", reportPath)
+	color.New(color.FgCyan).Printf("   📄 JSON report: %s\n", jsonReportPath(reportPath))
+	return nil
+}
+
+// writeDiffReport writes an HTML report of a backup comparison using the backup report styling
+func writeDiffReport(path string, summary DiffSummary, totalTime time.Duration, destA, destB string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Could not create diff report: %v", err)
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>backupbozo diff report</title>
+`)
+	f.WriteString(reportCSS)
+	f.WriteString(`
+</head>
+<body>
+    <div class="container">
+        <div class="mascot-header">
+            <h1>Diff Report</h1>
+            <p class="backup-timestamp">` + time.Now().Format("Monday, January 2, 2006 at 3:04 PM") + `</p>`)
+	fmt.Fprintf(f, `
+            <p class="mascot-quote">A: %s<br>B: %s</p>`, html.EscapeString(destA), html.EscapeString(destB))
+
+	f.WriteString(`
+        <div class="summary-badges">
+            <div class="badge-row">`)
+	writeBadge(f, "total", "Total Files", fmt.Sprintf("%d", len(summary.Results)))
+	writeBadge(f, "time", "Time Taken", formatDuration(totalTime))
+	writeBadge(f, "copied", "In Both", fmt.Sprintf("%d", summary.Both))
+	writeBadge(f, "skipped", "Only in A", fmt.Sprintf("%d", summary.OnlyA))
+	writeBadge(f, "skipped", "Only in B", fmt.Sprintf("%d", summary.OnlyB))
+	f.WriteString(`
+            </div>
+        </div>
+        </div>`)
+
+	f.WriteString(`
+        <div class="controls">
+            <input type="text" class="search-input" placeholder="Search files..." id="searchInput">
+            <div class="filter-buttons">
+                <button class="filter-btn active" data-filter="all">All</button>
+                <button class="filter-btn" data-filter="only-a">Only in A</button>
+                <button class="filter-btn" data-filter="only-b">Only in B</button>
+                <button class="filter-btn" data-filter="both">In Both</button>
+            </div>
+        </div>
+
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th data-sort="path">File Path<span class="sort-indicator">↕</span></th>
+                        <th data-sort="status">Status<span class="sort-indicator">↕</span></th>
+                        <th data-sort="destination">Copy in B<span class="sort-indicator">↕</span></th>
+                        <th data-sort="size">Size<span class="sort-indicator">↕</span></th>
+                        <th data-sort="camera">Camera<span class="sort-indicator">↕</span></th>
+                        <th data-sort="gps">GPS<span class="sort-indicator">↕</span></th>
+                        <th data-sort="details">Details<span class="sort-indicator">↕</span></th>
+                    </tr>
+                </thead>
+                <tbody class="table-body" id="fileTableBody">`)
+
+	for _, result := range summary.Results {
+		switch result.Status {
+		case DiffOnlyA:
+			writeTableRow(f, makeRelativePath(result.PathA, destA), result.PathA, string(result.Status), "", "",
+				FormatFileSize(result.Size), result.Camera, result.Location, "", "Not in B")
+		case DiffOnlyB:
+			writeTableRow(f, makeRelativePath(result.PathB, destB), result.PathB, string(result.Status), "", "",
+				FormatFileSize(result.Size), result.Camera, result.Location, "", "Not in A")
+		case DiffBoth:
+			writeTableRow(f, makeRelativePath(result.PathA, destA), result.PathA, string(result.Status),
+				makeRelativePath(result.PathB, destB), result.PathB, FormatFileSize(result.Size), result.Camera, result.Location, "", "")
+		}
+	}
+
+	f.WriteString(`                </tbody>
+            </table>
+        </div>`)
+
+	writeJavaScript(f)
+	f.WriteString("</body></html>")
+}
+
+// JSONDiffReport is the machine-readable report written by diff
+type JSONDiffReport struct {
+	Version     int             `json:"version"`
+	GeneratedAt string          `json:"generated_at"`
+	A           string          `json:"a"`
+	B           string          `json:"b"`
+	Summary     JSONDiffSummary `json:"summary"`
+	OnlyA       []JSONDiffEntry `json:"only_a"`
+	OnlyB       []JSONDiffEntry `json:"only_b"`
+	Both        []JSONDiffEntry `json:"both"`
+}
+
+// JSONDiffSummary mirrors the counts shown in the console summary
+type JSONDiffSummary struct {
+	OnlyA      int   `json:"only_a"`
+	OnlyB      int   `json:"only_b"`
+	Both       int   `json:"both"`
+	OnlyABytes int64 `json:"only_a_bytes"`
+	OnlyBBytes int64 `json:"only_b_bytes"`
+}
+
+// JSONDiffEntry describes one piece of content; a path is "" in the backup that lacks it
+type JSONDiffEntry struct {
+	Hash     string             `json:"hash"`
+	Size     int64              `json:"size"`
+	PathA    string             `json:"path_a"`
+	PathB    string             `json:"path_b"`
+	Camera   string             `json:"camera"`
+	Location *metadata.Location `json:"location"`
+}
+
+// writeDiffJSONReport writes the comparison as JSON next to the HTML report
+func writeDiffJSONReport(path string, summary DiffSummary, destA, destB string) {
+	report := JSONDiffReport{
+		Version:     jsonReportVersion,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		A:           destA,
+		B:           destB,
+		Summary: JSONDiffSummary{
+			OnlyA:      summary.OnlyA,
+			OnlyB:      summary.OnlyB,
+			Both:       summary.Both,
+			OnlyABytes: summary.OnlyABytes,
+			OnlyBBytes: summary.OnlyBBytes,
+		},
+		OnlyA: []JSONDiffEntry{},
+		OnlyB: []JSONDiffEntry{},
+		Both:  []JSONDiffEntry{},
+	}
+	for _, result := range summary.Results {
+		entry := JSONDiffEntry{Hash: result.Hash, Size: result.Size, PathA: result.PathA, PathB: result.PathB, Camera: result.Camera, Location: result.Location}
+		switch result.Status {
+		case DiffOnlyA:
+			report.OnlyA = append(report.OnlyA, entry)
+		case DiffOnlyB:
+			report.OnlyB = append(report.OnlyB, entry)
+		case DiffBoth:
+			report.Both = append(report.Both, entry)
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Could not encode JSON diff report: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("Could not write JSON diff report: %v", err)
+	}
+}
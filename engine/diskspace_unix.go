@@ -1,6 +1,6 @@
 //go:build !windows
 
-package main
+package engine
 
 import "syscall"
 
@@ -11,4 +11,13 @@ func getFreeSpace(path string) (uint64, error) {
 		return 0, err
 	}
 	return stat.Bavail * uint64(stat.Bsize), nil
-}
\ No newline at end of file
+}
+
+// getTotalSpace returns the total size of the filesystem holding path (Unix implementation)
+func getTotalSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -1,6 +1,6 @@
 //go:build windows
 
-package main
+package engine
 
 import (
 	"golang.org/x/sys/windows"
@@ -26,4 +26,26 @@ func getFreeSpace(path string) (uint64, error) {
 	}
 
 	return freeBytesAvailable, nil
-}
\ No newline at end of file
+}
+
+// getTotalSpace returns the total size of the volume holding path (Windows implementation)
+func getTotalSpace(path string) (uint64, error) {
+	var freeBytesAvailable, totalNumberOfBytes, totalNumberOfFreeBytes uint64
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	err = windows.GetDiskFreeSpaceEx(
+		pathPtr,
+		&freeBytesAvailable,
+		&totalNumberOfBytes,
+		&totalNumberOfFreeBytes,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return totalNumberOfBytes, nil
+}
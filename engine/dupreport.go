@@ -0,0 +1,417 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// DuplicateCluster is content found more than once: several times in the source, already
+// stored in the backup, or both
+type DuplicateCluster struct {
+	Hash       string
+	Size       int64
+	StoredPath string   // Copy already in the backup, "" if none
+	Sources    []string // Source files with this content, in path order
+}
+
+// Redundant is the space the cluster's extra copies take: every source copy when the content
+// is already stored, every copy but one otherwise
+func (c DuplicateCluster) Redundant() int64 {
+	copies := len(c.Sources)
+	if c.StoredPath == "" {
+		copies--
+	}
+	return int64(copies) * c.Size
+}
+
+// DuplicateAnalysis is the outcome of a --dedupe-report-only run
+type DuplicateAnalysis struct {
+	Clusters  []DuplicateCluster // Most redundant space first
+	Files     int                // Source files hashed
+	Unique    int                // Source files whose content is found nowhere else
+	Redundant int64              // Space taken by redundant copies in the source
+	Errors    []string           // Files that couldn't be read
+}
+
+// sourceHash is one hashed source file
+type sourceHash struct {
+	path string
+	size int64
+	hash string
+}
+
+// ReportDuplicates hashes every backed up type of file in the source and reports the content
+// found more than once, within the source or in the backup's database, without copying anything
+// or writing to the database (--dedupe-report-only)
+func ReportDuplicates(ctx context.Context, opts BackupOptions) error {
+	opts.setDefaults()
+	if IsZipFile(opts.SrcDir) {
+		return fmt.Errorf("a duplicate report needs a source folder, not a zip file")
+	}
+	if err := checkDirExists(opts.SrcDir, "source"); err != nil {
+		return err
+	}
+	if err := ValidateHashAlgorithm(opts.HashAlgo); err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+	allowed, err := extensionSet(opts.Extensions)
+	if err != nil {
+		return fmt.Errorf("invalid extensions: %w", err)
+	}
+	_, isLocal := opts.DestFS.(localFS)
+	srcDir, destDir := sourceKey(opts.SrcDir), absDestDir(opts.DestDir, isLocal)
+	startTime := time.Now()
+
+	// The database is only read: a missing one means there is nothing stored to compare with
+	hashToPath := map[string]string{}
+	var cache map[string]HashCacheEntry
+	if _, err := os.Stat(opts.DBPath); err == nil && !opts.NoDB {
+		db, err := OpenDatabaseReadOnly(opts.DBPath)
+		if err != nil {
+			return fmt.Errorf("could not open database: %w", err)
+		}
+		hashToPath = loadExistingHashes(db, opts.HashAlgo, opts.Verbosity.warnings())
+		cache = loadHashCache(db, opts.HashAlgo, opts.Verbosity.warnings())
+		db.Close()
+	} else {
+		color.New(color.FgYellow).Println("⚠️  No backup database found; only duplicates within the source are reported")
+	}
+
+	files, _, walkErrors := getAllFiles(srcDir, opts.Excludes, opts.FollowSymlinks, opts.MaxDepth, opts.IgnoreHidden, opts.Verbosity)
+	filter := FileFilter{MinSize: opts.MinSize, MaxSize: opts.MaxSize}
+	var candidates []FileWithInfo
+	for _, file := range files {
+		if allowed[metadata.NormalizeExt(file.Path)] && file.Info.Size() > 0 && filter.inSizeRange(file.Info.Size()) {
+			candidates = append(candidates, file)
+		}
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("🔍 Looking for duplicates\n")
+	fmt.Printf("   Hashing %d files in %s (nothing is copied)...\n", len(candidates), srcDir)
+	bar := progressbar.NewOptions(
+		len(candidates),
+		progressbar.OptionSetVisibility(opts.Verbosity.showProgressBars()),
+		progressbar.OptionSetDescription("Hashing"),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[cyan]=[reset]",
+			SaucerHead:    "[cyan]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+	hashed, errors := hashSources(ctx, candidates, cache, opts.HashAlgo, max(opts.Workers, 1), bar)
+	bar.Finish()
+	fmt.Println()
+	for _, walkErr := range walkErrors {
+		errors = append(errors, walkErr.Error())
+	}
+
+	analysis := analyzeDuplicates(hashed, hashToPath)
+	analysis.Errors = errors
+	totalTime := time.Since(startTime)
+
+	writeDuplicateAnalysisReport(opts.ReportPath, analysis, totalTime, srcDir, destDir, ctx.Err() != nil)
+	if opts.Formats.JSON {
+		writeDuplicateAnalysisJSON(jsonReportPath(opts.ReportPath), analysis, srcDir, destDir)
+	}
+
+	inSource, inBackup := 0, 0
+	for _, cluster := range analysis.Clusters {
+		if cluster.StoredPath != "" {
+			inBackup += len(cluster.Sources)
+		} else {
+			inSource++
+		}
+	}
+	fmt.Println()
+	color.New(color.FgMagenta, color.Bold).Printf("📊 Duplicate Analysis\n")
+	color.New(color.FgCyan).Printf("   📁 Files hashed: %d\n", analysis.Files)
+	color.New(color.FgGreen).Printf("   ✅ Unique: %d files\n", analysis.Unique)
+	color.New(color.FgBlue).Printf("   🔄 Already in the backup: %d files\n", inBackup)
+	color.New(color.FgBlue).Printf("   👯 Duplicated within the source: %d groups\n", inSource)
+	color.New(color.FgBlue).Printf("   💾 Redundant copies take: %s\n", FormatFileSize(analysis.Redundant))
+	if len(analysis.Errors) > 0 {
+		color.New(color.FgRed).Printf("   ❌ Errors: %d (listed in the report)\n", len(analysis.Errors))
+	}
+	if ctx.Err() != nil {
+		color.New(color.FgYellow, color.Bold).Printf("   Analysis interrupted, results are partial\n")
+	}
+	color.New(color.FgCyan).Printf("   📄 Duplicate report: %s\n", opts.ReportPath)
+	if opts.Formats.JSON {
+		color.New(color.FgCyan).Printf("   📄 JSON report: %s\n", jsonReportPath(opts.ReportPath))
+	}
+	return nil
+}
+
+// hashSources hashes files with a worker pool, reusing hashes the database has cached for
+// unchanged files; it returns the hashed files and an error line for each one that failed
+func hashSources(ctx context.Context, files []FileWithInfo, cache map[string]HashCacheEntry, algo string, workers int, bar *progressbar.ProgressBar) ([]sourceHash, []string) {
+	results := make([]*sourceHash, len(files))
+	failures := make([]string, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				file := files[i]
+				size, mtime := file.Info.Size(), file.Info.ModTime().Unix()
+				hash := ""
+				if entry, found := cache[file.Path]; found && entry.Size == size && entry.Mtime == mtime {
+					hash = entry.Hash
+				} else if computed, err := HashFile(file.Path, algo); err == nil {
+					hash = computed
+				} else {
+					failures[i] = fmt.Sprintf("%s: %v", file.Path, err)
+				}
+				if hash != "" {
+					results[i] = &sourceHash{path: file.Path, size: size, hash: hash}
+				}
+				bar.Add(1)
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var hashed []sourceHash
+	var errors []string
+	for i := range files {
+		if results[i] != nil {
+			hashed = append(hashed, *results[i])
+		} else if failures[i] != "" {
+			errors = append(errors, failures[i])
+		}
+	}
+	return hashed, errors
+}
+
+// analyzeDuplicates groups hashed source files by content and keeps the content found more
+// than once, with the clusters whose copies waste the most space first
+func analyzeDuplicates(hashed []sourceHash, hashToPath map[string]string) DuplicateAnalysis {
+	byHash := make(map[string]*DuplicateCluster)
+	for _, file := range hashed {
+		cluster, found := byHash[file.hash]
+		if !found {
+			cluster = &DuplicateCluster{Hash: file.hash, Size: file.size, StoredPath: hashToPath[file.hash]}
+			byHash[file.hash] = cluster
+		}
+		cluster.Sources = append(cluster.Sources, file.path)
+	}
+
+	analysis := DuplicateAnalysis{Files: len(hashed)}
+	for _, cluster := range byHash {
+		if len(cluster.Sources) < 2 && cluster.StoredPath == "" {
+			analysis.Unique++
+			continue
+		}
+		sort.Strings(cluster.Sources)
+		analysis.Clusters = append(analysis.Clusters, *cluster)
+		analysis.Redundant += cluster.Redundant()
+	}
+	sort.Slice(analysis.Clusters, func(i, j int) bool {
+		a, b := analysis.Clusters[i], analysis.Clusters[j]
+		if a.Redundant() != b.Redundant() {
+			return a.Redundant() > b.Redundant()
+		}
+		return a.Sources[0] < b.Sources[0]
+	})
+	return analysis
+}
+
+// writeDuplicateAnalysisReport writes the duplicate clusters as an HTML report in the backup report styling
+func writeDuplicateAnalysisReport(path string, analysis DuplicateAnalysis, totalTime time.Duration, srcRoot, destRoot string, interrupted bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Could not create duplicate report: %v", err)
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>backupbozo duplicate report</title>
+`)
+	f.WriteString(reportCSS)
+	f.WriteString(`
+</head>
+<body>
+    <div class="container">
+        <div class="mascot-header">
+            <h1>Duplicate Report</h1>
+            <p class="backup-timestamp">` + time.Now().Format("Monday, January 2, 2006 at 3:04 PM") + `</p>`)
+	fmt.Fprintf(f, `
+            <p class="mascot-quote">Source: %s<br>Backup: %s<br>Nothing was copied and the database was not changed.</p>`,
+		html.EscapeString(srcRoot), html.EscapeString(destRoot))
+	if interrupted {
+		f.WriteString(`
+            <p class="mascot-quote">The analysis was interrupted; only the files hashed before that are included.</p>`)
+	}
+
+	f.WriteString(`
+        <div class="summary-badges">
+            <div class="badge-row">`)
+	writeBadge(f, "total", "Files Hashed", fmt.Sprintf("%d", analysis.Files))
+	writeBadge(f, "time", "Time Taken", formatDuration(totalTime))
+	writeBadge(f, "copied", "Unique", fmt.Sprintf("%d", analysis.Unique))
+	writeBadge(f, "duplicate", "Duplicate Groups", fmt.Sprintf("%d", len(analysis.Clusters)))
+	writeBadge(f, "saved", "Redundant", FormatFileSize(analysis.Redundant))
+	writeBadge(f, "error", "Errors", fmt.Sprintf("%d", len(analysis.Errors)))
+	f.WriteString(`
+            </div>
+        </div>
+        </div>`)
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Duplicate Groups (%d)</h2>
+        <p>Each group is one piece of content found more than once. When it is already in the backup, every source copy is redundant; otherwise all but one are. Groups that waste the most space come first.</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>In Backup</th>
+                        <th>Hash</th>
+                        <th>Size</th>
+                        <th>Copies</th>
+                        <th>Redundant</th>
+                        <th>Source Files</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(analysis.Clusters))
+
+	for _, cluster := range analysis.Clusters {
+		var sources strings.Builder
+		for i, source := range cluster.Sources {
+			if i > 0 {
+				sources.WriteString("<br>")
+			}
+			fmt.Fprintf(&sources, `<a href="file://%s" title="%s">%s</a>`,
+				html.EscapeString(source), html.EscapeString(source), html.EscapeString(makeRelativePath(source, srcRoot)))
+		}
+		stored := "Not stored"
+		if cluster.StoredPath != "" {
+			stored = fmt.Sprintf(`<a href="file://%s" title="%s">%s</a>`,
+				html.EscapeString(cluster.StoredPath), html.EscapeString(cluster.StoredPath), html.EscapeString(makeRelativePath(cluster.StoredPath, destRoot)))
+		}
+		hash := cluster.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path">%s</td>
+                        <td class="file-path" title="%s">%s</td>
+                        <td>%s</td>
+                        <td>%d</td>
+                        <td>%s</td>
+                        <td class="file-path">%s</td>
+                    </tr>`,
+			stored, html.EscapeString(cluster.Hash), html.EscapeString(hash), FormatFileSize(cluster.Size),
+			len(cluster.Sources), FormatFileSize(cluster.Redundant()), sources.String())
+	}
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+
+	if len(analysis.Errors) > 0 {
+		fmt.Fprintf(f, `
+        <h2 class="section-title">Errors (%d)</h2>
+        <p>These files could not be read, so they are not in any group.</p>
+        <ul>`, len(analysis.Errors))
+		for _, message := range analysis.Errors {
+			fmt.Fprintf(f, `
+            <li class="file-path">%s</li>`, html.EscapeString(message))
+		}
+		f.WriteString(`
+        </ul>`)
+	}
+
+	f.WriteString("\n    </div>\n</body></html>")
+}
+
+// JSONDuplicateReport is the machine-readable report written by --dedupe-report-only
+type JSONDuplicateReport struct {
+	Version     int                    `json:"version"`
+	GeneratedAt string                 `json:"generated_at"`
+	Source      string                 `json:"source"`
+	Destination string                 `json:"destination"`
+	Files       int                    `json:"files"`
+	Unique      int                    `json:"unique"`
+	Redundant   int64                  `json:"redundant_bytes"`
+	Clusters    []JSONDuplicateCluster `json:"clusters"`
+	Errors      []string               `json:"errors"`
+}
+
+// JSONDuplicateCluster is one duplicate group; stored_path is "" when the backup lacks it
+type JSONDuplicateCluster struct {
+	Hash       string   `json:"hash"`
+	Size       int64    `json:"size"`
+	StoredPath string   `json:"stored_path"`
+	Sources    []string `json:"sources"`
+	Redundant  int64    `json:"redundant_bytes"`
+}
+
+// writeDuplicateAnalysisJSON writes the duplicate clusters as JSON next to the HTML report
+func writeDuplicateAnalysisJSON(path string, analysis DuplicateAnalysis, srcRoot, destRoot string) {
+	report := JSONDuplicateReport{
+		Version:     jsonReportVersion,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		Source:      srcRoot,
+		Destination: destRoot,
+		Files:       analysis.Files,
+		Unique:      analysis.Unique,
+		Redundant:   analysis.Redundant,
+		Clusters:    []JSONDuplicateCluster{},
+		Errors:      append([]string{}, analysis.Errors...),
+	}
+	for _, cluster := range analysis.Clusters {
+		report.Clusters = append(report.Clusters, JSONDuplicateCluster{
+			Hash:       cluster.Hash,
+			Size:       cluster.Size,
+			StoredPath: cluster.StoredPath,
+			Sources:    cluster.Sources,
+			Redundant:  cluster.Redundant(),
+		})
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Could not encode JSON duplicate report: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("Could not write JSON duplicate report: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultExtensions defines which file types are considered for backup unless
+// BackupOptions.Extensions says otherwise; the maintenance commands always use them
+var defaultExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".heic": true,
+	".png":  true,
+	".tif":  true,
+	".tiff": true,
+	".webp": true,
+	".gif":  true, // No date metadata; dated by file name or mtime
+	".bmp":  true, // No date metadata; dated by file name or mtime
+	".mp4":  true,
+	".mov":  true,
+	".mkv":  true,
+	".webm": true,
+	".avi":  true,
+	// Camera RAW formats (TIFF-based, dated from embedded EXIF)
+	".cr2": true,
+	".nef": true,
+	".arw": true,
+	".dng": true,
+	".orf": true,
+	".raf": true,
+}
+
+// videoExtensions are the backed up types that are always hashed, even with --hash-only-videos
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".mov":  true,
+	".mkv":  true,
+	".webm": true,
+	".avi":  true,
+}
+
+// normalizeExtension turns "JPG", ".Jpg", or "*.jpg" into ".jpg"
+func normalizeExtension(ext string) (string, error) {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	ext = strings.TrimPrefix(ext, "*")
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if len(ext) < 2 || strings.ContainsAny(ext[1:], `./\`) {
+		return "", fmt.Errorf("invalid extension %q", ext)
+	}
+	return ext, nil
+}
+
+// ConfigureExtensions applies --ext (replace the whole set), then --ext-add and --ext-remove to the
+// default extensions, returning the result for BackupOptions.Extensions
+func ConfigureExtensions(only, add, remove []string) ([]string, error) {
+	allowed := make(map[string]bool)
+	if len(only) > 0 {
+		add = append(only, add...)
+	} else {
+		for ext := range defaultExtensions {
+			allowed[ext] = true
+		}
+	}
+	for _, raw := range add {
+		ext, err := normalizeExtension(raw)
+		if err != nil {
+			return nil, err
+		}
+		allowed[ext] = true
+	}
+	for _, raw := range remove {
+		ext, err := normalizeExtension(raw)
+		if err != nil {
+			return nil, err
+		}
+		delete(allowed, ext)
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no file extensions left to back up")
+	}
+	exts := make([]string, 0, len(allowed))
+	for ext := range allowed {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts, nil
+}
+
+// extensionSet is the set of backed up extensions for BackupOptions.Extensions; nil means the defaults
+func extensionSet(exts []string) (map[string]bool, error) {
+	if exts == nil {
+		return defaultExtensions, nil
+	}
+	allowed := make(map[string]bool, len(exts))
+	for _, raw := range exts {
+		ext, err := normalizeExtension(raw)
+		if err != nil {
+			return nil, err
+		}
+		allowed[ext] = true
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no file extensions to back up")
+	}
+	return allowed, nil
+}
+
+// nonVideoExtensions are the backed up extensions checked for duplicates by name, size, and mtime
+// instead of by hash with --hash-only-videos: every one except videos
+func nonVideoExtensions(allowed map[string]bool) map[string]bool {
+	quick := make(map[string]bool)
+	for ext := range allowed {
+		if !videoExtensions[ext] {
+			quick[ext] = true
+		}
+	}
+	return quick
+}
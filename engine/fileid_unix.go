@@ -0,0 +1,21 @@
+//go:build !windows
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file or directory independently of the path it was reached by
+type fileID struct {
+	dev, ino uint64
+}
+
+// pathID returns the device and inode of a file or directory (Unix implementation)
+func pathID(path string, info os.FileInfo) (fileID, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+	}
+	return fileID{}, false
+}
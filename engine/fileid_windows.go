@@ -0,0 +1,34 @@
+//go:build windows
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file or directory independently of the path it was reached by
+type fileID struct {
+	volume    uint32
+	indexHigh uint32
+	indexLow  uint32
+}
+
+// pathID returns the volume serial number and file index of a file or directory (Windows implementation)
+func pathID(path string, info os.FileInfo) (fileID, bool) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	// FILE_FLAG_BACKUP_SEMANTICS is required to open a directory handle
+	h, err := syscall.CreateFile(p, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer syscall.CloseHandle(h)
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &data); err != nil {
+		return fileID{}, false
+	}
+	return fileID{volume: data.VolumeSerialNumber, indexHigh: data.FileIndexHigh, indexLow: data.FileIndexLow}, true
+}
@@ -0,0 +1,1317 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// FileWithInfo combines file path with cached os.FileInfo to eliminate duplicate syscalls
+type FileWithInfo struct {
+	Path      string
+	Info      os.FileInfo
+	Sidecars  []string      // Sidecar files (.xmp/.aae) that travel with this photo
+	LiveVideo *FileWithInfo // Video half of a live photo, backed up with this still
+}
+
+// sidecarExtensions are edit/metadata files that belong to the photo with the same name
+var sidecarExtensions = map[string]bool{
+	".xmp": true, // Lightroom and other editors
+	".aae": true, // iPhone Photos edits
+}
+
+// attachSidecars moves sidecar files onto the media file they belong to and drops them from the
+// list, so they are copied with their photo instead of being reported as skipped on their own
+// Both IMG_0001.xmp and IMG_0001.JPG.xmp naming styles are recognized; orphans stay in the list
+func (r *backupRun) attachSidecars(files []FileWithInfo) []FileWithInfo {
+	byPath := make(map[string]int)
+	byStem := make(map[string]int)
+	for i, file := range files {
+		if !r.extensions[metadata.NormalizeExt(file.Path)] {
+			continue
+		}
+		ext := filepath.Ext(file.Path)
+		byPath[file.Path] = i
+		stem := strings.TrimSuffix(file.Path, ext)
+		if _, taken := byStem[stem]; !taken {
+			byStem[stem] = i // Walk order puts IMG_0001.JPG before IMG_0001.MOV
+		}
+	}
+
+	attached := make(map[int]bool)
+	for i, file := range files {
+		if !sidecarExtensions[metadata.NormalizeExt(file.Path)] {
+			continue
+		}
+		ext := filepath.Ext(file.Path)
+		stem := strings.TrimSuffix(file.Path, ext)
+		parent, found := byPath[stem]
+		if !found {
+			parent, found = byStem[stem]
+		}
+		if found {
+			files[parent].Sidecars = append(files[parent].Sidecars, file.Path)
+			attached[i] = true
+		}
+	}
+	if len(attached) == 0 {
+		return files
+	}
+
+	kept := make([]FileWithInfo, 0, len(files)-len(attached))
+	for i, file := range files {
+		if !attached[i] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// sidecarDestPath names a sidecar after its photo's destination, so a photo renamed to avoid a
+// collision (IMG_0001_1a2b3c4d.jpg) keeps a matching sidecar (IMG_0001_1a2b3c4d.aae)
+func sidecarDestPath(parentSrc, parentDest, sidecarSrc string) string {
+	name := filepath.Base(sidecarSrc)
+	parentName := filepath.Base(parentSrc)
+	destName := filepath.Base(parentDest)
+	if strings.HasPrefix(name, parentName) {
+		return filepath.Join(filepath.Dir(parentDest), destName+name[len(parentName):])
+	}
+	parentStem := strings.TrimSuffix(parentName, filepath.Ext(parentName))
+	destStem := strings.TrimSuffix(destName, filepath.Ext(destName))
+	return filepath.Join(filepath.Dir(parentDest), destStem+name[len(parentStem):])
+}
+
+// copySidecars copies a photo's sidecars next to mainDest, where the photo is stored, recording
+// each one like any stored file, and returns the paths written. A sidecar already there with the
+// same content is left alone; a sidecar that can't be copied never fails its photo, it is logged
+// and left in the source
+func (r *backupRun) copySidecars(ctx context.Context, candidate *FileCandidate, mainDest string, date time.Time, batchInserter *BatchInserter) []string {
+	algo := batchInserter.hashAlgo
+	var copied []string
+	for _, sidecar := range candidate.Sidecars {
+		dest := sidecarDestPath(candidate.Path, mainDest, sidecar)
+		if _, err := r.statDest(dest); err == nil {
+			if r.storedAs(sidecar, dest, algo) {
+				continue // Backed up with its photo by an earlier run
+			}
+			r.console.Printf("Warning: Sidecar %s not copied, %s already exists", sidecar, dest)
+			r.log.Warn("sidecar %s not copied, %s already exists", sidecar, dest)
+			continue
+		}
+		info, err := os.Stat(sidecar)
+		if err != nil {
+			r.console.Printf("Warning: Could not copy sidecar %s: %v", sidecar, err)
+			r.log.Warn("could not copy sidecar %s: %v", sidecar, err)
+			continue
+		}
+		hash, err := r.storeFile(ctx, sidecar, dest, algo, fileAccessTime(info))
+		if err != nil {
+			r.console.Printf("Warning: Could not copy sidecar %s: %v", sidecar, err)
+			r.log.Warn("could not copy sidecar %s: %v", sidecar, err)
+			continue
+		}
+		// A sidecar only works next to its own photo, so a copy whose content is already stored for
+		// another photo stays, unrecorded
+		if existing, added := batchInserter.Add(sidecar, dest, hash, info.Size(), info.ModTime().Unix(), date, "", nil, "", dedupByHash, ""); !added {
+			r.log.Info("sidecar %s has the same content as %s, not recorded", dest, existing)
+		}
+		copied = append(copied, dest)
+	}
+	return copied
+}
+
+// sidecarHome returns where a photo that wasn't copied is stored, so its sidecars can join it:
+// its own destination when identical content was already there, the stored copy it duplicates,
+// or for an incremental skip the copy recorded for its unchanged source. "" means it isn't in
+// this backup (another --known-db backup, or not recorded)
+func sidecarHome(candidate *FileCandidate, result *FileResult, batchInserter *BatchInserter) string {
+	var home string
+	switch result.State {
+	case StateSkippedDestExists:
+		home = candidate.DestPath
+	case StateDuplicateHash:
+		home = result.ExistingDuplicatePath
+	case StateSkippedIncremental:
+		if hash, cached := batchInserter.CachedHash(candidate.Path, candidate.Info.Size(), candidate.Info.ModTime().Unix()); cached {
+			home, _ = batchInserter.Lookup(hash)
+		}
+	}
+	if home == "" || !pathContains(candidate.DestDir, home) {
+		return ""
+	}
+	return home
+}
+
+// storedAs reports whether a stored file has the same content as a source file
+func (d *destination) storedAs(src, dest, algo string) bool {
+	srcHash, err := HashFile(src, algo)
+	if err != nil {
+		return false
+	}
+	destHash, err := d.hashDestFile(dest, algo)
+	return err == nil && destHash == srcHash
+}
+
+// getAllFiles lists every file under root. Entries matching an --exclude pattern are returned
+// separately; excluded directories are pruned whole. Symlinked files are listed with their target's
+// size and date; symlinked directories are only descended into with followSymlinks, and never twice.
+// maxDepth > 0 limits how many folder levels are listed (1 is only root's own files).
+// With ignoreHidden, files and folders whose names start with "." are left out without being read.
+// VerbosityVerbose prints a line for every hidden file and folder it doesn't descend into
+func getAllFiles(root string, excludes []string, followSymlinks bool, maxDepth int, ignoreHidden bool, verbosity Verbosity) ([]FileWithInfo, []FileWithInfo, []error) {
+	w := &sourceWalker{root: root, excludes: excludes, followSymlinks: followSymlinks, maxDepth: maxDepth, ignoreHidden: ignoreHidden, verbosity: verbosity, visited: make(map[fileID]bool)}
+	info, err := os.Stat(root)
+	if err != nil {
+		w.errors = append(w.errors, &WalkError{Path: root, Err: err})
+		return nil, nil, w.errors
+	}
+	if !info.IsDir() {
+		return []FileWithInfo{{Path: root, Info: info}}, nil, nil
+	}
+	w.walkDir(root, info, 1)
+	return w.files, w.excluded, w.errors
+}
+
+// sourceWalker collects the results of one getAllFiles walk
+type sourceWalker struct {
+	root           string
+	excludes       []string
+	followSymlinks bool
+	maxDepth       int             // Deepest folder level listed, 0 for no limit (--max-depth)
+	ignoreHidden   bool            // Leave out dotfiles and dot folders (--ignore-hidden)
+	verbosity      Verbosity       // Says what is left out with --verbose
+	visited        map[fileID]bool // Directories already walked, so a symlink loop ends the walk
+	files          []FileWithInfo
+	excluded       []FileWithInfo
+	errors         []error
+}
+
+// walkDir lists one directory in lexical order (like filepath.Walk) and recurses into subfolders
+// depth is dir's level below the root, counting the root as 1
+func (w *sourceWalker) walkDir(dir string, info os.FileInfo, depth int) {
+	if w.followSymlinks {
+		if id, ok := pathID(dir, info); ok {
+			if w.visited[id] {
+				w.verbosity.warnings().Printf("Warning: Skipping %s, its folder was already walked (symlink loop?)", dir)
+				return
+			}
+			w.visited[id] = true
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// Unreadable folders are recorded and skipped; the rest of the tree is still walked
+		w.errors = append(w.errors, &WalkError{Path: dir, Err: err})
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if w.ignoreHidden && isHiddenName(entry.Name()) {
+			if w.verbosity == VerbosityVerbose {
+				fmt.Printf("ignoring hidden: %s\n", path)
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			w.errors = append(w.errors, &WalkError{Path: path, Err: err})
+			continue
+		}
+		if len(w.excludes) > 0 {
+			if rel, relErr := filepath.Rel(w.root, path); relErr == nil && isExcluded(rel, w.excludes) {
+				w.excluded = append(w.excluded, FileWithInfo{Path: path, Info: info})
+				continue
+			}
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				w.errors = append(w.errors, &WalkError{Path: path, Err: fmt.Errorf("broken symlink: %w", err)})
+				continue
+			}
+			if target.IsDir() && !w.followSymlinks {
+				if w.verbosity == VerbosityVerbose {
+					fmt.Printf("not following symlinked folder: %s\n", path)
+				}
+				continue
+			}
+			info = target
+		}
+		if info.IsDir() {
+			if w.maxDepth > 0 && depth >= w.maxDepth {
+				if w.verbosity == VerbosityVerbose {
+					fmt.Printf("not descending below --max-depth %d: %s\n", w.maxDepth, path)
+				}
+				continue
+			}
+			w.walkDir(path, info, depth+1)
+			continue
+		}
+		w.files = append(w.files, FileWithInfo{Path: path, Info: info})
+	}
+}
+
+// WalkError is a source file or folder the walk could not read
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (e *WalkError) Error() string { return fmt.Sprintf("%s: %v", e.Path, e.Err) }
+func (e *WalkError) Unwrap() error { return e.Err }
+
+// isHiddenName reports whether a file or folder name is hidden by Unix convention, like .DS_Store,
+// .thumbnails, or .Trash-1000
+func isHiddenName(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// isExcluded reports whether a path relative to the source root matches any --exclude glob
+// Patterns containing "/" match the whole relative path; others match any single file or folder name
+func isExcluded(rel string, excludes []string) bool {
+	rel = filepath.ToSlash(rel)
+	name := path.Base(rel)
+	for _, pattern := range excludes {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExcludes checks --exclude patterns for glob syntax errors up front
+func ValidateExcludes(excludes []string) error {
+	for _, pattern := range excludes {
+		if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// dateSourceMtime is the DateSource of files dated by their modification time
+const dateSourceMtime = "Filesystem mtime"
+
+// DefaultLayout is the destination folder layout used when --layout is not given (YYYY-MM)
+const DefaultLayout = "2006-01"
+
+// FlatLayout puts every file directly in the destination root (--flat)
+const FlatLayout = "."
+
+// --layout tokens, filled in per file. Date elements are formatted first and token values
+// inserted after, so letters in a value (a camera called "Canon EOS R6") are never read as dates
+const (
+	layoutExtToken    = "{ext}"    // Stored file's extension in lowercase, without the dot: jpg, mp4
+	LayoutMediaToken  = "{media}"  // Photos or Videos, the folders --separate-media uses
+	layoutCameraToken = "{camera}" // Camera or device from the file's metadata
+)
+
+// unknownCameraFolder holds files without camera metadata when the layout uses {camera}
+const unknownCameraFolder = "Unknown Camera"
+
+// layoutTokenPattern finds {name} tokens in a layout, known or not
+var layoutTokenPattern = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// layoutFile is what a file's --layout tokens are filled in from
+type layoutFile struct {
+	name   string // Stored file name, for {ext}
+	media  string // Photos or Videos, for {media}
+	date   time.Time
+	camera string
+}
+
+// dateFolder returns a file's destination folder using a --layout template: a Go time layout
+// with optional tokens, using "/" to separate nested folders, e.g. "2006/2006-01-02" or "{ext}/2006-01"
+func dateFolder(destDir, layout string, file layoutFile) string {
+	if layout == "" {
+		layout = DefaultLayout
+	}
+	folders := strings.Split(layout, "/")
+	for i, folder := range folders {
+		folders[i] = expandLayoutFolder(folder, file)
+	}
+	return filepath.Join(destDir, filepath.Join(folders...))
+}
+
+// expandLayoutFolder formats the date elements of one folder of a layout and fills in its tokens
+func expandLayoutFolder(folder string, file layoutFile) string {
+	var expanded strings.Builder
+	last := 0
+	for _, match := range layoutTokenPattern.FindAllStringIndex(folder, -1) {
+		expanded.WriteString(file.date.Format(folder[last:match[0]]))
+		expanded.WriteString(layoutTokenValue(folder[match[0]:match[1]], file))
+		last = match[1]
+	}
+	expanded.WriteString(file.date.Format(folder[last:]))
+	return expanded.String()
+}
+
+// layoutTokenValue returns a token's value for a file as a safe folder name
+func layoutTokenValue(token string, file layoutFile) string {
+	switch token {
+	case layoutExtToken:
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file.name)), ".")
+		return safeFolderName(ext, "noext")
+	case LayoutMediaToken:
+		return safeFolderName(file.media, "Other")
+	case layoutCameraToken:
+		return safeFolderName(file.camera, unknownCameraFolder)
+	default:
+		return safeFolderName(token, "_") // Rejected by ValidateLayout; kept harmless anyway
+	}
+}
+
+// safeFolderName makes a metadata value usable as one folder name, or returns fallback if nothing is left
+func safeFolderName(value, fallback string) string {
+	value = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, value)
+	value = strings.Trim(strings.TrimSpace(value), ".")
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// Top-level folders for --separate-media
+const (
+	photosFolder = "Photos"
+	videosFolder = "Videos"
+)
+
+// mediaFolder returns the --separate-media folder a file belongs in, or "" if it isn't backed up
+func (r *backupRun) mediaFolder(path string) string {
+	return r.mediaFolderForExt(metadata.NormalizeExt(path))
+}
+
+// mediaFolderForExt is mediaFolder for a normalized extension
+func (r *backupRun) mediaFolderForExt(ext string) string {
+	switch {
+	case videoExtensions[ext]:
+		return videosFolder
+	case r.extensions[ext]:
+		return photosFolder
+	default:
+		return ""
+	}
+}
+
+// mediaDateFolder returns a file's destination folder: its date folder, under Photos or Videos
+// with --separate-media
+func (r *backupRun) mediaDateFolder(destDir, layout, path string, date time.Time, camera string) string {
+	file := layoutFile{media: r.mediaFolder(path), date: date, camera: camera}
+	if r.opts.SeparateMedia {
+		destDir = filepath.Join(destDir, file.media)
+	}
+	if strings.Contains(layout, layoutExtToken) {
+		file.name = r.storedName(path, date)
+	}
+	return dateFolder(destDir, layout, file)
+}
+
+// renameNamePlaceholder stands for the original file name (without extension) in a --rename-pattern
+const renameNamePlaceholder = "{name}"
+
+// applyRenamePattern formats a --rename-pattern for a file, e.g. "2006-01-02_150405_{name}"
+// gives 2021-07-04_153000_IMG_0001; the extension is added by the caller
+// The original name is inserted after formatting, so letters in it are never read as date elements
+func applyRenamePattern(pattern, name string, date time.Time) string {
+	parts := strings.Split(pattern, renameNamePlaceholder)
+	for i, part := range parts {
+		parts[i] = date.Format(part)
+	}
+	return strings.Join(parts, name)
+}
+
+// ValidateRenamePattern checks that a --rename-pattern template produces a plain file name
+func ValidateRenamePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("pattern must not be empty")
+	}
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("pattern %q must not contain folder separators (use --layout for folders)", pattern)
+	}
+	sample := time.Date(2019, time.November, 23, 14, 35, 46, 0, time.UTC)
+	formatted := applyRenamePattern(pattern, "IMG_0001", sample)
+	if formatted == pattern {
+		return fmt.Errorf("pattern %q contains neither date elements nor %s (use Go reference time, e.g. 2006-01-02_150405_%s)", pattern, renameNamePlaceholder, renameNamePlaceholder)
+	}
+	if strings.Trim(formatted, ".") == "" {
+		return fmt.Errorf("pattern %q produces an invalid file name %q", pattern, formatted)
+	}
+	if strings.ContainsAny(formatted, `/\:*?"<>|`) {
+		return fmt.Errorf("pattern %q produces a file name with reserved characters: %q", pattern, formatted)
+	}
+	return nil
+}
+
+// ValidateLayout checks that a --layout template produces safe, relative folder names
+// Only the "/" separators written in the template may create folders; formatted date
+// values must never add separators, climb out of the destination, or use reserved characters.
+// Tokens must be known, used once each, and not mixed up with stray braces
+func ValidateLayout(layout string) error {
+	if strings.TrimSpace(layout) == "" {
+		return fmt.Errorf("layout must not be empty")
+	}
+	if strings.Contains(layout, "\\") {
+		return fmt.Errorf("layout %q must use '/' to separate folders", layout)
+	}
+	if strings.HasPrefix(layout, "/") || filepath.IsAbs(layout) {
+		return fmt.Errorf("layout %q must be relative to the destination", layout)
+	}
+
+	tokens := layoutTokenPattern.FindAllString(layout, -1)
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		switch token {
+		case layoutExtToken, LayoutMediaToken, layoutCameraToken:
+		default:
+			return fmt.Errorf("layout %q has an unknown token %s (use %s, %s, or %s)", layout, token, layoutExtToken, LayoutMediaToken, layoutCameraToken)
+		}
+		if seen[token] {
+			return fmt.Errorf("layout %q uses %s more than once", layout, token)
+		}
+		seen[token] = true
+	}
+	if strings.ContainsAny(layoutTokenPattern.ReplaceAllString(layout, ""), "{}") {
+		return fmt.Errorf("layout %q has an unmatched brace (tokens look like %s)", layout, layoutExtToken)
+	}
+
+	// Format a sample date with two-digit values everywhere so every element shows up
+	sample := time.Date(2019, time.November, 23, 14, 35, 46, 0, time.UTC)
+	dateOnly := layoutTokenPattern.ReplaceAllString(layout, "")
+	if sample.Format(dateOnly) == dateOnly && len(tokens) == 0 {
+		return fmt.Errorf("layout %q contains neither date elements nor tokens (use Go reference time, e.g. 2006-01, or %s)", layout, layoutExtToken)
+	}
+
+	file := layoutFile{name: "IMG_0001.jpg", media: photosFolder, date: sample, camera: "Canon EOS R6"}
+	for _, folder := range strings.Split(layout, "/") {
+		part := expandLayoutFolder(folder, file)
+		if strings.Contains(part, "/") {
+			return fmt.Errorf("layout %q produces unexpected path separators", layout)
+		}
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("layout %q produces an invalid folder name %q", layout, part)
+		}
+		if strings.ContainsAny(part, `\:*?"<>|`) {
+			return fmt.Errorf("layout %q produces a folder name with reserved characters: %q", layout, part)
+		}
+	}
+	return nil
+}
+
+// ParseTimezone parses a --timezone value: "local", "UTC", or an IANA name like "Europe/Paris"
+func ParseTimezone(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "local") || name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q (use local, UTC, or a name like Europe/Paris)", name)
+	}
+	return loc, nil
+}
+
+// planningIndex is what planning looks up in memory with --skip-existing-by-hash; nil stats the destination
+type planningIndex struct {
+	inserter    *BatchInserter
+	storedPaths map[string]bool // Destination paths recorded in the database
+}
+
+// newPlanningIndex loads the recorded destination paths once, before planning starts
+func newPlanningIndex(inserter *BatchInserter) *planningIndex {
+	return &planningIndex{inserter: inserter, storedPaths: inserter.StoredPaths()}
+}
+
+// alreadyStored reports whether a source file's cached hash (same path, size, and mtime as an
+// earlier run) is stored, without reading the file
+func (p *planningIndex) alreadyStored(candidate *FileCandidate) bool {
+	hash, cached := p.inserter.CachedHash(candidate.Path, candidate.Info.Size(), candidate.Info.ModTime().Unix())
+	if !cached {
+		return false
+	}
+	_, stored := p.inserter.Lookup(hash)
+	return stored
+}
+
+// PlanningResult contains the result of planning phase evaluation
+type PlanningResult struct {
+	ShouldCopy bool
+	Size       int64
+	Reason     string
+	ContentExt string // Extension the content calls for when the file's own doesn't match (--check-content-type)
+}
+
+// evaluateFileForPlanning performs fast evaluation without expensive metadata extraction
+// Used in planning phase to estimate space requirements using filesystem dates only
+// With an index, known content and recorded destinations are found in memory instead of on disk
+func (r *backupRun) evaluateFileForPlanning(candidate *FileCandidate, filter FileFilter, index *planningIndex) PlanningResult {
+	// 1. Extension check (already computed in FileCandidate)
+	if !r.extensions[candidate.Extension] {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "Extension not allowed",
+		}
+	}
+	if !filter.inSizeRange(candidate.Info.Size()) {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "Size out of range",
+		}
+	}
+	if candidate.Info.Size() == 0 {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "Empty file",
+		}
+	}
+
+	// 2. Incremental check (info already cached in FileCandidate)
+	if filter.olderThanLastBackup(candidate.Path, candidate.Info.ModTime()) && !r.fromZip(candidate.Path) {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "File older than last backup",
+		}
+	}
+
+	// Mislabeled files are found here, where every file is looked at anyway (--check-content-type)
+	contentExt := ""
+	if r.opts.ContentCheck != "" {
+		contentExt = mislabeledExt(candidate.Path)
+	}
+
+	// 3. Fast date check using filesystem mtime (avoid expensive metadata extraction)
+	// For planning purposes, we use filesystem modification time which is always available
+	// The execution phase will do full metadata extraction for accurate YYYY-MM organization
+	filesystemDate := candidate.Info.ModTime().In(r.opts.Timezone)
+	if filesystemDate.IsZero() {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "No valid filesystem date",
+			ContentExt: contentExt,
+		}
+	}
+	if !filter.inDateRange(filesystemDate) {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "Outside date range",
+			ContentExt: contentExt,
+		}
+	}
+
+	if index != nil && index.alreadyStored(candidate) {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "Content already in the backup",
+			ContentExt: contentExt,
+		}
+	}
+
+	// 4. Compute destination path using filesystem date for planning
+	planningDestPath := r.destPathIn(r.mediaDateFolder(candidate.DestDir, candidate.Layout, candidate.Path, filesystemDate, ""), r.storedName(candidate.Path, filesystemDate))
+
+	// Check if destination file already exists
+	if r.destinationExists(planningDestPath, index) {
+		return PlanningResult{
+			ShouldCopy: false,
+			Size:       0,
+			Reason:     "File already exists at destination",
+			ContentExt: contentExt,
+		}
+	}
+
+	// File would be copied (skip hash check in planning phase)
+	return PlanningResult{
+		ShouldCopy: true,
+		Size:       candidate.Info.Size(),
+		Reason:     "File ready for backup",
+		ContentExt: contentExt,
+	}
+}
+
+// destinationExists checks a planned destination against the recorded paths when there is an
+// index, or on the destination otherwise. Planning only estimates, so a file placed there by
+// hand is still caught by the execution phase, which never overwrites
+func (d *destination) destinationExists(dest string, index *planningIndex) bool {
+	if index != nil {
+		return index.storedPaths[dest]
+	}
+	_, err := d.statDest(dest)
+	return err == nil
+}
+
+// evaluateFilesForPlanningParallel processes files using a worker pool for concurrent planning evaluation
+// This provides 4-8x speedup on multi-core systems while maintaining result ordering
+// Uses fast filesystem dates and avoids expensive metadata extraction during planning
+func (r *backupRun) evaluateFilesForPlanningParallel(ctx context.Context, files []FileWithInfo, destDir, layout string,
+	bar *progressTracker, filter FileFilter, index *planningIndex, workers int) []PlanningResult {
+
+	// Channels for worker communication
+	type job struct {
+		index int // Preserve ordering
+		file  FileWithInfo
+	}
+
+	type resultWithIndex struct {
+		index  int
+		result PlanningResult
+	}
+
+	jobs := make(chan job, workers*2)                // Buffered channel for work items
+	results := make(chan resultWithIndex, workers*2) // Buffered channel for results
+
+	// Start worker goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Create FileCandidate for this file
+				candidate := &FileCandidate{
+					Path:      job.file.Path,
+					Info:      job.file.Info,
+					Extension: metadata.NormalizeExt(job.file.Path),
+					DestDir:   destDir,
+					Layout:    layout,
+				}
+
+				// Evaluate file for planning using fast filesystem dates
+				planResult := r.evaluateFileForPlanning(candidate, filter, index)
+				if planResult.ShouldCopy && job.file.LiveVideo != nil {
+					planResult.Size += job.file.LiveVideo.Info.Size()
+				}
+
+				// Send result with index to maintain ordering
+				select {
+				case results <- resultWithIndex{index: job.index, result: planResult}:
+					// Progress bar update (thread-safe)
+					if bar != nil {
+						bar.Add(1)
+					}
+				case <-ctx.Done():
+					return // Context cancelled
+				}
+			}
+		}()
+	}
+
+	// Producer: send jobs to workers
+	go func() {
+		defer close(jobs)
+		for i, file := range files {
+			select {
+			case jobs <- job{index: i, file: file}:
+				// Job sent successfully
+			case <-ctx.Done():
+				return // Context cancelled
+			}
+		}
+	}()
+
+	// Collector: gather results and close channel when done
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collect results in ordered slice with context awareness
+	orderedResults := make([]PlanningResult, len(files))
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				// Channel closed, all results collected
+				goto resultsComplete
+			}
+			orderedResults[result.index] = result.result
+		case <-ctx.Done():
+			// Context cancelled, stop collecting results
+			if r.opts.Verbosity.showSummary() {
+				fmt.Printf("\nPlanning phase interrupted\n")
+			}
+			goto resultsComplete
+		}
+	}
+resultsComplete:
+
+	return orderedResults
+}
+
+// EvaluationResult contains the result of file evaluation including duplicate path info
+type EvaluationResult struct {
+	State                 FileState
+	ExistingDuplicatePath string             // Only populated for StateDuplicateHash
+	DateSource            string             // Where the folder date came from (e.g., "EXIF DateTimeOriginal")
+	Date                  time.Time          // The date that decided the folder
+	Camera                string             // Camera or device named in the file's metadata, "" if unknown
+	Location              *metadata.Location // EXIF GPS position, nil if unknown
+	BurstID               string             // Identifier shared by the frames of one burst, "" if not a burst
+	Hash                  string             // Content hash, populated once the file has been hashed
+	RenamedFrom           string             // Intended destination when its name was taken by different content
+	DedupMethod           string             // How the file was checked for duplicates (dedupByHash, dedupBySizeMtimeName, or dedupBySample)
+	SampleHash            string             // Sampled checksum of a large file (--checksum-sample), "" otherwise
+	Error                 error              // Why an error state was reached, when known
+}
+
+// evaluateFileForBackup performs single-pass evaluation of a file for backup
+// This replaces the duplicate logic between the two passes in backup.go
+func (r *backupRun) evaluateFileForBackup(candidate *FileCandidate, db *sql.DB, batchInserter *BatchInserter, filter FileFilter) EvaluationResult {
+	// 1. Extension check (already computed in FileCandidate)
+	if !r.extensions[candidate.Extension] {
+		return EvaluationResult{State: StateSkippedExtension}
+	}
+	if !filter.inSizeRange(candidate.Info.Size()) {
+		return EvaluationResult{State: StateSkippedSize}
+	}
+	// Nothing to back up, and hashing or dating an empty file only produces confusing errors
+	if candidate.Info.Size() == 0 {
+		return EvaluationResult{State: StateSkippedEmpty}
+	}
+
+	// 2. Incremental check (info already cached in FileCandidate)
+	// Files from a zip were only extracted because the zip is newer than the last backup
+	if filter.olderThanLastBackup(candidate.Path, candidate.Info.ModTime()) && !r.fromZip(candidate.Path) {
+		return EvaluationResult{State: StateSkippedIncremental}
+	}
+
+	// 3. Date extraction and destination path computation
+	// EXIF DateTimeOriginal (photos) or container creation time (videos) wins over mtime,
+	// which is often reset when files are copied between devices
+	result := r.registry.ExtractBestDate(candidate.Path)
+	if errors.Is(result.Error, metadata.ErrTimeout) {
+		// A file that hangs the metadata reader is reported rather than guessed at by mtime
+		return EvaluationResult{State: StateErrorDate, Error: result.Error}
+	}
+	date := result.Date
+	dateSource := result.Source
+	camera, location, burstID := result.Camera, result.Location, result.BurstID
+	if result.Error != nil || date.IsZero() {
+		// Fallback to file modification time
+		if candidate.Info != nil {
+			date = candidate.Info.ModTime().In(r.opts.Timezone)
+			dateSource = dateSourceMtime
+		}
+		if date.IsZero() {
+			return EvaluationResult{State: StateSkippedDate}
+		}
+	}
+
+	// The same content seen under another date may be placed by that one instead (--prefer-date)
+	if preferred, found := r.preferredDates[candidate.Path]; found {
+		date, dateSource = preferred.date, preferred.source
+	}
+
+	// Date range filter uses the same date that decides folder placement
+	if !filter.inDateRange(date) {
+		return EvaluationResult{State: StateSkippedDateRange, DateSource: dateSource, Date: date, Camera: camera}
+	}
+
+	// Compute destination path
+	destDateDir := r.mediaDateFolder(candidate.DestDir, candidate.Layout, candidate.Path, date, camera)
+	candidate.DestPath = r.destPathIn(destDateDir, r.storedName(candidate.Path, date))
+
+	// Hash computation and duplicate check come before the destination check so identical
+	// content is caught even when its date would place it in a different folder
+	// Unchanged files (same path, size, and mtime as a previous run) reuse their cached hash
+	size, mtime := candidate.Info.Size(), candidate.Info.ModTime().Unix()
+	var hash, sample string
+	dedupMethod := dedupByHash
+	if r.quickDedupe[candidate.Extension] {
+		// Trusted by size and mtime (--hash-only-videos): no read at all; the copy still hashes
+		// the file, so identical content under another name is caught when it is recorded
+		dedupMethod = dedupBySizeMtimeName
+		if existingPath, exists := batchInserter.QuickLookup(candidate.Path, size, mtime); exists {
+			return EvaluationResult{State: StateDuplicateHash, ExistingDuplicatePath: existingPath, DateSource: dateSource, Date: date, Camera: camera, Location: location, BurstID: burstID, DedupMethod: dedupMethod}
+		}
+	} else if usesSampledChecksum(size, r.opts.ChecksumSample) {
+		// Large files are compared by their ends and size (--checksum-sample); the copy still
+		// computes the full hash, which is what the record stores
+		dedupMethod = dedupBySample
+		var err error
+		if sample, err = sampledChecksum(candidate.Path, batchInserter.hashAlgo, size, r.opts.ChecksumSample); err != nil {
+			return EvaluationResult{State: StateErrorHash, Error: err}
+		}
+		if existingPath, exists := batchInserter.SampleLookup(sample); exists {
+			return EvaluationResult{State: StateDuplicateHash, ExistingDuplicatePath: existingPath, DateSource: dateSource, Date: date, Camera: camera, Location: location, BurstID: burstID, DedupMethod: dedupMethod}
+		}
+		// Files stored before sampling was on have no sampled checksum, only their full hash
+		if batchInserter.MightBeStoredUnsampled(size) {
+			if hash, err = HashFile(candidate.Path, batchInserter.hashAlgo); err != nil {
+				return EvaluationResult{State: StateErrorHash, Error: err}
+			}
+			batchInserter.CacheHash(candidate.Path, size, mtime, hash)
+			dedupMethod = dedupByHash
+			if existingPath, exists := batchInserter.Lookup(hash); exists {
+				// Remembered for the stored file, so the next run matches it by its sample
+				batchInserter.RecordSample(sample, hash, existingPath)
+				return EvaluationResult{State: StateDuplicateHash, ExistingDuplicatePath: existingPath, DateSource: dateSource, Date: date, Camera: camera, Location: location, BurstID: burstID, Hash: hash, DedupMethod: dedupMethod}
+			}
+		}
+	} else {
+		var cached bool
+		hash, cached = batchInserter.CachedHash(candidate.Path, size, mtime)
+		// When no stored file has this size the content is new, and hashing it first would read
+		// it twice: the copy hashes it as it goes, and a same-run duplicate is caught when the
+		// copy is recorded. Archive members can't be taken back once appended, so they are hashed first
+		_, _, archived := splitArchiveMember(candidate.DestPath)
+		if !cached && (archived || batchInserter.MightBeStored(size)) {
+			var err error
+			hash, err = HashFile(candidate.Path, batchInserter.hashAlgo)
+			if err != nil {
+				return EvaluationResult{State: StateErrorHash, Error: err}
+			}
+			batchInserter.CacheHash(candidate.Path, size, mtime, hash)
+		}
+
+		// Check for hash duplicates in memory (O(1) lookup, safe across workers)
+		if existingPath, exists := batchInserter.Lookup(hash); hash != "" && exists {
+			return EvaluationResult{State: StateDuplicateHash, ExistingDuplicatePath: existingPath, DateSource: dateSource, Date: date, Camera: camera, Location: location, BurstID: burstID, Hash: hash, DedupMethod: dedupMethod}
+		}
+	}
+
+	// Check if destination file already exists (or another worker is about to write it)
+	// Cameras reuse names like IMG_0001.jpg, so a taken name with different content gets a
+	// hash suffix instead of being skipped; only identical content counts as already backed up
+	var renamedFrom string
+	if _, err := r.statDest(candidate.DestPath); err == nil || !batchInserter.ClaimDest(candidate.DestPath) {
+		if hash == "" {
+			// Name collisions are rare, so trusted files are only hashed when they hit one
+			var err error
+			if hash, err = HashFile(candidate.Path, batchInserter.hashAlgo); err != nil {
+				return EvaluationResult{State: StateErrorHash, Error: err}
+			}
+		}
+		if existingHash, err := r.hashDestFile(candidate.DestPath, batchInserter.hashAlgo); err == nil && existingHash == hash {
+			return EvaluationResult{State: StateSkippedDestExists, DateSource: dateSource, Date: date, Camera: camera, Hash: hash}
+		}
+		renamedFrom = candidate.DestPath
+		var state FileState
+		if candidate.DestPath, state = r.placeCollision(candidate.DestPath, hash, batchInserter); state != StateCopied {
+			return EvaluationResult{State: state, DateSource: dateSource, Date: date, Camera: camera, Hash: hash}
+		}
+	}
+
+	// Create destination directory (only for files that will actually be copied)
+	// A read-only or full destination fails here with a clear reason instead of as a confusing copy error
+	if err := r.mkdirAll(destParentDir(candidate.DestPath)); err != nil {
+		return EvaluationResult{State: StateErrorCopy, Error: fmt.Errorf("could not create date folder %s: %w", destParentDir(candidate.DestPath), err), DateSource: dateSource, Date: date, Camera: camera, Hash: hash}
+	}
+
+	// File should be copied!
+	return EvaluationResult{State: StateCopied, DateSource: dateSource, Date: date, Camera: camera, Location: location, BurstID: burstID, Hash: hash, RenamedFrom: renamedFrom, DedupMethod: dedupMethod, SampleHash: sample}
+}
+
+// collisionPath returns an alternative destination for a file whose name is taken by different
+// content, by appending a short content hash: IMG_0001.jpg -> IMG_0001_1a2b3c4d.jpg
+func collisionPath(destPath, hash string) string {
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	ext := filepath.Ext(destPath)
+	return strings.TrimSuffix(destPath, ext) + "_" + hash + ext
+}
+
+// Modes for --collision-mode, for a file whose name is taken by a different file
+const (
+	CollisionRename       = "rename"        // Add a short content hash to its name (IMG_0001_1a2b3c4d.jpg)
+	collisionSourceSubdir = "source-subdir" // Put it in a subfolder named after its source (2024-05/phone/IMG_0001.jpg)
+	collisionSkip         = "skip"          // Leave it out and report it
+)
+
+// ValidateCollisionMode checks a --collision-mode value
+func ValidateCollisionMode(mode string) error {
+	switch mode {
+	case CollisionRename, collisionSourceSubdir, collisionSkip:
+		return nil
+	default:
+		return fmt.Errorf("unsupported collision mode %q (use rename, source-subdir, or skip)", mode)
+	}
+}
+
+// sourceSubdirName names a source's subfolder for --collision-mode source-subdir: the source
+// folder's own name, or a zip's name without its extension
+func sourceSubdirName(srcDir string) string {
+	name := filepath.Base(srcDir)
+	if IsZipFile(srcDir) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		return "source"
+	}
+	return name
+}
+
+// placeCollision finds where a file goes when a different file already has its name at destPath,
+// following --collision-mode. It returns the new destination with StateCopied, or the state the
+// file is skipped with. A name taken in the source subfolder as well (two folders of one source
+// with the same names, or two sources with the same name) gets the content hash added
+func (r *backupRun) placeCollision(destPath, hash string, batchInserter *BatchInserter) (string, FileState) {
+	switch r.opts.CollisionMode {
+	case collisionSkip:
+		return destPath, StateSkippedCollision
+	case collisionSourceSubdir:
+		subdirPath := filepath.Join(filepath.Dir(destPath), r.collisionSubdir, filepath.Base(destPath))
+		if _, err := r.statDest(subdirPath); err != nil && batchInserter.ClaimDest(subdirPath) {
+			return subdirPath, StateCopied
+		}
+		if existingHash, err := r.hashDestFile(subdirPath, batchInserter.hashAlgo); err == nil && existingHash == hash {
+			return subdirPath, StateSkippedDestExists
+		}
+		destPath = subdirPath
+	}
+	renamed := collisionPath(destPath, hash)
+	if _, err := r.statDest(renamed); err == nil || !batchInserter.ClaimDest(renamed) {
+		return renamed, StateSkippedDestExists
+	}
+	return renamed, StateCopied
+}
+
+// collisionNote describes how a copied file was kept apart from a different file with its name,
+// "" if it had its name to itself
+func collisionNote(renamedFrom, dest string) string {
+	if renamedFrom == "" {
+		return ""
+	}
+	if filepath.Dir(dest) != filepath.Dir(renamedFrom) {
+		return fmt.Sprintf("placed in source subfolder %s", filepath.Base(filepath.Dir(dest)))
+	}
+	return "renamed from " + filepath.Base(renamedFrom)
+}
+
+// Supported content hash algorithms; the name is stored with every database record
+const (
+	hashMD5    = "md5"
+	hashSHA256 = "sha256"
+	hashBLAKE3 = "blake3"
+	hashXXHash = "xxhash"
+)
+
+// DefaultHashAlgorithm keeps existing databases (which only contain MD5 hashes) consistent
+const DefaultHashAlgorithm = hashMD5
+
+// ValidateHashAlgorithm checks a --hash value
+func ValidateHashAlgorithm(algo string) error {
+	_, err := newHasher(algo)
+	return err
+}
+
+// newHasher returns a fresh hash.Hash for the named algorithm
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case hashMD5:
+		return md5.New(), nil
+	case hashSHA256:
+		return sha256.New(), nil
+	case hashBLAKE3:
+		return blake3.New(), nil
+	case hashXXHash:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q (use md5, sha256, blake3, or xxhash)", algo)
+	}
+}
+
+// HashFile computes the hash of a file's contents with the given algorithm
+func HashFile(path, algo string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f, algo)
+}
+
+// hashReader computes the hash of everything read from r with the given algorithm
+func hashReader(r io.Reader, algo string) (string, error) {
+	h, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Dedup methods record how a file was checked against the backup before it was copied
+const (
+	dedupByHash          = "hash"            // Content hash, the default
+	dedupBySizeMtimeName = "size_mtime_name" // Same name, size, and mtime (--hash-only-videos)
+	dedupBySample        = "sample"          // Hash of the first and last bytes and the size (--checksum-sample)
+)
+
+// Dedupe modes decide what a duplicate leaves behind at its own destination path
+const (
+	DedupeSkip     = "skip"     // Nothing; the duplicate is only reported
+	dedupeHardlink = "hardlink" // Hard link to the stored copy (falls back to a copy across devices)
+	dedupeSymlink  = "symlink"  // Relative symbolic link to the stored copy
+)
+
+// DefaultDedupeMode keeps the original behaviour of skipping duplicates
+const DefaultDedupeMode = DedupeSkip
+
+// ValidateDedupeMode checks a --dedupe-mode value
+func ValidateDedupeMode(mode string) error {
+	switch mode {
+	case DedupeSkip, dedupeHardlink, dedupeSymlink:
+		return nil
+	default:
+		return fmt.Errorf("unsupported dedupe mode %q (use skip, hardlink, or symlink)", mode)
+	}
+}
+
+// linkDuplicate makes a duplicate appear at its intended destination by linking it to the stored copy
+// Returns how the file was placed ("hardlink", "symlink", or "copy"), or "" if the destination was already taken
+func (r *backupRun) linkDuplicate(ctx context.Context, src, existingPath, dest, mode, algo string, atime time.Time) (string, error) {
+	if filepath.Clean(existingPath) == filepath.Clean(dest) {
+		return "", nil
+	}
+	if _, err := r.fs.Lstat(dest); err == nil {
+		return "", nil
+	}
+	if err := r.mkdirAll(filepath.Dir(dest)); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	if mode == dedupeSymlink {
+		// Relative targets keep working if the whole backup folder is moved
+		target, err := filepath.Rel(filepath.Dir(dest), existingPath)
+		if err != nil {
+			target = existingPath
+		}
+		if err := r.fs.Symlink(target, dest); err != nil {
+			return "", fmt.Errorf("failed to symlink duplicate: %w", err)
+		}
+		if err := r.syncDestDir(dest); err != nil {
+			r.fs.Remove(dest)
+			return "", err
+		}
+		return dedupeSymlink, nil
+	}
+
+	if err := r.fs.Link(existingPath, dest); err == nil {
+		if err := r.syncDestDir(dest); err != nil {
+			r.fs.Remove(dest)
+			return "", err
+		}
+		return dedupeHardlink, nil
+	}
+	// Hard links can't cross devices (or some filesystems); store a full copy instead
+	if _, err := r.copyFileWithHash(ctx, src, dest, algo, atime, src); err != nil {
+		return "", fmt.Errorf("failed to copy duplicate after hard link failed: %w", err)
+	}
+	return "copy", nil
+}
+
+// removeVerifiedSource deletes a copied file's source after re-reading the destination
+// The source is only removed when the destination size and hash match what was copied
+func (r *backupRun) removeVerifiedSource(result *FileResult, algo string) error {
+	destInfo, err := r.statDest(result.DestPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if destInfo.Size() != result.BytesCopied {
+		return fmt.Errorf("destination size %d does not match source size %d", destInfo.Size(), result.BytesCopied)
+	}
+
+	destHash, err := r.hashDestFile(result.DestPath, algo)
+	if err != nil {
+		return fmt.Errorf("failed to hash destination: %w", err)
+	}
+	if result.Hash == "" || destHash != result.Hash {
+		return fmt.Errorf("destination hash does not match copied content")
+	}
+
+	if err := os.Remove(result.Path); err != nil {
+		return fmt.Errorf("failed to remove source: %w", err)
+	}
+	return nil
+}
+
+// copyFileWithHash combines file copying and hash computation in a single pass
+// This optimizes I/O by reading the file only once while preserving modification time
+// Returns the hash (computed with algo) and any error that occurred during the operation
+// atime is the source's access time from before anything read it; zero takes the current one
+// owner is the file whose owner the copy gets with --preserve-owner: src, or the original of a conversion
+func (r *backupRun) copyFileWithHash(ctx context.Context, src, dst, algo string, atime time.Time, owner string) (string, error) {
+	// Step 1: Get source file modification and access times
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source file %s: %w", src, err)
+	}
+	sourceModTime := srcInfo.ModTime()
+	sourceAccessTime := atime
+	if sourceAccessTime.IsZero() {
+		sourceAccessTime = fileAccessTime(srcInfo)
+	}
+
+	// Step 2: Perform atomic file copy with simultaneous hash computation
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source file %s: %w", src, err)
+	}
+	defer in.Close()
+
+	tmpDst, err := r.scratchPath(dst)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := r.fs.Create(tmpDst)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file %s: %w", tmpDst, err)
+	}
+
+	// Initialize hash computation
+	hasher, err := newHasher(algo)
+	if err != nil {
+		out.Close()
+		r.fs.Remove(tmpDst)
+		return "", err
+	}
+
+	// Ensure cleanup on error or cancellation; temp files in --tmp-dir have unique names,
+	// so one left behind by a failed copy would never be overwritten by the next run
+	moved := false
+	defer func() {
+		out.Close()
+		if ctx.Err() != nil || (r.opts.ScratchDir != "" && !moved) {
+			r.fs.Remove(tmpDst)
+		}
+	}()
+
+	// Copy data with simultaneous hash computation using io.MultiWriter
+	multiWriter := io.MultiWriter(out, hasher)
+	buf := make([]byte, 1024*1024) // 1MB buffer for efficient copying
+	var copied int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		default:
+		}
+
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			if r.limiter != nil {
+				if err := r.limiter.wait(ctx, n); err != nil {
+					return "", err
+				}
+			}
+			if _, writeErr := multiWriter.Write(buf[:n]); writeErr != nil {
+				return "", fmt.Errorf("failed to write to temp file: %w", writeErr)
+			}
+			copied += int64(n)
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("failed to read from source file: %w", readErr)
+		}
+	}
+
+	// A source that ends early (truncated by a bad card or cut off while it was being read) or
+	// grew during the copy would otherwise be stored as a partial file with a valid-looking hash
+	if copied != srcInfo.Size() {
+		out.Close()
+		r.fs.Remove(tmpDst)
+		return "", fmt.Errorf("short copy: read %d of %d bytes from source (file truncated or changed while copying)", copied, srcInfo.Size())
+	}
+
+	// Ensure data is written to disk, locally or on the remote server
+	if f, ok := out.(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return "", fmt.Errorf("failed to sync temp file: %w", err)
+		}
+	}
+
+	// Close temp file before setting timestamps
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	// Check for cancellation before final operations
+	if ctx.Err() != nil {
+		r.fs.Remove(tmpDst)
+		return "", ctx.Err()
+	}
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	// Optional read-back: the temp file must hold exactly the bytes read from the source,
+	// otherwise it never becomes the destination and nothing is recorded in the database
+	if r.verify {
+		writtenHash, err := r.hashDestFile(tmpDst, algo)
+		if err != nil {
+			r.fs.Remove(tmpDst)
+			return "", fmt.Errorf("failed to re-read temp file for verification: %w", err)
+		}
+		if writtenHash != hash {
+			r.fs.Remove(tmpDst)
+			return "", fmt.Errorf("copy verification failed: written file hashes to %s, source to %s", writtenHash, hash)
+		}
+	}
+
+	// Permissions and owner are set before the rename, so the file never appears without them
+	if err := r.applyFileOwnership(owner, tmpDst); err != nil {
+		r.fs.Remove(tmpDst)
+		return "", err
+	}
+
+	// Step 3: Set modification and access times on temp file before rename
+	if err := r.fs.Chtimes(tmpDst, sourceAccessTime, sourceModTime); err != nil {
+		// Log warning but don't fail - timestamp preservation is best-effort
+		r.console.Printf("Warning: Could not set timestamps on %s: %v", tmpDst, err)
+		r.log.Warn("could not set timestamps on %s: %v", tmpDst, err)
+	}
+
+	// Step 4: Atomically move temp file to final destination
+	if err := r.moveIntoDest(ctx, tmpDst, dst, owner, sourceAccessTime, sourceModTime); err != nil {
+		r.fs.Remove(tmpDst)
+		return "", fmt.Errorf("failed to rename temp file to destination: %w", err)
+	}
+	moved = true
+	if err := r.syncDestDir(dst); err != nil {
+		r.fs.Remove(dst)
+		return "", err
+	}
+
+	// Step 5: Return computed hash
+	return hash, nil
+}
@@ -0,0 +1,133 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// heicExtensions are the photo formats converted by --convert-heic-to-jpeg
+var heicExtensions = map[string]bool{
+	".heic": true,
+	".heif": true,
+}
+
+// heicConverter runs an external tool that writes src as a JPEG to dst, keeping its EXIF
+// Go has no HEIC decoder, so conversion relies on libheif, ImageMagick, or macOS sips
+type heicConverter struct {
+	tool string
+	args func(src, dst string) []string
+}
+
+// heicConverters are tried in order; the first one found in PATH is used
+var heicConverters = []heicConverter{
+	{"heif-convert", func(src, dst string) []string { return []string{"-q", "92", src, dst} }},
+	{"magick", func(src, dst string) []string { return []string{src, "-quality", "92", dst} }},
+	{"sips", func(src, dst string) []string { return []string{"-s", "format", "jpeg", src, "--out", dst} }},
+}
+
+// findHEICConverter picks the first available converter, returning nil if there is none
+func findHEICConverter() *heicConverter {
+	for i, converter := range heicConverters {
+		if converter.tool == "sips" && runtime.GOOS != "darwin" {
+			continue
+		}
+		if CheckExternalTool(converter.tool) {
+			return &heicConverters[i]
+		}
+	}
+	return nil
+}
+
+// CanConvertHEIC reports whether a converter for BackupOptions.ConvertHEIC is installed
+func CanConvertHEIC() bool {
+	return findHEICConverter() != nil
+}
+
+// CheckExternalTool checks if a tool is available in PATH
+func CheckExternalTool(tool string) bool {
+	_, err := exec.LookPath(tool)
+	return err == nil
+}
+
+// storedName is the file name a source dated date is stored under: its own (or the
+// --rename-pattern name), with the extension of its content for mislabeled files with
+// --check-content-type fix, and .jpg for converted HEIC photos
+func (r *backupRun) storedName(path string, date time.Time) string {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	if r.opts.RenamePattern != "" {
+		name = applyRenamePattern(r.opts.RenamePattern, strings.TrimSuffix(name, ext), date) + ext
+	}
+	if content, found := r.mislabeledTypes[path]; found && r.opts.ContentCheck == contentCheckFix {
+		name = strings.TrimSuffix(name, ext) + content
+		ext = content
+	}
+	if r.opts.ConvertHEIC && heicExtensions[strings.ToLower(ext)] {
+		return strings.TrimSuffix(name, ext) + ".jpg"
+	}
+	return name
+}
+
+// isHEICConversion reports whether storing src at dest means converting it
+// A mislabeled file is converted by what its content is (--check-content-type)
+func (r *backupRun) isHEICConversion(src, dest string) bool {
+	return r.opts.ConvertHEIC && heicExtensions[r.contentExt(src)] &&
+		!heicExtensions[metadata.NormalizeExt(dest)]
+}
+
+// storeConvertedHEIC converts src to a local temp JPEG and stores that at dest
+// Returns the hash of the original HEIC, so duplicates are still detected by the source content
+func (r *backupRun) storeConvertedHEIC(ctx context.Context, src, dest, algo string, atime time.Time) (string, error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source file %s: %w", src, err)
+	}
+	if atime.IsZero() {
+		atime = fileAccessTime(srcInfo)
+	}
+	hash, err := HashFile(src, algo)
+	if err != nil {
+		return "", err
+	}
+
+	tmpDir, err := os.MkdirTemp(r.opts.ScratchDir, "backupbozo-heic-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp folder for conversion: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	jpeg := filepath.Join(tmpDir, filepath.Base(dest))
+
+	cmd := exec.CommandContext(ctx, r.heic.tool, r.heic.args(src, jpeg)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%s could not convert %s: %v: %s", r.heic.tool, src, err, strings.TrimSpace(string(output)))
+	}
+	// The JPEG carries the photo's original dates, like any other copy
+	if err := os.Chtimes(jpeg, atime, srcInfo.ModTime()); err != nil {
+		return "", fmt.Errorf("failed to set timestamps on converted file: %w", err)
+	}
+
+	// Stored like any other copy, but owned like the original (--preserve-owner)
+	if _, _, archived := splitArchiveMember(dest); archived {
+		_, err = r.storeFile(ctx, jpeg, dest, algo, atime)
+	} else {
+		_, err = r.retryCopy(ctx, src, func() (string, error) {
+			return r.copyFileWithHash(ctx, jpeg, dest, algo, atime, src)
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
@@ -0,0 +1,81 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// ParseHookCommand splits a --post-copy-hook value into a program and its arguments
+// Arguments are separated by spaces; a script can do anything that needs shell quoting
+func ParseHookCommand(value string) ([]string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid --post-copy-hook: %w", err)
+	}
+	return fields, nil
+}
+
+// runPostCopyHook runs --post-copy-hook for a copied file, if one is set, and returns its error
+// The pending batch, which holds the file's record, is committed first: a hook that reads the
+// database finds the file, and a crash after the hook acted on it can't lose its record.
+// A failure is logged; with --post-copy-hook-fatal it also stops the backup. A hook killed because
+// the backup was stopped still returns its error, so the file isn't kept as if it had succeeded
+func (r *backupRun) runPostCopyHook(ctx context.Context, batchInserter *BatchInserter, src, dest string) error {
+	if len(r.opts.PostCopyHook) == 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err := batchInserter.FlushWithContext(ctx)
+	if err != nil {
+		err = fmt.Errorf("could not record the file before running the hook: %w", err)
+	} else {
+		err = r.execPostCopyHook(ctx, src, dest)
+	}
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	r.console.Printf("Warning: Post-copy hook failed for %s: %v", src, err)
+	r.log.Warn("post-copy hook failed for %s: %v", src, err)
+	if r.opts.PostCopyHookFatal && r.stop != nil {
+		if r.opts.Verbosity.showSummary() {
+			color.New(color.FgRed, color.Bold).Printf("\nPost-copy hook failed for %s, stopping the backup: %v\n", src, err)
+		}
+		r.log.Error("stopping the backup after a failed post-copy hook")
+		r.stop()
+	}
+	return err
+}
+
+// execPostCopyHook runs the hook command once, honouring --post-copy-hook-timeout
+func (r *backupRun) execPostCopyHook(ctx context.Context, src, dest string) error {
+	hook, timeout := r.opts.PostCopyHook, r.opts.PostCopyHookTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	args := append(append([]string{}, hook[1:]...), src, dest)
+	cmd := exec.CommandContext(ctx, hook[0], args...)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if message := strings.TrimSpace(string(output)); message != "" {
+		return fmt.Errorf("%v: %s", err, message)
+	}
+	return err
+}
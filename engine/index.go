@@ -0,0 +1,133 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// IndexDestination records the media files already in a destination in the database without
+// copying anything, so an archive organized by hand (or by another tool) becomes the baseline for
+// deduplication. Files the database already knows are left alone. Indexed records have no source
+// path, so a rollback of the index run never deletes them
+func IndexDestination(ctx context.Context, destDir, dbPath, hashAlgo string, force bool) error {
+	if err := ValidateHashAlgorithm(hashAlgo); err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+	destDir = absDestDir(destDir, true)
+	if err := checkDirExists(destDir, "destination"); err != nil {
+		return err
+	}
+
+	// A backup running meanwhile would record the same files
+	lock, err := acquireRunLock(dbPath, force, nil, VerbosityNormal)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := useDestDir(db, destDir, true); err != nil {
+		return err
+	}
+
+	records, err := loadRecordedFiles(db)
+	if err != nil {
+		return fmt.Errorf("could not read database: %w", err)
+	}
+	known := make(map[string]bool, len(records))
+	for _, record := range records {
+		known[filepath.Clean(record.DestPath)] = true
+	}
+
+	reportsDir := filepath.Join(destDir, "reports")
+	files, _, walkErrors := getAllFiles(destDir, nil, false, 0, false, VerbosityNormal)
+	for _, walkErr := range walkErrors {
+		log.Printf("Warning: %v", walkErr)
+	}
+	var toIndex []FileWithInfo
+	for _, file := range files {
+		path := filepath.Clean(file.Path)
+		if known[path] || strings.HasPrefix(path, reportsDir+string(filepath.Separator)) {
+			continue
+		}
+		if !defaultExtensions[metadata.NormalizeExt(path)] {
+			continue
+		}
+		toIndex = append(toIndex, file)
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("🗂️  Indexing Destination\n")
+	fmt.Printf("   %d files already in the database, %d new files to hash...\n", len(known), len(toIndex))
+
+	runID := time.Now().Format(runIDLayout)
+	batchInserter := NewBatchInserter(db, loadExistingHashes(db, hashAlgo, log.Default()), hashAlgo, runID, DefaultBatchSize, log.Default())
+
+	bar := progressbar.NewOptions(
+		len(toIndex),
+		progressbar.OptionSetVisibility(StdoutIsTerminal()),
+		progressbar.OptionSetDescription("Indexing"),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[cyan]=[reset]",
+			SaucerHead:    "[cyan]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+
+	indexed, duplicates, failed := 0, 0, 0
+	for _, file := range toIndex {
+		if ctx.Err() != nil {
+			break
+		}
+		hash, err := HashFile(file.Path, hashAlgo)
+		if err != nil {
+			log.Printf("Warning: Could not hash %s: %v", file.Path, err)
+			failed++
+			bar.Add(1)
+			continue
+		}
+		// The same content stored twice in the archive keeps only its first path as the record
+		if _, added := batchInserter.Add("", file.Path, hash, file.Info.Size(), file.Info.ModTime().Unix(), time.Time{}, "", nil, "", dedupByHash, ""); !added {
+			duplicates++
+		} else {
+			indexed++
+		}
+		bar.Add(1)
+	}
+	bar.Finish()
+	fmt.Println()
+
+	if err := batchInserter.FlushWithContext(context.Background()); err != nil {
+		return fmt.Errorf("could not write database: %w", err)
+	}
+
+	if ctx.Err() != nil {
+		color.New(color.FgYellow).Printf("   Interrupted; run index again to finish\n")
+	}
+	color.New(color.FgGreen, color.Bold).Printf("   ✅ Indexed: %d files\n", indexed)
+	color.New(color.FgBlue).Printf("   🔄 Duplicates within the archive: %d files\n", duplicates)
+	if failed > 0 {
+		color.New(color.FgRed).Printf("   ❌ Could not read: %d files\n", failed)
+	}
+	return nil
+}
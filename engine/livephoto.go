@@ -0,0 +1,201 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// livePhotoStillExtensions and livePhotoVideoExtensions are the two halves of an iPhone Live Photo
+// (IMG_0001.HEIC + IMG_0001.MOV; "Most Compatible" capture writes a JPG instead of HEIC)
+var livePhotoStillExtensions = map[string]bool{
+	".heic": true,
+	".jpg":  true,
+	".jpeg": true,
+}
+
+var livePhotoVideoExtensions = map[string]bool{
+	".mov": true,
+}
+
+// LiveVideoResult is the outcome for the video half of a live photo
+type LiveVideoResult struct {
+	Path          string    // Source video
+	DestPath      string    // Where the video was (or would have been) placed
+	State         FileState // StateCopied, StateDuplicateHash, StateSkippedDestExists, or an error state
+	Error         error
+	Hash          string
+	Size          int64
+	ExistingPath  string // Stored copy, for duplicates
+	LinkedAs      string // hardlink, symlink, or copy when a duplicate was linked in (--dedupe-mode)
+	SourceRemoved bool   // Source deleted after verified copy (--move mode)
+}
+
+// pairLivePhotos moves each live photo video onto its still (same folder, same name, case-insensitive)
+// and drops it from the list, so the pair is dated, placed, and reported as one unit
+// Sidecars of the video travel with the still; sidecarDestPath names them after the video's extension
+func (r *backupRun) pairLivePhotos(files []FileWithInfo) []FileWithInfo {
+	if !r.extensions[".mov"] {
+		return files
+	}
+	stills := make(map[string]int)
+	for i, file := range files {
+		ext := metadata.NormalizeExt(file.Path)
+		if livePhotoStillExtensions[ext] && r.extensions[ext] {
+			stills[strings.ToLower(strings.TrimSuffix(file.Path, filepath.Ext(file.Path)))] = i
+		}
+	}
+	if len(stills) == 0 {
+		return files
+	}
+
+	paired := make(map[int]bool)
+	for i, file := range files {
+		ext := metadata.NormalizeExt(file.Path)
+		if !livePhotoVideoExtensions[ext] {
+			continue
+		}
+		still, found := stills[strings.ToLower(strings.TrimSuffix(file.Path, filepath.Ext(file.Path)))]
+		if !found || files[still].LiveVideo != nil {
+			continue
+		}
+		video := file
+		files[still].Sidecars = append(files[still].Sidecars, video.Sidecars...)
+		video.Sidecars = nil
+		files[still].LiveVideo = &video
+		paired[i] = true
+	}
+	if len(paired) == 0 {
+		return files
+	}
+
+	kept := make([]FileWithInfo, 0, len(files)-len(paired))
+	for i, file := range files {
+		if !paired[i] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// followsStill reports whether a live photo's video should be backed up after its still ended in state
+// Filters (dates, sizes, incremental) and errors apply to the whole pair
+func followsStill(state FileState) bool {
+	return state == StateCopied || state == StateDuplicateHash || state == StateSkippedDestExists
+}
+
+// processLiveVideo backs up the video half of a live photo into the folder chosen for its still
+// The video is deduplicated on its own: it is only copied when its content isn't stored yet
+// It is recorded under the still's date, camera, and location; the date is the one that chose its folder
+func (r *backupRun) processLiveVideo(ctx context.Context, candidate *FileCandidate, date time.Time, camera string, location *metadata.Location, batchInserter *BatchInserter) *LiveVideoResult {
+	video := candidate.LiveVideo
+	size, mtime := video.Info.Size(), video.Info.ModTime().Unix()
+	result := &LiveVideoResult{
+		Path:     video.Path,
+		DestPath: sidecarDestPath(candidate.Path, candidate.DestPath, video.Path),
+		Size:     size,
+	}
+
+	hash, cached := batchInserter.CachedHash(video.Path, size, mtime)
+	if !cached {
+		var err error
+		if hash, err = HashFile(video.Path, batchInserter.hashAlgo); err != nil {
+			result.State, result.Error = StateErrorHash, err
+			return result
+		}
+		batchInserter.CacheHash(video.Path, size, mtime, hash)
+	}
+	result.Hash = hash
+
+	if existingPath, exists := batchInserter.Lookup(hash); exists {
+		result.State, result.ExistingPath = StateDuplicateHash, existingPath
+		r.placeLiveVideoDuplicate(ctx, candidate, result, batchInserter.hashAlgo)
+		return result
+	}
+
+	// Same collision rules as any other file: identical content is already backed up,
+	// different content under the same name is handled by --collision-mode
+	if _, err := r.statDest(result.DestPath); err == nil || !batchInserter.ClaimDest(result.DestPath) {
+		if existingHash, err := r.hashDestFile(result.DestPath, batchInserter.hashAlgo); err == nil && existingHash == hash {
+			result.State = StateSkippedDestExists
+			return result
+		}
+		var state FileState
+		if result.DestPath, state = r.placeCollision(result.DestPath, hash, batchInserter); state != StateCopied {
+			result.State = state
+			return result
+		}
+	}
+
+	if err := r.mkdirAll(destParentDir(result.DestPath)); err != nil {
+		result.State, result.Error = StateErrorCopy, fmt.Errorf("failed to create destination directory: %w", err)
+		return result
+	}
+	copiedHash, err := r.storeFile(ctx, video.Path, result.DestPath, batchInserter.hashAlgo, fileAccessTime(video.Info))
+	if err != nil {
+		result.State, result.Error = StateErrorCopy, err
+		return result
+	}
+	result.Hash = copiedHash
+	if existingPath, added := batchInserter.Add(video.Path, result.DestPath, copiedHash, size, mtime, date, camera, location, "", dedupByHash, ""); !added {
+		// Another worker stored identical content first - drop our copy
+		r.fs.Remove(result.DestPath)
+		result.State, result.ExistingPath = StateDuplicateHash, existingPath
+		return result
+	}
+	result.State = StateCopied
+	return result
+}
+
+// placeLiveVideoDuplicate links a duplicate video next to its still when --dedupe-mode asks for it
+func (r *backupRun) placeLiveVideoDuplicate(ctx context.Context, candidate *FileCandidate, result *LiveVideoResult, algo string) {
+	if candidate.DedupeMode == "" || candidate.DedupeMode == DedupeSkip || r.knownDBPaths[result.ExistingPath] {
+		return
+	}
+	linkedAs, err := r.linkDuplicate(ctx, result.Path, result.ExistingPath, result.DestPath, candidate.DedupeMode, algo, fileAccessTime(candidate.LiveVideo.Info))
+	if err != nil {
+		result.State, result.Error = StateErrorCopy, err
+		return
+	}
+	result.LinkedAs = linkedAs
+}
+
+// attachLiveVideo processes a live photo's video once its still is settled and folds the
+// outcome into the still's result; a failed video fails the pair so the next run retries it
+func (r *backupRun) attachLiveVideo(ctx context.Context, candidate *FileCandidate, result *FileResult, batchInserter *BatchInserter) {
+	if candidate.LiveVideo == nil || !followsStill(result.State) || ctx.Err() != nil {
+		return
+	}
+	video := r.processLiveVideo(ctx, candidate, result.Date, result.Camera, result.Location, batchInserter)
+	result.LiveVideo = video
+	if video.State.IsError() {
+		result.State = StateErrorCopy
+		result.Error = fmt.Errorf("live photo video %s not backed up: %w", filepath.Base(video.Path), video.Error)
+	}
+}
+
+// liveVideoNote describes a live photo's video for report details, or "" for plain files
+func liveVideoNote(video *LiveVideoResult) string {
+	if video == nil {
+		return ""
+	}
+	name := filepath.Base(video.Path)
+	switch video.State {
+	case StateCopied:
+		return fmt.Sprintf("live photo, video %s copied to %s", name, video.DestPath)
+	case StateDuplicateHash:
+		if video.LinkedAs != "" {
+			return fmt.Sprintf("live photo, video %s already stored at %s, %s at %s", name, video.ExistingPath, video.LinkedAs, video.DestPath)
+		}
+		return fmt.Sprintf("live photo, video %s already stored at %s", name, video.ExistingPath)
+	case StateSkippedDestExists:
+		return fmt.Sprintf("live photo, video %s already at %s", name, video.DestPath)
+	default:
+		return fmt.Sprintf("live photo, video %s: %s", name, video.State)
+	}
+}
@@ -0,0 +1,43 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+
+	"backupbozo/metadata"
+)
+
+// formatLocation shows GPS coordinates to five decimals (about a metre), "" when unknown
+func formatLocation(location *metadata.Location) string {
+	if location == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", location.Latitude, location.Longitude)
+}
+
+// locationURL links GPS coordinates to OpenStreetMap, so a report can show where a photo was
+// taken without geocoding anything itself; "" when unknown
+func locationURL(location *metadata.Location) string {
+	if location == nil {
+		return ""
+	}
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.5f&mlon=%.5f#map=15/%.5f/%.5f",
+		location.Latitude, location.Longitude, location.Latitude, location.Longitude)
+}
+
+// locationColumns returns GPS coordinates as the files table's latitude and longitude (NULL when unknown)
+func locationColumns(location *metadata.Location) (sql.NullFloat64, sql.NullFloat64) {
+	if location == nil {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: location.Latitude, Valid: true}, sql.NullFloat64{Float64: location.Longitude, Valid: true}
+}
+
+// scannedLocation turns the latitude and longitude columns back into a location
+func scannedLocation(latitude, longitude sql.NullFloat64) *metadata.Location {
+	if !latitude.Valid || !longitude.Valid {
+		return nil
+	}
+	return &metadata.Location{Latitude: latitude.Float64, Longitude: longitude.Float64}
+}
@@ -0,0 +1,136 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// runLock keeps two backups from writing the same database and destination at once
+// It is a file next to the database holding the owner's pid, host, and start time
+type runLock struct {
+	path string
+	log  *EventLog
+}
+
+// lockOwner is what a lock file says about the run holding it
+type lockOwner struct {
+	pid     int
+	host    string
+	started time.Time
+}
+
+// parseLockOwner reads a lock file's "pid host unix-time" line; ok is false if it is garbled
+func parseLockOwner(data string) (owner lockOwner, ok bool) {
+	fields := strings.Fields(data)
+	if len(fields) != 3 {
+		return owner, false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return owner, false
+	}
+	started, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return owner, false
+	}
+	return lockOwner{pid: pid, host: fields[1], started: time.Unix(started, 0)}, true
+}
+
+// acquireRunLock creates the lock file for dbPath, returning an error if another backup holds it
+// A lock left by a crashed run on this machine is taken over automatically; one from another
+// machine (a shared disk) can't be checked, so it needs force (--force), which takes over any lock
+// Taking over a lock is printed unless verbosity is VerbositySilent
+func acquireRunLock(dbPath string, force bool, log *EventLog, verbosity Verbosity) (*runLock, error) {
+	path := dbPath + ".lock"
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "unknown"
+	}
+	content := fmt.Sprintf("%d %s %d\n", os.Getpid(), host, time.Now().Unix())
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(content)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("could not write lock file %s: %w", path, err)
+			}
+			return &runLock{path: path, log: log}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("could not create lock file %s: %w", path, err)
+		}
+
+		data, readErr := os.ReadFile(path)
+		owner, ok := parseLockOwner(string(data))
+		switch {
+		case force:
+			if verbosity.showSummary() {
+				color.New(color.FgYellow).Printf("Taking over lock %s (--force)\n", path)
+			}
+			log.Warn("took over lock %s held by %q (--force)", path, strings.TrimSpace(string(data)))
+		case readErr == nil && ok && owner.host == host && !processRunning(owner.pid):
+			if verbosity.showSummary() {
+				color.New(color.FgYellow).Printf("Removing stale lock left by an earlier backup (pid %d, started %s)\n",
+					owner.pid, owner.started.Format("2006-01-02 15:04:05"))
+			}
+			log.Warn("removed stale lock %s left by pid %d", path, owner.pid)
+		case ok:
+			return nil, fmt.Errorf("another backup is already using %s (pid %d on %s, started %s); wait for it to finish, or run with --force if it is no longer running (lock file: %s)",
+				dbPath, owner.pid, owner.host, owner.started.Format("2006-01-02 15:04:05"), path)
+		default:
+			return nil, fmt.Errorf("another backup may be using %s (unreadable lock file %s); run with --force if no other backup is running", dbPath, path)
+		}
+		if err := takeOverLock(path, data, force); err != nil {
+			return nil, fmt.Errorf("could not remove lock file %s: %w", path, err)
+		}
+	}
+	// Another run took the lock between our takeover and retry
+	return nil, fmt.Errorf("another backup took the lock on %s while it was being cleared", dbPath)
+}
+
+// errLockChanged means another run replaced the stale lock while it was being taken over
+var errLockChanged = errors.New("another backup replaced it while it was being cleared")
+
+// takeOverLock moves aside the lock file judged stale from its content seen, so only one of
+// several runs clearing it at once succeeds: the others' renames fail, and they retry creating it.
+// A run that read the lock before another replaced it would move the new, live lock aside, so the
+// moved file is checked against seen and put back if it differs (unless forced). A lock already
+// gone is not an error, since the retry will create or find the current one
+func takeOverLock(path string, seen []byte, force bool) error {
+	aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(aside)
+	if force {
+		return nil
+	}
+	if moved, err := os.ReadFile(aside); err != nil || !bytes.Equal(moved, seen) {
+		os.Link(aside, path) // Fails if yet another run already holds the lock, which is fine
+		return errLockChanged
+	}
+	return nil
+}
+
+// release removes the lock file so the next backup can run
+func (l *runLock) release() {
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		l.log.Warn("could not remove lock file %s: %v", l.path, err)
+	}
+}
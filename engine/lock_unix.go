@@ -0,0 +1,14 @@
+//go:build !windows
+
+package engine
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process with this pid exists (Unix implementation)
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package engine
+
+import "syscall"
+
+// processRunning reports whether a process with this pid is still running (Windows implementation)
+func processRunning(pid int) bool {
+	const processQueryLimitedInformation = 0x1000
+	const stillActive = 259
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Access denied means the process exists but belongs to someone else
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,93 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log levels written to the --log-file
+const (
+	levelInfo  = "INFO"
+	levelWarn  = "WARN"
+	levelError = "ERROR"
+)
+
+// EventLog writes timestamped, leveled lines to a log file for unattended runs
+// All methods are safe on a nil *EventLog, which discards everything (no --log-file)
+type EventLog struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// OpenEventLog opens (appending to) the log file at path
+func OpenEventLog(path string) (*EventLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: f}, nil
+}
+
+// write formats one line: "<RFC3339 time> <LEVEL> <message>"
+func (l *EventLog) write(level, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Fprintf(l.file, "%s %-5s %s\n", time.Now().Format(time.RFC3339), level, fmt.Sprintf(format, args...))
+}
+
+// Info logs a normal event such as a copied file
+func (l *EventLog) Info(format string, args ...interface{}) {
+	l.write(levelInfo, format, args...)
+}
+
+// Warn logs something that needs attention but did not fail the file
+func (l *EventLog) Warn(format string, args ...interface{}) {
+	l.write(levelWarn, format, args...)
+}
+
+// Error logs a failure
+func (l *EventLog) Error(format string, args ...interface{}) {
+	l.write(levelError, format, args...)
+}
+
+// Close closes the log file
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// logFileResult writes the outcome of one file at a level matching its state
+func (l *EventLog) logFileResult(result *FileResult) {
+	switch {
+	case result.State.IsError() && result.Error != nil:
+		l.Error("%s: %s: %v", result.State, result.Path, result.Error)
+	case result.State.IsError():
+		l.Error("%s: %s", result.State, result.Path)
+	case result.State == StateCopied && result.RenamedFrom != "":
+		l.Warn("copied %s -> %s (%s, %s holds a different file)", result.Path, result.DestPath, collisionNote(result.RenamedFrom, result.DestPath), result.RenamedFrom)
+	case result.State == StateCopied:
+		l.Info("copied %s -> %s (%d bytes)", result.Path, result.DestPath, result.BytesCopied)
+	case result.State == StateDuplicateHash:
+		if result.LinkedAs != "" {
+			l.Info("duplicate %s of %s, %s at %s", result.Path, result.ExistingDuplicatePath, result.LinkedAs, result.DestPath)
+		} else {
+			l.Info("duplicate %s of %s", result.Path, result.ExistingDuplicatePath)
+		}
+	default:
+		l.Info("%s: %s", result.State, result.Path)
+	}
+	for _, sidecar := range result.Sidecars {
+		l.Info("copied sidecar %s", sidecar)
+	}
+	if note := liveVideoNote(result.LiveVideo); note != "" && !result.LiveVideo.State.IsError() {
+		l.Info("%s: %s", result.Path, note)
+	}
+}
@@ -0,0 +1,150 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestName is the checksum file written to the destination root, readable by `sha256sum -c`
+const manifestName = "SHA256SUMS"
+
+// writeManifest rewrites dest/SHA256SUMS from the database so the archive can be checked with
+// standard tools. Records already hashed with sha256 are used as-is; files recorded with another
+// algorithm are hashed once and their sums kept in manifest_cache, reused while the file's size
+// and mtime are unchanged
+func (d *destination) writeManifest(db *sql.DB, destDir string) (int, error) {
+	records, err := loadRecordedFiles(db)
+	if err != nil {
+		return 0, err
+	}
+	cached := loadManifestCache(db, d.console)
+
+	sums := make(map[string]string, len(records))
+	var hashed []manifestCacheEntry
+	for _, record := range records {
+		rel, err := filepath.Rel(destDir, record.DestPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue // Stored outside this destination
+		}
+		rel = filepath.ToSlash(rel)
+		info, err := d.fs.Stat(record.DestPath)
+		if err != nil {
+			continue // Deleted from the backup; sha256sum -c would only report it missing
+		}
+		if record.HashAlgo == hashSHA256 {
+			sums[rel] = record.Hash
+			continue
+		}
+
+		entry, found := cached[rel]
+		if !found || entry.size != info.Size() || entry.mtime != info.ModTime().Unix() {
+			sum, err := d.hashDestFile(record.DestPath, hashSHA256)
+			if err != nil {
+				return 0, fmt.Errorf("could not hash %s: %w", record.DestPath, err)
+			}
+			entry = manifestCacheEntry{path: rel, size: info.Size(), mtime: info.ModTime().Unix(), sum: sum}
+		}
+		sums[rel] = entry.sum
+		hashed = append(hashed, entry)
+	}
+
+	paths := make([]string, 0, len(sums))
+	for rel := range sums {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	// Write to a temp file and rename so a crash never leaves a truncated manifest
+	manifestPath := filepath.Join(destDir, manifestName)
+	tmpPath := manifestPath + ".tmp"
+	f, err := d.fs.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	for _, rel := range paths {
+		w.WriteString(manifestLine(sums[rel], rel))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		d.fs.Remove(tmpPath)
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		d.fs.Remove(tmpPath)
+		return 0, err
+	}
+	if err := d.fs.Rename(tmpPath, manifestPath); err != nil {
+		d.fs.Remove(tmpPath)
+		return 0, err
+	}
+	if err := saveManifestCache(db, hashed); err != nil {
+		d.console.Printf("Warning: Could not save manifest checksums: %v", err)
+	}
+	return len(paths), nil
+}
+
+// manifestLine formats one entry like sha256sum does, including its escaping of
+// backslashes and newlines in file names (such lines start with a backslash)
+func manifestLine(sum, rel string) string {
+	if strings.ContainsAny(rel, "\\\n") {
+		rel = strings.ReplaceAll(rel, "\\", "\\\\")
+		rel = strings.ReplaceAll(rel, "\n", "\\n")
+		return fmt.Sprintf("\\%s  %s\n", sum, rel)
+	}
+	return fmt.Sprintf("%s  %s\n", sum, rel)
+}
+
+// manifestCacheEntry is the sha256 of a stored file recorded with another algorithm, valid while
+// the file keeps its size and mtime
+type manifestCacheEntry struct {
+	path        string // Relative to the destination, as in the manifest
+	size, mtime int64
+	sum         string
+}
+
+// loadManifestCache reads the sha256 sums kept for the manifest, by relative path
+func loadManifestCache(db *sql.DB, console *log.Logger) map[string]manifestCacheEntry {
+	entries := make(map[string]manifestCacheEntry)
+	rows, err := db.Query("SELECT dest_path, size, mtime, sha256 FROM manifest_cache")
+	if err != nil {
+		console.Printf("Warning: Could not load manifest checksums: %v", err)
+		return entries
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry manifestCacheEntry
+		if err := rows.Scan(&entry.path, &entry.size, &entry.mtime, &entry.sum); err != nil {
+			continue
+		}
+		entries[entry.path] = entry
+	}
+	return entries
+}
+
+// saveManifestCache replaces the kept sums with those of the manifest just written, so files
+// gone from the backup drop out
+func saveManifestCache(db *sql.DB, entries []manifestCacheEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM manifest_cache"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO manifest_cache (dest_path, size, mtime, sha256) VALUES (?, ?, ?, ?)",
+			entry.path, entry.size, entry.mtime, entry.sum); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
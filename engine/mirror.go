@@ -0,0 +1,232 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkMirrorPath fails if the mirror is the source or the destination, or inside either of
+// them (or the other way round): the run would copy its own copies again, or mirror onto itself
+func checkMirrorPath(mirrorDir, srcDir, destDir string, fsys DestFS) error {
+	mirror := resolvedPath(mirrorDir)
+	others := []struct{ label, path string }{{"source", srcDir}}
+	if _, isLocal := fsys.(localFS); isLocal {
+		others = append(others, struct{ label, path string }{"destination", destDir})
+	}
+	for _, other := range others {
+		if IsZipFile(other.path) {
+			continue
+		}
+		path := resolvedPath(other.path)
+		if mirror == path || pathContains(mirror, path) || pathContains(path, mirror) {
+			return fmt.Errorf("mirror '%s' overlaps the %s '%s'; mirror to a folder outside it", mirror, other.label, path)
+		}
+	}
+	return nil
+}
+
+// mirrorPath is where a stored file goes in mirrorDir, at the same place relative to the destination
+func mirrorPath(mirrorDir, destDir, dest string) (string, error) {
+	rel, err := filepath.Rel(filepath.FromSlash(destDir), filepath.FromSlash(dest))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%s is outside the destination %s", dest, destDir)
+	}
+	return filepath.Join(mirrorDir, rel), nil
+}
+
+// errMirrorMismatch is a mirror copy whose content doesn't hash to what the database recorded
+var errMirrorMismatch = errors.New("mirror copy does not match the recorded hash")
+
+// mirrorResult writes a copied file to the mirror with its sidecars and live photo video, and
+// records where it went or why it didn't. A stored file found again as a duplicate is mirrored
+// too when the mirror lacks it, so a mirror copy that failed is retried by the next run
+// The backup itself is never undone by a mirror failure. hashAlgo is what the recorded hashes use
+func (r *backupRun) mirrorResult(ctx context.Context, destDir, hashAlgo string, result *FileResult) {
+	if r.opts.MirrorDir == "" || ctx.Err() != nil {
+		return
+	}
+
+	switch result.State {
+	case StateCopied:
+		// A converted HEIC is only in the destination in its stored form, and its recorded hash
+		// is the original's
+		src, hash := result.Path, result.Hash
+		if r.isHEICConversion(result.Path, result.DestPath) {
+			src, hash = "", ""
+		}
+		target, err := r.mirrorCopy(ctx, destDir, src, result.DestPath, hash, hashAlgo)
+		for i := 0; err == nil && i < len(result.Sidecars); i++ {
+			_, err = r.mirrorCopy(ctx, destDir, "", result.Sidecars[i], "", "")
+		}
+		if video := result.LiveVideo; err == nil && video != nil && video.State == StateCopied {
+			_, err = r.mirrorCopy(ctx, destDir, video.Path, video.DestPath, video.Hash, hashAlgo)
+		}
+		if err != nil {
+			result.MirrorError = err
+			r.console.Printf("Warning: Could not mirror %s: %v", result.Path, err)
+			r.log.Warn("mirror copy failed for %s: %v", result.Path, err)
+			return
+		}
+		result.MirrorPath = target
+
+	case StateDuplicateHash:
+		// Stored files from other backups (--known-db) have no place in this mirror
+		if _, err := mirrorPath(r.opts.MirrorDir, destDir, result.ExistingDuplicatePath); err != nil {
+			return
+		}
+		hash := result.Hash
+		if r.isHEICConversion(result.Path, result.ExistingDuplicatePath) {
+			hash = ""
+		}
+		if _, err := r.mirrorCopy(ctx, destDir, "", result.ExistingDuplicatePath, hash, hashAlgo); err != nil {
+			r.console.Printf("Warning: Could not mirror %s: %v", result.ExistingDuplicatePath, err)
+			r.log.Warn("mirror copy failed for %s: %v", result.ExistingDuplicatePath, err)
+		}
+		r.mirrorSidecars(ctx, destDir, result)
+
+	default:
+		r.mirrorSidecars(ctx, destDir, result)
+	}
+}
+
+// mirrorSidecars mirrors the sidecars placed next to a photo stored by an earlier run
+func (r *backupRun) mirrorSidecars(ctx context.Context, destDir string, result *FileResult) {
+	for _, sidecar := range result.Sidecars {
+		if _, err := r.mirrorCopy(ctx, destDir, "", sidecar, "", ""); err != nil {
+			r.console.Printf("Warning: Could not mirror %s: %v", sidecar, err)
+			r.log.Warn("mirror copy failed for %s: %v", sidecar, err)
+		}
+	}
+}
+
+// mirrorCopy copies one stored file into the mirror through a temp file, reading it from src,
+// or back from the destination when src is "". When hash is set the copy must hash to it with
+// algo; a source that changed since it was stored is then mirrored from the destination instead.
+// A file already at its mirror path with the same size counts as mirrored; a different one is
+// never overwritten
+func (r *backupRun) mirrorCopy(ctx context.Context, destDir, src, dest, hash, algo string) (string, error) {
+	target, err := mirrorPath(r.opts.MirrorDir, destDir, dest)
+	if err != nil {
+		return "", err
+	}
+	info, err := r.fs.Stat(dest)
+	if err != nil {
+		return target, fmt.Errorf("failed to stat stored file: %w", err)
+	}
+	if existing, err := os.Lstat(target); err == nil {
+		if existing.Size() != info.Size() {
+			return target, fmt.Errorf("a different file is already at %s", target)
+		}
+		return target, nil
+	}
+
+	_, err = r.retryCopy(ctx, dest, func() (string, error) {
+		err := r.writeMirrorFile(ctx, src, dest, target, info, hash, algo)
+		if src != "" && errors.Is(err, errMirrorMismatch) {
+			err = r.writeMirrorFile(ctx, "", dest, target, info, hash, algo)
+		}
+		return "", err
+	})
+	return target, err
+}
+
+// writeMirrorFile writes one mirror copy, checking it against the stored file's size and recorded
+// hash (when there is one) and, with --verify-copy, reading it back
+func (r *backupRun) writeMirrorFile(ctx context.Context, src, dest, target string, info os.FileInfo, hash, algo string) error {
+	var in io.ReadCloser
+	var err error
+	if src != "" {
+		in, err = os.Open(src)
+	} else {
+		in, err = r.fs.Open(dest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dest, err)
+	}
+	defer in.Close()
+
+	if err := (localFS{}).MkdirAll(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create mirror folder: %w", err)
+	}
+	tmp := target + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file %s: %w", tmp, err)
+	}
+	if hash == "" {
+		algo = hashSHA256
+	}
+	hasher, err := newHasher(algo)
+	if err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	copied, err := io.Copy(io.MultiWriter(out, hasher), contextReader{ctx, in})
+	if err == nil && copied != info.Size() {
+		err = fmt.Errorf("short copy: wrote %d of %d bytes (file changed while mirroring)", copied, info.Size())
+	}
+	read := fmt.Sprintf("%x", hasher.Sum(nil))
+	if err == nil && hash != "" && read != hash {
+		err = errMirrorMismatch
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && r.verify {
+		var written string
+		if written, err = HashFile(tmp, algo); err == nil && written != read {
+			err = fmt.Errorf("mirror verification failed: written file does not match what was read")
+		}
+	}
+	if err == nil && r.fileMode != 0 {
+		err = os.Chmod(tmp, r.fileMode)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		r.console.Printf("Warning: Could not set timestamps on %s: %v", tmp, err)
+		r.log.Warn("could not set timestamps on %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename temp file to %s: %w", target, err)
+	}
+	return nil
+}
+
+// contextReader stops a copy when its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// mirrorNote describes a copied file's mirror copy for the reports, "" without --mirror
+func mirrorNote(copied CopiedFile) string {
+	switch {
+	case copied.MirrorError != nil:
+		return fmt.Sprintf("mirror copy failed: %v", copied.MirrorError)
+	case copied.MirrorPath != "":
+		return "mirrored"
+	}
+	return ""
+}
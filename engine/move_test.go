@@ -0,0 +1,141 @@
+// backupbozo tests for removing sources in --move mode
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copiedFile writes a source file and its stored copy with the given content, and returns the
+// result a worker would report for the copy
+func copiedFile(t *testing.T, name, content string) *FileResult {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), name)
+	dest := filepath.Join(t.TempDir(), name)
+	for _, path := range []string{src, dest} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	hash, err := HashFile(src, hashSHA256)
+	if err != nil {
+		t.Fatalf("hash %s: %v", src, err)
+	}
+	return &FileResult{Path: src, DestPath: dest, State: StateCopied, Hash: hash, Size: int64(len(content)), BytesCopied: int64(len(content))}
+}
+
+// liveVideo makes a copied file the video half of a live photo
+func liveVideo(result *FileResult) *LiveVideoResult {
+	return &LiveVideoResult{Path: result.Path, DestPath: result.DestPath, State: result.State, Hash: result.Hash, Size: result.Size}
+}
+
+// exists reports whether a path is still there
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// TestRemoveVerifiedSource checks a source is only deleted when its stored copy re-reads with
+// the size and hash that were copied
+func TestRemoveVerifiedSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(dest string) error // What happens to the stored copy before the check
+		removed bool
+	}{
+		{"verified", func(string) error { return nil }, true},
+		{"size mismatch", func(dest string) error { return os.WriteFile(dest, []byte("truncated"), 0644) }, false},
+		{"hash mismatch", func(dest string) error { return os.WriteFile(dest, []byte("photo Content"), 0644) }, false},
+		{"missing copy", os.Remove, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &backupRun{destination: localDestination()}
+			result := copiedFile(t, "IMG_0001.jpg", "photo content")
+			if err := tt.damage(result.DestPath); err != nil {
+				t.Fatalf("damage copy: %v", err)
+			}
+			err := r.removeVerifiedSource(result, hashSHA256)
+			if tt.removed {
+				if err != nil || exists(result.Path) {
+					t.Errorf("expected the source to be removed, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("expected an error for a copy that doesn't verify")
+			}
+			if !exists(result.Path) {
+				t.Error("source removed although its copy doesn't verify")
+			}
+		})
+	}
+}
+
+// TestRemoveMovedSourcesKeepsUncopied checks --move never deletes the source of a file it didn't
+// copy itself: duplicates, skipped files, errors, and files extracted from a zip
+func TestRemoveMovedSourcesKeepsUncopied(t *testing.T) {
+	r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+	var results []*FileResult
+	for _, state := range []FileState{StateDuplicateHash, StateSkippedDestExists, StateSkippedIncremental, StateErrorCopy, StateErrorHash} {
+		result := copiedFile(t, "IMG_0001.jpg", "photo content")
+		result.State = state
+		results = append(results, result)
+	}
+	member := copiedFile(t, "IMG_0002.jpg", "zipped photo")
+	r.zipSources[member.Path] = filepath.Join("album.zip", "IMG_0002.jpg")
+	results = append(results, member, nil)
+
+	r.removeMovedSources(results, hashSHA256)
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		if !exists(result.Path) || result.SourceRemoved {
+			t.Errorf("source %s (state %v) was removed", result.Path, result.State)
+		}
+	}
+}
+
+// TestRemoveMovedSourcesLiveVideo checks a live photo's video is removed with its still, but only
+// when its own copy verifies, and never when the still is kept
+func TestRemoveMovedSourcesLiveVideo(t *testing.T) {
+	tests := []struct {
+		name         string
+		stillDamaged bool
+		videoDamaged bool
+		videoState   FileState
+		stillRemoved bool
+		videoRemoved bool
+	}{
+		{"both verified", false, false, StateCopied, true, true},
+		{"video copy damaged", false, true, StateCopied, true, false},
+		{"still copy damaged", true, false, StateCopied, false, false},
+		{"video duplicate", false, false, StateDuplicateHash, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+			still := copiedFile(t, "IMG_0001.HEIC", "still image")
+			videoFile := copiedFile(t, "IMG_0001.MOV", "motion video")
+			video := liveVideo(videoFile)
+			video.State = tt.videoState
+			still.LiveVideo = video
+			if tt.stillDamaged {
+				os.WriteFile(still.DestPath, []byte("still imagf"), 0644)
+			}
+			if tt.videoDamaged {
+				os.WriteFile(video.DestPath, []byte("motion videp"), 0644)
+			}
+
+			r.removeMovedSources([]*FileResult{still}, hashSHA256)
+			if got := !exists(still.Path); got != tt.stillRemoved || still.SourceRemoved != tt.stillRemoved {
+				t.Errorf("still removed: expected %t, got %t (reported %t)", tt.stillRemoved, got, still.SourceRemoved)
+			}
+			if got := !exists(video.Path); got != tt.videoRemoved || video.SourceRemoved != tt.videoRemoved {
+				t.Errorf("video removed: expected %t, got %t (reported %t)", tt.videoRemoved, got, video.SourceRemoved)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"strconv"
+	"sync"
+
+	"backupbozo/metadata"
+)
+
+// perceptualExtensions are the images the standard library can decode for perceptual hashing
+// HEIC, RAW, and WebP are backed up as usual but never checked for near-duplicates
+var perceptualExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// nearDuplicateMaxDistance is how many of the 64 dHash bits may differ for two images to count as
+// near-duplicates; re-saves and re-compressions land well below it, different shots well above
+const nearDuplicateMaxDistance = 6
+
+// NearDuplicate is a newly copied image that looks like one already stored (--near-duplicates)
+type NearDuplicate struct {
+	Path      string // Source of the new copy
+	DestPath  string // Where the new copy was stored
+	SimilarTo string // Stored image it resembles
+	Distance  int    // Differing dHash bits (0 = visually identical)
+}
+
+// storedPerceptualHash is a stored image's dHash, as loaded from the database
+type storedPerceptualHash struct {
+	destPath string
+	phash    uint64
+}
+
+// findNearDuplicates computes a dHash for every image copied this run, stores it in the database,
+// and lists the ones that look like an image already stored. Nothing is skipped or removed:
+// near-duplicates can be deliberate edits, so they are only reported
+func (r *backupRun) findNearDuplicates(ctx context.Context, db *sql.DB, results []*FileResult, workers int) []NearDuplicate {
+	var copied []*FileResult
+	for _, result := range results {
+		if result != nil && result.State == StateCopied && perceptualExtensions[metadata.NormalizeExt(result.Path)] {
+			copied = append(copied, result)
+		}
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+
+	stored, err := loadPerceptualHashes(db)
+	if err != nil {
+		r.console.Printf("Warning: Could not read perceptual hashes, skipping near-duplicate check: %v", err)
+		return nil
+	}
+
+	// Decoding is the slow part, so it runs on the worker pool
+	phashes := make([]uint64, len(copied))
+	ok := make([]bool, len(copied))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				phash, err := perceptualHash(copied[i].Path)
+				if err != nil {
+					if r.opts.Verbosity == VerbosityVerbose {
+						fmt.Printf("no perceptual hash for %s: %v\n", copied[i].Path, err)
+					}
+					continue
+				}
+				phashes[i], ok[i] = phash, true
+			}
+		}()
+	}
+	for i := range copied {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var nearDuplicates []NearDuplicate
+	tx, err := db.Begin()
+	if err != nil {
+		r.console.Printf("Warning: Could not store perceptual hashes: %v", err)
+		return nil
+	}
+	for i, result := range copied {
+		if !ok[i] {
+			continue
+		}
+		// Closest match wins; images copied earlier in this run count as stored
+		best := NearDuplicate{Distance: nearDuplicateMaxDistance + 1}
+		for _, other := range stored {
+			if distance := bits.OnesCount64(phashes[i] ^ other.phash); distance < best.Distance {
+				best = NearDuplicate{Path: result.Path, DestPath: result.DestPath, SimilarTo: other.destPath, Distance: distance}
+			}
+		}
+		if best.Distance <= nearDuplicateMaxDistance {
+			nearDuplicates = append(nearDuplicates, best)
+		}
+		stored = append(stored, storedPerceptualHash{destPath: result.DestPath, phash: phashes[i]})
+
+		if _, err := tx.Exec("UPDATE files SET phash = ? WHERE hash = ?", formatPerceptualHash(phashes[i]), result.Hash); err != nil {
+			r.console.Printf("Warning: Could not store perceptual hash for %s: %v", result.Path, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.console.Printf("Warning: Could not store perceptual hashes: %v", err)
+	}
+	return nearDuplicates
+}
+
+// loadPerceptualHashes reads every stored image's dHash
+func loadPerceptualHashes(db *sql.DB) ([]storedPerceptualHash, error) {
+	rows, err := db.Query("SELECT dest_path, phash FROM files WHERE phash IS NOT NULL AND phash != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	var stored []storedPerceptualHash
+	for rows.Next() {
+		var destPath, text string
+		if err := rows.Scan(&destPath, &text); err != nil {
+			return nil, err
+		}
+		phash, err := strconv.ParseUint(text, 16, 64)
+		if err != nil {
+			continue
+		}
+		stored = append(stored, storedPerceptualHash{destPath: resolveDestPath(root, destPath), phash: phash})
+	}
+	return stored, rows.Err()
+}
+
+// formatPerceptualHash stores a dHash as 16 hex digits
+func formatPerceptualHash(phash uint64) string {
+	return fmt.Sprintf("%016x", phash)
+}
+
+// perceptualHash computes the 64-bit difference hash (dHash) of an image: it is shrunk to 9x8
+// grayscale cells and each bit records whether a cell is brighter than its right neighbour,
+// so re-compression, resizing, and small edits barely change it
+func perceptualHash(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+
+	const cols, rows = 9, 8
+	var sums [rows][cols]float64
+	var counts [rows][cols]int
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0, fmt.Errorf("empty image")
+	}
+
+	// Average the luminance of every pixel into its cell; JPEG's Y plane is read directly
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	for y := 0; y < height; y++ {
+		row := y * rows / height
+		for x := 0; x < width; x++ {
+			col := x * cols / width
+			var luma float64
+			if isYCbCr {
+				luma = float64(ycbcr.Y[ycbcr.YOffset(bounds.Min.X+x, bounds.Min.Y+y)])
+			} else {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				luma = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			}
+			sums[row][col] += luma
+			counts[row][col]++
+		}
+	}
+
+	var phash uint64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols-1; col++ {
+			left := sums[row][col] / float64(max(counts[row][col], 1))
+			right := sums[row][col+1] / float64(max(counts[row][col+1], 1))
+			phash <<= 1
+			if left > right {
+				phash |= 1
+			}
+		}
+	}
+	return phash, nil
+}
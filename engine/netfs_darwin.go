@@ -0,0 +1,26 @@
+//go:build darwin
+
+package engine
+
+import "syscall"
+
+// isNetworkFS reports whether path is on a network share, whose changes made by other machines
+// the kernel can't report (macOS implementation)
+func isNetworkFS(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+	var name []byte
+	for _, c := range stat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	switch string(name) {
+	case "nfs", "smbfs", "afpfs", "webdav", "cifs":
+		return true
+	}
+	return false
+}
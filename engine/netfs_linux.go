@@ -0,0 +1,30 @@
+//go:build linux
+
+package engine
+
+import "syscall"
+
+// Superblock magic numbers of network file systems (see statfs(2))
+const (
+	nfsMagic  = 0x6969
+	smbMagic  = 0x517B
+	cifsMagic = 0xFF534D42
+	smb2Magic = 0xFE534D42
+	v9fsMagic = 0x01021997
+	afsMagic  = 0x5346414F
+	cephMagic = 0x00C36400
+)
+
+// isNetworkFS reports whether path is on a network share, whose changes made by other machines
+// the kernel can't report (Linux implementation)
+func isNetworkFS(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+	switch uint32(stat.Type) {
+	case nfsMagic, smbMagic, cifsMagic, smb2Magic, v9fsMagic, afsMagic, cephMagic:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package engine
+
+// isNetworkFS can't tell network shares apart here; Windows reports changes on SMB shares itself
+func isNetworkFS(path string) bool {
+	return false
+}
@@ -0,0 +1,127 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Webhook notification settings (--notify-webhook, --notify-on)
+const (
+	NotifyAlways = "always" // Every finished run
+	NotifyError  = "error"  // Only runs with errors, an interruption, or an early stop
+)
+
+// Webhook is where the run summary is posted when a backup ends (--notify-webhook)
+type Webhook struct {
+	URL         string
+	OnlyOnError bool // Only runs with errors, an interruption, or an early stop (--notify-on error)
+}
+
+// WebhookPayload is the JSON body posted to --notify-webhook
+type WebhookPayload struct {
+	Status          string            `json:"status"` // ok, errors, interrupted, or aborted
+	Source          string            `json:"source"`
+	Destination     string            `json:"destination"`
+	Report          string            `json:"report"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Summary         JSONReportSummary `json:"summary"`
+	Errors          []string          `json:"errors"` // First few error messages; the report has all of them
+}
+
+// webhookMaxErrors caps the error messages sent, so a run with thousands of failures stays a small request
+const webhookMaxErrors = 20
+
+// notifyCompletion posts the run summary to the webhook, if one is configured
+func (r *backupRun) notifyCompletion(summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot, reportPath string, isInterrupted bool) {
+	webhook := r.opts.Webhook
+	if webhook == nil {
+		return
+	}
+	status := "ok"
+	switch {
+	case isInterrupted:
+		status = "interrupted"
+	case summary.Errors > 0:
+		status = "errors"
+	}
+	if webhook.OnlyOnError && status == "ok" {
+		return
+	}
+
+	errs := summary.ErrorList
+	if len(errs) > webhookMaxErrors {
+		errs = errs[:webhookMaxErrors]
+	}
+	payload := WebhookPayload{
+		Status:          status,
+		Source:          srcRoot,
+		Destination:     destRoot,
+		Report:          reportPath,
+		DurationSeconds: totalTime.Seconds(),
+		Summary: JSONReportSummary{
+			Copied:     summary.Copied,
+			Duplicates: summary.Duplicates,
+			Skipped:    summary.Skipped,
+			Errors:     summary.Errors,
+			TotalFiles: summary.TotalFiles,
+			TotalBytes: summary.TotalBytes,
+			SavedBytes: summary.DuplicateBytes,
+		},
+		Errors: append([]string{}, errs...),
+	}
+	webhook.send(payload, r.log, r.console)
+}
+
+// notifyStopped posts a run that stopped before processing files (not enough space, not confirmed,
+// interrupted while planning), with the reason as its only error; it is sent even with --notify-on error
+func (r *backupRun) notifyStopped(totalTime time.Duration, srcRoot, destRoot, reason string, isInterrupted bool) {
+	webhook := r.opts.Webhook
+	if webhook == nil {
+		return
+	}
+	status := "aborted"
+	if isInterrupted {
+		status = "interrupted"
+	}
+	webhook.send(WebhookPayload{
+		Status:          status,
+		Source:          srcRoot,
+		Destination:     destRoot,
+		DurationSeconds: totalTime.Seconds(),
+		Errors:          []string{reason},
+	}, r.log, r.console)
+}
+
+// send posts the payload, logging the outcome
+// A failed notification is only a warning: the backup itself already finished
+func (w *Webhook) send(payload WebhookPayload, eventLog *EventLog, console *log.Logger) {
+	if err := w.post(payload); err != nil {
+		console.Printf("Warning: Could not send webhook notification: %v", err)
+		eventLog.Warn("could not send webhook notification: %v", err)
+		return
+	}
+	eventLog.Info("sent %s notification to webhook", payload.Status)
+}
+
+// post sends the payload as JSON; any non-2xx response counts as a failure
+func (w *Webhook) post(payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// BackupOptions is everything one backup run needs; the CLI fills it from flags and the config file
+// Zero values are the plain defaults: a local destination, the built-in extensions, dates in the
+// local time zone, no retries, and nothing printed but the final summary
+type BackupOptions struct {
+	SrcDir     string // Source directory or .zip
+	DestDir    string // Destination directory (the path on DestFS for a remote one)
+	DestFS     DestFS // Filesystem DestDir is on; nil for a local or mounted disk
+	DBPath     string
+	NoDB       bool   // Keep the database in memory for this run alone, ignoring DBPath (--no-db)
+	ReportPath string // HTML report; JSON/CSV reports are written next to it
+	Formats    ReportFormats
+
+	Incremental bool
+	Workers     int
+	BatchSize   int // Files recorded per database transaction
+	Move        bool
+	Layout      string // Go time layout for date folders
+	HashAlgo    string
+	DedupeMode  string
+	Manifest    bool // Refresh SHA256SUMS in the destination
+	Reserve     SpaceReserve
+	KnownDBs    []string // Other backups whose files count as duplicates
+	MirrorDir   string   // Second local folder every copied file is also written to (--mirror), "" for none
+	ScratchDir  string   // Local folder for copies in progress, HEIC conversions, and extracted zips (--tmp-dir)
+	PreferDate  string   // Which date places content found under several (--prefer-date); "" keeps the first-seen one
+
+	// What happens to a file whose name is taken by a different file (--collision-mode); "" renames it
+	CollisionMode string
+
+	// How stored files are named and placed
+	RenamePattern string // Go time template for stored names, {name} being the original (--rename-pattern)
+	SeparateMedia bool   // Photos/ and Videos/ top-level folders (--separate-media)
+	Archive       string // ArchiveTarGz stores each date folder as one archive (--archive); "" stores loose files
+	ConvertHEIC   bool   // Store HEIC/HEIF photos as JPEG (--convert-heic-to-jpeg)
+	ContentCheck  string // --check-content-type mode; "" trusts extensions
+
+	// How stored files are written
+	VerifyCopies   bool          // Re-read each copy before keeping it (--verify-copy)
+	Fsync          bool          // Also sync the folder of each stored file (--fsync, local destinations only)
+	DirMode        os.FileMode   // Permissions for created folders (--dir-mode); 0 keeps 0755 less the umask
+	FileMode       os.FileMode   // Permissions for stored files (--file-mode); 0 keeps 0666 less the umask
+	PreserveOwner  bool          // Give stored files the owner of their source (--preserve-owner)
+	RateLimit      int64         // Bytes per second across all workers (--rate-limit); 0 is unlimited
+	CopyRetries    int           // Extra attempts for a failed copy (--copy-retries)
+	CopyRetryDelay time.Duration // Wait before the first retry, doubled for each one after (--copy-retry-delay)
+
+	// Where file dates come from
+	DateSource     string         // --date-source; "" is metadata.DateSourceAuto
+	FFprobeTimeout time.Duration  // Limit for one ffprobe run (--ffprobe-timeout); 0 waits forever
+	Timezone       *time.Location // Decides the day and month a file is filed under; nil is time.Local
+
+	// Which source files are considered
+	Extensions       []string // Backed up extensions (see ConfigureExtensions); nil for the built-in list
+	Since, Until     time.Time
+	MinSize, MaxSize int64
+	Excludes         []string
+	FollowSymlinks   bool
+	MaxDepth         int
+	IgnoreHidden     bool     // Leave out dotfiles and dot folders
+	Only             []string // When set, only these source files are backed up (watch mode)
+
+	// How duplicates are found and handled
+	HashOnlyVideos        bool  // Match photos by name, size, and mtime instead of hashing them (--hash-only-videos)
+	ChecksumSample        int64 // Hash only this much of each end of larger files (--checksum-sample); 0 hashes everything
+	NearDuplicates        bool  // Flag copied images that look like stored ones (--near-duplicates)
+	PurgeSourceDuplicates bool  // Delete extra copies from the source once one is backed up (--purge-duplicates-in-source)
+
+	// Plan from the database instead of the destination (--skip-existing-by-hash), which saves
+	// a stat per file on large or remote destinations
+	SkipExistingByHash bool
+
+	// Reports
+	ReportAppend     bool // Add the run to the top of a rolling HTML report at ReportPath (--report-append)
+	ReportThumbnails bool // Embed previews of copied images in the HTML report (--report-thumbnails)
+
+	// PostCopyHook runs with each copied file's source and destination appended (--post-copy-hook)
+	PostCopyHook        []string
+	PostCopyHookTimeout time.Duration // 0 waits forever
+	PostCopyHookFatal   bool          // A failed hook stops the backup
+
+	// Run even if the database's lock file says another backup is using it (--force)
+	ForceLock bool
+
+	// Confirm is asked before copying more than ConfirmThreshold files; nil or a threshold of 0 never asks
+	ConfirmThreshold int
+	Confirm          func(files int, totalSize int64) bool
+
+	Verbosity Verbosity // What is printed to the console; the zero value prints nothing
+	EventLog  *EventLog // Receives a line for every file and event (--log-file); nil discards them
+	Webhook   *Webhook  // Told how the run ended (--notify-webhook); nil for none
+
+	// Progress replaces the terminal progress bars when set
+	Progress ProgressFunc
+}
+
+// setDefaults fills in the options whose zero value stands for a default
+func (o *BackupOptions) setDefaults() {
+	if o.DestFS == nil {
+		o.DestFS = localFS{}
+	}
+	if o.Layout == "" {
+		o.Layout = DefaultLayout
+	}
+	if o.HashAlgo == "" {
+		o.HashAlgo = DefaultHashAlgorithm
+	}
+	if o.DedupeMode == "" {
+		o.DedupeMode = DefaultDedupeMode
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.DateSource == "" {
+		o.DateSource = metadata.DateSourceAuto
+	}
+	if o.Timezone == nil {
+		o.Timezone = time.Local
+	}
+}
+
+// validate checks the options that don't depend on the source or destination existing
+func (o *BackupOptions) validate() error {
+	if err := ValidateLayout(o.Layout); err != nil {
+		return fmt.Errorf("invalid layout: %w", err)
+	}
+	if o.SeparateMedia && strings.Contains(o.Layout, LayoutMediaToken) {
+		return fmt.Errorf("separate media folders and a layout with %s both sort by media type", LayoutMediaToken)
+	}
+	if err := ValidateHashAlgorithm(o.HashAlgo); err != nil {
+		return fmt.Errorf("invalid hash: %w", err)
+	}
+	if err := ValidateDedupeMode(o.DedupeMode); err != nil {
+		return fmt.Errorf("invalid dedupe mode: %w", err)
+	}
+	if o.CollisionMode != "" {
+		if err := ValidateCollisionMode(o.CollisionMode); err != nil {
+			return fmt.Errorf("invalid collision mode: %w", err)
+		}
+	}
+	if o.PreferDate != "" {
+		if err := ValidatePreferDate(o.PreferDate); err != nil {
+			return fmt.Errorf("invalid prefer-date: %w", err)
+		}
+	}
+	if o.ContentCheck != "" {
+		if err := ValidateContentCheck(o.ContentCheck); err != nil {
+			return fmt.Errorf("invalid content check: %w", err)
+		}
+	}
+	if o.RenamePattern != "" {
+		if err := ValidateRenamePattern(o.RenamePattern); err != nil {
+			return fmt.Errorf("invalid rename pattern: %w", err)
+		}
+	}
+	if err := ValidateExcludes(o.Excludes); err != nil {
+		return fmt.Errorf("invalid exclude: %w", err)
+	}
+	if o.Archive != "" && o.Archive != ArchiveTarGz {
+		return fmt.Errorf("invalid archive format %q: only tar.gz is supported", o.Archive)
+	}
+	_, local := o.DestFS.(localFS)
+	remote := !local
+	switch {
+	case o.Archive != "" && o.Layout == FlatLayout:
+		return fmt.Errorf("archives need date folders and can't be used with a flat layout")
+	case o.Archive != "" && o.DedupeMode != DedupeSkip:
+		return fmt.Errorf("archives can't be used with hardlink or symlink dedupe modes")
+	case o.Archive != "" && remote:
+		return fmt.Errorf("archives are not supported for remote destinations")
+	case o.Archive != "" && o.MirrorDir != "":
+		return fmt.Errorf("a mirror can't be used with archives")
+	case o.Fsync && remote:
+		return fmt.Errorf("fsync is not supported for remote destinations")
+	case o.ScratchDir != "" && remote:
+		return fmt.Errorf("a temp folder is not supported for remote destinations")
+	case o.ConvertHEIC && o.Move:
+		return fmt.Errorf("HEIC conversion can't be used with move: the original HEIC files would be deleted")
+	case o.PreserveOwner && !CanChangeOwner():
+		return fmt.Errorf("preserving owners needs to run as root on Linux or macOS")
+	case o.BatchSize < 0, o.MaxDepth < 0, o.RateLimit < 0, o.ChecksumSample < 0, o.FFprobeTimeout < 0,
+		o.CopyRetries < 0, o.CopyRetryDelay < 0, o.PostCopyHookTimeout < 0:
+		return fmt.Errorf("batch size, max depth, rate limit, checksum sample, timeouts, and retries can't be negative")
+	case o.MaxSize > 0 && o.MaxSize < o.MinSize:
+		return fmt.Errorf("max size (%d) is smaller than min size (%d)", o.MaxSize, o.MinSize)
+	case !o.Since.IsZero() && !o.Until.IsZero() && o.Until.Before(o.Since):
+		return fmt.Errorf("until (%s) is before since (%s)", o.Until.Format("2006-01-02"), o.Since.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// ReportFormats selects the extra reports written next to the HTML report
+type ReportFormats struct {
+	JSON bool
+	CSV  bool
+}
+
+// BackupResult is the outcome of a backup run that got as far as processing files
+type BackupResult struct {
+	Summary     AccountingSummary
+	Duration    time.Duration
+	ReportPath  string // The HTML report written for the run
+	Interrupted bool   // The context was cancelled; Summary covers the files processed before that
+	Mismatch    bool   // The summary doesn't account for every processed file (a bug worth reporting)
+	// Source paths that failed or were never reached (a zip for any of its members); watch retries them
+	Unfinished []string
+}
+
+// StrictFailure says why a run fails --strict, or returns "" for a clean run
+// A nil result is a backup that stopped before processing files (no space, cancelled, interrupted)
+func StrictFailure(result *BackupResult) string {
+	switch {
+	case result == nil:
+		return "the backup stopped before processing any files"
+	case result.Interrupted:
+		return "the backup was interrupted"
+	case result.Summary.Errors > 0 || len(result.Summary.ErrorList) > 0:
+		return fmt.Sprintf("the run had %d error(s), listed in the report", max(result.Summary.Errors, len(result.Summary.ErrorList)))
+	case result.Mismatch:
+		return "not every processed file was accounted for"
+	}
+	return ""
+}
+
+// Backup phases reported to a ProgressFunc
+const (
+	PhasePlanning = "planning"
+	PhaseCopying  = "copying"
+)
+
+// ProgressFunc is told how many of a phase's files are done; it is called from worker goroutines,
+// one call at a time
+type ProgressFunc func(phase string, done, total int)
+
+// progressTracker advances a phase's terminal progress bar or the caller's ProgressFunc
+type progressTracker struct {
+	bar   *progressbar.ProgressBar
+	fn    ProgressFunc
+	phase string
+	total int
+	// The bar counts bytes rather than files (see AddFile); fn is still told about files
+	byBytes bool
+
+	mu   sync.Mutex
+	done int
+}
+
+// newProgressTracker wraps bar for a phase of total files; bar should be hidden when fn is set
+func newProgressTracker(bar *progressbar.ProgressBar, fn ProgressFunc, phase string, total int) *progressTracker {
+	return &progressTracker{bar: bar, fn: fn, phase: phase, total: total}
+}
+
+// Add marks n more files as done
+func (p *progressTracker) Add(n int) {
+	p.bar.Add(n)
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.fn(p.phase, p.done, p.total)
+}
+
+// AddFile marks one more file done, advancing a bar counted in bytes by the file's planned size
+func (p *progressTracker) AddFile(bytes int64) {
+	if !p.byBytes {
+		p.Add(1)
+		return
+	}
+	p.bar.Add64(bytes)
+	if p.fn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.fn(p.phase, p.done, p.total)
+}
+
+// Describe sets the text shown next to the progress bar
+func (p *progressTracker) Describe(description string) {
+	p.bar.Describe(description)
+}
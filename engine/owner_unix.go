@@ -0,0 +1,21 @@
+//go:build !windows
+
+package engine
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user and group that own a file (Unix implementation)
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
+
+// CanChangeOwner reports whether this process may give files away to other users
+func CanChangeOwner() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package engine
+
+import "os"
+
+// fileOwner is not supported on Windows, where files have no Unix owner
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// CanChangeOwner is always false on Windows (--preserve-owner is Unix only)
+func CanChangeOwner() bool {
+	return false
+}
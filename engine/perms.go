@@ -0,0 +1,52 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ParseModeFlag parses an octal permission flag like 0664 or 2775 (setgid folders keep the group)
+func ParseModeFlag(name, value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	bits, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || bits == 0 || bits > 07777 {
+		return 0, fmt.Errorf("invalid --%s %q: expected octal permissions like 0775", name, value)
+	}
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// applyFileOwnership sets --file-mode and --preserve-owner on a stored file (or its temp file)
+// src is the source file whose owner is kept
+func (d *destination) applyFileOwnership(src, dest string) error {
+	if d.fileMode != 0 {
+		if err := d.fs.Chmod(dest, d.fileMode); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", dest, err)
+		}
+	}
+	if d.preserveOwner {
+		info, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("failed to read owner of %s: %w", src, err)
+		}
+		if uid, gid, ok := fileOwner(info); ok {
+			if err := d.fs.Chown(dest, uid, gid); err != nil {
+				return fmt.Errorf("failed to set owner of %s: %w", dest, err)
+			}
+		}
+	}
+	return nil
+}
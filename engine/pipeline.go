@@ -0,0 +1,740 @@
+// backupbozo: File processing pipeline structures for Phase 1 refactor
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// FileState represents the explicit state of a file during processing
+// This eliminates the classification ambiguity between passes
+type FileState int
+
+const (
+	// File was successfully copied
+	StateCopied FileState = iota
+
+	// File was skipped for various reasons
+	StateSkippedExtension   // Extension not in allowedExtensions
+	StateSkippedIncremental // File older than last backup (incremental mode)
+	StateSkippedDate        // Could not extract valid date from file
+	StateSkippedDestExists  // Destination file already exists
+
+	// File is a duplicate based on hash
+	StateDuplicateHash // Hash already exists in database
+
+	// Errors during processing
+	StateErrorStat // Error calling os.Stat()
+	StateErrorDate // Error extracting date metadata
+	StateErrorHash // Error computing file hash
+	StateErrorCopy // Error copying file
+	StateErrorWalk // Error during directory walking
+
+	// States added later go at the end, so the values above never change
+	StateSkippedProcessed // Already processed by an interrupted run (progress journal)
+	StateSkippedDateRange // File date outside --since/--until range
+	StateSkippedExcluded  // Path matched an --exclude pattern
+	StateSkippedSize      // File size outside --min-size/--max-size
+	StateSkippedEmpty     // Zero-byte file (e.g. a stub left by a failed recovery)
+	StateSkippedCollision // Name taken by a different file (--collision-mode skip)
+)
+
+// String returns human-readable state names for reporting
+func (s FileState) String() string {
+	switch s {
+	case StateCopied:
+		return "copied"
+	case StateSkippedExtension:
+		return "skipped (extension)"
+	case StateSkippedIncremental:
+		return "skipped (incremental)"
+	case StateSkippedDate:
+		return "skipped (no date)"
+	case StateSkippedDestExists:
+		return "skipped (destination exists)"
+	case StateSkippedProcessed:
+		return "skipped (already processed before interruption)"
+	case StateSkippedDateRange:
+		return "skipped (outside date range)"
+	case StateSkippedExcluded:
+		return "excluded"
+	case StateSkippedSize:
+		return "skipped (size out of range)"
+	case StateSkippedEmpty:
+		return "skipped (empty file)"
+	case StateSkippedCollision:
+		return "skipped (name taken by a different file)"
+	case StateDuplicateHash:
+		return "duplicate (hash exists)"
+	case StateErrorStat:
+		return "error (stat)"
+	case StateErrorDate:
+		return "error (date extraction)"
+	case StateErrorHash:
+		return "error (hash computation)"
+	case StateErrorCopy:
+		return "error (copy failed)"
+	case StateErrorWalk:
+		return "error (walk failed)"
+	default:
+		return "unknown"
+	}
+}
+
+// IsError reports whether the state is one of the error states
+func (s FileState) IsError() bool {
+	return s >= StateErrorStat && s <= StateErrorWalk
+}
+
+// FileFilter holds the rules that decide which source files are considered for backup
+type FileFilter struct {
+	Incremental bool            // Only process files modified after MinMtime
+	MinMtime    int64           // Unix time of this source's last complete backup (0 = no previous backup)
+	Retry       map[string]bool // Paths an earlier run failed on, looked at again whatever their mtime
+	Since       time.Time       // Earliest file date to back up (zero = no lower bound)
+	Until       time.Time       // Last day to back up, inclusive (zero = no upper bound)
+	MinSize     int64           // Smallest file size to back up in bytes (0 = no lower bound)
+	MaxSize     int64           // Largest file size to back up in bytes (0 = no upper bound)
+}
+
+// olderThanLastBackup reports whether incremental mode should skip a file with this mtime
+// A file from the same second the last backup started may have been written after the scan, so it is kept,
+// and so is a file that an earlier run failed on or that sits in a folder it couldn't read
+func (f FileFilter) olderThanLastBackup(path string, mtime time.Time) bool {
+	if !f.Incremental || f.MinMtime == 0 || mtime.Unix() >= f.MinMtime {
+		return false
+	}
+	for dir := path; len(f.Retry) > 0; dir = filepath.Dir(dir) {
+		if f.Retry[dir] {
+			return false
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return true
+}
+
+// narrowed reports whether date or size limits left files out that a later run should still see
+func (f FileFilter) narrowed() bool {
+	return !f.Since.IsZero() || !f.Until.IsZero() || f.MinSize > 0 || f.MaxSize > 0
+}
+
+// scanKey describes which files a walk of the source finds: excluded and hidden paths, the
+// depth limit, followed symlinks, and the backed up extensions. A source's incremental mark only
+// applies to runs with the same key, since a file an earlier run left out is older than its mark
+// but was never looked at
+func (r *backupRun) scanKey() string {
+	exts := make([]string, 0, len(r.extensions))
+	for ext := range r.extensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	excludes := append([]string(nil), r.opts.Excludes...)
+	sort.Strings(excludes)
+	return fmt.Sprintf("exclude=%s;max-depth=%d;ignore-hidden=%t;follow-symlinks=%t;ext=%s",
+		strings.Join(excludes, ","), r.opts.MaxDepth, r.opts.IgnoreHidden, r.opts.FollowSymlinks, strings.Join(exts, ","))
+}
+
+// inSizeRange reports whether a file size falls inside the --min-size/--max-size range
+func (f FileFilter) inSizeRange(size int64) bool {
+	if size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// inDateRange reports whether a file date falls inside the --since/--until range
+func (f FileFilter) inDateRange(date time.Time) bool {
+	if !f.Since.IsZero() && date.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !date.Before(f.Until.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// FileCandidate represents a file being evaluated for backup
+type FileCandidate struct {
+	// Basic file information
+	Path      string      // Full source path
+	Info      os.FileInfo // Cached os.Stat() result (expensive, called once)
+	Extension string      // Normalized lowercase extension (e.g., ".jpg")
+
+	// Destination information
+	DestDir    string        // Base destination directory
+	Layout     string        // Go time layout for the date folder (e.g., "2006-01")
+	DedupeMode string        // What to leave at DestPath for duplicates (skip, hardlink, symlink)
+	Sidecars   []string      // Sidecar files copied next to wherever this file is stored
+	LiveVideo  *FileWithInfo // Video half of a live photo, placed next to this still
+	DestPath   string        // Full computed destination path (<layout>/filename)
+}
+
+// FileResult tracks the outcome of file operations in a simplified way
+type FileResult struct {
+	Path                  string             // Source file path
+	DestPath              string             // Destination file path (for reporting)
+	State                 FileState          // Final processing state
+	Error                 error              // Any error that occurred during processing
+	BytesCopied           int64              // Actual bytes copied (0 if skipped/error)
+	ExistingDuplicatePath string             // Path of existing file with same hash (for duplicates only)
+	DateSource            string             // Where the folder date came from (EXIF, video metadata, mtime)
+	Date                  time.Time          // The date that decided the folder (zero if never dated)
+	Camera                string             // Camera or device named in the file's metadata, "" if unknown
+	Location              *metadata.Location // EXIF GPS position, nil if unknown
+	BurstID               string             // Identifier shared by the frames of one burst, "" if not a burst
+	Hash                  string             // Content hash (copied and duplicate files)
+	Size                  int64              // Source file size in bytes
+	SourceRemoved         bool               // Source deleted after verified copy (--move mode)
+	MoveError             error              // Why the source was kept in --move mode, if it was
+	LinkedAs              string             // How a duplicate was placed at DestPath (hardlink, symlink, copy), if it was
+	RenamedFrom           string             // Intended destination when a different file already had that name
+	Sidecars              []string           // Destination paths of sidecars copied for this file
+	DedupMethod           string             // How the file was checked for duplicates (hash or size_mtime_name)
+	LiveVideo             *LiveVideoResult   // Outcome for the video half, when this is a live photo
+	PurgedFor             string             // Source copy kept when this duplicate was deleted (--purge-duplicates-in-source)
+	PurgeError            error              // Why this source duplicate was kept, if purging was requested
+	MirrorPath            string             // Copy written to --mirror, "" if none
+	MirrorError           error              // Why the mirror copy failed, if it did
+	ContentExt            string             // Extension the content calls for, when the file's own doesn't match
+}
+
+// classifyAndProcessFile performs unified file classification and processing
+// Returns a FileResult with the outcome of processing
+func (r *backupRun) classifyAndProcessFile(ctx context.Context, candidate *FileCandidate, db *sql.DB, batchInserter *BatchInserter, filter FileFilter) *FileResult {
+	size, mtime := candidate.Info.Size(), candidate.Info.ModTime().Unix()
+
+	// Skip straight past files an interrupted run already finished (no re-hashing)
+	if entry, done := batchInserter.AlreadyProcessed(candidate.Path, size, mtime); done {
+		return &FileResult{
+			Path:     candidate.Path,
+			DestPath: entry.DestPath,
+			State:    StateSkippedProcessed,
+			Size:     size,
+		}
+	}
+
+	result := r.classifyAndCopyFile(ctx, candidate, db, batchInserter, filter)
+	r.attachLiveVideo(ctx, candidate, result, batchInserter)
+	r.mirrorResult(ctx, candidate.DestDir, batchInserter.hashAlgo, result)
+	result.ContentExt = r.mislabeledTypes[candidate.Path]
+
+	// Journal every settled outcome so a resumed run can skip it; errors are retried next time
+	if result.Error == nil && !result.State.IsError() {
+		batchInserter.MarkProcessed(result.Path, result.DestPath, size, mtime, result.State)
+	}
+	return result
+}
+
+// classifyAndCopyFile evaluates a file and copies it when it is new content
+func (r *backupRun) classifyAndCopyFile(ctx context.Context, candidate *FileCandidate, db *sql.DB, batchInserter *BatchInserter, filter FileFilter) *FileResult {
+	// Get processing state using evaluation logic
+	evalResult := r.evaluateFileForBackup(candidate, db, batchInserter, filter)
+
+	// If state is not StateCopied, we're done - no copy needed
+	if evalResult.State != StateCopied {
+		result := &FileResult{
+			Path:                  candidate.Path,
+			DestPath:              candidate.DestPath,
+			State:                 evalResult.State,
+			Error:                 evalResult.Error,
+			BytesCopied:           0,
+			ExistingDuplicatePath: evalResult.ExistingDuplicatePath,
+			DateSource:            evalResult.DateSource,
+			Date:                  evalResult.Date,
+			Camera:                evalResult.Camera,
+			Location:              evalResult.Location,
+			BurstID:               evalResult.BurstID,
+			Hash:                  evalResult.Hash,
+			Size:                  candidate.Info.Size(),
+			DedupMethod:           evalResult.DedupMethod,
+		}
+		if result.State == StateDuplicateHash {
+			r.placeDuplicate(ctx, candidate, result, batchInserter.hashAlgo)
+		}
+		// New or edited sidecars of a photo stored earlier still join it
+		if len(candidate.Sidecars) > 0 {
+			if home := sidecarHome(candidate, result, batchInserter); home != "" {
+				result.Sidecars = r.copySidecars(ctx, candidate, home, result.Date, batchInserter)
+			}
+		}
+		return result
+	}
+
+	// State is StateCopied - attempt the actual copy operation
+	var finalState FileState = StateCopied
+	var bytesCopied int64 = 0
+	var copyErr error
+	var duplicatePath string
+	var copiedHash string
+	var sidecars []string
+
+	if ctx.Err() != nil {
+		// Context cancelled before we could copy
+		finalState = StateErrorCopy
+		copyErr = ctx.Err()
+	} else {
+		// Use streaming copy that computes hash during copy for maximum efficiency
+		hash, streamErr := r.storeFile(ctx, candidate.Path, candidate.DestPath, batchInserter.hashAlgo, fileAccessTime(candidate.Info))
+		if streamErr != nil {
+			finalState = StateErrorCopy
+			copyErr = streamErr
+		} else {
+			copiedHash = hash
+			if evalResult.Hash == "" && evalResult.DedupMethod == dedupByHash {
+				// New content hashed by the copy: cached like a hash from evaluation
+				batchInserter.CacheHash(candidate.Path, candidate.Info.Size(), candidate.Info.ModTime().Unix(), hash)
+			}
+
+			// Copy succeeded - add to batch inserter
+			// Only a converted copy differs from its source; its hash is that of the original
+			var origExt string
+			if r.isHEICConversion(candidate.Path, candidate.DestPath) {
+				origExt = strings.ToLower(filepath.Ext(candidate.Path))
+			}
+			existingPath, added := batchInserter.Add(candidate.Path, candidate.DestPath, hash,
+				candidate.Info.Size(), candidate.Info.ModTime().Unix(), evalResult.Date, evalResult.Camera, evalResult.Location, evalResult.BurstID, evalResult.DedupMethod, origExt)
+			if !added {
+				// Another worker copied identical content first - drop our copy
+				r.fs.Remove(candidate.DestPath)
+				finalState = StateDuplicateHash
+				duplicatePath = existingPath
+			} else if hookErr := r.runPostCopyHook(ctx, batchInserter, candidate.Path, candidate.DestPath); hookErr != nil && r.opts.PostCopyHookFatal {
+				// Not kept, so the next run copies it again and runs its hook
+				finalState = StateErrorCopy
+				copyErr = fmt.Errorf("post-copy hook failed: %w", hookErr)
+				if err := batchInserter.Withdraw(candidate.Path, candidate.DestPath, hash, candidate.Info.Size(), candidate.Info.ModTime().Unix()); err != nil {
+					copyErr = fmt.Errorf("post-copy hook failed: %w (the copy stays recorded: %v)", hookErr, err)
+				} else {
+					r.fs.Remove(candidate.DestPath)
+				}
+			} else {
+				finalState = StateCopied
+				bytesCopied = candidate.Info.Size()
+				if evalResult.SampleHash != "" {
+					batchInserter.RecordSample(evalResult.SampleHash, hash, candidate.DestPath)
+				}
+				sidecars = r.copySidecars(ctx, candidate, candidate.DestPath, evalResult.Date, batchInserter)
+			}
+		}
+	}
+
+	result := &FileResult{
+		Path:                  candidate.Path,
+		DestPath:              candidate.DestPath,
+		State:                 finalState,
+		Error:                 copyErr,
+		BytesCopied:           bytesCopied,
+		ExistingDuplicatePath: duplicatePath, // Only set when a concurrent worker won the hash
+		DateSource:            evalResult.DateSource,
+		Date:                  evalResult.Date,
+		Camera:                evalResult.Camera,
+		Location:              evalResult.Location,
+		BurstID:               evalResult.BurstID,
+		Hash:                  copiedHash,
+		Size:                  candidate.Info.Size(),
+		RenamedFrom:           evalResult.RenamedFrom,
+		Sidecars:              sidecars,
+		DedupMethod:           evalResult.DedupMethod,
+	}
+	if finalState == StateDuplicateHash {
+		result.DedupMethod = dedupByHash // Matched by the hash computed during the copy
+		r.placeDuplicate(ctx, candidate, result, batchInserter.hashAlgo)
+	}
+	return result
+}
+
+// placeDuplicate links a duplicate into its own destination folder when --dedupe-mode asks for it
+// A failed link turns the result into a copy error so the file is retried on the next run
+func (r *backupRun) placeDuplicate(ctx context.Context, candidate *FileCandidate, result *FileResult, algo string) {
+	if candidate.DedupeMode == "" || candidate.DedupeMode == DedupeSkip || result.ExistingDuplicatePath == "" ||
+		r.knownDBPaths[result.ExistingDuplicatePath] {
+		return
+	}
+	linkedAs, err := r.linkDuplicate(ctx, candidate.Path, result.ExistingDuplicatePath, candidate.DestPath, candidate.DedupeMode, algo, fileAccessTime(candidate.Info))
+	if err != nil {
+		result.State = StateErrorCopy
+		result.Error = err
+		return
+	}
+	result.LinkedAs = linkedAs
+}
+
+// AccountingSummary provides accounting from FileResult collection
+type AccountingSummary struct {
+	// Counts by final state
+	Copied     int
+	Skipped    int
+	Duplicates int
+	Errors     int
+
+	// File lists for HTML report generation
+	CopiedFiles    []CopiedFile    // Files copied with their date source
+	SkippedFiles   []SkippedFile   // Files skipped with reasons
+	DuplicateFiles []DuplicateFile // Duplicates with the existing copy they match
+	ErrorList      []string        // Error messages
+	RemovedSources []string        // Source files deleted after a verified copy (--move mode)
+	PurgedSources  []PurgedSource  // Source duplicates deleted (--purge-duplicates-in-source)
+	// Source paths that could not be read for lack of permission (also in ErrorList)
+	PermissionDenied []string
+	// Outcome counts per lowercase file extension, see Extensions()
+	ByExtension map[string]*ExtensionStats
+	// Copied images that look like stored ones (--near-duplicates); they are not counted as duplicates
+	NearDuplicates []NearDuplicate
+	// Copied files whose --mirror copy failed; they are still counted as copied
+	MirrorErrors int
+	// Files whose content doesn't match their extension (--check-content-type), in any state
+	Mislabeled []MislabeledFile
+	// Copied mislabeled files were stored under the extension of their content (--check-content-type fix)
+	MislabeledFixed bool
+
+	// Statistics
+	TotalBytes     int64 // Total bytes copied
+	DuplicateBytes int64 // Bytes not stored again thanks to deduplication
+	TotalFiles     int   // Total files processed
+	WalkErrors     int   // Directory walking errors
+}
+
+// CopiedFile represents a file that was copied during backup
+type CopiedFile struct {
+	Path          string
+	DestPath      string
+	DateSource    string
+	Date          time.Time
+	Camera        string
+	Location      *metadata.Location
+	BurstID       string // Shared by the frames of one burst, "" if not a burst
+	Hash          string
+	Size          int64
+	SourceRemoved bool
+	MoveError     error
+	RenamedFrom   string   // Intended destination when its name was taken by a different file
+	Sidecars      []string // Destination paths of sidecars copied with this file
+	LiveVideo     *LiveVideoResult
+	MirrorPath    string // Copy in --mirror, "" if none
+	MirrorError   error
+	ContentExt    string // Extension the content calls for, when the file's own doesn't match
+}
+
+// DuplicateFile represents a file whose content already exists in the backup
+type DuplicateFile struct {
+	Path         string
+	ExistingPath string
+	Hash         string
+	Size         int64
+	LinkedPath   string // Where the duplicate was linked in (--dedupe-mode), if it was
+	LinkedAs     string // hardlink, symlink, or copy
+	DedupMethod  string // How it was matched (hash or size_mtime_name)
+	Date         time.Time
+	Camera       string
+	Location     *metadata.Location
+	BurstID      string
+	LiveVideo    *LiveVideoResult
+	PurgedFor    string // Source copy kept when this one was deleted from the source
+	PurgeError   error  // Why it was kept in the source despite --purge-duplicates-in-source
+}
+
+// PurgedSource is a source file deleted because another source file holds the same content
+type PurgedSource struct {
+	Path     string // The deleted duplicate
+	KeptPath string // The source file kept in its place
+}
+
+// ExtensionStats counts the outcomes for one file extension
+// A live photo's video is counted under its own extension
+type ExtensionStats struct {
+	Extension  string // Lowercase with the dot (".jpg"), or "(none)"
+	Copied     int
+	Duplicates int
+	Skipped    int
+	Errors     int
+	Bytes      int64 // Bytes copied
+}
+
+// countExtension adds one file's outcome to the per-extension breakdown
+func (s *AccountingSummary) countExtension(path string, state FileState, bytesCopied int64) {
+	ext := metadata.NormalizeExt(path)
+	if ext == "" {
+		ext = "(none)"
+	}
+	if s.ByExtension == nil {
+		s.ByExtension = make(map[string]*ExtensionStats)
+	}
+	stats, exists := s.ByExtension[ext]
+	if !exists {
+		stats = &ExtensionStats{Extension: ext}
+		s.ByExtension[ext] = stats
+	}
+	switch {
+	case state == StateCopied:
+		stats.Copied++
+		stats.Bytes += bytesCopied
+	case state == StateDuplicateHash:
+		stats.Duplicates++
+	case state.IsError():
+		stats.Errors++
+	default:
+		stats.Skipped++
+	}
+}
+
+// Extensions returns the per-extension breakdown, most bytes copied first, then most files
+func (s *AccountingSummary) Extensions() []*ExtensionStats {
+	list := make([]*ExtensionStats, 0, len(s.ByExtension))
+	for _, stats := range s.ByExtension {
+		list = append(list, stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if totalA, totalB := a.Copied+a.Duplicates+a.Skipped+a.Errors, b.Copied+b.Duplicates+b.Skipped+b.Errors; totalA != totalB {
+			return totalA > totalB
+		}
+		return a.Extension < b.Extension
+	})
+	return list
+}
+
+// mediaTypes groups the per-extension breakdown into photos, videos, and other files
+// (--separate-media), in that order; folder names the group of an extension
+func (s *AccountingSummary) mediaTypes(folder func(ext string) string) []*ExtensionStats {
+	groups := make(map[string]*ExtensionStats)
+	for ext, stats := range s.ByExtension {
+		name := folder(ext)
+		if name == "" {
+			name = "Other"
+		}
+		group, exists := groups[name]
+		if !exists {
+			group = &ExtensionStats{Extension: name}
+			groups[name] = group
+		}
+		group.Copied += stats.Copied
+		group.Duplicates += stats.Duplicates
+		group.Skipped += stats.Skipped
+		group.Errors += stats.Errors
+		group.Bytes += stats.Bytes
+	}
+	var list []*ExtensionStats
+	for _, name := range []string{photosFolder, videosFolder, "Other"} {
+		if group, exists := groups[name]; exists {
+			list = append(list, group)
+		}
+	}
+	return list
+}
+
+// DuplicateGroup is a stored file together with every source file this run found to be a copy of it
+type DuplicateGroup struct {
+	StoredPath string // The copy in the backup (or in a --known-db backup)
+	Hash       string // Content hash, "" when no source was hashed in full
+	// How the sources were matched: hash, size_mtime_name, or sample
+	DedupMethod string
+	Sources     []DuplicateFile
+}
+
+// DuplicateGroups groups the duplicates by the stored file they match, most copies first
+func (s *AccountingSummary) DuplicateGroups() []DuplicateGroup {
+	index := make(map[string]int)
+	var groups []DuplicateGroup
+	for _, dup := range s.DuplicateFiles {
+		i, exists := index[dup.ExistingPath]
+		if !exists {
+			i = len(groups)
+			index[dup.ExistingPath] = i
+			groups = append(groups, DuplicateGroup{StoredPath: dup.ExistingPath})
+		}
+		if groups[i].Hash == "" {
+			groups[i].Hash = dup.Hash
+		}
+		if groups[i].DedupMethod == "" {
+			groups[i].DedupMethod = dup.DedupMethod
+		}
+		groups[i].Sources = append(groups[i].Sources, dup)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].Sources) != len(groups[j].Sources) {
+			return len(groups[i].Sources) > len(groups[j].Sources)
+		}
+		return groups[i].StoredPath < groups[j].StoredPath
+	})
+	return groups
+}
+
+// SkippedFile represents a file that was skipped during backup
+type SkippedFile struct {
+	Path   string
+	Reason string
+	Size   int64
+}
+
+// permissionDeniedReason replaces the raw error for sources the backup was not allowed to read
+const permissionDeniedReason = "permission denied, check that the user running backupbozo can read it (owner, mode, or ACLs)"
+
+// sourcePermissionDenied reports whether a result failed because its source couldn't be read,
+// as opposed to a permission problem on the destination (e.g. a read-only date folder)
+func sourcePermissionDenied(result *FileResult) bool {
+	var pathErr *fs.PathError
+	return errors.Is(result.Error, fs.ErrPermission) && errors.As(result.Error, &pathErr) && pathErr.Path == result.Path
+}
+
+// GenerateAccountingSummary creates a complete accounting summary from FileResult collection
+func GenerateAccountingSummary(results []*FileResult, walkErrors []error) AccountingSummary {
+	summary := AccountingSummary{
+		TotalFiles: len(results),
+		WalkErrors: len(walkErrors),
+	}
+
+	// Process each result and categorize by state
+	for _, result := range results {
+		// Skip nil results (can happen when context is cancelled during processing)
+		if result == nil {
+			continue
+		}
+		if result.ContentExt != "" {
+			mislabeled := MislabeledFile{Path: result.Path, State: result.State, ContentExt: result.ContentExt}
+			switch result.State {
+			case StateCopied:
+				mislabeled.DestPath = result.DestPath
+			case StateDuplicateHash:
+				mislabeled.DestPath = result.ExistingDuplicatePath
+			}
+			summary.Mislabeled = append(summary.Mislabeled, mislabeled)
+		}
+		switch result.State {
+		case StateCopied:
+			summary.Copied++
+			summary.CopiedFiles = append(summary.CopiedFiles, CopiedFile{
+				Path:          result.Path,
+				DestPath:      result.DestPath,
+				DateSource:    result.DateSource,
+				Date:          result.Date,
+				Camera:        result.Camera,
+				Location:      result.Location,
+				BurstID:       result.BurstID,
+				Hash:          result.Hash,
+				Size:          result.BytesCopied,
+				SourceRemoved: result.SourceRemoved,
+				MoveError:     result.MoveError,
+				RenamedFrom:   result.RenamedFrom,
+				Sidecars:      result.Sidecars,
+				LiveVideo:     result.LiveVideo,
+				MirrorPath:    result.MirrorPath,
+				MirrorError:   result.MirrorError,
+				ContentExt:    result.ContentExt,
+			})
+			summary.TotalBytes += result.BytesCopied
+			if result.MirrorError != nil {
+				summary.MirrorErrors++
+			}
+			if result.SourceRemoved {
+				summary.RemovedSources = append(summary.RemovedSources, result.Path)
+			}
+
+		case StateDuplicateHash:
+			summary.Duplicates++
+			dup := DuplicateFile{
+				Path:         result.Path,
+				ExistingPath: result.ExistingDuplicatePath,
+				Hash:         result.Hash,
+				Size:         result.Size,
+				DedupMethod:  result.DedupMethod,
+				Date:         result.Date,
+				Camera:       result.Camera,
+				Location:     result.Location,
+				BurstID:      result.BurstID,
+				LiveVideo:    result.LiveVideo,
+				PurgedFor:    result.PurgedFor,
+				PurgeError:   result.PurgeError,
+			}
+			if result.PurgedFor != "" {
+				summary.PurgedSources = append(summary.PurgedSources, PurgedSource{Path: result.Path, KeptPath: result.PurgedFor})
+			}
+			if result.LinkedAs != "" {
+				dup.LinkedPath = result.DestPath
+				dup.LinkedAs = result.LinkedAs
+			}
+			summary.DuplicateFiles = append(summary.DuplicateFiles, dup)
+			if result.LinkedAs != "copy" {
+				// A duplicate stored as a full copy (hard link fallback) saved nothing
+				summary.DuplicateBytes += result.Size
+			}
+
+		case StateSkippedExtension, StateSkippedIncremental, StateSkippedDate, StateSkippedDestExists, StateSkippedProcessed, StateSkippedDateRange, StateSkippedExcluded, StateSkippedSize, StateSkippedEmpty, StateSkippedCollision:
+			summary.Skipped++
+			reason := result.State.String()
+			if note := liveVideoNote(result.LiveVideo); note != "" {
+				reason += ", " + note
+			}
+			summary.SkippedFiles = append(summary.SkippedFiles, SkippedFile{
+				Path:   result.Path,
+				Reason: reason,
+				Size:   result.Size,
+			})
+
+		case StateErrorStat, StateErrorDate, StateErrorHash, StateErrorCopy:
+			summary.Errors++
+			errorMsg := fmt.Sprintf("%s: %v", result.Path, result.Error)
+			if result.Error == nil {
+				errorMsg = fmt.Sprintf("%s: %s", result.Path, result.State.String())
+			} else if sourcePermissionDenied(result) {
+				errorMsg = fmt.Sprintf("%s: %s", result.Path, permissionDeniedReason)
+				summary.PermissionDenied = append(summary.PermissionDenied, result.Path)
+			}
+			summary.ErrorList = append(summary.ErrorList, errorMsg)
+
+		case StateErrorWalk:
+			// Walk errors are handled separately in walkErrors parameter
+			summary.Errors++
+		}
+
+		summary.countExtension(result.Path, result.State, result.BytesCopied)
+
+		// A live photo is one entry; its video only adds to the byte totals
+		if video := result.LiveVideo; video != nil {
+			summary.countExtension(video.Path, video.State, video.Size)
+			switch {
+			case video.State == StateCopied:
+				summary.TotalBytes += video.Size
+				if video.SourceRemoved {
+					summary.RemovedSources = append(summary.RemovedSources, video.Path)
+				}
+			case video.State == StateDuplicateHash && video.LinkedAs != "copy":
+				summary.DuplicateBytes += video.Size
+			}
+		}
+	}
+
+	// Add walk errors to error list, keeping the unreadable path first so reports can link it
+	for _, walkErr := range walkErrors {
+		var we *WalkError
+		switch {
+		case errors.As(walkErr, &we) && errors.Is(we.Err, fs.ErrPermission):
+			summary.ErrorList = append(summary.ErrorList, fmt.Sprintf("%s: %s", we.Path, permissionDeniedReason))
+			summary.PermissionDenied = append(summary.PermissionDenied, we.Path)
+		case errors.As(walkErr, &we):
+			summary.ErrorList = append(summary.ErrorList, fmt.Sprintf("%s: walk error: %v", we.Path, we.Err))
+		default:
+			summary.ErrorList = append(summary.ErrorList, fmt.Sprintf("walk error: %v", walkErr))
+		}
+	}
+	summary.Errors += len(walkErrors)
+
+	return summary
+}
@@ -0,0 +1,157 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// Policies for --prefer-date, deciding which date places content found under several dates
+const (
+	preferDateEXIF   = "exif"   // A date read from metadata over a file modification time, then the oldest
+	preferDateOldest = "oldest" // The earliest date
+	preferDateNewest = "newest" // The latest date
+)
+
+// ValidatePreferDate checks a --prefer-date value
+func ValidatePreferDate(policy string) error {
+	switch policy {
+	case preferDateEXIF, preferDateOldest, preferDateNewest:
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q (use exif, oldest, or newest)", policy)
+	}
+}
+
+// datedSource is one source file with its content hash and the date that would place it
+type datedSource struct {
+	path   string
+	hash   string
+	date   time.Time
+	source string // Where the date came from, as in EvaluationResult.DateSource
+}
+
+// preferredDate is the date a source is placed by instead of its own (--prefer-date)
+type preferredDate struct {
+	date   time.Time
+	source string
+}
+
+// resolvePreferredDates hashes and dates the source files up front, so that when the same
+// content turns up under different dates, every copy is placed by the date the policy picks
+// and the order workers happen to reach them in doesn't matter. Hashes are kept for the
+// processing phase, so no file is read twice. Files filtered out by extension, size, or
+// incremental mode are left out, as are those deduplicated by name, size, and mtime
+func (r *backupRun) resolvePreferredDates(ctx context.Context, files []FileWithInfo, batchInserter *BatchInserter, filter FileFilter, workers int) {
+	var candidates []FileWithInfo
+	for _, file := range files {
+		ext := metadata.NormalizeExt(file.Path)
+		if !r.extensions[ext] || r.quickDedupe[ext] || !filter.inSizeRange(file.Info.Size()) {
+			continue
+		}
+		if filter.olderThanLastBackup(file.Path, file.Info.ModTime()) && !r.fromZip(file.Path) {
+			continue
+		}
+		candidates = append(candidates, file)
+	}
+
+	dated := make([]*datedSource, len(candidates))
+	fresh := make([]bool, len(candidates)) // Hashed now rather than found in the cache
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				file := candidates[i]
+				size, mtime := file.Info.Size(), file.Info.ModTime().Unix()
+				hash, cached := batchInserter.CachedHash(file.Path, size, mtime)
+				if !cached {
+					var err error
+					if hash, err = HashFile(file.Path, batchInserter.hashAlgo); err != nil {
+						continue // Processing hits the same error and reports it
+					}
+					fresh[i] = true
+				}
+				result := r.registry.ExtractBestDate(file.Path)
+				if errors.Is(result.Error, metadata.ErrTimeout) {
+					continue // Reported as a date error when processed
+				}
+				date, source := result.Date, result.Source
+				if result.Error != nil || date.IsZero() {
+					date, source = file.Info.ModTime().In(r.opts.Timezone), dateSourceMtime
+				}
+				dated[i] = &datedSource{path: file.Path, hash: hash, date: date, source: source}
+			}
+		}()
+	}
+	for i := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	groups := make(map[string][]*datedSource)
+	var order []string
+	for i, d := range dated {
+		if d == nil {
+			continue
+		}
+		if fresh[i] {
+			info := candidates[i].Info
+			batchInserter.RememberHash(d.path, info.Size(), info.ModTime().Unix(), d.hash)
+		}
+		if _, seen := groups[d.hash]; !seen {
+			order = append(order, d.hash)
+		}
+		groups[d.hash] = append(groups[d.hash], d)
+	}
+
+	for _, hash := range order {
+		group := groups[hash]
+		if len(group) < 2 {
+			continue
+		}
+		winner := group[0]
+		for _, d := range group[1:] {
+			if prefersDate(r.opts.PreferDate, d, winner) {
+				winner = d
+			}
+		}
+		for _, d := range group {
+			if d.date.Equal(winner.date) {
+				continue
+			}
+			r.preferredDates[d.path] = preferredDate{
+				date:   winner.date,
+				source: fmt.Sprintf("%s of %s, --prefer-date %s", winner.source, filepath.Base(winner.path), r.opts.PreferDate),
+			}
+			r.log.Info("prefer-date %s: %s placed by %s (%s of %s) instead of %s (%s)", r.opts.PreferDate, d.path,
+				winner.date.Format("2006-01-02 15:04:05"), winner.source, winner.path, d.date.Format("2006-01-02 15:04:05"), d.source)
+		}
+	}
+}
+
+// prefersDate reports whether a --prefer-date policy picks a's date over b's
+func prefersDate(policy string, a, b *datedSource) bool {
+	switch policy {
+	case preferDateNewest:
+		return a.date.After(b.date)
+	case preferDateEXIF:
+		aMeta, bMeta := a.source != dateSourceMtime, b.source != dateSourceMtime
+		if aMeta != bMeta {
+			return aMeta
+		}
+	}
+	return a.date.Before(b.date)
+}
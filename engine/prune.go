@@ -0,0 +1,74 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// PruneDatabase removes database records whose destination file no longer exists, so content
+// deleted from the backup by hand is no longer treated as a duplicate when imported again
+// With dryRun set, stale records are only listed; with vacuum set, the database is compacted
+// after records were removed. Returns the number of stale records found
+func PruneDatabase(destDir, dbPath string, dryRun, vacuum, force bool) (int, error) {
+	destDir = absDestDir(destDir, true)
+	db, lock, err := lockExistingDB(destDir, dbPath, force)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.release()
+	defer db.Close()
+
+	records, err := loadRecordedFiles(db)
+	if err != nil {
+		return 0, fmt.Errorf("could not read database: %w", err)
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("🧹 Pruning Database\n")
+	fmt.Printf("   Checking %d files recorded in the database...\n", len(records))
+
+	dest := localDestination()
+	var stale []string
+	for _, record := range records {
+		var err error
+		if _, _, inArchive := splitArchiveMember(record.DestPath); inArchive {
+			_, err = dest.statDest(record.DestPath)
+		} else {
+			_, err = os.Lstat(record.DestPath)
+		}
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			// Unreadable is not the same as deleted (e.g. permissions); keep the record
+			log.Printf("Warning: could not check %s: %v", record.DestPath, err)
+			continue
+		}
+		stale = append(stale, record.Hash)
+		fmt.Printf("   %s\n", record.DestPath)
+	}
+
+	if len(stale) == 0 {
+		color.New(color.FgGreen).Printf("   ✅ No stale records found\n")
+		return 0, nil
+	}
+	if dryRun {
+		color.New(color.FgYellow).Printf("   Dry run: %d stale records would be removed\n", len(stale))
+		return len(stale), nil
+	}
+
+	if err := deleteFileRecords(db, stale); err != nil {
+		return 0, fmt.Errorf("could not remove stale records: %w", err)
+	}
+	color.New(color.FgGreen, color.Bold).Printf("   ✅ Removed %d stale records\n", len(stale))
+	if vacuum {
+		if err := vacuumDatabase(db, dbPath); err != nil {
+			return len(stale), err
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,84 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"fmt"
+	"os"
+)
+
+// purgeDuplicateSources keeps one source file per content hash and deletes the others
+// A group is only purged once its stored copy re-hashes to the shared hash, and every file is
+// re-hashed right before it is deleted; the copied file (or the first by path) is the one kept.
+// Duplicates matched by name, size, and mtime (--hash-only-videos) have no hash and are never purged,
+// and files inside a source zip are left alone
+func (r *backupRun) purgeDuplicateSources(results []*FileResult, algo string) {
+	groups := make(map[string][]*FileResult)
+	var order []string
+	for _, result := range results {
+		if result == nil || result.Hash == "" || r.fromZip(result.Path) {
+			continue
+		}
+		if result.State != StateCopied && result.State != StateDuplicateHash {
+			continue
+		}
+		if _, seen := groups[result.Hash]; !seen {
+			order = append(order, result.Hash)
+		}
+		groups[result.Hash] = append(groups[result.Hash], result)
+	}
+
+	for _, hash := range order {
+		group := groups[hash]
+		if len(group) < 2 {
+			continue
+		}
+		kept := group[0]
+		for _, result := range group {
+			if result.State == StateCopied {
+				kept = result
+				break
+			}
+		}
+		stored := kept.DestPath
+		if kept.State == StateDuplicateHash {
+			stored = kept.ExistingDuplicatePath
+		}
+		if storedHash, err := r.hashDestFile(stored, algo); err != nil || storedHash != hash {
+			r.log.Warn("kept source duplicates of %s: stored copy %s could not be verified", kept.Path, stored)
+			for _, result := range group {
+				if result != kept {
+					result.PurgeError = fmt.Errorf("stored copy %s could not be verified", stored)
+				}
+			}
+			continue
+		}
+
+		for _, result := range group {
+			if result == kept {
+				continue
+			}
+			if err := removeSourceDuplicate(result.Path, hash, algo); err != nil {
+				result.PurgeError = err
+				r.log.Warn("kept source duplicate %s: %v", result.Path, err)
+				continue
+			}
+			result.PurgedFor = kept.Path
+			r.log.Info("removed source duplicate %s (kept %s)", result.Path, kept.Path)
+		}
+	}
+}
+
+// removeSourceDuplicate deletes a source file after checking it still holds the expected content
+func removeSourceDuplicate(path, hash, algo string) error {
+	current, err := HashFile(path, algo)
+	if err != nil {
+		return fmt.Errorf("failed to re-read source: %w", err)
+	}
+	if current != hash {
+		return fmt.Errorf("source changed since it was hashed")
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove source: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// backupbozo tests for deleting extra copies in the source (--purge-duplicates-in-source)
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sourceDuplicate writes another source file with the content of a copied one, reported as its duplicate
+func sourceDuplicate(t *testing.T, of *FileResult, name string) *FileResult {
+	t.Helper()
+	content, err := os.ReadFile(of.Path)
+	if err != nil {
+		t.Fatalf("read %s: %v", of.Path, err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return &FileResult{Path: path, DestPath: of.DestPath, State: StateDuplicateHash, Hash: of.Hash, Size: of.Size, ExistingDuplicatePath: of.DestPath}
+}
+
+// TestPurgeDuplicateSources checks which copies of the same content in the source are deleted
+func TestPurgeDuplicateSources(t *testing.T) {
+	t.Run("copied file kept", func(t *testing.T) {
+		r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+		copied := copiedFile(t, "IMG_0001.jpg", "photo content")
+		first := sourceDuplicate(t, copied, "IMG_0001 (1).jpg")
+		second := sourceDuplicate(t, copied, "IMG_0001 (2).jpg")
+
+		// The duplicate comes first, so the copied file is kept because it was copied, not by order
+		r.purgeDuplicateSources([]*FileResult{first, copied, second}, hashSHA256)
+		if !exists(copied.Path) || copied.PurgedFor != "" {
+			t.Error("the copied source was purged")
+		}
+		for _, dup := range []*FileResult{first, second} {
+			if exists(dup.Path) || dup.PurgedFor != copied.Path {
+				t.Errorf("duplicate %s not purged for %s (purged for %q, error %v)", dup.Path, copied.Path, dup.PurgedFor, dup.PurgeError)
+			}
+		}
+	})
+
+	t.Run("stored copy fails to verify", func(t *testing.T) {
+		r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+		copied := copiedFile(t, "IMG_0001.jpg", "photo content")
+		dup := sourceDuplicate(t, copied, "IMG_0001 (1).jpg")
+		os.WriteFile(copied.DestPath, []byte("photo Content"), 0644)
+
+		r.purgeDuplicateSources([]*FileResult{copied, dup}, hashSHA256)
+		if !exists(copied.Path) || !exists(dup.Path) {
+			t.Error("a source was purged although the stored copy doesn't verify")
+		}
+		if dup.PurgeError == nil {
+			t.Error("expected the kept duplicate to report why")
+		}
+	})
+
+	t.Run("source changed after hashing", func(t *testing.T) {
+		r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+		copied := copiedFile(t, "IMG_0001.jpg", "photo content")
+		unchanged := sourceDuplicate(t, copied, "IMG_0001 (1).jpg")
+		changed := sourceDuplicate(t, copied, "IMG_0001 (2).jpg")
+		os.WriteFile(changed.Path, []byte("edited since"), 0644)
+
+		r.purgeDuplicateSources([]*FileResult{copied, unchanged, changed}, hashSHA256)
+		if exists(unchanged.Path) {
+			t.Error("unchanged duplicate was not purged")
+		}
+		if !exists(changed.Path) || changed.PurgeError == nil {
+			t.Errorf("changed duplicate was purged (error %v)", changed.PurgeError)
+		}
+	})
+
+	t.Run("name size mtime duplicates and zip members kept", func(t *testing.T) {
+		r := &backupRun{destination: localDestination(), zipSources: make(map[string]string)}
+		copied := copiedFile(t, "IMG_0001.jpg", "photo content")
+		// --hash-only-videos matches photos by name, size, and mtime, without a hash
+		quick := sourceDuplicate(t, copied, "IMG_0001.jpg")
+		quick.Hash = ""
+		quick.DedupMethod = dedupBySizeMtimeName
+		member := sourceDuplicate(t, copied, "IMG_0001 (1).jpg")
+		r.zipSources[member.Path] = filepath.Join("album.zip", "IMG_0001 (1).jpg")
+
+		r.purgeDuplicateSources([]*FileResult{copied, quick, member}, hashSHA256)
+		for _, kept := range []*FileResult{copied, quick, member} {
+			if !exists(kept.Path) || kept.PurgedFor != "" {
+				t.Errorf("source %s was purged", kept.Path)
+			}
+		}
+	})
+}
@@ -1,5 +1,5 @@
 // backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
-package main
+package engine
 
 import (
 	"encoding/base64"
@@ -12,6 +12,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"backupbozo/metadata"
 )
 
 const (
@@ -204,6 +206,14 @@ const reportCSS = `    <style>
             text-decoration: underline;
         }
 
+        .thumbnail {
+            display: block;
+            max-width: 96px;
+            max-height: 96px;
+            margin-bottom: 0.25rem;
+            border-radius: 4px;
+        }
+
         .status-badge {
             display: inline-flex;
             align-items: center;
@@ -214,12 +224,12 @@ const reportCSS = `    <style>
             white-space: nowrap;
         }
 
-        .status-copied {
+        .status-copied, .status-ok, .status-both {
             background: hsl(142 76% 36% / 0.1);
             color: hsl(142 76% 36%);
         }
 
-        .status-skipped {
+        .status-skipped, .status-extra, .status-only-a, .status-only-b {
             background: hsl(45 93% 47% / 0.1);
             color: hsl(45 93% 47%);
         }
@@ -229,7 +239,7 @@ const reportCSS = `    <style>
             color: hsl(221 83% 53%);
         }
 
-        .status-error {
+        .status-error, .status-mismatch, .status-missing {
             background: hsl(var(--destructive) / 0.1);
             color: hsl(var(--destructive));
         }
@@ -247,6 +257,12 @@ const reportCSS = `    <style>
             display: none !important;
         }
 
+        .section-title {
+            font-size: 1.25rem;
+            font-weight: 600;
+            margin: 2rem 0 1rem;
+        }
+
         /* Mascot header styles */
         .mascot-header {
             text-align: center;
@@ -326,7 +342,7 @@ const reportCSS = `    <style>
             border-color: hsl(142 76% 36% / 0.3);
         }
 
-        .badge-duplicate {
+        .badge-duplicate, .badge-saved {
             background: hsl(221 83% 53% / 0.1);
             color: hsl(221 83% 53%);
             border-color: hsl(221 83% 53% / 0.3);
@@ -490,10 +506,18 @@ const reportJavaScript = `        <script>
                                 aVal = parseSizeForSort(a.cells[3].textContent);
                                 bVal = parseSizeForSort(b.cells[3].textContent);
                                 break;
-                            case 'details':
+                            case 'camera':
                                 aVal = a.cells[4].textContent;
                                 bVal = b.cells[4].textContent;
                                 break;
+                            case 'gps':
+                                aVal = a.cells[5].textContent;
+                                bVal = b.cells[5].textContent;
+                                break;
+                            case 'details':
+                                aVal = a.cells[6].textContent;
+                                bVal = b.cells[6].textContent;
+                                break;
                             default:
                                 return 0;
                         }
@@ -525,18 +549,18 @@ const reportJavaScript = `        <script>
         </script>`
 
 // embedIconAsBase64 reads the icon.webp file and returns it as a base64 data URL
-func embedIconAsBase64() string {
+func embedIconAsBase64(console *log.Logger) string {
 	iconPath := "icon.webp"
 	file, err := os.Open(iconPath)
 	if err != nil {
-		log.Printf("Could not read icon file: %v", err)
+		console.Printf("Could not read icon file: %v", err)
 		return ""
 	}
 	defer file.Close()
 
 	data, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("Could not read icon data: %v", err)
+		console.Printf("Could not read icon data: %v", err)
 		return ""
 	}
 
@@ -667,8 +691,9 @@ func createQuoteContext(summary AccountingSummary, lastBackupTime time.Time, tot
 	// Calculate oldest file age by examining copied files
 	var oldestFileAge time.Duration = 0
 	now := time.Now()
-	for _, pair := range summary.CopiedFiles {
-		if info, err := os.Stat(pair[0]); err == nil {
+	for _, copied := range summary.CopiedFiles {
+		// Destination keeps the source mtime and still exists in --move mode
+		if info, err := os.Stat(copied.DestPath); err == nil {
 			age := now.Sub(info.ModTime())
 			if age > oldestFileAge {
 				oldestFileAge = age
@@ -720,7 +745,7 @@ func formatTimeDuration(d time.Duration) string {
 }
 
 // writeBadge writes a single summary badge with the given type, label, and value
-func writeBadge(f *os.File, badgeType, label, value string) {
+func writeBadge(f io.Writer, badgeType, label, value string) {
 	fmt.Fprintf(f, `
                 <span class="summary-badge badge-%s">
                     <span class="badge-label">%s</span>
@@ -729,35 +754,42 @@ func writeBadge(f *os.File, badgeType, label, value string) {
 }
 
 // writeSummaryBadges generates colored statistics badges
-func writeSummaryBadges(f *os.File, summary AccountingSummary, totalTime time.Duration) {
+func writeSummaryBadges(f io.Writer, summary AccountingSummary, totalTime time.Duration) {
 	totalFiles := len(summary.CopiedFiles) + len(summary.DuplicateFiles) + len(summary.SkippedFiles) + len(summary.ErrorList)
 
-	// Calculate total data size from copied files
-	var totalBytes int64
-	for _, pair := range summary.CopiedFiles {
-		if info, err := os.Stat(pair[0]); err == nil {
-			totalBytes += info.Size()
-		}
-	}
+	// Total data size from copied files (recorded during copy, sources may be gone in --move mode)
+	totalBytes := summary.TotalBytes
 
-	f.WriteString(`
+	io.WriteString(f, `
         <div class="summary-badges">
             <div class="badge-row">`)
 
-	// Always show all 7 badges in single row
+	// Always show all 9 badges in single row
+	mbPerSec, filesPerSec := throughput(summary, totalTime)
 	writeBadge(f, "total", "Total Files", fmt.Sprintf("%d", totalFiles))
-	writeBadge(f, "data", "Data Size", formatFileSize(totalBytes))
+	writeBadge(f, "data", "Data Size", FormatFileSize(totalBytes))
 	writeBadge(f, "time", "Time Taken", formatDuration(totalTime))
+	writeBadge(f, "time", "Throughput", fmt.Sprintf("%.1f MB/s · %.1f files/s", mbPerSec, filesPerSec))
 	writeBadge(f, "copied", "Copied", fmt.Sprintf("%d", len(summary.CopiedFiles)))
 	writeBadge(f, "duplicate", "Duplicates", fmt.Sprintf("%d", len(summary.DuplicateFiles)))
+	writeBadge(f, "saved", "Space Saved", FormatFileSize(summary.DuplicateBytes))
 	writeBadge(f, "skipped", "Skipped", fmt.Sprintf("%d", len(summary.SkippedFiles)))
 	writeBadge(f, "error", "Errors", fmt.Sprintf("%d", len(summary.ErrorList)))
 
-	f.WriteString(`
+	io.WriteString(f, `
             </div>
         </div>`)
 }
 
+// throughput is how fast a run copied data (MB/s) and got through files (files/s), over its whole time
+func throughput(summary AccountingSummary, totalTime time.Duration) (mbPerSec, filesPerSec float64) {
+	seconds := totalTime.Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(summary.TotalBytes) / (1024 * 1024) / seconds, float64(summary.TotalFiles) / seconds
+}
+
 // formatDuration formats time.Duration into human-readable format
 func formatDuration(d time.Duration) string {
 	if d.Hours() >= 1 {
@@ -770,10 +802,10 @@ func formatDuration(d time.Duration) string {
 
 // writeHTMLReport generates a detailed HTML report of the backup session
 // Features a modern table-based layout with search, filtering, and sorting
-func writeHTMLReport(path string, summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot string, lastBackupTime time.Time, incremental bool, isInterrupted bool) {
+func (r *backupRun) writeHTMLReport(path string, summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot string, lastBackupTime time.Time, incremental bool, isInterrupted bool) {
 	f, err := os.Create(path)
 	if err != nil {
-		log.Printf("Could not create report: %v", err)
+		r.console.Printf("Could not create report: %v", err)
 		return
 	}
 	defer f.Close()
@@ -782,17 +814,45 @@ func writeHTMLReport(path string, summary AccountingSummary, totalTime time.Dura
 	ctx := createQuoteContext(summary, lastBackupTime, totalTime, incremental, isInterrupted)
 
 	// Write HTML header with embedded CSS and JavaScript
-	writeHTMLHeader(f, ctx)
+	r.writeHTMLHeader(f, ctx)
+
+	// Unreadable sources go above the table so they aren't lost among other errors
+	writePermissionDenied(f, summary, srcRoot)
 
 	// Write table with all file data
-	writeFileTable(f, summary, srcRoot, destRoot)
+	r.writeFileTable(f, summary, srcRoot, destRoot)
+
+	// List sources deleted in --move mode
+	writeRemovedSources(f, summary, srcRoot)
+	writePurgedSources(f, summary, srcRoot)
+
+	// Flag copies that look like stored images (--near-duplicates)
+	writeNearDuplicates(f, summary, srcRoot, destRoot)
+
+	// Show every source copy of each stored file, to find where redundant copies live
+	writeDuplicateGroups(f, summary, srcRoot, destRoot)
+
+	// Keep burst frames together so they can be reviewed (and thinned) as one
+	writeBursts(f, summary, srcRoot, destRoot)
+
+	// Files whose extension lies about their content (--check-content-type)
+	writeMislabeled(f, summary, srcRoot, destRoot)
+
+	// Break the run down by file type
+	if r.opts.SeparateMedia {
+		writeOutcomeTable(f, "By Media Type", "Folder", summary.mediaTypes(r.mediaFolderForExt))
+	}
+	writeExtensionStats(f, summary)
+
+	// Add JavaScript for search, filter, and sort functionality
+	writeJavaScript(f)
 
 	// Close HTML
 	f.WriteString("</body></html>")
 }
 
 // writeHTMLHeader writes the HTML header with embedded CSS and JavaScript
-func writeHTMLHeader(f *os.File, ctx QuoteContext) {
+func (r *backupRun) writeHTMLHeader(f *os.File, ctx QuoteContext) {
 	f.WriteString(`<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -810,7 +870,7 @@ func writeHTMLHeader(f *os.File, ctx QuoteContext) {
             <p class="backup-timestamp">` + time.Now().Format("Monday, January 2, 2006 at 3:04 PM") + `</p>`)
 
 	// Add mascot icon
-	iconData := embedIconAsBase64()
+	iconData := embedIconAsBase64(r.console)
 	if iconData != "" {
 		fmt.Fprintf(f, `
             <img src="%s" alt="Backup Mascot" class="mascot-icon">`, iconData)
@@ -830,7 +890,7 @@ func writeHTMLHeader(f *os.File, ctx QuoteContext) {
 }
 
 // writeFileTable writes the main file table with all processed files
-func writeFileTable(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
+func (r *backupRun) writeFileTable(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
 	f.WriteString(`
         <div class="controls">
             <input type="text" class="search-input" placeholder="Search files..." id="searchInput">
@@ -851,49 +911,337 @@ func writeFileTable(f *os.File, summary AccountingSummary, srcRoot, destRoot str
                         <th data-sort="status">Status<span class="sort-indicator">↕</span></th>
                         <th data-sort="destination">Destination<span class="sort-indicator">↕</span></th>
                         <th data-sort="size">Size<span class="sort-indicator">↕</span></th>
+                        <th data-sort="camera">Camera<span class="sort-indicator">↕</span></th>
+                        <th data-sort="gps">GPS<span class="sort-indicator">↕</span></th>
                         <th data-sort="details">Details<span class="sort-indicator">↕</span></th>
                     </tr>
                 </thead>
                 <tbody class="table-body" id="fileTableBody">`)
 
 	// Add copied files
-	for _, pair := range summary.CopiedFiles {
-		srcRel := makeRelativePath(pair[0], srcRoot)
-		destRel := makeRelativePath(pair[1], destRoot)
-		writeTableRow(f, srcRel, pair[0], "copied", destRel, pair[1], getFileSize(pair[0]), "Successfully copied")
+	for _, copied := range summary.CopiedFiles {
+		srcRel := makeRelativePath(copied.Path, srcRoot)
+		destRel := makeRelativePath(copied.DestPath, destRoot)
+		details := "Successfully copied"
+		if copied.DateSource != "" {
+			details = fmt.Sprintf("Successfully copied (date from %s)", copied.DateSource)
+		}
+		if note := collisionNote(copied.RenamedFrom, copied.DestPath); note != "" {
+			details += fmt.Sprintf(", %s (name taken by a different file)", note)
+		}
+		for _, sidecar := range copied.Sidecars {
+			details += fmt.Sprintf(", with sidecar %s", filepath.Base(sidecar))
+		}
+		if note := liveVideoNote(copied.LiveVideo); note != "" {
+			details += ", " + note
+		}
+		if copied.SourceRemoved {
+			details += ", source removed"
+		} else if copied.MoveError != nil {
+			details += fmt.Sprintf(", source kept: %v", copied.MoveError)
+		}
+		if note := mirrorNote(copied); note != "" {
+			details += ", " + note
+		}
+		if note := mislabeledNote(copied.ContentExt, summary.MislabeledFixed); note != "" {
+			details += ", " + note
+		}
+		thumbnail := ""
+		if r.opts.ReportThumbnails {
+			thumbnail = r.thumbnailDataURI(copied.Path, copied.DestPath)
+		}
+		writeTableRow(f, srcRel, copied.Path, "copied", destRel, copied.DestPath, FormatFileSize(copied.Size), copied.Camera, copied.Location, thumbnail, details)
 	}
 
 	// Add duplicate files
-	for _, pair := range summary.DuplicateFiles {
-		srcRel := makeRelativePath(pair[0], srcRoot)
-		existingRel := makeRelativePath(pair[1], destRoot)
-		writeTableRow(f, srcRel, pair[0], "duplicate", existingRel, pair[1], getFileSize(pair[0]), "Duplicate of existing file")
+	for _, dup := range summary.DuplicateFiles {
+		srcRel := makeRelativePath(dup.Path, srcRoot)
+		existingRel := makeRelativePath(dup.ExistingPath, destRoot)
+		details := "Duplicate of existing file"
+		if dup.DedupMethod == dedupBySizeMtimeName {
+			details += " (same name, size, and mtime)"
+		} else if dup.DedupMethod == dedupBySample {
+			details += " (sampled checksum)"
+		}
+		if dup.LinkedAs != "" {
+			details = fmt.Sprintf("%s, %s at %s", details, dup.LinkedAs, makeRelativePath(dup.LinkedPath, destRoot))
+		}
+		if note := liveVideoNote(dup.LiveVideo); note != "" {
+			details += ", " + note
+		}
+		if dup.PurgedFor != "" {
+			details += ", removed from source (kept " + filepath.Base(dup.PurgedFor) + ")"
+		} else if dup.PurgeError != nil {
+			details += fmt.Sprintf(", kept in source: %v", dup.PurgeError)
+		}
+		writeTableRow(f, srcRel, dup.Path, "duplicate", existingRel, dup.ExistingPath, getFileSize(dup.Path), dup.Camera, dup.Location, "", details)
 	}
 
 	// Add skipped files
 	for _, skipped := range summary.SkippedFiles {
 		srcRel := makeRelativePath(skipped.Path, srcRoot)
-		writeTableRow(f, srcRel, skipped.Path, "skipped", "", "", getFileSize(skipped.Path), skipped.Reason)
+		writeTableRow(f, srcRel, skipped.Path, "skipped", "", "", getFileSize(skipped.Path), "", nil, "", skipped.Reason)
 	}
 
 	// Add error files
 	for _, errorMsg := range summary.ErrorList {
-		parts := strings.SplitN(errorMsg, ": ", 2)
-		path := parts[0]
-		details := errorMsg
-		if len(parts) > 1 {
-			details = parts[1]
-		}
+		path, details := splitErrorMessage(errorMsg)
 		srcRel := makeRelativePath(path, srcRoot)
-		writeTableRow(f, srcRel, path, "error", "", "", getFileSize(path), details)
+		writeTableRow(f, srcRel, path, "error", "", "", getFileSize(path), "", nil, "", details)
 	}
 
 	f.WriteString(`                </tbody>
             </table>
         </div>`)
+}
 
-	// Add JavaScript for search, filter, and sort functionality
-	writeJavaScript(f)
+// writeRemovedSources lists source files deleted in --move mode, if any
+func writeRemovedSources(f *os.File, summary AccountingSummary, srcRoot string) {
+	if len(summary.RemovedSources) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Removed Sources (%d)</h2>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Source Path</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(summary.RemovedSources))
+
+	for _, path := range summary.RemovedSources {
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                    </tr>`, html.EscapeString(path), html.EscapeString(makeRelativePath(path, srcRoot)))
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// writePurgedSources lists source duplicates deleted with --purge-duplicates-in-source, if any
+func writePurgedSources(f *os.File, summary AccountingSummary, srcRoot string) {
+	if len(summary.PurgedSources) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Removed Source Duplicates (%d)</h2>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Removed</th>
+                        <th>Kept</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(summary.PurgedSources))
+
+	for _, purged := range summary.PurgedSources {
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                        <td class="file-path" title="%s">%s</td>
+                    </tr>`, html.EscapeString(purged.Path), html.EscapeString(makeRelativePath(purged.Path, srcRoot)),
+			html.EscapeString(purged.KeptPath), html.EscapeString(makeRelativePath(purged.KeptPath, srcRoot)))
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// writeNearDuplicates lists copied images that look like images already stored
+func writeNearDuplicates(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
+	if len(summary.NearDuplicates) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Near Duplicates (%d)</h2>
+        <p>These images were copied because their contents differ, but they look like images already in the backup (re-saved, re-compressed, or resized). Review them and delete the ones you don't need. Distance is how many of 64 perceptual hash bits differ; 0 means visually identical.</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Source Path</th>
+                        <th>Copied To</th>
+                        <th>Looks Like</th>
+                        <th>Distance</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(summary.NearDuplicates))
+
+	for _, near := range summary.NearDuplicates {
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                        <td class="file-path"><a href="file://%s" title="%s">%s</a></td>
+                        <td class="file-path"><a href="file://%s" title="%s">%s</a></td>
+                        <td>%d</td>
+                    </tr>`,
+			html.EscapeString(near.Path), html.EscapeString(makeRelativePath(near.Path, srcRoot)),
+			html.EscapeString(near.DestPath), html.EscapeString(near.DestPath), html.EscapeString(makeRelativePath(near.DestPath, destRoot)),
+			html.EscapeString(near.SimilarTo), html.EscapeString(near.SimilarTo), html.EscapeString(makeRelativePath(near.SimilarTo, destRoot)),
+			near.Distance)
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// writeDuplicateGroups lists each stored file that duplicates matched, with all of its copies in the source
+func writeDuplicateGroups(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
+	groups := summary.DuplicateGroups()
+	if len(groups) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Duplicate Groups (%d)</h2>
+        <p>Each stored file below is what these source files were found to be copies of, so nothing was stored again for them. Groups with the most copies come first.</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Stored Copy</th>
+                        <th>Hash</th>
+                        <th>Copies</th>
+                        <th>Source Files</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(groups))
+
+	for _, group := range groups {
+		var sources strings.Builder
+		for i, dup := range group.Sources {
+			if i > 0 {
+				sources.WriteString("<br>")
+			}
+			fmt.Fprintf(&sources, `<a href="file://%s" title="%s">%s</a>`,
+				html.EscapeString(dup.Path), html.EscapeString(dup.Path), html.EscapeString(makeRelativePath(dup.Path, srcRoot)))
+		}
+		hash := group.Hash
+		switch {
+		case len(hash) > 12:
+			hash = hash[:12]
+		case hash != "":
+		case group.DedupMethod == dedupBySizeMtimeName:
+			hash = "same name, size, and mtime"
+		case group.DedupMethod == dedupBySample:
+			hash = "sampled checksum"
+		default:
+			hash = "-"
+		}
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path"><a href="file://%s" title="%s">%s</a></td>
+                        <td class="file-path" title="%s">%s</td>
+                        <td>%d</td>
+                        <td class="file-path">%s</td>
+                    </tr>`,
+			html.EscapeString(group.StoredPath), html.EscapeString(group.StoredPath), html.EscapeString(makeRelativePath(group.StoredPath, destRoot)),
+			html.EscapeString(group.Hash), html.EscapeString(hash), len(group.Sources), sources.String())
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// writeExtensionStats writes a table of outcomes per file extension
+func writeExtensionStats(f *os.File, summary AccountingSummary) {
+	writeOutcomeTable(f, "By Extension", "Extension", summary.Extensions())
+}
+
+// writeOutcomeTable writes a table of copied, duplicate, skipped, and error counts per group
+func writeOutcomeTable(f *os.File, title, column string, groups []*ExtensionStats) {
+	if len(groups) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">%s</h2>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>%s</th>
+                        <th>Copied</th>
+                        <th>Data Copied</th>
+                        <th>Duplicates</th>
+                        <th>Skipped</th>
+                        <th>Errors</th>
+                    </tr>
+                </thead>
+                <tbody>`, title, column)
+
+	for _, stats := range groups {
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td>%s</td>
+                        <td>%d</td>
+                        <td class="file-size">%s</td>
+                        <td>%d</td>
+                        <td>%d</td>
+                        <td>%d</td>
+                    </tr>`, html.EscapeString(stats.Extension), stats.Copied, FormatFileSize(stats.Bytes), stats.Duplicates, stats.Skipped, stats.Errors)
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// writePermissionDenied lists source files and folders that could not be read, if any
+func writePermissionDenied(f *os.File, summary AccountingSummary, srcRoot string) {
+	if len(summary.PermissionDenied) == 0 {
+		return
+	}
+
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Permission Denied (%d)</h2>
+        <p>These were not backed up because they could not be read. Check that the user running backupbozo can read them (owner, mode, or ACLs); folders also need execute permission. Files inside an unreadable folder are not listed individually.</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Source Path</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(summary.PermissionDenied))
+
+	for _, path := range summary.PermissionDenied {
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                    </tr>`, html.EscapeString(path), html.EscapeString(makeRelativePath(path, srcRoot)))
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
+
+// splitErrorMessage splits a "path: details" error list entry into its parts
+func splitErrorMessage(errorMsg string) (string, string) {
+	parts := strings.SplitN(errorMsg, ": ", 2)
+	if len(parts) > 1 {
+		return parts[0], parts[1]
+	}
+	return parts[0], errorMsg
 }
 
 // makeRelativePath creates a relative path from the full path, including the root folder name
@@ -925,11 +1273,13 @@ func makeRelativePath(fullPath, rootPath string) string {
 }
 
 // writeTableRow writes a single table row with clickable file links
-func writeTableRow(f *os.File, pathDisplay, pathAbsolute, status, destDisplay, destAbsolute, size, details string) {
+// thumbnail is an image data URI shown before the source path, or ""; location links to a map
+func writeTableRow(f *os.File, pathDisplay, pathAbsolute, status, destDisplay, destAbsolute, size, camera string, location *metadata.Location, thumbnail, details string) {
 	escapedPathDisplay := html.EscapeString(pathDisplay)
 	escapedPathAbsolute := html.EscapeString(pathAbsolute)
 	escapedDestDisplay := html.EscapeString(destDisplay)
 	escapedDestAbsolute := html.EscapeString(destAbsolute)
+	escapedCamera := html.EscapeString(camera)
 	escapedDetails := html.EscapeString(details)
 
 	// Create source cell with clickable link if absolute path exists
@@ -941,6 +1291,10 @@ func writeTableRow(f *os.File, pathDisplay, pathAbsolute, status, destDisplay, d
 		sourceCell = escapedPathDisplay
 	}
 
+	if thumbnail != "" {
+		sourceCell = fmt.Sprintf(`<img class="thumbnail" src="%s" alt="" loading="lazy">`, thumbnail) + sourceCell
+	}
+
 	// Create destination cell with clickable link if absolute path exists
 	var destCell string
 	if destAbsolute != "" {
@@ -950,6 +1304,12 @@ func writeTableRow(f *os.File, pathDisplay, pathAbsolute, status, destDisplay, d
 		destCell = escapedDestDisplay
 	}
 
+	var locationCell string
+	if location != nil {
+		locationCell = fmt.Sprintf(`<a href="%s" title="Show on OpenStreetMap">%s</a>`,
+			html.EscapeString(locationURL(location)), formatLocation(location))
+	}
+
 	fmt.Fprintf(f, `
                     <tr data-status="%s" data-path="%s">
                         <td class="file-path">%s</td>
@@ -957,12 +1317,16 @@ func writeTableRow(f *os.File, pathDisplay, pathAbsolute, status, destDisplay, d
                         <td class="file-path">%s</td>
                         <td class="file-size">%s</td>
                         <td>%s</td>
+                        <td>%s</td>
+                        <td>%s</td>
                     </tr>`,
 		status, strings.ToLower(escapedPathDisplay),
 		sourceCell,
 		status, strings.Title(status),
 		destCell,
 		size,
+		escapedCamera,
+		locationCell,
 		escapedDetails)
 }
 
@@ -977,11 +1341,11 @@ func getFileSize(path string) string {
 		return "-"
 	}
 
-	return formatFileSize(info.Size())
+	return FormatFileSize(info.Size())
 }
 
-// formatFileSize formats bytes into human-readable format
-func formatFileSize(bytes int64) string {
+// FormatFileSize formats bytes into human-readable format
+func FormatFileSize(bytes int64) string {
 	if bytes == 0 {
 		return "-"
 	}
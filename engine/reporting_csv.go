@@ -0,0 +1,116 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// csvHeader is the column order of the CSV report; spreadsheets depend on it, so only append
+var csvHeader = []string{"status", "source", "dest", "hash", "size", "date", "reason", "camera", "latitude", "longitude", "burst_id"}
+
+// csvDateLayout is a date format spreadsheets recognise without help
+const csvDateLayout = "2006-01-02 15:04:05"
+
+// csvLatitude and csvLongitude write GPS coordinates in decimal degrees, empty when unknown
+func csvLatitude(location *metadata.Location) string {
+	if location == nil {
+		return ""
+	}
+	return strconv.FormatFloat(location.Latitude, 'f', 6, 64)
+}
+
+func csvLongitude(location *metadata.Location) string {
+	if location == nil {
+		return ""
+	}
+	return strconv.FormatFloat(location.Longitude, 'f', 6, 64)
+}
+
+// csvReportPath derives the CSV report path from the HTML report path (report.html -> report.csv)
+func csvReportPath(htmlPath string) string {
+	return strings.TrimSuffix(htmlPath, filepath.Ext(htmlPath)) + ".csv"
+}
+
+// csvDate formats a folder date, leaving files that were never dated blank
+func csvDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(csvDateLayout)
+}
+
+// writeCSVReport writes one row per file, built from the same summary as the HTML report
+func (r *backupRun) writeCSVReport(path string, summary AccountingSummary) {
+	f, err := os.Create(path)
+	if err != nil {
+		r.console.Printf("Could not create CSV report: %v", err)
+		return
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(csvHeader)
+
+	for _, copied := range summary.CopiedFiles {
+		reason := "copied"
+		if copied.DateSource != "" {
+			reason = "copied (date from " + copied.DateSource + ")"
+		}
+		if note := collisionNote(copied.RenamedFrom, copied.DestPath); note != "" {
+			reason += ", " + note
+		}
+		if note := liveVideoNote(copied.LiveVideo); note != "" {
+			reason += ", " + note
+		}
+		if note := mirrorNote(copied); note != "" {
+			reason += ", " + note
+		}
+		if note := mislabeledNote(copied.ContentExt, summary.MislabeledFixed); note != "" {
+			reason += ", " + note
+		}
+		w.Write([]string{"copied", copied.Path, copied.DestPath, copied.Hash, fmt.Sprint(copied.Size), csvDate(copied.Date), reason, copied.Camera, csvLatitude(copied.Location), csvLongitude(copied.Location), copied.BurstID})
+	}
+
+	for _, dup := range summary.DuplicateFiles {
+		reason := StateDuplicateHash.String()
+		if dup.DedupMethod == dedupBySizeMtimeName {
+			reason = "duplicate (same name, size, and mtime)"
+		} else if dup.DedupMethod == dedupBySample {
+			reason = "duplicate (sampled checksum)"
+		}
+		if dup.LinkedAs != "" {
+			reason = fmt.Sprintf("%s, %s at %s", reason, dup.LinkedAs, dup.LinkedPath)
+		}
+		if note := liveVideoNote(dup.LiveVideo); note != "" {
+			reason += ", " + note
+		}
+		if dup.PurgedFor != "" {
+			reason += ", removed from source (kept " + dup.PurgedFor + ")"
+		} else if dup.PurgeError != nil {
+			reason += fmt.Sprintf(", kept in source: %v", dup.PurgeError)
+		}
+		w.Write([]string{"duplicate", dup.Path, dup.ExistingPath, dup.Hash, fmt.Sprint(dup.Size), csvDate(dup.Date), reason, dup.Camera, csvLatitude(dup.Location), csvLongitude(dup.Location), dup.BurstID})
+	}
+
+	for _, skipped := range summary.SkippedFiles {
+		w.Write([]string{"skipped", skipped.Path, "", "", fmt.Sprint(skipped.Size), "", skipped.Reason, "", "", "", ""})
+	}
+
+	for _, errorMsg := range summary.ErrorList {
+		path, details := splitErrorMessage(errorMsg)
+		w.Write([]string{"error", path, "", "", "", "", details, "", "", "", ""})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		r.console.Printf("Could not write CSV report: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+// historyRuns is how many runs a rolling report keeps; older runs are dropped from it
+const historyRuns = 100
+
+// historyFileLimit caps how many copied files and errors a run lists in the rolling report
+const historyFileLimit = 500
+
+// historyRunPattern matches one run's section in a rolling report
+var historyRunPattern = regexp.MustCompile(`(?s)<!-- run -->.*?<!-- /run -->`)
+
+// historyCSS styles the run sections, on top of the regular report styles
+const historyCSS = `
+    <style>
+        .history-run { margin-bottom: 3rem; }
+        .history-paths { color: hsl(var(--muted-foreground)); margin-bottom: 1rem; }
+        .history-run details { margin-top: 1rem; }
+        .history-run summary { cursor: pointer; font-weight: 600; }
+        .history-run ul { margin: 0.5rem 0 0 1.5rem; }
+    </style>`
+
+// appendHTMLReport adds this run at the top of the rolling report at path, creating it if needed
+// Each run is a summary with its copied files and errors; the full tables are only in per-run reports
+func (r *backupRun) appendHTMLReport(path string, summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot string, isInterrupted bool) {
+	var runs []string
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		r.console.Printf("Could not read report %s: %v", path, err)
+		return
+	}
+	runs = historyRunPattern.FindAllString(string(existing), -1)
+	if len(runs) >= historyRuns {
+		runs = runs[:historyRuns-1]
+	}
+
+	var buf bytes.Buffer
+	writeHistoryHeader(&buf, len(runs)+1)
+	writeHistoryRun(&buf, summary, totalTime, srcRoot, destRoot, isInterrupted)
+	for _, run := range runs {
+		buf.WriteString("\n")
+		buf.WriteString(run)
+	}
+	buf.WriteString(`
+    </div>
+</body></html>`)
+
+	// The previous runs are only replaced once the new report is complete
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		r.console.Printf("Could not write report: %v", err)
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		r.console.Printf("Could not write report: %v", err)
+		os.Remove(tmp)
+	}
+}
+
+// writeHistoryHeader starts a rolling report
+func writeHistoryHeader(w io.Writer, runs int) {
+	io.WriteString(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>backupbozo history</title>
+`)
+	io.WriteString(w, reportCSS)
+	io.WriteString(w, historyCSS)
+	fmt.Fprintf(w, `
+</head>
+<body>
+    <div class="container">
+        <div class="mascot-header">
+            <h1>Backup History</h1>
+            <p class="backup-timestamp">Last %d run(s), newest first; older runs are dropped after %d</p>
+        </div>`, runs, historyRuns)
+}
+
+// writeHistoryRun writes one run's section of a rolling report
+func writeHistoryRun(w io.Writer, summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot string, isInterrupted bool) {
+	title := time.Now().Format("Monday, January 2, 2006 at 3:04 PM")
+	if isInterrupted {
+		title += " (interrupted)"
+	}
+	fmt.Fprintf(w, `
+<!-- run -->
+        <section class="history-run">
+            <h2 class="section-title">%s</h2>
+            <p class="history-paths file-path">%s → %s</p>`,
+		html.EscapeString(title), html.EscapeString(srcRoot), html.EscapeString(destRoot))
+	writeSummaryBadges(w, summary, totalTime)
+
+	if len(summary.CopiedFiles) > 0 {
+		fmt.Fprintf(w, `
+            <details>
+                <summary>Copied files (%d)</summary>
+                <ul>`, len(summary.CopiedFiles))
+		for i, copied := range summary.CopiedFiles {
+			if i == historyFileLimit {
+				fmt.Fprintf(w, `
+                    <li>… and %d more</li>`, len(summary.CopiedFiles)-historyFileLimit)
+				break
+			}
+			fmt.Fprintf(w, `
+                    <li class="file-path">%s → %s</li>`,
+				html.EscapeString(makeRelativePath(copied.Path, srcRoot)), html.EscapeString(makeRelativePath(copied.DestPath, destRoot)))
+		}
+		io.WriteString(w, `
+                </ul>
+            </details>`)
+	}
+
+	// Errors are open from the start: they are what a look back at a run is usually for
+	if len(summary.ErrorList) > 0 {
+		fmt.Fprintf(w, `
+            <details open>
+                <summary>Errors (%d)</summary>
+                <ul>`, len(summary.ErrorList))
+		for i, msg := range summary.ErrorList {
+			if i == historyFileLimit {
+				fmt.Fprintf(w, `
+                    <li>… and %d more</li>`, len(summary.ErrorList)-historyFileLimit)
+				break
+			}
+			fmt.Fprintf(w, `
+                    <li class="file-path">%s</li>`, html.EscapeString(msg))
+		}
+		io.WriteString(w, `
+                </ul>
+            </details>`)
+	}
+
+	io.WriteString(w, `
+        </section>
+<!-- /run -->`)
+}
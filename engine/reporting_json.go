@@ -0,0 +1,236 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+)
+
+// jsonReportVersion is bumped whenever the JSON report schema changes incompatibly
+const jsonReportVersion = 1
+
+// JSONReport is the machine-readable report written by --json
+// Field names are part of the public schema; add new fields rather than renaming existing ones
+type JSONReport struct {
+	Version         int               `json:"version"`
+	GeneratedAt     string            `json:"generated_at"`
+	Source          string            `json:"source"`
+	Destination     string            `json:"destination"`
+	Incremental     bool              `json:"incremental"`
+	Interrupted     bool              `json:"interrupted"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	Summary         JSONReportSummary `json:"summary"`
+	Copied          []JSONReportEntry `json:"copied"`
+	Duplicates      []JSONReportEntry `json:"duplicates"`
+	Skipped         []JSONReportEntry `json:"skipped"`
+	Errors          []JSONReportEntry `json:"errors"`
+	// Source paths that could not be read for lack of permission (each is also in errors)
+	PermissionDenied []string `json:"permission_denied"`
+	// Outcome counts per file extension, most bytes copied first
+	ByExtension []JSONExtensionStats `json:"by_extension"`
+	// Copied images that look like stored ones (--near-duplicates)
+	NearDuplicates []JSONNearDuplicate `json:"near_duplicates"`
+}
+
+// JSONNearDuplicate is a copied image that resembles a stored one
+type JSONNearDuplicate struct {
+	Source    string `json:"source"`
+	Dest      string `json:"dest"`
+	SimilarTo string `json:"similar_to"`
+	Distance  int    `json:"distance"`
+}
+
+// JSONExtensionStats is one row of the per-extension breakdown
+type JSONExtensionStats struct {
+	Extension  string `json:"extension"`
+	Copied     int    `json:"copied"`
+	Duplicates int    `json:"duplicates"`
+	Skipped    int    `json:"skipped"`
+	Errors     int    `json:"errors"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// JSONReportSummary mirrors the counts shown in the console summary
+type JSONReportSummary struct {
+	Copied     int   `json:"copied"`
+	Duplicates int   `json:"duplicates"`
+	Skipped    int   `json:"skipped"`
+	Errors     int   `json:"errors"`
+	TotalFiles int   `json:"total_files"`
+	TotalBytes int64 `json:"total_bytes"`
+	SavedBytes int64 `json:"saved_bytes"` // Size of duplicates that were not stored again
+	// Copied files whose --mirror copy failed (they are also counted as copied)
+	MirrorErrors int `json:"mirror_errors"`
+	// Copied data and processed files per second of the whole run
+	MBPerSecond    float64 `json:"mb_per_second"`
+	FilesPerSecond float64 `json:"files_per_second"`
+}
+
+// JSONReportEntry describes one file; every key is always present so consumers can rely on it
+type JSONReportEntry struct {
+	SourcePath string `json:"source_path"`
+	DestPath   string `json:"dest_path"`
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	Camera     string `json:"camera"` // Camera or device from the file's metadata, "" if unknown
+	// EXIF GPS position as {"latitude", "longitude"} in decimal degrees, null if unknown
+	Location *metadata.Location `json:"location"`
+	// Identifier shared by the frames of one burst, "" if not a burst
+	BurstID string `json:"burst_id"`
+	Reason  string `json:"reason"`
+}
+
+// jsonReportPath derives the JSON report path from the HTML report path (report.html -> report.json)
+func jsonReportPath(htmlPath string) string {
+	return strings.TrimSuffix(htmlPath, filepath.Ext(htmlPath)) + ".json"
+}
+
+// writeJSONReport writes a machine-readable report built from the same summary as the HTML report
+func (r *backupRun) writeJSONReport(path string, summary AccountingSummary, totalTime time.Duration, srcRoot, destRoot string, incremental bool, isInterrupted bool) {
+	mbPerSec, filesPerSec := throughput(summary, totalTime)
+	report := JSONReport{
+		Version:         jsonReportVersion,
+		GeneratedAt:     time.Now().Format(time.RFC3339),
+		Source:          srcRoot,
+		Destination:     destRoot,
+		Incremental:     incremental,
+		Interrupted:     isInterrupted,
+		DurationSeconds: totalTime.Seconds(),
+		Summary: JSONReportSummary{
+			Copied:     summary.Copied,
+			Duplicates: summary.Duplicates,
+			Skipped:    summary.Skipped,
+			Errors:     summary.Errors,
+			TotalFiles: summary.TotalFiles,
+			TotalBytes: summary.TotalBytes,
+			SavedBytes: summary.DuplicateBytes,
+
+			MirrorErrors: summary.MirrorErrors,
+
+			MBPerSecond:    mbPerSec,
+			FilesPerSecond: filesPerSec,
+		},
+		// Empty arrays instead of null keep the schema stable for consumers
+		Copied:     []JSONReportEntry{},
+		Duplicates: []JSONReportEntry{},
+		Skipped:    []JSONReportEntry{},
+		Errors:     []JSONReportEntry{},
+		// Never null, like the file lists
+		PermissionDenied: append([]string{}, summary.PermissionDenied...),
+		ByExtension:      []JSONExtensionStats{},
+		NearDuplicates:   []JSONNearDuplicate{},
+	}
+
+	for _, near := range summary.NearDuplicates {
+		report.NearDuplicates = append(report.NearDuplicates, JSONNearDuplicate{
+			Source:    near.Path,
+			Dest:      near.DestPath,
+			SimilarTo: near.SimilarTo,
+			Distance:  near.Distance,
+		})
+	}
+
+	for _, stats := range summary.Extensions() {
+		report.ByExtension = append(report.ByExtension, JSONExtensionStats{
+			Extension:  stats.Extension,
+			Copied:     stats.Copied,
+			Duplicates: stats.Duplicates,
+			Skipped:    stats.Skipped,
+			Errors:     stats.Errors,
+			Bytes:      stats.Bytes,
+		})
+	}
+
+	for _, copied := range summary.CopiedFiles {
+		reason := "copied"
+		if copied.DateSource != "" {
+			reason = "copied (date from " + copied.DateSource + ")"
+		}
+		if note := collisionNote(copied.RenamedFrom, copied.DestPath); note != "" {
+			reason += ", " + note
+		}
+		for _, sidecar := range copied.Sidecars {
+			reason += ", with sidecar " + filepath.Base(sidecar)
+		}
+		if note := liveVideoNote(copied.LiveVideo); note != "" {
+			reason += ", " + note
+		}
+		if note := mirrorNote(copied); note != "" {
+			reason += ", " + note
+		}
+		if note := mislabeledNote(copied.ContentExt, summary.MislabeledFixed); note != "" {
+			reason += ", " + note
+		}
+		report.Copied = append(report.Copied, JSONReportEntry{
+			SourcePath: copied.Path,
+			DestPath:   copied.DestPath,
+			Hash:       copied.Hash,
+			Size:       copied.Size,
+			Camera:     copied.Camera,
+			Location:   copied.Location,
+			BurstID:    copied.BurstID,
+			Reason:     reason,
+		})
+	}
+
+	for _, dup := range summary.DuplicateFiles {
+		reason := StateDuplicateHash.String()
+		if dup.DedupMethod == dedupBySizeMtimeName {
+			reason = "duplicate (same name, size, and mtime)"
+		} else if dup.DedupMethod == dedupBySample {
+			reason = "duplicate (sampled checksum)"
+		}
+		if dup.LinkedAs != "" {
+			reason = fmt.Sprintf("%s, %s at %s", reason, dup.LinkedAs, dup.LinkedPath)
+		}
+		if note := liveVideoNote(dup.LiveVideo); note != "" {
+			reason += ", " + note
+		}
+		if dup.PurgedFor != "" {
+			reason += ", removed from source (kept " + dup.PurgedFor + ")"
+		} else if dup.PurgeError != nil {
+			reason += fmt.Sprintf(", kept in source: %v", dup.PurgeError)
+		}
+		report.Duplicates = append(report.Duplicates, JSONReportEntry{
+			SourcePath: dup.Path,
+			DestPath:   dup.ExistingPath,
+			Hash:       dup.Hash,
+			Size:       dup.Size,
+			Camera:     dup.Camera,
+			Location:   dup.Location,
+			BurstID:    dup.BurstID,
+			Reason:     reason,
+		})
+	}
+
+	for _, skipped := range summary.SkippedFiles {
+		report.Skipped = append(report.Skipped, JSONReportEntry{
+			SourcePath: skipped.Path,
+			Size:       skipped.Size,
+			Reason:     skipped.Reason,
+		})
+	}
+
+	for _, errorMsg := range summary.ErrorList {
+		path, details := splitErrorMessage(errorMsg)
+		report.Errors = append(report.Errors, JSONReportEntry{
+			SourcePath: path,
+			Reason:     details,
+		})
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		r.console.Printf("Could not encode JSON report: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		r.console.Printf("Could not create JSON report: %v", err)
+	}
+}
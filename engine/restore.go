@@ -0,0 +1,307 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// restorePlan is one backed up file and where restore writes it
+type restorePlan struct {
+	record FileRecord
+	target string
+}
+
+// loadRestoreRecords reads the records filed under a date in [since, until] (either may be zero)
+// Records without a filing date (older backups, index) are matched by their modification time
+func loadRestoreRecords(db *sql.DB, since, until time.Time) ([]FileRecord, error) {
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	if !since.IsZero() {
+		from = since.Unix()
+	}
+	if !until.IsZero() {
+		to = until.AddDate(0, 0, 1).Unix() // --until includes the whole day
+	}
+	rows, err := db.Query(`SELECT src_path, dest_path, hash, COALESCE(hash_algo, 'md5'), size, mtime, COALESCE(orig_ext, '')
+		FROM files
+		WHERE hash IS NOT NULL AND COALESCE(taken_at, mtime) >= ? AND COALESCE(taken_at, mtime) < ?
+		ORDER BY dest_path`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	var records []FileRecord
+	for rows.Next() {
+		var record FileRecord
+		var srcPath sql.NullString
+		if err := rows.Scan(&srcPath, &record.DestPath, &record.Hash, &record.HashAlgo, &record.Size, &record.Mtime, &record.OrigExt); err != nil {
+			return nil, err
+		}
+		record.SrcPath = srcPath.String
+		record.DestPath = resolveDestPath(root, record.DestPath)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// restoreTarget picks where a backed up file goes under outDir
+// mirror keeps the backup's folders (an archive's files go in a folder named after it);
+// flat puts everything in outDir, giving same-named files a hash suffix like backups do
+func restoreTarget(record FileRecord, destDir, outDir string, mirror bool, taken map[string]bool) string {
+	var target string
+	if mirror {
+		path := record.DestPath
+		if archive, member, ok := splitArchiveMember(path); ok {
+			path = filepath.Join(strings.TrimSuffix(archive, archiveSuffix), member)
+		}
+		if rel, err := filepath.Rel(destDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			target = filepath.Join(outDir, rel)
+		}
+	}
+	if target == "" {
+		target = filepath.Join(outDir, filepath.Base(record.DestPath))
+	}
+	if taken[target] {
+		target = collisionPath(target, record.Hash)
+	}
+	taken[target] = true
+	return target
+}
+
+// restoredAs reports whether path already holds the file a record restores
+func restoredAs(record FileRecord, path string) bool {
+	hash, err := HashFile(path, record.HashAlgo)
+	return err == nil && hash == record.Hash
+}
+
+// RestoreFiles copies the backed up files dated within [since, until] out to outDir
+// Files keep their original modification time and are checked against their recorded hash;
+// files already present in outDir are left alone, and a flat restore renames files whose name
+// is taken by a different file. Returns false if any file failed
+func RestoreFiles(ctx context.Context, destDir, dbPath, outDir string, since, until time.Time, mirror, dryRun, force bool) (bool, error) {
+	destDir = absDestDir(destDir, true)
+	// A backup running meanwhile could be appending to the archives being read
+	db, lock, err := lockExistingDB(destDir, dbPath, force)
+	if err != nil {
+		return false, err
+	}
+	defer lock.release()
+	defer db.Close()
+
+	records, err := loadRestoreRecords(db, since, until)
+	if err != nil {
+		return false, fmt.Errorf("could not read database: %w", err)
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("📦 Restoring Files\n")
+	fmt.Printf("   %d files in the database match the date range\n", len(records))
+
+	// Plan every target first so flat name collisions are settled before anything is written
+	var loose []restorePlan
+	archived := make(map[string]map[string]restorePlan) // archive -> member -> plan
+	taken := make(map[string]bool)
+	skipped := 0
+	var totalSize int64
+	for _, record := range records {
+		target := restoreTarget(record, destDir, outDir, mirror, taken)
+		if _, err := os.Lstat(target); err == nil {
+			// A flat restore only has names to go by: a different file of the same name (another
+			// IMG_0001.jpg from an earlier restore) leaves this one a hash-suffixed name
+			if mirror || restoredAs(record, target) {
+				skipped++
+				continue
+			}
+			target = collisionPath(target, record.Hash)
+			taken[target] = true
+			if _, err := os.Lstat(target); err == nil {
+				skipped++
+				continue
+			}
+		}
+		plan := restorePlan{record: record, target: target}
+		totalSize += record.Size
+		if dryRun {
+			fmt.Printf("   %s -> %s\n", record.DestPath, target)
+			continue
+		}
+		if archive, member, ok := splitArchiveMember(record.DestPath); ok {
+			if archived[archive] == nil {
+				archived[archive] = make(map[string]restorePlan)
+			}
+			archived[archive][member] = plan
+		} else {
+			loose = append(loose, plan)
+		}
+	}
+	if skipped > 0 {
+		color.New(color.FgYellow).Printf("   %d files already exist in %s and are left alone\n", skipped, outDir)
+	}
+	if dryRun {
+		if len(records) == skipped {
+			color.New(color.FgGreen).Printf("   Dry run: nothing to restore\n")
+		} else {
+			color.New(color.FgYellow).Printf("   Dry run: %d files (%s) would be restored\n", len(records)-skipped, FormatFileSize(totalSize))
+		}
+		return true, nil
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return false, fmt.Errorf("could not create output directory: %w", err)
+	}
+
+	bar := progressbar.NewOptions(
+		len(records)-skipped,
+		progressbar.OptionSetVisibility(StdoutIsTerminal()),
+		progressbar.OptionSetDescription("Restoring"),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[cyan]=[reset]",
+			SaucerHead:    "[cyan]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+
+	restored := 0
+	var failures []string
+	fail := func(path string, err error) {
+		failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+	}
+
+	for _, plan := range loose {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := restoreLooseFile(ctx, plan); err != nil {
+			fail(plan.record.DestPath, err)
+		} else {
+			restored++
+		}
+		bar.Add(1)
+	}
+
+	// Each archive is read once, extracting the wanted files as they come by
+	archives := make([]string, 0, len(archived))
+	for archive := range archived {
+		archives = append(archives, archive)
+	}
+	sort.Strings(archives)
+	for _, archive := range archives {
+		if ctx.Err() != nil {
+			break
+		}
+		wanted := archived[archive]
+		err := readTarGz(archive, func(header *tar.Header, r io.Reader) error {
+			plan, found := wanted[header.Name]
+			if !found {
+				return ctx.Err()
+			}
+			delete(wanted, header.Name)
+			if err := writeRestoredFile(ctx, r, plan); err != nil {
+				fail(plan.record.DestPath, err)
+			} else {
+				restored++
+			}
+			bar.Add(1)
+			return ctx.Err()
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		reason := fmt.Errorf("not found in archive")
+		if err != nil {
+			reason = fmt.Errorf("could not read archive: %w", err)
+		}
+		for _, plan := range wanted {
+			fail(plan.record.DestPath, reason)
+			bar.Add(1)
+		}
+	}
+	bar.Finish()
+	fmt.Println()
+
+	if ctx.Err() != nil {
+		color.New(color.FgYellow).Printf("   Interrupted: restored %d files before stopping\n", restored)
+		return false, nil
+	}
+	sort.Strings(failures)
+	for _, failure := range failures {
+		color.New(color.FgRed).Printf("   ❌ %s\n", failure)
+	}
+	color.New(color.FgGreen, color.Bold).Printf("   ✅ Restored %d files to %s\n", restored, outDir)
+	return len(failures) == 0, nil
+}
+
+// restoreLooseFile copies one file stored directly in the backup
+func restoreLooseFile(ctx context.Context, plan restorePlan) error {
+	in, err := os.Open(plan.record.DestPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeRestoredFile(ctx, in, plan)
+}
+
+// writeRestoredFile writes r to the plan's target through a temp file, checking its hash on the way
+// Content that no longer matches the database is not restored; verify tells which files are affected
+func writeRestoredFile(ctx context.Context, r io.Reader, plan restorePlan) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	hasher, err := newHasher(plan.record.HashAlgo)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(plan.target), 0755); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	tmp := plan.target + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file %s: %w", tmp, err)
+	}
+	_, err = io.Copy(io.MultiWriter(out, hasher), r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	// A converted file's recorded hash is that of its original, so there is nothing to compare
+	if err == nil && plan.record.OrigExt == "" && fmt.Sprintf("%x", hasher.Sum(nil)) != plan.record.Hash {
+		err = fmt.Errorf("content does not match the database (run verify)")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	mtime := time.Unix(plan.record.Mtime, 0)
+	if err := os.Chtimes(tmp, mtime, mtime); err != nil {
+		fmt.Printf("Warning: failed to set timestamps on %s: %v\n", tmp, err)
+	}
+	if err := os.Rename(tmp, plan.target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename temp file to %s: %w", plan.target, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// DefaultCopyRetryDelay is the wait before the first copy retry when none is given (--copy-retry-delay)
+const DefaultCopyRetryDelay = time.Second
+
+// retryCopy runs copy until it succeeds or --copy-retries retries have failed, waiting with
+// exponential backoff in between, starting from --copy-retry-delay. Flaky drives and network shares often fail a copy with an
+// I/O error that works moments later. Cancellation and a missing source are not retried
+func (r *backupRun) retryCopy(ctx context.Context, src string, copy func() (string, error)) (string, error) {
+	copyRetries, delay := r.opts.CopyRetries, r.opts.CopyRetryDelay
+	for attempt := 1; ; attempt++ {
+		hash, err := copy()
+		if err == nil || attempt > copyRetries || !retryableCopyError(ctx, err) {
+			return hash, err
+		}
+
+		r.console.Printf("Warning: Copy of %s failed (attempt %d of %d), retrying in %s: %v", src, attempt, copyRetries+1, delay, err)
+		r.log.Warn("copy retry %d/%d for %s in %s: %v", attempt, copyRetries, src, delay, err)
+		if r.opts.Verbosity == VerbosityVerbose {
+			color.New(color.FgYellow).Printf("↻ Retrying %s in %s (%v)\n", src, delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryableCopyError reports whether a failed copy may succeed if tried again
+func retryableCopyError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// The source was moved or deleted since the scan: trying again won't bring it back
+	return !errors.Is(err, fs.ErrNotExist)
+}
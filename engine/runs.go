@@ -0,0 +1,330 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// runIDLayout formats the start time of a backup run into its ID (matches report file names)
+const runIDLayout = "20060102_150405"
+
+// RunInfo summarizes the files one backup run copied
+type RunInfo struct {
+	ID         string
+	Files      int
+	TotalBytes int64
+}
+
+// loadRuns lists every tagged backup run, oldest first
+func loadRuns(db *sql.DB) ([]RunInfo, error) {
+	rows, err := db.Query("SELECT run_id, COUNT(*), COALESCE(SUM(size), 0) FROM files WHERE run_id IS NOT NULL GROUP BY run_id ORDER BY run_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunInfo
+	for rows.Next() {
+		var run RunInfo
+		if err := rows.Scan(&run.ID, &run.Files, &run.TotalBytes); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// loadRunRecords returns the database records written by one backup run
+func loadRunRecords(db *sql.DB, runID string) ([]FileRecord, error) {
+	rows, err := db.Query("SELECT src_path, dest_path, hash, COALESCE(hash_algo, 'md5'), size, mtime, COALESCE(orig_ext, '') FROM files WHERE run_id = ?", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	var records []FileRecord
+	for rows.Next() {
+		record := FileRecord{RunID: runID}
+		if err := rows.Scan(&record.SrcPath, &record.DestPath, &record.Hash, &record.HashAlgo, &record.Size, &record.Mtime, &record.OrigExt); err != nil {
+			return nil, err
+		}
+		record.DestPath = resolveDestPath(root, record.DestPath)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// openExistingDB opens the backup database for the maintenance commands, failing if it is missing
+// destDir must be absolute (see absDestDir); stored destination paths are resolved against it
+func openExistingDB(destDir, dbPath string) (*sql.DB, error) {
+	if err := checkExistingDB(destDir, dbPath); err != nil {
+		return nil, err
+	}
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := useDestDir(db, destDir, true); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// lockExistingDB is openExistingDB for the commands that change the database or the
+// destination: it also takes the run lock, so they can't run alongside a backup or each other
+func lockExistingDB(destDir, dbPath string, force bool) (*sql.DB, *runLock, error) {
+	if err := checkExistingDB(destDir, dbPath); err != nil {
+		return nil, nil, err
+	}
+	lock, err := acquireRunLock(dbPath, force, nil, VerbosityNormal)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := openExistingDB(destDir, dbPath)
+	if err != nil {
+		lock.release()
+		return nil, nil, err
+	}
+	return db, lock, nil
+}
+
+// checkExistingDB returns an error unless the destination and its database exist
+func checkExistingDB(destDir, dbPath string) error {
+	if err := checkDirExists(destDir, "destination"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database '%s' not found: %w", dbPath, err)
+	}
+	return nil
+}
+
+// openReadOnlyDB opens a backup database without writing to it, failing if it is missing or was
+// written by a newer build. Nothing is migrated, so a database from before schema versioning (which
+// may lack newer columns) has to be opened once by a command that writes first
+func openReadOnlyDB(destDir, dbPath string) (*sql.DB, error) {
+	if err := checkExistingDB(destDir, dbPath); err != nil {
+		return nil, err
+	}
+	db, err := OpenDatabaseReadOnly(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database '%s': %w", dbPath, err)
+	}
+	version, err := readSchemaVersion(db)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("could not read database '%s': %w", dbPath, err)
+	case version > schemaVersion:
+		err = schemaTooNew(version)
+	case version == 0:
+		err = fmt.Errorf("database '%s' predates schema versioning; run 'verify' on it once to upgrade it", dbPath)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// readOnlyDestRoot is what a database opened with openReadOnlyDB resolves its paths against: destDir
+// for relative paths, so a moved backup resolves where it is now; "" for older absolute paths
+func readOnlyDestRoot(db *sql.DB, destDir string) string {
+	if destRootOf(db) == "" {
+		return ""
+	}
+	return destDir
+}
+
+// ListRuns prints the backup runs recorded in the database
+func ListRuns(destDir, dbPath string) error {
+	destDir = absDestDir(destDir, true)
+	db, err := openExistingDB(destDir, dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runs, err := loadRuns(db)
+	if err != nil {
+		return fmt.Errorf("could not read database: %w", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No backup runs recorded yet.")
+		return nil
+	}
+
+	color.New(color.FgCyan, color.Bold).Printf("%-17s  %-19s  %8s  %10s\n", "RUN", "STARTED", "FILES", "SIZE")
+	for _, run := range runs {
+		started := run.ID
+		if t, err := time.ParseInLocation(runIDLayout, run.ID, time.Local); err == nil {
+			started = t.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-17s  %-19s  %8d  %10s\n", run.ID, started, run.Files, FormatFileSize(run.TotalBytes))
+	}
+	return nil
+}
+
+// RollbackRun deletes the files a backup run copied and removes their database records
+// An empty runID means the most recent run. Files are only deleted while their content still
+// matches the database and their source still holds the same content, so a rollback never destroys
+// the only copy of a photo (e.g. after --move removed the source, or a card reused its name).
+// Symlinks to a deleted file (duplicates placed by --dedupe-mode symlink, in any run) are deleted
+// with it, so none is left dangling
+func RollbackRun(destDir, dbPath, runID string, dryRun, force bool) error {
+	destDir = absDestDir(destDir, true)
+	// A backup running meanwhile could link to or record the files being removed
+	db, lock, err := lockExistingDB(destDir, dbPath, force)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	defer db.Close()
+
+	if runID == "" {
+		runs, err := loadRuns(db)
+		if err != nil {
+			return fmt.Errorf("could not read database: %w", err)
+		}
+		if len(runs) == 0 {
+			fmt.Println("No backup runs recorded yet.")
+			return nil
+		}
+		runID = runs[len(runs)-1].ID
+	}
+
+	records, err := loadRunRecords(db, runID)
+	if err != nil {
+		return fmt.Errorf("could not read database: %w", err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no files recorded for run '%s' (see 'backupbozo runs')", runID)
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("⏪ Rolling Back Run %s\n", runID)
+
+	dest := localDestination()
+	stored := make(map[string]bool, len(records))
+	for _, record := range records {
+		stored[filepath.Clean(record.DestPath)] = true
+	}
+	links := symlinksTo(destDir, stored)
+	var removed []string
+	var kept, unlinked int
+	for _, record := range records {
+		if reason := dest.rollbackBlocker(record); reason != "" {
+			color.New(color.FgYellow).Printf("   kept %s: %s\n", record.DestPath, reason)
+			kept++
+			continue
+		}
+		if dryRun {
+			fmt.Printf("   would remove %s\n", record.DestPath)
+			for _, link := range links[filepath.Clean(record.DestPath)] {
+				fmt.Printf("   would remove %s (a symlink to it)\n", link)
+				unlinked++
+			}
+			removed = append(removed, record.Hash)
+			continue
+		}
+		if err := os.Remove(record.DestPath); err != nil && !os.IsNotExist(err) {
+			color.New(color.FgYellow).Printf("   kept %s: %v\n", record.DestPath, err)
+			kept++
+			continue
+		}
+		os.Remove(filepath.Dir(record.DestPath)) // Drop the date folder if this emptied it
+		removed = append(removed, record.Hash)
+		for _, link := range links[filepath.Clean(record.DestPath)] {
+			if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+				color.New(color.FgYellow).Printf("   could not remove %s, a symlink to it: %v\n", link, err)
+				continue
+			}
+			fmt.Printf("   removed %s (a symlink to %s)\n", link, record.DestPath)
+			os.Remove(filepath.Dir(link))
+			unlinked++
+		}
+	}
+
+	if dryRun {
+		color.New(color.FgYellow).Printf("   Dry run: %d files and %d symlinks to them would be removed, %d kept\n", len(removed), unlinked, kept)
+		return nil
+	}
+
+	if err := deleteFileRecords(db, removed); err != nil {
+		return fmt.Errorf("could not remove database records: %w", err)
+	}
+	// The next incremental backup of this run's source must look at its files again
+	if err := deleteSourceRuns(db, runID); err != nil {
+		return fmt.Errorf("could not remove database records: %w", err)
+	}
+	if _, err := dest.writeManifest(db, destDir); err != nil {
+		color.New(color.FgYellow).Printf("   Could not update %s: %v\n", manifestName, err)
+	}
+	color.New(color.FgGreen, color.Bold).Printf("   ✅ Removed %d files and %d symlinks to them, kept %d\n", len(removed), unlinked, kept)
+	return nil
+}
+
+// symlinksTo finds the symlinks under destDir that point at one of targets, keyed by the target
+// Duplicates placed by --dedupe-mode symlink aren't recorded, so the destination is walked
+func symlinksTo(destDir string, targets map[string]bool) map[string][]string {
+	links := make(map[string][]string)
+	filepath.WalkDir(destDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Type()&fs.ModeSymlink == 0 {
+			return nil // Unreadable folders are skipped; their links stay
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if target = filepath.Clean(target); targets[target] {
+			links[target] = append(links[target], path)
+		}
+		return nil
+	})
+	return links
+}
+
+// rollbackBlocker explains why a copied file must not be deleted, or returns "" if it can be
+func (d *destination) rollbackBlocker(record FileRecord) string {
+	if _, err := d.statDest(record.DestPath); os.IsNotExist(err) {
+		return "" // Already gone; only the record needs removing
+	}
+	if archive, _, ok := splitArchiveMember(record.DestPath); ok {
+		return fmt.Sprintf("stored in %s; remove it from the archive by hand", archive)
+	}
+	if record.SrcPath == "" {
+		return "recorded by index, not copied by a backup"
+	}
+	// The source must still hold this content; a card reusing the name holds a different photo
+	srcHash, err := HashFile(record.SrcPath, record.HashAlgo)
+	switch {
+	case os.IsNotExist(err):
+		return "source no longer exists, this is the only copy"
+	case err != nil:
+		return fmt.Sprintf("could not read source: %v", err)
+	case srcHash != record.Hash:
+		return "source changed since it was backed up, this is the only copy"
+	}
+	if record.OrigExt != "" {
+		return "" // Converted copy; its original is still in the source
+	}
+	hash, err := HashFile(record.DestPath, record.HashAlgo)
+	if err != nil {
+		return fmt.Sprintf("could not read file: %v", err)
+	}
+	if hash != record.Hash {
+		return "file changed since it was backed up"
+	}
+	return ""
+}
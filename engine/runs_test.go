@@ -0,0 +1,122 @@
+// backupbozo tests for rolling back runs
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// backUp runs a backup of srcDir into destDir, with its database in destDir
+func backUp(t *testing.T, srcDir, destDir, dedupeMode string) {
+	t.Helper()
+	_, err := Backup(context.Background(), BackupOptions{
+		SrcDir:     srcDir,
+		DestDir:    destDir,
+		DBPath:     filepath.Join(destDir, "backupbozo.db"),
+		ReportPath: filepath.Join(t.TempDir(), "report.html"),
+		Workers:    1,
+		DedupeMode: dedupeMode,
+	})
+	if err != nil {
+		t.Fatalf("backup %s: %v", srcDir, err)
+	}
+}
+
+// storedRecords returns the database records of every run into destDir
+func storedRecords(t *testing.T, destDir string) []FileRecord {
+	t.Helper()
+	db, err := OpenDatabase(filepath.Join(destDir, "backupbozo.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	runs, err := loadRuns(db)
+	if err != nil {
+		t.Fatalf("load runs: %v", err)
+	}
+	var records []FileRecord
+	for _, run := range runs {
+		runRecords, err := loadRunRecords(db, run.ID)
+		if err != nil {
+			t.Fatalf("load run %s: %v", run.ID, err)
+		}
+		records = append(records, runRecords...)
+	}
+	return records
+}
+
+// writeSource writes a source file for a backup
+func writeSource(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+// TestRollbackRun checks a rollback deletes what the run stored, unless its source changed since
+func TestRollbackRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    bool // Whether the source is changed after the backup
+		removed bool
+	}{
+		{"source unchanged", false, true},
+		{"source changed", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir, destDir := t.TempDir(), t.TempDir()
+			src := writeSource(t, srcDir, "IMG_0001.jpg", "photo content")
+			backUp(t, srcDir, destDir, "")
+			records := storedRecords(t, destDir)
+			if len(records) != 1 {
+				t.Fatalf("expected 1 stored file, got %d", len(records))
+			}
+			if tt.edit {
+				os.WriteFile(src, []byte("edited photo"), 0644)
+			}
+
+			if err := RollbackRun(destDir, filepath.Join(destDir, "backupbozo.db"), "", false, false); err != nil {
+				t.Fatalf("rollback: %v", err)
+			}
+			if got := !exists(records[0].DestPath); got != tt.removed {
+				t.Errorf("stored copy removed: expected %t, got %t", tt.removed, got)
+			}
+			if got := len(storedRecords(t, destDir)) == 0; got != tt.removed {
+				t.Errorf("record forgotten: expected %t, got %t", tt.removed, got)
+			}
+		})
+	}
+}
+
+// TestRollbackRunRemovesSymlinks checks symlinks a later run made to a rolled back file are
+// deleted with it instead of being left dangling
+func TestRollbackRunRemovesSymlinks(t *testing.T) {
+	first, second, destDir := t.TempDir(), t.TempDir(), t.TempDir()
+	writeSource(t, first, "IMG_0001.jpg", "photo content")
+	backUp(t, first, destDir, "")
+	records := storedRecords(t, destDir)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 stored file, got %d", len(records))
+	}
+	writeSource(t, second, "IMG_0002.jpg", "photo content")
+	backUp(t, second, destDir, dedupeSymlink)
+	links := symlinksTo(destDir, map[string]bool{filepath.Clean(records[0].DestPath): true})[filepath.Clean(records[0].DestPath)]
+	if len(links) != 1 {
+		t.Fatalf("expected the second run to link to %s, got %v", records[0].DestPath, links)
+	}
+
+	if err := RollbackRun(destDir, filepath.Join(destDir, "backupbozo.db"), records[0].RunID, false, false); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if exists(records[0].DestPath) {
+		t.Error("stored copy not removed")
+	}
+	if exists(links[0]) {
+		t.Errorf("symlink %s left dangling", links[0])
+	}
+}
@@ -0,0 +1,86 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// SampleEntry links the sampled checksum of a copied file to its full content hash
+type SampleEntry struct {
+	Sample string
+	Hash   string
+}
+
+// usesSampledChecksum reports whether a source file of this size is checked by --checksum-sample,
+// which hashes sample bytes from each end of a large file instead of reading all of it (0 = off)
+// Files up to twice the sample are read whole anyway, so they keep their full hash
+func usesSampledChecksum(size, sample int64) bool {
+	return sample > 0 && size > 2*sample
+}
+
+// sampledChecksum fingerprints a large file from its first and last sample bytes and its size
+// The algorithm and sample size are part of the result, so fingerprints taken with other
+// settings never match, and it is stored apart from full hashes (files.sample_hash)
+func sampledChecksum(path, algo string, size, sample int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, sample)); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(f, size-sample, sample)); err != nil {
+		return "", err
+	}
+	var sizeBytes [8]byte
+	binary.BigEndian.PutUint64(sizeBytes[:], uint64(size))
+	h.Write(sizeBytes[:])
+	return fmt.Sprintf("%s/%d:%x", algo, sample, h.Sum(nil)), nil
+}
+
+// loadSampleIndex maps the sampled checksum of every file copied with --checksum-sample to its destination
+func loadSampleIndex(db *sql.DB, console *log.Logger) map[string]string {
+	index := make(map[string]string)
+	rows, err := db.Query("SELECT sample_hash, dest_path FROM files WHERE sample_hash IS NOT NULL AND sample_hash != ''")
+	if err != nil {
+		console.Printf("Warning: Could not load sampled checksums: %v", err)
+		return index
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	for rows.Next() {
+		var sample, dest string
+		if err := rows.Scan(&sample, &dest); err != nil {
+			console.Printf("Warning: Error scanning sampled checksum: %v", err)
+			continue
+		}
+		index[sample] = resolveDestPath(root, dest)
+	}
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating sampled checksums: %v", err)
+	}
+	return index
+}
+
+// loadUnsampledSizes indexes the sizes of large files stored without a sampled checksum taken with
+// the current settings: those copied before --checksum-sample was on, or with another sample size.
+// They can only be matched by their full hash
+func loadUnsampledSizes(db *sql.DB, hashAlgo string, sample int64, console *log.Logger) *sizeIndex {
+	index := &sizeIndex{sizes: make(map[int64]bool)}
+	index.mergeQuery(db, console, `SELECT DISTINCT COALESCE(size, 0) FROM files
+		WHERE hash IS NOT NULL AND COALESCE(hash_algo, 'md5') = ? AND (size IS NULL OR size > ?)
+		AND COALESCE(sample_hash, '') NOT LIKE ?`,
+		hashAlgo, 2*sample, fmt.Sprintf("%s/%d:%%", hashAlgo, sample))
+	return index
+}
@@ -0,0 +1,134 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// schemaVersion is the database layout this build reads and writes
+// Bump it with a new entry in migrations whenever the schema changes
+const schemaVersion = 2
+
+// migrations upgrade a database one version at a time: migrations[i] takes it from version i to
+// i+1. Each step must be safe to run again, since a step cut off before its version was recorded
+// (a crash, a full disk) runs again on the next start
+var migrations = []func(db *sql.DB) error{
+	migrateColumns, // 0 -> 1: columns added to files before the schema was versioned
+	migrateScanKey, // 1 -> 2: source_runs records how each run walked its source
+}
+
+// schemaTooNew is the error for a database written by a newer build, which this one could corrupt
+func schemaTooNew(version int) error {
+	return fmt.Errorf("database schema version %d is newer than this build supports (%d); upgrade backupbozo to use it", version, schemaVersion)
+}
+
+// readSchemaVersion returns a database's schema version; databases from before versioning are 0
+func readSchemaVersion(db *sql.DB) (int, error) {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// checkSchemaVersion refuses a database written by a newer build, before anything is written to it
+func checkSchemaVersion(db *sql.DB) error {
+	version, err := readSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+	if version > schemaVersion {
+		return schemaTooNew(version)
+	}
+	return nil
+}
+
+// migrateSchema brings a database up to schemaVersion, recording each step as it completes
+func migrateSchema(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL, migrated_at TEXT)"); err != nil {
+		return err
+	}
+	version, err := readSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return schemaTooNew(version)
+	}
+	for ; version < schemaVersion; version++ {
+		if err := migrations[version](db); err != nil {
+			return fmt.Errorf("migration to version %d failed: %w", version+1, err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_version (version, migrated_at) VALUES (?, datetime('now'))", version+1); err != nil {
+			return fmt.Errorf("could not record schema version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// migrateColumns adds the files columns introduced before versioning; databases of that time
+// have any subset of them, depending on the release that last opened them
+func migrateColumns(db *sql.DB) error {
+	columns := []struct{ name, columnType string }{
+		{"hash_algo", "TEXT"},    // Databases created before it only contain MD5 hashes (NULL algorithm)
+		{"run_id", "TEXT"},       // Records from before run tracking have no run ID and can't be rolled back
+		{"dedup_method", "TEXT"}, // Records from before --hash-only-videos were all checked by hash (NULL method)
+		{"phash", "TEXT"},        // Perceptual hashes are only stored for images copied with --near-duplicates
+		{"taken_at", "INTEGER"},  // Older records and indexed files have no filing date; restore falls back to their mtime
+		{"orig_ext", "TEXT"},     // Only set for files stored converted (--convert-heic-to-jpeg)
+		{"camera", "TEXT"},       // Older records and files without make/model metadata have no camera
+		{"latitude", "REAL"},     // GPS coordinates from EXIF, in decimal degrees; NULL for files without them
+		{"longitude", "REAL"},
+		{"sample_hash", "TEXT"}, // Only set for large files copied with --checksum-sample; never compared with full hashes
+		{"burst_id", "TEXT"},    // Frames of one iPhone burst share an identifier, so they can be found together
+	}
+	for _, column := range columns {
+		if err := ensureColumn(db, "files", column.name, column.columnType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateScanKey adds source_runs.scan_key; marks recorded before it are left without one and
+// no longer used, as the run that set them may have left files out (see sourceMark)
+func migrateScanKey(db *sql.DB) error {
+	return ensureColumn(db, "source_runs", "scan_key", "TEXT")
+}
+
+// ShowSchemaVersion prints a database's schema version next to this build's, without upgrading it
+func ShowSchemaVersion(dbPath string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database '%s' not found: %w", dbPath, err)
+	}
+	db, err := OpenDatabaseReadOnly(dbPath)
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	defer db.Close()
+	version, err := readSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+
+	fmt.Printf("Database schema version: %d\n", version)
+	fmt.Printf("This build's version:    %d\n", schemaVersion)
+	switch {
+	case version > schemaVersion:
+		fmt.Println("The database is newer than this build; upgrade backupbozo to use it.")
+	case version < schemaVersion:
+		fmt.Println("The database will be upgraded the next time it is opened for writing.")
+	default:
+		fmt.Println("Up to date.")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// scratchPath is the temp file a copy to dst is written to: next to it, or a new file in
+// --tmp-dir that can't collide with another worker's
+func (r *backupRun) scratchPath(dst string) (string, error) {
+	if r.opts.ScratchDir == "" {
+		return dst + ".tmp", nil
+	}
+	f, err := os.CreateTemp(r.opts.ScratchDir, "backupbozo-*-"+filepath.Base(dst))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file in %s: %w", r.opts.ScratchDir, err)
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// moveIntoDest renames a finished temp file to dst. A temp file in --tmp-dir on another device
+// can't be renamed there; it is copied to a temp file next to dst instead, synced and renamed,
+// so dst still only ever appears complete
+func (r *backupRun) moveIntoDest(ctx context.Context, tmp, dst, src string, atime, mtime time.Time) error {
+	err := r.fs.Rename(tmp, dst)
+	if err == nil || r.opts.ScratchDir == "" {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	local := dst + ".tmp"
+	out, err := os.Create(local)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file %s: %w", local, err)
+	}
+	_, err = io.Copy(out, contextReader{ctx, in})
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && r.verify {
+		err = sameContent(tmp, local)
+	}
+	// The copy is a new file, so permissions, owner and dates are set on it again
+	if err == nil {
+		err = r.applyFileOwnership(src, local)
+	}
+	if err != nil {
+		os.Remove(local)
+		return fmt.Errorf("failed to copy temp file into the destination: %w", err)
+	}
+	if err := os.Chtimes(local, atime, mtime); err != nil {
+		r.console.Printf("Warning: Could not set timestamps on %s: %v", local, err)
+		r.log.Warn("could not set timestamps on %s: %v", local, err)
+	}
+	if err := os.Rename(local, dst); err != nil {
+		os.Remove(local)
+		return err
+	}
+	return nil
+}
+
+// sameContent checks that a copy made with --verify-copy reads back like the file it came from
+func sameContent(original, copy string) error {
+	want, err := HashFile(original, hashSHA256)
+	if err != nil {
+		return err
+	}
+	got, err := HashFile(copy, hashSHA256)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("copy verification failed: %s does not match %s", copy, original)
+	}
+	return nil
+}
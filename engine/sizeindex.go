@@ -0,0 +1,61 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"log"
+)
+
+// sizeIndex holds the size of every stored file. A source file whose size matches none of them
+// can't be a duplicate, so it is not hashed before the copy: the copy hashes it in the same read
+// (see copyFileWithHash), instead of the file being read once to hash it and again to copy it
+type sizeIndex struct {
+	sizes map[int64]bool
+	// Some stored files have no recorded size, so any size might match
+	incomplete bool
+}
+
+// loadSizeIndex reads the sizes of the files stored in db with hashAlgo
+func loadSizeIndex(db *sql.DB, hashAlgo string, console *log.Logger) *sizeIndex {
+	index := &sizeIndex{sizes: make(map[int64]bool)}
+	index.merge(db, hashAlgo, console)
+	return index
+}
+
+// merge adds the sizes of the files stored in db with hashAlgo (another database, see --known-db)
+func (s *sizeIndex) merge(db *sql.DB, hashAlgo string, console *log.Logger) {
+	s.mergeQuery(db, console, "SELECT DISTINCT COALESCE(size, 0) FROM files WHERE hash IS NOT NULL AND COALESCE(hash_algo, 'md5') = ?", hashAlgo)
+}
+
+// mergeQuery adds the sizes a query returns; a size of 0 stands for an unknown one
+func (s *sizeIndex) mergeQuery(db *sql.DB, console *log.Logger, query string, args ...any) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		console.Printf("Warning: Could not load stored file sizes: %v", err)
+		s.incomplete = true
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var size int64
+		if err := rows.Scan(&size); err != nil {
+			s.incomplete = true
+			continue
+		}
+		if size <= 0 {
+			s.incomplete = true
+		}
+		s.sizes[size] = true
+	}
+	if err := rows.Err(); err != nil {
+		console.Printf("Warning: Error iterating stored file sizes: %v", err)
+		s.incomplete = true
+	}
+}
+
+// mightMatch reports whether a stored file could have the same content as a file of this size
+// The caller holds the BatchInserter mutex
+func (s *sizeIndex) mightMatch(size int64) bool {
+	return s.incomplete || s.sizes[size]
+}
@@ -0,0 +1,192 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+
+	"backupbozo/metadata"
+)
+
+// Modes for --check-content-type
+const (
+	contentCheckReport = "report" // List files whose content doesn't match their extension
+	contentCheckFix    = "fix"    // Also store them under the extension their content calls for
+)
+
+// ValidateContentCheck checks a --check-content-type value
+func ValidateContentCheck(mode string) error {
+	switch mode {
+	case contentCheckReport, contentCheckFix:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q (use report or fix)", mode)
+	}
+}
+
+// contentFamilies maps extensions to the kind of content they name; extensions in one family
+// are interchangeable (a JPEG named .jpeg, a QuickTime movie named .mp4, a CR2 that is a TIFF)
+var contentFamilies = map[string]string{
+	".jpg": "jpeg", ".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".webp": "webp",
+	".tif":  "tiff", ".tiff": "tiff", ".cr2": "tiff", ".nef": "tiff", ".arw": "tiff", ".dng": "tiff",
+	".orf":  "orf",
+	".raf":  "raf",
+	".heic": "heif", ".heif": "heif",
+	".avif": "avif",
+	".cr3":  "cr3",
+	".mp4":  "mp4", ".mov": "mp4", ".m4v": "mp4", ".3gp": "mp4",
+	".mkv": "matroska", ".webm": "matroska",
+	".avi": "avi",
+}
+
+// heifBrands are the ISO media file brands of HEIC and HEIF images
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true, "heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// sniffHeader names the extension for the content that starts with header, "" if unrecognized
+func sniffHeader(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return ".png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return ".gif"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return ".tif"
+	case bytes.HasPrefix(header, []byte("IIRO")), bytes.HasPrefix(header, []byte("IIRS")), bytes.HasPrefix(header, []byte("MMOR")):
+		return ".orf"
+	case bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")):
+		return ".raf"
+	case bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return ".mkv"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")):
+		switch string(header[8:12]) {
+		case "WEBP":
+			return ".webp"
+		case "AVI ":
+			return ".avi"
+		}
+	case len(header) >= 12 && string(header[4:8]) == "ftyp":
+		switch brand := string(header[8:12]); {
+		case heifBrands[brand]:
+			return ".heic"
+		case brand == "avif" || brand == "avis":
+			return ".avif"
+		case brand == "crx ":
+			return ".cr3"
+		case brand == "qt  ":
+			return ".mov"
+		default:
+			return ".mp4"
+		}
+	case bytes.HasPrefix(header, []byte("BM")):
+		return ".bmp"
+	}
+	return ""
+}
+
+// mislabeledExt returns the extension a file's content calls for when its own extension names
+// a different kind of file, or "" when they agree or either is unknown (nothing to go by)
+func mislabeledExt(path string) string {
+	family, known := contentFamilies[metadata.NormalizeExt(path)]
+	if !known {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "" // Unreadable files are reported when they are processed
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	content := sniffHeader(header[:n])
+	if content == "" || contentFamilies[content] == family {
+		return ""
+	}
+	return content
+}
+
+// contentExt is a source file's extension, or the one its content calls for when it is mislabeled
+func (r *backupRun) contentExt(path string) string {
+	if ext, found := r.mislabeledTypes[path]; found {
+		return ext
+	}
+	return metadata.NormalizeExt(path)
+}
+
+// MislabeledFile is a source file whose content doesn't match its extension (--check-content-type)
+type MislabeledFile struct {
+	Path       string
+	DestPath   string
+	State      FileState
+	ContentExt string // Extension the content calls for
+}
+
+// mislabeledNote describes a copied file's mismatch for the reports, "" if it has none
+// fixed says whether it was stored under the extension of its content
+func mislabeledNote(contentExt string, fixed bool) string {
+	if contentExt == "" {
+		return ""
+	}
+	if fixed {
+		return fmt.Sprintf("content is %s, stored with that extension", strings.TrimPrefix(contentExt, "."))
+	}
+	return fmt.Sprintf("content is %s, not what its extension says", strings.TrimPrefix(contentExt, "."))
+}
+
+// writeMislabeled lists files whose content doesn't match their extension
+func writeMislabeled(f *os.File, summary AccountingSummary, srcRoot, destRoot string) {
+	if len(summary.Mislabeled) == 0 {
+		return
+	}
+
+	action := "They were stored under their own names; run with <code>--check-content-type fix</code> to store copies under the extension of their content."
+	if summary.MislabeledFixed {
+		action = "Copied files were stored under the extension of their content."
+	}
+	fmt.Fprintf(f, `
+        <h2 class="section-title">Mislabeled Files (%d)</h2>
+        <p>The content of these files doesn't match their extension (checked from the first bytes of each file). %s</p>
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th>Source</th>
+                        <th>Content</th>
+                        <th>Status</th>
+                        <th>Destination</th>
+                    </tr>
+                </thead>
+                <tbody>`, len(summary.Mislabeled), action)
+
+	for _, file := range summary.Mislabeled {
+		dest := ""
+		if file.DestPath != "" {
+			dest = html.EscapeString(makeRelativePath(file.DestPath, destRoot))
+		}
+		fmt.Fprintf(f, `
+                    <tr>
+                        <td class="file-path" title="%s">%s</td>
+                        <td>%s</td>
+                        <td>%s</td>
+                        <td class="file-path">%s</td>
+                    </tr>`,
+			html.EscapeString(file.Path), html.EscapeString(makeRelativePath(file.Path, srcRoot)),
+			strings.ToUpper(strings.TrimPrefix(file.ContentExt, ".")), html.EscapeString(file.State.String()), dest)
+	}
+
+	f.WriteString(`
+                </tbody>
+            </table>
+        </div>`)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package engine
+
+import "os"
+
+// syncDir flushes a folder's entries to disk, so files renamed or created in it survive a power loss
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
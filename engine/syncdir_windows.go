@@ -0,0 +1,9 @@
+//go:build windows
+
+package engine
+
+// syncDir does nothing on Windows, where folders can't be opened for syncing and NTFS journals
+// renames and new files itself
+func syncDir(path string) error {
+	return nil
+}
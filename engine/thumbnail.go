@@ -0,0 +1,109 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+
+	"backupbozo/metadata"
+)
+
+// thumbnailSize is the longest side of a report thumbnail, in pixels
+const thumbnailSize = 96
+
+// thumbnailMaxPixels skips images too large to decode quickly (about a 100 megapixel panorama)
+const thumbnailMaxPixels = 100_000_000
+
+// thumbnailDataURI returns a copied image's preview as a base64 JPEG data URI, or "" if it has none
+// The source is read when it still exists; after --move the stored copy is used, unless it is
+// remote or inside an archive
+func (r *backupRun) thumbnailDataURI(src, dest string) string {
+	if !perceptualExtensions[metadata.NormalizeExt(src)] {
+		return ""
+	}
+	path := src
+	if _, err := os.Stat(src); err != nil {
+		if !r.isLocal() {
+			return ""
+		}
+		if _, _, archived := splitArchiveMember(dest); archived {
+			return ""
+		}
+		path = dest
+	}
+	thumb, err := makeThumbnail(path)
+	if err != nil {
+		if r.opts.Verbosity == VerbosityVerbose {
+			fmt.Printf("no thumbnail for %s: %v\n", src, err)
+		}
+		return ""
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumb)
+}
+
+// makeThumbnail decodes an image and encodes it again as a JPEG no larger than thumbnailSize
+func makeThumbnail(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return nil, fmt.Errorf("image too large (%dx%d)", config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, thumbnailSize), &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown shrinks img to fit in a size x size box, averaging a grid of samples per pixel
+// Sampling rather than reading every pixel keeps large photos fast at thumbnail quality
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	thumbWidth, thumbHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			thumbWidth, thumbHeight = size, max(height*size/width, 1)
+		} else {
+			thumbWidth, thumbHeight = max(width*size/height, 1), size
+		}
+	}
+
+	const samples = 4 // per axis, per thumbnail pixel
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for ty := 0; ty < thumbHeight; ty++ {
+		for tx := 0; tx < thumbWidth; tx++ {
+			var r, g, b, n uint32
+			for sy := 0; sy < samples; sy++ {
+				y := bounds.Min.Y + (ty*samples+sy)*height/(thumbHeight*samples)
+				for sx := 0; sx < samples; sx++ {
+					x := bounds.Min.X + (tx*samples+sx)*width/(thumbWidth*samples)
+					pr, pg, pb, _ := img.At(x, y).RGBA()
+					r, g, b, n = r+pr>>8, g+pg>>8, b+pb>>8, n+1
+				}
+			}
+			thumb.Set(tx, ty, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255})
+		}
+	}
+	return thumb
+}
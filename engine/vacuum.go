@@ -0,0 +1,57 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// VacuumDatabase rebuilds the SQLite file at dbPath without the free pages left behind by prune,
+// rollback, and cleared journals, and prints its size before and after. It takes the run lock
+func VacuumDatabase(dbPath string, force bool) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database '%s' not found: %w", dbPath, err)
+	}
+	lock, err := acquireRunLock(dbPath, force, nil, VerbosityNormal)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return vacuumDatabase(db, dbPath)
+}
+
+// vacuumDatabase is VacuumDatabase for a database the caller has locked and opened
+func vacuumDatabase(db *sql.DB, dbPath string) error {
+	before, err := os.Stat(dbPath)
+	if err != nil {
+		return fmt.Errorf("could not read database: %w", err)
+	}
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("🗜️  Compacting Database\n")
+	// VACUUM writes a complete temporary copy, so it needs up to the database's size in free space
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("could not vacuum database: %w", err)
+	}
+
+	after, err := os.Stat(dbPath)
+	if err != nil {
+		return fmt.Errorf("could not read database: %w", err)
+	}
+	saved := before.Size() - after.Size()
+	if saved <= 0 {
+		color.New(color.FgGreen, color.Bold).Printf("   ✅ %s, nothing to reclaim\n", FormatFileSize(after.Size()))
+		return nil
+	}
+	color.New(color.FgGreen, color.Bold).Printf("   ✅ %s → %s (%s reclaimed)\n",
+		FormatFileSize(before.Size()), FormatFileSize(after.Size()), FormatFileSize(saved))
+	return nil
+}
@@ -0,0 +1,347 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"backupbozo/metadata"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// VerifyStatus is the outcome of checking one file during verification
+type VerifyStatus string
+
+const (
+	VerifyOK       VerifyStatus = "ok"       // File exists and its hash matches the database
+	VerifyMismatch VerifyStatus = "mismatch" // File exists but its content changed (bit rot, edits)
+	VerifyMissing  VerifyStatus = "missing"  // File recorded in the database is gone
+	VerifyExtra    VerifyStatus = "extra"    // Media file in the destination that the database doesn't know
+)
+
+// VerifyResult describes a single verified file
+type VerifyResult struct {
+	Path     string
+	Status   VerifyStatus
+	Details  string
+	Size     int64
+	Camera   string             // From the database record, for the report
+	Location *metadata.Location // From the database record, for the report
+}
+
+// VerifySummary collects verification results and per-status counts
+type VerifySummary struct {
+	Results  []VerifyResult
+	OK       int
+	Mismatch int
+	Missing  int
+	Extra    int
+}
+
+// add records a result and updates the matching counter
+func (s *VerifySummary) add(result VerifyResult) {
+	s.Results = append(s.Results, result)
+	switch result.Status {
+	case VerifyOK:
+		s.OK++
+	case VerifyMismatch:
+		s.Mismatch++
+	case VerifyMissing:
+		s.Missing++
+	case VerifyExtra:
+		s.Extra++
+	}
+}
+
+// VerifyBackup re-hashes every file recorded in the database and looks for untracked files
+// Returns true when the archive is intact (no mismatched or missing files)
+func VerifyBackup(ctx context.Context, destDir, dbPath, reportPath string) (bool, error) {
+	destDir = absDestDir(destDir, true)
+	db, err := openExistingDB(destDir, dbPath)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	records, err := loadRecordedFiles(db)
+	if err != nil {
+		return false, fmt.Errorf("could not read database: %w", err)
+	}
+
+	startTime := time.Now()
+	var summary VerifySummary
+
+	fmt.Println()
+	color.New(color.FgCyan, color.Bold).Printf("🔎 Verifying Backup\n")
+	fmt.Printf("   Checking %d files recorded in the database...\n", len(records))
+
+	bar := progressbar.NewOptions(
+		len(records),
+		progressbar.OptionSetVisibility(StdoutIsTerminal()),
+		progressbar.OptionSetDescription("Verifying"),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetWidth(50),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionEnableColorCodes(true),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "[cyan]=[reset]",
+			SaucerHead:    "[cyan]>[reset]",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+	)
+
+	dest := localDestination()
+	known := make(map[string]bool, len(records))
+	for _, record := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		known[filepath.Clean(record.DestPath)] = true
+		result := dest.verifyRecordedFile(record)
+		result.Camera, result.Location = record.Camera, record.Location
+		summary.add(result)
+		bar.Add(1)
+	}
+	bar.Finish()
+	fmt.Println()
+
+	// Look for media files in the destination that the database doesn't track
+	if ctx.Err() == nil {
+		reportsDir := filepath.Join(destDir, "reports")
+		files, _, walkErrors := getAllFiles(destDir, nil, false, 0, false, VerbosityNormal)
+		for _, walkErr := range walkErrors {
+			log.Printf("Warning: %v", walkErr)
+		}
+		var linked map[fileID]bool
+		for _, file := range files {
+			path := filepath.Clean(file.Path)
+			if known[path] || strings.HasPrefix(path, reportsDir+string(filepath.Separator)) {
+				continue
+			}
+			if !defaultExtensions[metadata.NormalizeExt(path)] {
+				continue
+			}
+			if linked == nil {
+				linked = recordedFileIDs(records)
+			}
+			if linksRecordedFile(path, linked) {
+				continue // A duplicate linked in by --dedupe-mode hardlink or symlink
+			}
+			summary.add(VerifyResult{
+				Path:    path,
+				Status:  VerifyExtra,
+				Details: "Not recorded in the database",
+				Size:    file.Info.Size(),
+			})
+		}
+	}
+
+	writeVerifyReport(reportPath, summary, time.Since(startTime), destDir)
+
+	fmt.Println()
+	color.New(color.FgMagenta, color.Bold).Printf("📊 Verification Results\n")
+	color.New(color.FgGreen).Printf("   ✅ OK: %d files\n", summary.OK)
+	color.New(color.FgRed).Printf("   ❌ Hash mismatches: %d files\n", summary.Mismatch)
+	color.New(color.FgRed).Printf("   🚫 Missing: %d files\n", summary.Missing)
+	color.New(color.FgYellow).Printf("   ❔ Not in database: %d files\n", summary.Extra)
+	if ctx.Err() != nil {
+		color.New(color.FgYellow, color.Bold).Printf("   Verification interrupted, results are partial\n")
+	}
+	color.New(color.FgCyan).Printf("   📄 Verify report: %s\n", reportPath)
+
+	return summary.Mismatch == 0 && summary.Missing == 0, nil
+}
+
+// recordedFileIDs returns the identity of every recorded file present in the destination
+func recordedFileIDs(records []FileRecord) map[fileID]bool {
+	ids := make(map[fileID]bool, len(records))
+	for _, record := range records {
+		if info, err := os.Stat(record.DestPath); err == nil {
+			if id, ok := pathID(record.DestPath, info); ok {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// linksRecordedFile reports whether path is a hard link to, or a symlink resolving to, a recorded file
+func linksRecordedFile(path string, recorded map[fileID]bool) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	id, ok := pathID(path, info)
+	return ok && recorded[id]
+}
+
+// verifyRecordedFile re-hashes one destination file and compares it to the database record
+func (d *destination) verifyRecordedFile(record FileRecord) VerifyResult {
+	info, err := d.statDest(record.DestPath)
+	if err != nil {
+		return VerifyResult{
+			Path:    record.DestPath,
+			Status:  VerifyMissing,
+			Details: fmt.Sprintf("File not found (copied from %s)", record.SrcPath),
+			Size:    record.Size,
+		}
+	}
+
+	// A converted file's hash is that of its original, which the backup doesn't hold
+	if record.OrigExt != "" {
+		return VerifyResult{
+			Path:    record.DestPath,
+			Status:  VerifyOK,
+			Details: fmt.Sprintf("Present (converted from %s, content not re-hashed)", record.OrigExt),
+			Size:    info.Size(),
+		}
+	}
+
+	hash, err := d.hashDestFile(record.DestPath, record.HashAlgo)
+	if err != nil {
+		return VerifyResult{
+			Path:    record.DestPath,
+			Status:  VerifyMismatch,
+			Details: fmt.Sprintf("Could not read file: %v", err),
+			Size:    info.Size(),
+		}
+	}
+	if hash != record.Hash {
+		return VerifyResult{
+			Path:    record.DestPath,
+			Status:  VerifyMismatch,
+			Details: fmt.Sprintf("Hash mismatch: expected %s, got %s", record.Hash, hash),
+			Size:    info.Size(),
+		}
+	}
+
+	return VerifyResult{
+		Path:    record.DestPath,
+		Status:  VerifyOK,
+		Details: "Hash matches database",
+		Size:    info.Size(),
+	}
+}
+
+// loadRecordedFiles reads every file record from the database
+func loadRecordedFiles(db *sql.DB) ([]FileRecord, error) {
+	return loadRecordedFilesUnder(db, destRootOf(db))
+}
+
+// loadRecordedFilesUnder reads every file record, resolving stored destination paths against root
+func loadRecordedFilesUnder(db *sql.DB, root string) ([]FileRecord, error) {
+	rows, err := db.Query("SELECT src_path, dest_path, hash, COALESCE(hash_algo, 'md5'), size, mtime, copied_at, COALESCE(orig_ext, ''), COALESCE(camera, ''), latitude, longitude FROM files WHERE hash IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []FileRecord
+	for rows.Next() {
+		var record FileRecord
+		var copiedAt sql.NullString
+		var latitude, longitude sql.NullFloat64
+		if err := rows.Scan(&record.SrcPath, &record.DestPath, &record.Hash, &record.HashAlgo, &record.Size, &record.Mtime, &copiedAt, &record.OrigExt, &record.Camera, &latitude, &longitude); err != nil {
+			log.Printf("Warning: Error scanning file record: %v", err)
+			continue
+		}
+		record.CopiedAt = copiedAt.String
+		record.Location = scannedLocation(latitude, longitude)
+		record.DestPath = resolveDestPath(root, record.DestPath)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// writeVerifyReport writes an HTML report of verification results using the backup report styling
+func writeVerifyReport(path string, summary VerifySummary, totalTime time.Duration, destRoot string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Could not create verify report: %v", err)
+		return
+	}
+	defer f.Close()
+
+	f.WriteString(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>backupbozo verify report</title>
+`)
+	f.WriteString(reportCSS)
+	f.WriteString(`
+</head>
+<body>
+    <div class="container">
+        <div class="mascot-header">
+            <h1>Verify Report</h1>
+            <p class="backup-timestamp">` + time.Now().Format("Monday, January 2, 2006 at 3:04 PM") + `</p>`)
+	fmt.Fprintf(f, `
+            <p class="mascot-quote">%s</p>`, html.EscapeString(destRoot))
+
+	f.WriteString(`
+        <div class="summary-badges">
+            <div class="badge-row">`)
+	writeBadge(f, "total", "Total Files", fmt.Sprintf("%d", len(summary.Results)))
+	writeBadge(f, "time", "Time Taken", formatDuration(totalTime))
+	writeBadge(f, "copied", "OK", fmt.Sprintf("%d", summary.OK))
+	writeBadge(f, "error", "Mismatched", fmt.Sprintf("%d", summary.Mismatch))
+	writeBadge(f, "error", "Missing", fmt.Sprintf("%d", summary.Missing))
+	writeBadge(f, "skipped", "Not in DB", fmt.Sprintf("%d", summary.Extra))
+	f.WriteString(`
+            </div>
+        </div>
+        </div>`)
+
+	f.WriteString(`
+        <div class="controls">
+            <input type="text" class="search-input" placeholder="Search files..." id="searchInput">
+            <div class="filter-buttons">
+                <button class="filter-btn active" data-filter="all">All</button>
+                <button class="filter-btn" data-filter="mismatch">Mismatched</button>
+                <button class="filter-btn" data-filter="missing">Missing</button>
+                <button class="filter-btn" data-filter="extra">Not in DB</button>
+                <button class="filter-btn" data-filter="ok">OK</button>
+            </div>
+        </div>
+
+        <div class="table-container">
+            <table>
+                <thead class="table-header">
+                    <tr>
+                        <th data-sort="path">File Path<span class="sort-indicator">↕</span></th>
+                        <th data-sort="status">Status<span class="sort-indicator">↕</span></th>
+                        <th data-sort="destination">Location<span class="sort-indicator">↕</span></th>
+                        <th data-sort="size">Size<span class="sort-indicator">↕</span></th>
+                        <th data-sort="camera">Camera<span class="sort-indicator">↕</span></th>
+                        <th data-sort="gps">GPS<span class="sort-indicator">↕</span></th>
+                        <th data-sort="details">Details<span class="sort-indicator">↕</span></th>
+                    </tr>
+                </thead>
+                <tbody class="table-body" id="fileTableBody">`)
+
+	for _, result := range summary.Results {
+		rel := makeRelativePath(result.Path, destRoot)
+		writeTableRow(f, rel, result.Path, string(result.Status), filepath.Dir(rel), "", FormatFileSize(result.Size), result.Camera, result.Location, "", result.Details)
+	}
+
+	f.WriteString(`                </tbody>
+            </table>
+        </div>`)
+
+	writeJavaScript(f)
+	f.WriteString("</body></html>")
+}
@@ -0,0 +1,438 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchOptions are the watch command's own settings; each backup it runs takes BackupOptions
+type WatchOptions struct {
+	// How long a new file's size and modification time must stay the same before it is backed
+	// up, so files still being written or uploaded are left alone (--settle)
+	Settle   time.Duration
+	Poll     bool          // Scan every Interval instead of waiting for file system events (--poll)
+	Interval time.Duration // How often a polled source is scanned (--interval); zero means DefaultWatchInterval
+}
+
+// Defaults of the watch command's --settle and --interval
+const (
+	DefaultWatchSettle   = 5 * time.Second
+	DefaultWatchInterval = 10 * time.Second
+)
+
+// watchDebounce is how long the watcher waits after a file system event before scanning, so a
+// burst of events (a file written in chunks, a folder of uploads) costs one scan
+const watchDebounce = time.Second
+
+// watchedFile is the last size and modification time seen for a source file
+type watchedFile struct {
+	size  int64
+	mtime int64 // Unix nanoseconds
+}
+
+// pendingFile is a new or changed source file waiting to settle
+type pendingFile struct {
+	watchedFile
+	since time.Time // When the file was last seen changing
+}
+
+// WatchSource backs up the source once, then backs up files that appear or change once they
+// have settled, until ctx is cancelled. Each batch of new files is a backup run of its own, with
+// the usual duplicate checks, database records, and report.
+// File system events say when to look at the source again (see watchForChanges); what changed
+// is found by scanning it, so a missed or merged event costs nothing but a later scan
+// A run that fails to start (see Backup) ends the session with its error
+func WatchSource(ctx context.Context, opts BackupOptions, watch WatchOptions) error {
+	if watch.Interval == 0 {
+		watch.Interval = DefaultWatchInterval
+	}
+	if watch.Interval < 0 || watch.Settle < 0 {
+		return fmt.Errorf("watch interval must be positive and settle time can't be negative")
+	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	opts.SrcDir = sourceKey(opts.SrcDir) // The paths backup() walks, so batches match them
+	// Each batch gets a run of its own, so nothing one run collected (known-db paths, zip entries,
+	// preferred dates) is stale for the next
+	runBatch := func(opts BackupOptions) (*BackupResult, error) {
+		r, err := newBackupRun(opts, stop)
+		if err != nil {
+			return nil, err
+		}
+		return r.backup(ctx)
+	}
+	// An in-memory database (--no-db) is gone once no connection to it is open, so one is held
+	// for the whole session; each batch would otherwise start without the files already stored
+	if opts.NoDB {
+		db, err := OpenDatabase(memoryDBPath)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("could not open the in-memory database: %w", err)
+		}
+		defer conn.Close()
+	}
+	// Both taken before the first run, so files arriving while it works (which can take hours)
+	// are still found new afterwards
+	changes, mode, stopWatching := watchForChanges(opts, watch)
+	defer stopWatching()
+	known := scanWatchedFiles(opts)
+	result, err := runBatch(opts)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	// Files the first run failed on or never reached are retried like those of later batches;
+	// a run that stopped before processing anything leaves every file to the watcher
+	if result == nil {
+		known = make(map[string]watchedFile)
+	} else {
+		for _, path := range result.Unfinished {
+			delete(known, path)
+		}
+	}
+	pending := make(map[string]*pendingFile)
+	reportsDir := filepath.Dir(opts.ReportPath)
+	reportBase := strings.TrimSuffix(filepath.Base(opts.ReportPath), filepath.Ext(opts.ReportPath))
+
+	if opts.Verbosity.showSummary() {
+		fmt.Println()
+		color.New(color.FgCyan, color.Bold).Printf("👀 Watching %s for new files (%s, Ctrl+C to stop)\n", opts.SrcDir, mode)
+	}
+	opts.EventLog.Info("watching %s: %s, settle=%s", opts.SrcDir, mode, watch.Settle)
+
+	// One timer for the next scan: soon after a change, or when a pending file could have settled
+	// The first scan also picks up anything that changed during the first run
+	scan := time.NewTimer(0)
+	defer scan.Stop()
+	var scanDue time.Time
+	scheduleScan := func(at time.Time) {
+		if !scanDue.IsZero() && !at.Before(scanDue) {
+			return
+		}
+		scanDue = at
+		scan.Reset(time.Until(at))
+	}
+	for batch := 1; ; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changes:
+			scheduleScan(time.Now().Add(watchDebounce))
+			continue
+		case <-scan.C:
+			scanDue = time.Time{}
+		}
+
+		now := time.Now()
+		current := scanWatchedFiles(opts)
+		var ready []string
+		for path, state := range current {
+			if seen, found := known[path]; found && seen == state {
+				continue
+			}
+			p, found := pending[path]
+			if !found || p.watchedFile != state {
+				// New, or still being written: wait for it to settle
+				pending[path] = &pendingFile{watchedFile: state, since: now}
+				continue
+			}
+			if now.Sub(p.since) >= watch.Settle {
+				ready = append(ready, path)
+			}
+		}
+		// Files deleted from the source (or moved away by --move) are forgotten
+		for path := range known {
+			if _, found := current[path]; !found {
+				delete(known, path)
+			}
+		}
+		for path := range pending {
+			if _, found := current[path]; !found {
+				delete(pending, path)
+			}
+		}
+		if len(ready) > 0 {
+			if opts.Verbosity.showPhases() {
+				color.New(color.FgCyan).Printf("\n%s: %d new file(s) in %s\n", now.Format("15:04:05"), len(ready), opts.SrcDir)
+			}
+			opts.EventLog.Info("watch: backing up %d new file(s)", len(ready))
+			batchOpts := opts
+			batchOpts.Only = ready
+			// New files are picked by the watcher, not by their modification time: a photo
+			// synced from a phone keeps the time it was taken
+			batchOpts.Incremental = false
+			if !opts.ReportAppend {
+				batchOpts.ReportPath = filepath.Join(reportsDir, fmt.Sprintf("%s_watch%d_%s.html", reportBase, batch, now.Format("150405")))
+			}
+			result, err := runBatch(batchOpts)
+			if err != nil {
+				return err
+			}
+			batch++
+			if ctx.Err() != nil {
+				return nil
+			}
+			// Files that failed or were never reached settle again and are tried on a later scan;
+			// a batch that stopped before processing anything (no space, not confirmed) is retried whole
+			var unfinished map[string]bool
+			if result != nil {
+				unfinished = make(map[string]bool, len(result.Unfinished))
+				for _, path := range result.Unfinished {
+					unfinished[path] = true
+				}
+			}
+			for _, path := range ready {
+				if result == nil || unfinished[path] {
+					pending[path].since = time.Now()
+					continue
+				}
+				known[path] = pending[path].watchedFile
+				delete(pending, path)
+			}
+		}
+
+		// Files still settling are looked at again once they could be ready, events or not
+		for _, p := range pending {
+			scheduleScan(p.since.Add(watch.Settle))
+		}
+	}
+}
+
+// watchForChanges returns a channel that receives when the source may have changed, a
+// description of how that is noticed, and a function that stops watching. Changes are reported
+// by file system events, except where those can't be relied on: network shares don't report
+// changes made by other machines, and symlinked folders (--follow-symlinks) aren't covered by
+// the folder watches. Those sources, and any the watches can't be set up for (too many
+// folders for the system's limit), are polled every watch.Interval instead
+func watchForChanges(opts BackupOptions, watch WatchOptions) (<-chan struct{}, string, func()) {
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default: // A scan is already due
+		}
+	}
+
+	var reason string
+	switch {
+	case watch.Poll:
+		reason = "--poll"
+	case opts.FollowSymlinks:
+		reason = "--follow-symlinks"
+	case isNetworkFS(opts.SrcDir):
+		reason = "network share"
+	default:
+		watcher, err := newSourceWatcher(opts)
+		if err == nil {
+			go watcher.run(notify)
+			return changes, "file system events", func() { watcher.Close() }
+		}
+		opts.Verbosity.warnings().Printf("Warning: Could not watch %s for changes, polling it instead: %v", opts.SrcDir, err)
+		opts.EventLog.Warn("could not watch %s for changes: %v", opts.SrcDir, err)
+		reason = "no file system events"
+	}
+
+	ticker := time.NewTicker(watch.Interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				notify()
+			case <-done:
+				return
+			}
+		}
+	}()
+	stop := func() {
+		ticker.Stop()
+		close(done)
+	}
+	return changes, fmt.Sprintf("polling every %s: %s", watch.Interval, reason), stop
+}
+
+// sourceWatcher watches every folder of the source that a backup walks
+// fsnotify watches single folders, so new subfolders are added as they appear
+type sourceWatcher struct {
+	*fsnotify.Watcher
+	opts BackupOptions
+}
+
+// newSourceWatcher starts watching the source's folders
+func newSourceWatcher(opts BackupOptions) (*sourceWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceWatcher{Watcher: watcher, opts: opts}
+	if err := w.addTree(opts.SrcDir, 1); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addTree watches dir and the folders below it that a walk of the source descends into
+// depth counts like getAllFiles: the source itself is 1
+func (w *sourceWatcher) addTree(dir string, depth int) error {
+	if err := w.Add(dir); err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil // Unreadable folders are reported by the backup runs
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() && !w.skipped(path) {
+			if err := w.addTree(path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipped reports whether a walk of the source leaves out this folder (--ignore-hidden, --exclude)
+func (w *sourceWatcher) skipped(dir string) bool {
+	if w.opts.IgnoreHidden && isHiddenName(filepath.Base(dir)) {
+		return true
+	}
+	rel, err := filepath.Rel(w.opts.SrcDir, dir)
+	return err == nil && isExcluded(rel, w.opts.Excludes)
+}
+
+// run turns file system events into change notifications until the watcher is closed
+func (w *sourceWatcher) run(notify func()) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// Folders created in or moved into the source (a whole album uploaded) are watched too
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() && !w.skipped(event.Name) {
+					rel, _ := filepath.Rel(w.opts.SrcDir, event.Name)
+					depth := strings.Count(filepath.ToSlash(rel), "/") + 2
+					if w.opts.MaxDepth == 0 || depth-1 < w.opts.MaxDepth {
+						if err := w.addTree(event.Name, depth); err != nil {
+							w.opts.Verbosity.warnings().Printf("Warning: Could not watch new folder %v", err)
+						}
+					}
+				}
+			}
+			notify()
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// Events lost to a full queue are made up for by the scan this triggers
+			w.opts.Verbosity.warnings().Printf("Warning: Watching the source: %v", err)
+			notify()
+		}
+	}
+}
+
+// unfinishedSources lists the source files of a run that failed, weren't mirrored, or were never
+// reached (cancelled before a worker got to them), with their sidecars and live photo videos, and
+// the paths of walk errors, as a walk of the source finds them: a zip stands for its members
+func (r *backupRun) unfinishedSources(files []FileWithInfo, results []*FileResult, walkErrors []error) []string {
+	var unfinished []string
+	add := func(path string) {
+		if archive, found := r.zipArchives[path]; found {
+			path = archive
+		}
+		unfinished = append(unfinished, path)
+	}
+	for i, file := range files {
+		result := results[i]
+		if result != nil && !result.State.IsError() && result.MirrorError == nil {
+			if video := result.LiveVideo; video != nil && video.State.IsError() {
+				add(video.Path)
+			}
+			continue
+		}
+		add(file.Path)
+		for _, sidecar := range file.Sidecars {
+			add(sidecar)
+		}
+		if file.LiveVideo != nil {
+			add(file.LiveVideo.Path)
+		}
+	}
+	for _, walkErr := range walkErrors {
+		var we *WalkError
+		if errors.As(walkErr, &we) {
+			unfinished = append(unfinished, we.Path)
+		}
+	}
+	return unfinished
+}
+
+// onlyListedZips drops the zips that aren't in wanted, so a watch batch only extracts the zips it
+// was started for; other files stay until onlyFiles, as their sidecars may be in wanted
+func onlyListedZips(files []FileWithInfo, wanted map[string]bool) []FileWithInfo {
+	var kept []FileWithInfo
+	for _, file := range files {
+		if wanted[file.Path] || !IsZipFile(file.Path) || file.Info.IsDir() {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// onlyFiles keeps the files in wanted once sidecars and live photo videos are paired, with the
+// photos whose sidecar or video is in wanted: an edit that appears after its photo was backed up
+// joins the stored photo, as in a full run. Files extracted from a zip count as the zip
+func (r *backupRun) onlyFiles(files []FileWithInfo, wanted map[string]bool) []FileWithInfo {
+	listed := func(path string) bool {
+		if archive, found := r.zipArchives[path]; found {
+			path = archive
+		}
+		return wanted[path]
+	}
+	var kept []FileWithInfo
+	for _, file := range files {
+		keep := listed(file.Path) || (file.LiveVideo != nil && listed(file.LiveVideo.Path))
+		for _, sidecar := range file.Sidecars {
+			keep = keep || listed(sidecar)
+		}
+		if keep {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// scanWatchedFiles lists the source files a backup would consider, with their size and mtime
+// Walk errors are left for the backup runs to report
+func scanWatchedFiles(opts BackupOptions) map[string]watchedFile {
+	files, _, _ := getAllFiles(opts.SrcDir, opts.Excludes, opts.FollowSymlinks, opts.MaxDepth, opts.IgnoreHidden, opts.Verbosity)
+	states := make(map[string]watchedFile, len(files))
+	for _, file := range files {
+		states[file.Path] = watchedFile{size: file.Info.Size(), mtime: file.Info.ModTime().UnixNano()}
+	}
+	return states
+}
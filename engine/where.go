@@ -0,0 +1,141 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// minHashPrefix is the shortest hash prefix `where` accepts, so a typo doesn't list half the backup
+const minHashPrefix = 6
+
+// findRecordsByHash returns the records whose hash starts with prefix (the whole hash matches too)
+func findRecordsByHash(db *sql.DB, prefix string) ([]FileRecord, error) {
+	rows, err := db.Query(`SELECT COALESCE(src_path, ''), dest_path, hash, COALESCE(hash_algo, 'md5'), size, mtime,
+		COALESCE(copied_at, ''), COALESCE(run_id, ''), COALESCE(camera, ''), latitude, longitude, COALESCE(burst_id, '') FROM files WHERE hash >= ? AND hash < ? ORDER BY copied_at`,
+		prefix, prefix+"\xff")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	root := destRootOf(db)
+
+	var records []FileRecord
+	for rows.Next() {
+		var record FileRecord
+		var size, mtime sql.NullInt64
+		var latitude, longitude sql.NullFloat64
+		if err := rows.Scan(&record.SrcPath, &record.DestPath, &record.Hash, &record.HashAlgo, &size, &mtime,
+			&record.CopiedAt, &record.RunID, &record.Camera, &latitude, &longitude, &record.BurstID); err != nil {
+			return nil, err
+		}
+		record.Size, record.Mtime = size.Int64, mtime.Int64
+		record.Location = scannedLocation(latitude, longitude)
+		record.DestPath = resolveDestPath(root, record.DestPath)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// findRecordsByFile hashes a file with every algorithm the backup uses and returns its records
+func findRecordsByFile(db *sql.DB, path string) ([]FileRecord, error) {
+	rows, err := db.Query("SELECT DISTINCT COALESCE(hash_algo, 'md5') FROM files")
+	if err != nil {
+		return nil, err
+	}
+	var algos []string
+	for rows.Next() {
+		var algo string
+		if err := rows.Scan(&algo); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		algos = append(algos, algo)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var records []FileRecord
+	for _, algo := range algos {
+		hash, err := HashFile(path, algo)
+		if err != nil {
+			return nil, err
+		}
+		found, err := findRecordsByHash(db, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range found {
+			if record.Hash == hash && record.HashAlgo == algo {
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+// WhereBackedUp prints where the files matching query (a hash, a hash prefix, or a file to hash)
+// are stored and which source they were copied from. Returns false if nothing matched
+func WhereBackedUp(destDir, dbPath, query string) (bool, error) {
+	destDir = absDestDir(destDir, true)
+	db, err := openExistingDB(destDir, dbPath)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var records []FileRecord
+	if info, statErr := os.Stat(query); statErr == nil && !info.IsDir() {
+		records, err = findRecordsByFile(db, query)
+	} else {
+		prefix := strings.ToLower(strings.TrimSpace(query))
+		if len(prefix) < minHashPrefix {
+			return false, fmt.Errorf("'%s' is not a file, and a hash needs at least %d characters", query, minHashPrefix)
+		}
+		records, err = findRecordsByHash(db, prefix)
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not read database: %w", err)
+	}
+	if len(records) == 0 {
+		color.New(color.FgYellow).Printf("No backed up file matches %s\n", query)
+		return false, nil
+	}
+
+	for i, record := range records {
+		if i > 0 {
+			fmt.Println()
+		}
+		color.New(color.FgCyan, color.Bold).Println(record.DestPath)
+		source := record.SrcPath
+		if source == "" {
+			source = "(recorded by index, not copied by a backup)"
+		}
+		fmt.Printf("   Source: %s\n", source)
+		if record.CopiedAt != "" {
+			if record.RunID != "" {
+				fmt.Printf("   Copied: %s (run %s)\n", record.CopiedAt, record.RunID)
+			} else {
+				fmt.Printf("   Copied: %s\n", record.CopiedAt)
+			}
+		}
+		fmt.Printf("   Hash:   %s %s\n", record.HashAlgo, record.Hash)
+		fmt.Printf("   Size:   %s\n", FormatFileSize(record.Size))
+		if record.Camera != "" {
+			fmt.Printf("   Camera: %s\n", record.Camera)
+		}
+		if record.Location != nil {
+			fmt.Printf("   GPS:    %s (%s)\n", formatLocation(record.Location), locationURL(record.Location))
+		}
+		if record.BurstID != "" {
+			fmt.Printf("   Burst:  %s\n", record.BurstID)
+		}
+	}
+	return true, nil
+}
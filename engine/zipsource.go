@@ -0,0 +1,190 @@
+// backupbozo: Incremental, deduplicating photo/video backup tool with HTML reporting.
+package engine
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"backupbozo/metadata"
+)
+
+// IsZipFile reports whether a source path is a .zip archive to import
+func IsZipFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// checkZipExists returns an error unless a zip given as --src can be used
+func checkZipExists(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("source zip '%s' does not exist: %v", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("source path '%s' is a directory, not a zip file", path)
+	}
+	return nil
+}
+
+// sourceLabel returns the path a source file is known by across runs: the zip entry of a file
+// extracted from a zip, whose temp path changes every run, or else the path itself. Records,
+// the progress journal, and the hash cache are all keyed by it
+func (bi *BatchInserter) sourceLabel(path string) string {
+	if entry, found := bi.zipSources[path]; found {
+		return entry
+	}
+	return path
+}
+
+// fromZip reports whether a source file was extracted from a .zip (and so can't be deleted from the source)
+func (r *backupRun) fromZip(path string) bool {
+	_, found := r.zipSources[path]
+	return found
+}
+
+// expandZipSources replaces each .zip in files with the media and sidecar files inside it,
+// extracted to a temp folder so they are dated, hashed, and copied like any other file
+// Entries keep their zip time as mtime, which only matters for files without a metadata date.
+// In incremental mode a zip older than the last backup is not opened (and is reported as skipped);
+// its entries are never skipped by their own time, since an old photo can arrive in a new zip.
+// cleanup removes the extracted files and must be called once the backup is done
+func (r *backupRun) expandZipSources(files []FileWithInfo, filter FileFilter) (expanded []FileWithInfo, cleanup func(), errs []error) {
+	cleanup = func() {}
+	var tmpDir string
+	zips := 0
+	for _, file := range files {
+		if !IsZipFile(file.Path) || file.Info.IsDir() {
+			expanded = append(expanded, file)
+			continue
+		}
+		if filter.olderThanLastBackup(file.Path, file.Info.ModTime()) {
+			if r.opts.Verbosity == VerbosityVerbose {
+				fmt.Printf("zip older than last backup, not opened: %s\n", file.Path)
+			}
+			expanded = append(expanded, file)
+			continue
+		}
+		if tmpDir == "" {
+			var err error
+			if tmpDir, err = os.MkdirTemp(r.opts.ScratchDir, "backupbozo-zip-"); err != nil {
+				errs = append(errs, &WalkError{Path: file.Path, Err: fmt.Errorf("could not create temp folder for extraction: %w", err)})
+				expanded = append(expanded, file)
+				continue
+			}
+			dir := tmpDir
+			cleanup = func() { os.RemoveAll(dir) }
+		}
+		// Each zip gets its own folder, so equal entry names in two zips never meet
+		zips++
+		entries, err := r.extractZipSource(file.Path, filepath.Join(tmpDir, fmt.Sprintf("%d_%s", zips, filepath.Base(file.Path))))
+		if err != nil {
+			errs = append(errs, &WalkError{Path: file.Path, Err: err})
+		}
+		expanded = append(expanded, entries...)
+	}
+	return expanded, cleanup, errs
+}
+
+// extractZipSource extracts the media and sidecar entries of a zip into dir
+// Entries that would land outside dir ("../" names) are refused
+func (r *backupRun) extractZipSource(zipPath, dir string) ([]FileWithInfo, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open zip: %w", err)
+	}
+	defer zr.Close()
+
+	// Every wanted entry is extracted before the backup starts, so they must all fit at once; the
+	// reader refuses entries longer than their declared size, so the sum is a true bound
+	var wanted []*zip.File
+	var needed uint64
+	for _, entry := range zr.File {
+		ext := metadata.NormalizeExt(entry.Name)
+		if entry.FileInfo().IsDir() || !r.extensions[ext] && !sidecarExtensions[ext] {
+			continue
+		}
+		wanted = append(wanted, entry)
+		needed += entry.UncompressedSize64
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if free, err := getFreeSpace(dir); err != nil {
+		return nil, fmt.Errorf("could not check free space for extraction: %w", err)
+	} else if needed+spaceBuffer > free {
+		return nil, fmt.Errorf("not enough space to extract it in %s: need %.2f GB, %.2f GB available (use --tmp-dir)",
+			filepath.Dir(dir), float64(needed+spaceBuffer)/(1024*1024*1024), float64(free)/(1024*1024*1024))
+	}
+
+	var files []FileWithInfo
+	for _, entry := range wanted {
+		name := path.Clean(strings.ReplaceAll(entry.Name, "\\", "/"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return files, fmt.Errorf("refusing entry %q outside the zip", entry.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := extractZipEntry(entry, target); err != nil {
+			return files, fmt.Errorf("could not extract %s: %w", entry.Name, err)
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return files, err
+		}
+		r.zipSources[target] = filepath.Join(zipPath, filepath.FromSlash(name))
+		r.zipArchives[target] = zipPath
+		files = append(files, FileWithInfo{Path: target, Info: info})
+	}
+	return files, nil
+}
+
+// extractZipEntry writes one zip entry to target, with the entry's time as mtime
+func extractZipEntry(entry *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	in, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !entry.Modified.IsZero() {
+		return os.Chtimes(target, entry.Modified, entry.Modified)
+	}
+	return nil
+}
+
+// relabelZipSources points results for extracted files back at their zip entry, so reports
+// name phone.zip/DCIM/IMG_0001.jpg instead of a temp file that is gone after the run
+func (r *backupRun) relabelZipSources(results []*FileResult) {
+	if len(r.zipSources) == 0 {
+		return
+	}
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		if entry, found := r.zipSources[result.Path]; found {
+			result.Path = entry
+		}
+		if video := result.LiveVideo; video != nil {
+			if entry, found := r.zipSources[video.Path]; found {
+				video.Path = entry
+			}
+		}
+	}
+}
@@ -4,10 +4,12 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"os/signal"
@@ -15,28 +17,102 @@ import (
 
 	"context"
 
+	"backupbozo/engine"
+	"backupbozo/metadata"
+
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	_ "modernc.org/sqlite"
+
+	// Zone names for --timezone on systems without a zoneinfo database (Windows)
+	_ "time/tzdata"
 )
 
-// allowedExtensions defines which file types are considered for backup
-var allowedExtensions = map[string]bool{
-	".jpg":  true,
-	".jpeg": true,
-	".heic": true,
-	".png":  true,
-	".mp4":  true,
-	".mov":  true,
-	".mkv":  true,
-	".webm": true,
-	".avi":  true,
+// parseReportFormats parses --format values; the HTML report is always written
+func parseReportFormats(values []string) (engine.ReportFormats, error) {
+	var formats engine.ReportFormats
+	for _, value := range values {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "html":
+		case "json":
+			formats.JSON = true
+		case "csv":
+			formats.CSV = true
+		default:
+			return formats, fmt.Errorf("unsupported report format %q (use html, json, or csv)", value)
+		}
+	}
+	return formats, nil
+}
+
+// parseDateFlag parses a YYYY-MM-DD date flag as midnight in loc, returning the zero time when unset
+func parseDateFlag(name, value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: expected YYYY-MM-DD", name, value)
+	}
+	return date, nil
 }
 
-// checkExternalTool checks if a tool is available in PATH
-func checkExternalTool(tool string) bool {
-	_, err := exec.LookPath(tool)
-	return err == nil
+// sizeUnits are the 1024-based unit letters of a size flag, as engine.FormatFileSize prints them
+const sizeUnits = "KMGTPE"
+
+// parseSizeFlag parses a human size like "500", "10KB", or "2.5GB" (1024-based), returning 0 when unset
+func parseSizeFlag(name, value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(s, "B")
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		if exp := strings.IndexByte(sizeUnits, s[n-1]); exp >= 0 {
+			for i := 0; i <= exp; i++ {
+				multiplier *= 1024
+			}
+			s = s[:n-1]
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid --%s %q: expected a size like 500KB or 2GB", name, value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// parseReserveFlag parses --reserve as a size ("5GB") or a percentage of the disk ("10%")
+func parseReserveFlag(value string) (engine.SpaceReserve, error) {
+	s := strings.TrimSpace(value)
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			return engine.SpaceReserve{}, fmt.Errorf("invalid --reserve %q: expected a size like 5GB or a percentage like 10%%", value)
+		}
+		return engine.SpaceReserve{Percent: percent}, nil
+	}
+	size, err := parseSizeFlag("reserve", value)
+	if err != nil {
+		return engine.SpaceReserve{}, err
+	}
+	return engine.SpaceReserve{Bytes: uint64(size)}, nil
+}
+
+// parseRateFlag parses --rate-limit as a size per second ("20MB/s"; the "/s" is optional)
+func parseRateFlag(value string) (int64, error) {
+	s := strings.TrimSpace(value)
+	if strings.HasSuffix(strings.ToLower(s), "/s") {
+		s = s[:len(s)-2]
+	}
+	rate, err := parseSizeFlag("rate-limit", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --rate-limit %q: expected a speed like 20MB/s", value)
+	}
+	if rate == 0 && s != "" {
+		return 0, fmt.Errorf("invalid --rate-limit %q: must be more than 0", value)
+	}
+	return rate, nil
 }
 
 func main() {
@@ -44,7 +120,57 @@ func main() {
 	var incremental bool
 	var interactive bool
 	var workers int
+	var batchSize int
 	var gui bool
+	var move bool
+	var layout string
+	var dateSource string
+	var ffprobeTimeout time.Duration
+	var timezoneName string
+	var jsonReport bool
+	var hashAlgo string
+	var sinceStr, untilStr string
+	var configPath string
+	var excludes []string
+	var dedupeMode string
+	var logFile string
+	var minSizeStr, maxSizeStr string
+	var checksumSampleStr string
+	var manifest bool
+	var flat bool
+	var quiet, verbose bool
+	var extOnly, extAdd, extRemove []string
+	var reserveStr string
+	var rateLimitStr string
+	var dirModeStr, fileModeStr string
+	var notifyURL, notifyOn string
+	var postCopyHookStr string
+	var hashOnlyVideos bool
+	var reportFormats []string
+	var knownDBs []string
+	var followSymlinks bool
+	var maxDepth int
+	var ignoreHidden bool
+	var reportOpen bool
+	var strict bool
+	var noDB bool
+	var skipExistingByHash bool
+	var mirrorDir string
+	var scratchDir string
+	var collisionMode string
+	var preferDate string
+	var watching bool // Set by the watch command, which shares the backup flags
+	var watch engine.WatchOptions
+	var forceLock bool
+	var appendReport, reportThumbnails bool
+	var renamePattern, contentCheck, archiveFormat string
+	var separateMedia, convertHEIC bool
+	var dedupeReportOnly, checkNearDuplicates, purgeSourceDuplicates bool
+	var verifyCopies, fsyncCopies, preserveOwner bool
+	var copyRetries int
+	var copyRetryDelay time.Duration
+	var postCopyHookTimeout time.Duration
+	var postCopyHookFatal bool
 
 	var rootCmd = &cobra.Command{
 		Use:   "backupbozo",
@@ -52,14 +178,17 @@ func main() {
 		Long: `backupbozo is a fast, incremental backup tool for photos and videos.
 
 Features:
-- Deduplicates files using SHA256 hashes and an SQLite database (pure Go driver)
+- Deduplicates files using content hashes and an SQLite database (pure Go driver)
+- Hash algorithm is configurable (md5, sha256, blake3, xxhash)
 - Supports incremental backups (only new/changed files are processed)
-- Organizes files into YYYY-MM folders by date
+- Organizes files into YYYY-MM folders by date (customizable with --layout)
 - Supports .jpg, .jpeg, .heic, .mp4, .mov, .mkv, .webm, .avi
-- Generates an HTML report of copied, duplicate, and error files
+- Supports other images: .png, .tif, .tiff, .webp, .gif, .bmp (GIF and BMP dated by file name or mtime)
+- Supports camera RAW: .cr2, .nef, .arw, .dng, .orf, .raf
+- Generates an HTML report of copied, duplicate, and error files (plus JSON with --json)
 - Skips files already present at the destination
 - Handles iPhone .heic photos
-- Requires ffprobe for video date extraction
+- Reads MP4/MOV creation dates natively; uses ffprobe (if installed) for other videos
 - GUI directory picker with fallback to text prompts
 `,
 		Example: `  # Basic usage: backup new photos from ~/DCIM to ~/backup_photos
@@ -68,37 +197,490 @@ Features:
   # Full backup (not incremental)
   backupbozo --src ~/DCIM --dest ~/backup_photos --incremental=false
 
+  # Only back up photos from a trip
+  backupbozo --src ~/DCIM --dest ~/backup_photos --since 2023-06-01 --until 2023-06-30
+
+  # Skip thumbnail folders and screenshots
+  backupbozo --src ~/DCIM --dest ~/backup_photos --exclude .thumbnails --exclude 'Screenshot*'
+
+  # Also back up GIFs, but not AVI files
+  backupbozo --src ~/DCIM --dest ~/backup_photos --ext-add 3gp --ext-remove avi
+
+  # Skip tiny thumbnails and huge video files
+  backupbozo --src ~/DCIM --dest ~/backup_photos --min-size 10KB --max-size 2GB
+
+  # Organize by year, then by day
+  backupbozo --src ~/DCIM --dest ~/backup_photos --layout 2006/2006-01-02
+
+  # Sort by file type, then month (jpg/2021-07, mp4/2021-07), or by camera
+  backupbozo --src ~/DCIM --dest ~/backup_photos --layout "{ext}/2006-01"
+  backupbozo --src ~/DCIM --dest ~/backup_photos --layout "{camera}/2006"
+
+  # Back up the photos inside a zip without unzipping it first
+  backupbozo --src ~/Downloads/phone-backup.zip --dest ~/backup_photos
+
+  # Identical files with different dates (an edited copy's mtime): file them by the camera's date
+  backupbozo --src ~/Pictures --dest ~/backup_photos --prefer-date exif
+
+  # Store PNGs saved as .jpg (and other mislabeled files) under the extension of their content
+  backupbozo --src /media/old_phone --dest ~/backup_photos --check-content-type fix
+
+  # Trust only file modification times when placing files
+  backupbozo --src ~/Pictures --dest ~/backup_photos --date-source mtime
+
+  # Date screenshots and exports by when they were created, not last edited
+  backupbozo --src ~/Desktop/Screenshots --dest ~/backup_photos --date-source birthtime
+
+  # File a trip's photos and videos by the dates where they were taken
+  backupbozo --src /media/sdcard --dest ~/backup_photos --timezone America/New_York
+
+  # Find duplicate clips in a huge video library from their first and last 64 MB
+  backupbozo --src ~/Videos --dest ~/backup_videos --checksum-sample 64MB
+
+  # Keep photos and videos apart: Photos/2021-07 and Videos/2021-07
+  backupbozo --src ~/DCIM --dest ~/backup_photos --separate-media
+
+  # Give stored files sortable names like 2021-07-04_153000_IMG_0001.jpg
+  backupbozo --src ~/DCIM --dest ~/backup_photos --rename-pattern 2006-01-02_150405_{name}
+
+  # Keep every duplicate in its own month folder as a hard link to the stored copy
+  backupbozo --src ~/DCIM --dest ~/backup_photos --dedupe-mode hardlink
+
+  # Two phones both have an IMG_0001.jpg: keep the second one in 2024-05/phone_b/
+  backupbozo --src ~/phone_b --dest ~/backup_photos --collision-mode source-subdir
+
+  # No date folders: everything goes straight into the destination
+  backupbozo --src ~/DCIM --dest ~/backup_photos --flat
+
+  # Never fill the backup disk past 90%
+  backupbozo --src ~/DCIM --dest ~/backup_photos --reserve 10%
+
+  # Faster photo-heavy backups: photos are matched by name, size, and mtime instead of hashed
+  backupbozo --src ~/DCIM --dest ~/backup_photos --hash-only-videos
+
+  # Also write a CSV report for spreadsheets
+  backupbozo --src ~/DCIM --dest ~/backup_photos --format csv
+
+  # Skip files already archived on an external drive's backup
+  backupbozo --src ~/DCIM --dest ~/backup_photos --known-db /media/external/backup_photos/backupbozo.db
+
+  # Post a summary to an alerting webhook, only when something went wrong
+  backupbozo --src ~/DCIM --dest ~/backup_photos --notify-webhook https://alerts.example.com/hook --notify-on error
+
+  # Upload each copied file to cloud storage with your own script, stopping if an upload fails
+  backupbozo --src ~/DCIM --dest ~/backup_photos --post-copy-hook ~/bin/upload.sh --post-copy-hook-fatal
+
+  # Back up to a network drive without saturating the connection
+  backupbozo --src ~/DCIM --dest /mnt/nas/photos --rate-limit 20MB/s
+
+  # Share the backup with a NAS group: group-writable files in folders that keep the group
+  backupbozo --src ~/DCIM --dest /mnt/nas/photos --dir-mode 2775 --file-mode 0664
+
+  # Ride out I/O errors on a flaky external drive
+  backupbozo --src ~/DCIM --dest /mnt/usb/photos --copy-retries 3 --copy-retry-delay 2s
+
+  # Point out re-saved copies of photos that are already backed up
+  backupbozo --src ~/Pictures --dest ~/backup_photos --near-duplicates
+
+  # Before consolidating a drive, see what on it is duplicated or already backed up (copies nothing)
+  backupbozo --src /mnt/old_drive --dest ~/backup_photos --dedupe-report-only
+
+  # Re-read every copy to catch silent write corruption
+  backupbozo --src ~/DCIM --dest /media/archive --verify-copy
+
+  # One-shot archive onto a drive that is unplugged right after: flush folders to disk too
+  backupbozo --src ~/DCIM --dest /media/archive --fsync
+
+  # Store iPhone photos as JPEG for devices that can't show HEIC
+  backupbozo --src ~/DCIM --dest ~/backup_photos --convert-heic-to-jpeg
+
+  # Keep each month as one tar.gz instead of thousands of loose files
+  backupbozo --src ~/DCIM --dest /media/archive --archive tar.gz
+
+  # Keep a second copy on an external drive, reading each photo only once
+  backupbozo --src ~/DCIM --dest ~/backup_photos --mirror /media/external/backup_photos
+
+  # Write copies on a scratch disk first when the destination partition is nearly full
+  backupbozo --src ~/DCIM --dest /media/small_card --tmp-dir /mnt/scratch
+
+  # Weekly backups: keep every run in one report instead of a new file each time
+  backupbozo --src ~/DCIM --dest ~/backup_photos --report-append
+
+  # Open the report in the browser when done
+  backupbozo --src ~/DCIM --dest ~/backup_photos --report-open
+
+  # Glance over what was copied: show a thumbnail of each copied image in the report
+  backupbozo --src ~/DCIM --dest ~/backup_photos --report-thumbnails
+
+  # Skip deeply nested app caches: only look two folder levels into the source
+  backupbozo --src /media/old_drive --dest ~/backup_photos --max-depth 2
+
+  # Leave out .thumbnails, .Trash-1000, .DS_Store and other hidden clutter
+  backupbozo --src /media/sdcard --dest ~/backup_photos --ignore-hidden
+
+  # Plan from the database instead of checking every file on a slow network destination
+  backupbozo --src ~/Pictures --dest /mnt/nas/photos --skip-existing-by-hash
+
+  # Clean up a messy source: keep one copy of each file there, deleting the rest once it is backed up
+  backupbozo --src ~/Downloads --dest ~/backup_photos --purge-duplicates-in-source
+
+  # Also back up folders that are symlinked into the source
+  backupbozo --src ~/Pictures --dest ~/backup_photos --follow-symlinks
+
+  # Move files off an SD card (sources are deleted after a verified copy)
+  backupbozo --src /media/sdcard/DCIM --dest ~/backup_photos --move
+
+  # Back up to a NAS over SFTP (database and reports stay on this machine)
+  backupbozo --src ~/DCIM --dest sftp://me@nas.local/volume1/photos
+
+  # Unattended cron run with a log file to check afterwards
+  backupbozo --src ~/DCIM --dest ~/backup_photos --log-file ~/backup_photos/backup.log
+
+  # Fail the cron job (exit status 1) when any file could not be backed up
+  backupbozo --src ~/DCIM --dest ~/backup_photos --strict
+
+  # Quick organized copy onto a fresh drive, without keeping a database there
+  backupbozo --src /media/sdcard/DCIM --dest /media/usb/photos --no-db
+
   # Custom database and report paths
   backupbozo --src ~/DCIM --dest ~/backup_photos --db ~/backup_photos/my.db --report ~/backup_photos/report.html
 
+  # Check an existing backup for missing or corrupted files
+  backupbozo verify --dest ~/backup_photos
+
+  # Forget files you deleted from the backup so they can be imported again
+  backupbozo prune --dest ~/backup_photos
+
+  # Adopt an archive made before backupbozo so its files count as duplicates
+  backupbozo index --dest ~/old_photo_archive
+
+  # Undo the most recent backup run
+  backupbozo rollback --dest ~/backup_photos
+
+  # Use a config file instead of retyping flags (default: ~/.bozobackup.yaml)
+  backupbozo --config ~/weekly-backup.yaml
+
 `,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Config file values fill in any flags not given on the command line
+			if configPath != "" {
+				return applyConfigFile(cmd, configPath, true)
+			}
+			if path := defaultConfigPath(); path != "" {
+				return applyConfigFile(cmd, path, false)
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// Standard backup mode
-			// If no arguments are supplied, default to interactive mode
-			if len(os.Args) == 1 {
+			// If no arguments are supplied (and no config file provided directories), default to interactive mode
+			if len(os.Args) == 1 && (srcDir == "" || destDir == "") {
 				interactive = true
 			}
-			if !checkExternalTool("ffprobe") {
-				fmt.Fprintln(os.Stderr, "[FATAL] Required tool 'ffprobe' not found in PATH. Please install ffmpeg/ffprobe.")
+			if err := engine.ValidateLayout(layout); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --layout: %v\n", err)
+				os.Exit(1)
+			}
+			if separateMedia && strings.Contains(layout, engine.LayoutMediaToken) {
+				fmt.Fprintf(os.Stderr, "[FATAL] --separate-media and a --layout with %s both sort by media type; use one of them\n", engine.LayoutMediaToken)
 				os.Exit(1)
 			}
+			if preferDate != "" {
+				if err := engine.ValidatePreferDate(preferDate); err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] Invalid --prefer-date: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			if contentCheck != "" {
+				if err := engine.ValidateContentCheck(contentCheck); err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] Invalid --check-content-type: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			if _, err := metadata.NewExtractorRegistryFor(dateSource); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --date-source: %v\n", err)
+				os.Exit(1)
+			}
+			if ffprobeTimeout < 0 {
+				fmt.Fprintln(os.Stderr, "[FATAL] --ffprobe-timeout can't be negative")
+				os.Exit(1)
+			}
+			timezone, err := engine.ParseTimezone(timezoneName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --timezone: %v\n", err)
+				os.Exit(1)
+			}
+			if renamePattern != "" {
+				if err := engine.ValidateRenamePattern(renamePattern); err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] Invalid --rename-pattern: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			extensions, err := engine.ConfigureExtensions(extOnly, extAdd, extRemove)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --ext: %v\n", err)
+				os.Exit(1)
+			}
+			formats, err := parseReportFormats(reportFormats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --format: %v\n", err)
+				os.Exit(1)
+			}
+			formats.JSON = formats.JSON || jsonReport
+			for i, known := range knownDBs {
+				knownDBs[i] = expandHome(known)
+				if info, err := os.Stat(knownDBs[i]); err != nil || info.IsDir() {
+					fmt.Fprintf(os.Stderr, "[FATAL] Known database '%s' not found\n", known)
+					os.Exit(1)
+				}
+			}
+			if quiet && verbose {
+				fmt.Fprintln(os.Stderr, "[FATAL] --quiet and --verbose cannot be used together")
+				os.Exit(1)
+			}
+			verbosity := engine.VerbosityNormal
+			if quiet {
+				verbosity = engine.VerbosityQuiet
+			} else if verbose {
+				verbosity = engine.VerbosityVerbose
+			}
+			if flat {
+				if cmd.Flags().Changed("layout") {
+					fmt.Fprintln(os.Stderr, "[FATAL] --flat and --layout cannot be used together")
+					os.Exit(1)
+				}
+				layout = engine.FlatLayout
+			}
+			if err := engine.ValidateHashAlgorithm(hashAlgo); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --hash: %v\n", err)
+				os.Exit(1)
+			}
+			since, err := parseDateFlag("since", sinceStr, timezone)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			until, err := parseDateFlag("until", untilStr, timezone)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if !since.IsZero() && !until.IsZero() && until.Before(since) {
+				fmt.Fprintf(os.Stderr, "[FATAL] --until (%s) is before --since (%s)\n", untilStr, sinceStr)
+				os.Exit(1)
+			}
+			minSize, err := parseSizeFlag("min-size", minSizeStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			maxSize, err := parseSizeFlag("max-size", maxSizeStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if maxSize > 0 && maxSize < minSize {
+				fmt.Fprintf(os.Stderr, "[FATAL] --max-size (%s) is smaller than --min-size (%s)\n", maxSizeStr, minSizeStr)
+				os.Exit(1)
+			}
+			checksumSample, err := parseSizeFlag("checksum-sample", checksumSampleStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			rateLimit, err := parseRateFlag(rateLimitStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			dirMode, err := engine.ParseModeFlag("dir-mode", dirModeStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			fileMode, err := engine.ParseModeFlag("file-mode", fileModeStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if preserveOwner && !engine.CanChangeOwner() {
+				fmt.Fprintln(os.Stderr, "[FATAL] --preserve-owner needs to run as root on Linux or macOS")
+				os.Exit(1)
+			}
+			if batchSize < 1 {
+				fmt.Fprintf(os.Stderr, "[FATAL] --batch-size must be at least 1\n")
+				os.Exit(1)
+			}
+			postCopyHook, err := engine.ParseHookCommand(postCopyHookStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if postCopyHookTimeout < 0 {
+				fmt.Fprintf(os.Stderr, "[FATAL] --post-copy-hook-timeout can't be negative\n")
+				os.Exit(1)
+			}
+			if copyRetries < 0 || copyRetryDelay < 0 {
+				fmt.Fprintf(os.Stderr, "[FATAL] --copy-retries and --copy-retry-delay can't be negative\n")
+				os.Exit(1)
+			}
+			var webhook *engine.Webhook
+			switch {
+			case notifyOn != engine.NotifyAlways && notifyOn != engine.NotifyError:
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --notify-on %q (use always or error)\n", notifyOn)
+				os.Exit(1)
+			case notifyURL != "":
+				if u, err := url.Parse(notifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					fmt.Fprintf(os.Stderr, "[FATAL] Invalid --notify-webhook %q: expected an http:// or https:// URL\n", notifyURL)
+					os.Exit(1)
+				}
+				webhook = &engine.Webhook{URL: notifyURL, OnlyOnError: notifyOn == engine.NotifyError}
+			}
+			reserve, err := parseReserveFlag(reserveStr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if err := engine.ValidateDedupeMode(dedupeMode); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --dedupe-mode: %v\n", err)
+				os.Exit(1)
+			}
+			if err := engine.ValidateCollisionMode(collisionMode); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --collision-mode: %v\n", err)
+				os.Exit(1)
+			}
+			if archiveFormat != "" {
+				if archiveFormat != engine.ArchiveTarGz {
+					fmt.Fprintf(os.Stderr, "[FATAL] Invalid --archive %q: only tar.gz is supported\n", archiveFormat)
+					os.Exit(1)
+				}
+				if layout == engine.FlatLayout {
+					fmt.Fprintln(os.Stderr, "[FATAL] --archive needs date folders to archive and cannot be used with --flat")
+					os.Exit(1)
+				}
+				if dedupeMode != engine.DedupeSkip {
+					fmt.Fprintln(os.Stderr, "[FATAL] --archive cannot be used with --dedupe-mode hardlink or symlink")
+					os.Exit(1)
+				}
+				if engine.IsRemoteDest(destDir) {
+					fmt.Fprintln(os.Stderr, "[FATAL] --archive is not supported for sftp:// destinations")
+					os.Exit(1)
+				}
+			}
+			if mirrorDir != "" {
+				if archiveFormat != "" {
+					fmt.Fprintln(os.Stderr, "[FATAL] --mirror cannot be used with --archive")
+					os.Exit(1)
+				}
+				if abs, err := filepath.Abs(mirrorDir); err == nil {
+					mirrorDir = abs
+				}
+			}
+			if fsyncCopies && engine.IsRemoteDest(destDir) {
+				fmt.Fprintln(os.Stderr, "[FATAL] --fsync is not supported for sftp:// destinations")
+				os.Exit(1)
+			}
+			if scratchDir != "" {
+				if engine.IsRemoteDest(destDir) {
+					fmt.Fprintln(os.Stderr, "[FATAL] --tmp-dir is not supported for sftp:// destinations")
+					os.Exit(1)
+				}
+				if abs, err := filepath.Abs(scratchDir); err == nil {
+					scratchDir = abs
+				}
+			}
+			if convertHEIC {
+				if move {
+					fmt.Fprintln(os.Stderr, "[FATAL] --convert-heic-to-jpeg cannot be used with --move: the original HEIC files would be deleted")
+					os.Exit(1)
+				}
+				if !engine.CanConvertHEIC() {
+					fmt.Fprintln(os.Stderr, "[FATAL] --convert-heic-to-jpeg needs 'heif-convert' (libheif) or 'magick' (ImageMagick) in PATH")
+					os.Exit(1)
+				}
+			}
+			if maxDepth < 0 {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --max-depth %d: must be 0 (no limit) or more\n", maxDepth)
+				os.Exit(1)
+			}
+			if err := engine.ValidateExcludes(excludes); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --exclude: %v\n", err)
+				os.Exit(1)
+			}
+			if !engine.CheckExternalTool("ffprobe") {
+				// MP4/MOV dates are read natively; other videos fall back to their modification time
+				color.New(color.FgYellow).Fprintln(os.Stderr, "[WARN] 'ffprobe' not found in PATH. MKV, WebM, and AVI videos will be dated by file modification time. Install ffmpeg/ffprobe for full video support.")
+			}
 			if interactive {
+				if !cmd.Flags().Changed("confirm-threshold") {
+					confirmThreshold = defaultInteractiveConfirmThreshold
+				}
 				srcDir, destDir, incremental = interactivePrompt(gui)
 			}
 			// Only check for required directories if not in interactive mode
 			if !interactive && (srcDir == "" || destDir == "") {
 				log.Fatal("Source and destination directories are required")
 			}
-			if dbPath == "" {
-				dbPath = filepath.Join(destDir, "backupbozo.db")
+			// A remote destination keeps its database and reports in a local folder
+			localDir := destDir
+			var destFS engine.DestFS
+			if engine.IsRemoteDest(destDir) {
+				fsys, dir, err := engine.NewSFTPFS(destDir)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+					os.Exit(1)
+				}
+				defer fsys.Close()
+				stateDir, err := engine.RemoteStateDir(destDir)
+				if err == nil {
+					err = os.MkdirAll(stateDir, 0755)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] Could not create local folder for the remote destination's database: %v\n", err)
+					os.Exit(1)
+				}
+				destFS = fsys
+				destDir = dir
+				localDir = stateDir
+			}
+			// Before the reports folder is made, so a refused backup leaves nothing behind in the source
+			if err := engine.CheckPaths(srcDir, destDir, destFS); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if noDB {
+				if dbPath != "" {
+					fmt.Fprintln(os.Stderr, "[FATAL] --no-db and --db can't be used together")
+					os.Exit(1)
+				}
+			} else if dbPath == "" {
+				dbPath = filepath.Join(localDir, "backupbozo.db")
 			}
 			if reportPath == "" {
-				reportsDir := filepath.Join(destDir, "reports")
+				reportsDir := filepath.Join(localDir, "reports")
 				// Create reports directory if it doesn't exist
 				if err := os.MkdirAll(reportsDir, 0755); err != nil {
 					log.Fatalf("[FATAL] Could not create reports directory: %v", err)
 				}
-				reportPath = filepath.Join(reportsDir, fmt.Sprintf("report_%s.html", time.Now().Format("20060102_150405")))
+				reportName := "report"
+				if dedupeReportOnly {
+					reportName = "duplicates"
+				}
+				reportPath = filepath.Join(reportsDir, fmt.Sprintf("%s_%s.html", reportName, time.Now().Format("20060102_150405")))
+				if appendReport {
+					// One file collects every run
+					reportPath = filepath.Join(reportsDir, "history.html")
+				}
+			}
+
+			var eventLog *engine.EventLog
+			if logFile != "" {
+				eventLog, err = engine.OpenEventLog(logFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] Could not open log file: %v\n", err)
+					os.Exit(1)
+				}
+				defer eventLog.Close()
 			}
 
 			// Handle interrupts for graceful shutdown using context
@@ -111,18 +693,642 @@ Features:
 				cancel()
 			}()
 
-			backup(ctx, srcDir, destDir, dbPath, reportPath, incremental, workers)
+			opts := engine.BackupOptions{
+				SrcDir:         srcDir,
+				DestDir:        destDir,
+				DestFS:         destFS,
+				DBPath:         dbPath,
+				NoDB:           noDB,
+				ReportPath:     reportPath,
+				Formats:        formats,
+				Incremental:    incremental,
+				Workers:        workers,
+				BatchSize:      batchSize,
+				Move:           move,
+				Layout:         layout,
+				HashAlgo:       hashAlgo,
+				DedupeMode:     dedupeMode,
+				CollisionMode:  collisionMode,
+				Manifest:       manifest,
+				Reserve:        reserve,
+				KnownDBs:       knownDBs,
+				MirrorDir:      mirrorDir,
+				ScratchDir:     scratchDir,
+				PreferDate:     preferDate,
+				Since:          since,
+				Until:          until,
+				MinSize:        minSize,
+				MaxSize:        maxSize,
+				Excludes:       excludes,
+				FollowSymlinks: followSymlinks,
+				MaxDepth:       maxDepth,
+				IgnoreHidden:   ignoreHidden,
+
+				RenamePattern: renamePattern,
+				SeparateMedia: separateMedia,
+				Archive:       archiveFormat,
+				ConvertHEIC:   convertHEIC,
+				ContentCheck:  contentCheck,
+
+				VerifyCopies:   verifyCopies,
+				Fsync:          fsyncCopies,
+				DirMode:        dirMode,
+				FileMode:       fileMode,
+				PreserveOwner:  preserveOwner,
+				RateLimit:      rateLimit,
+				CopyRetries:    copyRetries,
+				CopyRetryDelay: copyRetryDelay,
+
+				DateSource:     dateSource,
+				FFprobeTimeout: ffprobeTimeout,
+				Timezone:       timezone,
+				Extensions:     extensions,
+
+				HashOnlyVideos:        hashOnlyVideos,
+				ChecksumSample:        checksumSample,
+				NearDuplicates:        checkNearDuplicates,
+				PurgeSourceDuplicates: purgeSourceDuplicates,
+				SkipExistingByHash:    skipExistingByHash,
+
+				ReportAppend:     appendReport,
+				ReportThumbnails: reportThumbnails,
+
+				PostCopyHook:        postCopyHook,
+				PostCopyHookTimeout: postCopyHookTimeout,
+				PostCopyHookFatal:   postCopyHookFatal,
+
+				ForceLock:        forceLock,
+				ConfirmThreshold: confirmThreshold,
+				Confirm: func(files int, totalSize int64) bool {
+					return confirmLargeCopy(files, totalSize, srcDir, destDir)
+				},
+
+				Verbosity: verbosity,
+				EventLog:  eventLog,
+				Webhook:   webhook,
+			}
+			if dedupeReportOnly {
+				if watching {
+					fmt.Fprintln(os.Stderr, "[FATAL] --dedupe-report-only can't be used with watch")
+					os.Exit(1)
+				}
+				if appendReport {
+					fmt.Fprintln(os.Stderr, "[FATAL] --dedupe-report-only writes its own report and can't be used with --report-append")
+					os.Exit(1)
+				}
+				if err := engine.ReportDuplicates(ctx, opts); err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+			if watching {
+				if engine.IsZipFile(srcDir) {
+					fmt.Fprintln(os.Stderr, "[FATAL] watch needs a source folder, not a zip file")
+					os.Exit(1)
+				}
+				if err := engine.WatchSource(ctx, opts, watch); err != nil {
+					fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+					os.Exit(1)
+				}
+				return
+			}
+			result, err := engine.Backup(ctx, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+
+			// An interrupted run writes its report under another name; a run that stopped early writes none
+			if reportOpen && result != nil {
+				openReport(result.ReportPath)
+			}
+			if strict {
+				if reason := engine.StrictFailure(result); reason != "" {
+					fmt.Fprintf(os.Stderr, "[FATAL] --strict: %s\n", reason)
+					os.Exit(1)
+				}
+			}
 		},
 	}
 
-	rootCmd.Flags().StringVarP(&srcDir, "src", "s", "", "Source directory")
-	rootCmd.Flags().StringVarP(&destDir, "dest", "d", "", "Destination directory")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Path to YAML config file (default: ~/.bozobackup.yaml)")
+	rootCmd.Flags().StringVarP(&srcDir, "src", "s", "", "Source directory, or a .zip to back up the photos inside it")
+	rootCmd.Flags().StringVarP(&destDir, "dest", "d", "", "Destination directory, or sftp://[user@]host[:port]/path for a remote one")
 	rootCmd.Flags().StringVar(&dbPath, "db", "", "Path to SQLite database")
+	rootCmd.Flags().BoolVar(&noDB, "no-db", false, "One-shot copy: keep the database in memory and don't write it to the destination")
+	rootCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says another backup is using it")
 	rootCmd.Flags().StringVar(&reportPath, "report", "", "Path to HTML report")
+	rootCmd.Flags().BoolVar(&reportThumbnails, "report-thumbnails", false, "Embed a small preview of every copied JPEG, PNG, and GIF in the HTML report (slower)")
+	rootCmd.Flags().BoolVar(&strict, "strict", false, "Exit with status 1 if any file failed, the run was interrupted, or it stopped early (for cron and CI)")
+	rootCmd.Flags().BoolVar(&appendReport, "report-append", false, "Add each run at the top of one rolling HTML report (reports/history.html, or --report) instead of writing a new report per run")
+	rootCmd.Flags().BoolVar(&reportOpen, "report-open", false, "Open the HTML report in the default browser when the backup finishes (only when run from a terminal)")
+	rootCmd.Flags().StringVar(&logFile, "log-file", "", "Append timestamped INFO/WARN/ERROR lines for every file and event to this file")
+	rootCmd.Flags().BoolVar(&jsonReport, "json", false, "Also write a machine-readable JSON report next to the HTML report")
+	rootCmd.Flags().StringSliceVar(&reportFormats, "format", nil, "Extra reports to write next to the HTML report: json, csv (repeatable or comma-separated)")
+	rootCmd.Flags().BoolVar(&manifest, "manifest", false, "Keep a SHA256SUMS file in the destination (check it with sha256sum -c)")
 	rootCmd.Flags().BoolVar(&incremental, "incremental", true, "Only process files newer than last backup")
+	rootCmd.Flags().StringVar(&sinceStr, "since", "", "Only back up files dated on or after this day (YYYY-MM-DD)")
+	rootCmd.Flags().StringVar(&untilStr, "until", "", "Only back up files dated on or before this day (YYYY-MM-DD)")
+	rootCmd.Flags().StringVar(&minSizeStr, "min-size", "", "Skip files smaller than this (e.g. 10KB)")
+	rootCmd.Flags().StringVar(&maxSizeStr, "max-size", "", "Skip files larger than this (e.g. 2GB)")
+	rootCmd.Flags().StringSliceVar(&extOnly, "ext", nil, "Only back up these extensions, replacing the built-in list (repeatable, e.g. --ext jpg --ext mp4)")
+	rootCmd.Flags().StringSliceVar(&extAdd, "ext-add", nil, "Also back up these extensions (repeatable, e.g. --ext-add 3gp)")
+	rootCmd.Flags().StringSliceVar(&extRemove, "ext-remove", nil, "Don't back up these extensions (repeatable, e.g. --ext-remove avi)")
+	rootCmd.Flags().BoolVar(&skipExistingByHash, "skip-existing-by-hash", false, "Plan from the database: skip files whose cached hash is already stored and check destinations against recorded paths instead of the disk")
+	rootCmd.Flags().BoolVar(&ignoreHidden, "ignore-hidden", false, "Skip hidden files and folders in the source (names starting with a dot, like .thumbnails or .DS_Store)")
+	rootCmd.Flags().IntVar(&maxDepth, "max-depth", 0, "Only look this many folder levels into the source (1 = just its own files; 0 = no limit)")
+	rootCmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked folders in the source (each folder is walked once, so loops are safe)")
+	rootCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Glob pattern for files or folders to skip (repeatable, e.g. .thumbnails or 'Screenshot*')")
+	rootCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print the final summary (no progress bars)")
+	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print one line per file instead of progress bars")
 	rootCmd.Flags().BoolVar(&interactive, "interactive", false, "Run in interactive mode (prompts for input)")
+	rootCmd.Flags().IntVar(&confirmThreshold, "confirm-threshold", 0, "Ask before copying more than this many files (default 1000 in interactive mode, 0 = never ask)")
 	rootCmd.Flags().BoolVar(&gui, "gui", true, "Use GUI directory picker in interactive mode (falls back to text prompts)")
 	rootCmd.Flags().IntVar(&workers, "workers", runtime.NumCPU(), "Number of parallel workers (default: CPU cores)")
+	rootCmd.Flags().IntVar(&batchSize, "batch-size", engine.DefaultBatchSize, "Files recorded in the database per transaction; progress is committed after each batch")
+	rootCmd.Flags().StringVar(&layout, "layout", engine.DefaultLayout, "Destination folder layout as a Go time template, with optional {ext}, {media}, and {camera} tokens (e.g. 2006/2006-01-02 or {ext}/2006-01)")
+	rootCmd.Flags().StringVar(&contentCheck, "check-content-type", "", "Check each file's first bytes against its extension: report (list mismatches) or fix (also store them with the right extension)")
+	rootCmd.Flags().StringVar(&preferDate, "prefer-date", "", "When identical files have different dates, place them by: exif (metadata over mtime), oldest, or newest")
+	rootCmd.Flags().StringVar(&dateSource, "date-source", metadata.DateSourceAuto, "Where file dates come from: auto, exif, ffprobe, mtime, filename, or birthtime (files without one use mtime)")
+	rootCmd.Flags().StringVar(&timezoneName, "timezone", "local", "Time zone that decides which day and month a file is filed under: local, UTC, or a name like Europe/Paris")
+	rootCmd.Flags().DurationVar(&ffprobeTimeout, "ffprobe-timeout", metadata.DefaultFFprobeTimeout, "Give up reading a video's date with ffprobe after this long and report the file as an error (0 = wait forever)")
+	rootCmd.Flags().StringVar(&renamePattern, "rename-pattern", "", "Name stored files from a Go time template, {name} being the original name (e.g. 2006-01-02_150405_{name})")
+	rootCmd.Flags().BoolVar(&dedupeReportOnly, "dedupe-report-only", false, "Don't back up: hash the source and report content found more than once in it or already in the backup, without copying or writing to the database")
+	rootCmd.Flags().BoolVar(&checkNearDuplicates, "near-duplicates", false, "Flag copied JPEG/PNG/GIF images that look like stored ones (re-saves, re-compressions) in the report; they are still copied")
+	rootCmd.Flags().BoolVar(&convertHEIC, "convert-heic-to-jpeg", false, "Store HEIC/HEIF photos as JPEG (needs heif-convert or ImageMagick); duplicates are still detected by the original")
+	rootCmd.Flags().StringVar(&mirrorDir, "mirror", "", "Also write every copied file to this second local folder, in the same layout, in the same pass")
+	rootCmd.Flags().StringVar(&scratchDir, "tmp-dir", "", "Write copies, HEIC conversions, and extracted zips to this local folder before moving them into the destination")
+	rootCmd.Flags().StringVar(&archiveFormat, "archive", "", "Store each date folder as a compressed archive (tar.gz), appending new files to it")
+	rootCmd.Flags().BoolVar(&verifyCopies, "verify-copy", false, "Re-read each copy and compare its hash with the source before keeping it (slower, for archival backups)")
+	rootCmd.Flags().BoolVar(&fsyncCopies, "fsync", false, "Also sync each stored file's folder to disk before recording it, so a power loss can't lose a recorded file (slower)")
+	rootCmd.Flags().BoolVar(&hashOnlyVideos, "hash-only-videos", false, "Only hash videos; photos count as duplicates when name, size, and modification time match a backed up file")
+	rootCmd.Flags().StringVar(&checksumSampleStr, "checksum-sample", "", "Check files larger than twice this for duplicates by hashing only this much of each end plus the size (e.g. 64MB)")
+	rootCmd.Flags().StringArrayVar(&knownDBs, "known-db", nil, "Database of another backup whose files count as duplicates (repeatable; only read, never written)")
+	rootCmd.Flags().StringVar(&notifyURL, "notify-webhook", "", "POST a JSON summary of the run to this URL when the backup ends")
+	rootCmd.Flags().StringVar(&postCopyHookStr, "post-copy-hook", "", "Command to run after each copied file, with its source and destination paths appended (e.g. \"/usr/local/bin/upload.sh\")")
+	rootCmd.Flags().DurationVar(&postCopyHookTimeout, "post-copy-hook-timeout", time.Minute, "How long one --post-copy-hook run may take before it is killed (0 waits forever)")
+	rootCmd.Flags().BoolVar(&postCopyHookFatal, "post-copy-hook-fatal", false, "Stop the backup when --post-copy-hook fails, instead of only logging the failure")
+	rootCmd.Flags().StringVar(&notifyOn, "notify-on", engine.NotifyAlways, "When to call --notify-webhook: always, or error (only runs with errors or an interruption)")
+	rootCmd.Flags().StringVar(&rateLimitStr, "rate-limit", "", "Maximum copy speed to the destination across all workers (e.g. 20MB/s)")
+	rootCmd.Flags().StringVar(&dirModeStr, "dir-mode", "", "Permissions for folders the backup creates, in octal (e.g. 2775 to keep the group on shared drives)")
+	rootCmd.Flags().StringVar(&fileModeStr, "file-mode", "", "Permissions for stored files, in octal (e.g. 0664)")
+	rootCmd.Flags().BoolVar(&preserveOwner, "preserve-owner", false, "Give stored files the owner and group of their source (Linux/macOS, as root)")
+	rootCmd.Flags().IntVar(&copyRetries, "copy-retries", 0, "Try a failed copy this many more times before reporting it as an error (for flaky drives)")
+	rootCmd.Flags().DurationVar(&copyRetryDelay, "copy-retry-delay", engine.DefaultCopyRetryDelay, "Wait before the first copy retry; doubles for each retry after")
+	rootCmd.Flags().StringVar(&reserveStr, "reserve", "", "Free space to always leave on the destination, as a size (5GB) or a percentage of the disk (10%)")
+	rootCmd.Flags().BoolVar(&separateMedia, "separate-media", false, "Put photos and videos in their own top-level folders (Photos/2021-07, Videos/2021-07)")
+	rootCmd.Flags().BoolVar(&flat, "flat", false, "Put all files directly in the destination folder instead of date folders")
+	rootCmd.Flags().StringVar(&hashAlgo, "hash", engine.DefaultHashAlgorithm, "Content hash algorithm: md5, sha256, blake3, or xxhash")
+	rootCmd.Flags().StringVar(&collisionMode, "collision-mode", engine.CollisionRename, "What to do with a file whose name is taken by a different file: rename (add a content hash), source-subdir (put it in a subfolder named after the source), or skip")
+	rootCmd.Flags().StringVar(&dedupeMode, "dedupe-mode", engine.DefaultDedupeMode, "What to do with duplicates: skip, hardlink, or symlink (link to the stored copy in their own date folder)")
+	rootCmd.Flags().BoolVar(&move, "move", false, "Delete source files after they are copied and verified")
+	rootCmd.Flags().BoolVar(&purgeSourceDuplicates, "purge-duplicates-in-source", false, "Delete extra copies of the same file from the source once one copy is safely backed up")
+
+	var verifyDestDir, verifyDBPath, verifyReportPath string
+	var verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Check backup integrity against the database",
+		Long: `verify re-hashes every file recorded in the backup database and reports
+files whose content changed (bit rot, accidental edits), files that are missing,
+and media files in the destination that the database doesn't know about.
+
+Exits with status 1 if any mismatched or missing files are found.`,
+		Example: `  # Verify an existing backup
+  backupbozo verify --dest ~/backup_photos
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if verifyDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if verifyDBPath == "" {
+				verifyDBPath = filepath.Join(verifyDestDir, "backupbozo.db")
+			}
+			if verifyReportPath == "" {
+				reportsDir := filepath.Join(verifyDestDir, "reports")
+				if err := os.MkdirAll(reportsDir, 0755); err != nil {
+					log.Fatalf("[FATAL] Could not create reports directory: %v", err)
+				}
+				verifyReportPath = filepath.Join(reportsDir, fmt.Sprintf("verify_%s.html", time.Now().Format("20060102_150405")))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-interrupt
+				color.New(color.FgRed, color.Bold).Println("\nInterrupted. Exiting cleanly.")
+				cancel()
+			}()
+
+			intact, err := engine.VerifyBackup(ctx, verifyDestDir, verifyDBPath, verifyReportPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if !intact {
+				os.Exit(1)
+			}
+		},
+	}
+	verifyCmd.Flags().StringVarP(&verifyDestDir, "dest", "d", "", "Backup destination directory to verify")
+	verifyCmd.Flags().StringVar(&verifyDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	verifyCmd.Flags().StringVar(&verifyReportPath, "report", "", "Path to HTML verify report")
+	rootCmd.AddCommand(verifyCmd)
+
+	var pruneDestDir, pruneDBPath string
+	var pruneDryRun, pruneVacuum bool
+	var pruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove database entries for files deleted from the backup",
+		Long: `prune checks every file recorded in the backup database and removes the
+records whose destination file no longer exists. Without this, content you
+deleted from the backup by hand keeps being skipped as a duplicate.`,
+		Example: `  # See what would be removed
+  backupbozo prune --dest ~/backup_photos --dry-run
+
+  # Remove stale records
+  backupbozo prune --dest ~/backup_photos
+
+  # Remove stale records and compact the database file
+  backupbozo prune --dest ~/backup_photos --vacuum
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if pruneDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if pruneDBPath == "" {
+				pruneDBPath = filepath.Join(pruneDestDir, "backupbozo.db")
+			}
+			if _, err := engine.PruneDatabase(pruneDestDir, pruneDBPath, pruneDryRun, pruneVacuum, forceLock); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	pruneCmd.Flags().StringVarP(&pruneDestDir, "dest", "d", "", "Backup destination directory")
+	pruneCmd.Flags().StringVar(&pruneDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	pruneCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says a backup is using it")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "List stale records without removing them")
+	pruneCmd.Flags().BoolVar(&pruneVacuum, "vacuum", false, "Compact the database file after removing records")
+	rootCmd.AddCommand(pruneCmd)
+
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "Database maintenance",
+	}
+	var vacuumDestDir, vacuumDBPath string
+	var vacuumCmd = &cobra.Command{
+		Use:   "vacuum",
+		Short: "Compact the backup database file",
+		Long: `vacuum rebuilds the backup database without the free pages left behind by
+prune, rollback, and finished runs, and reports its size before and after.
+It needs free space for a temporary copy of the database.`,
+		Example: `  backupbozo db vacuum --dest ~/backup_photos
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if vacuumDestDir == "" && vacuumDBPath == "" {
+				log.Fatal("Destination directory or --db is required")
+			}
+			if vacuumDBPath == "" {
+				vacuumDBPath = filepath.Join(vacuumDestDir, "backupbozo.db")
+			}
+			if err := engine.VacuumDatabase(vacuumDBPath, forceLock); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	vacuumCmd.Flags().StringVarP(&vacuumDestDir, "dest", "d", "", "Backup destination directory")
+	vacuumCmd.Flags().StringVar(&vacuumDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	vacuumCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says a backup is using it")
+	dbCmd.AddCommand(vacuumCmd)
+
+	var versionDestDir, versionDBPath string
+	var versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Show the backup database's schema version",
+		Long: `version prints the schema version recorded in the backup database next to the
+one this build uses. Older databases are upgraded the next time a backup or any
+other command opens them for writing; newer ones are refused. Nothing is changed.`,
+		Example: `  backupbozo db version --dest ~/backup_photos
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if versionDestDir == "" && versionDBPath == "" {
+				log.Fatal("Destination directory or --db is required")
+			}
+			if versionDBPath == "" {
+				versionDBPath = filepath.Join(versionDestDir, "backupbozo.db")
+			}
+			if err := engine.ShowSchemaVersion(versionDBPath); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	versionCmd.Flags().StringVarP(&versionDestDir, "dest", "d", "", "Backup destination directory")
+	versionCmd.Flags().StringVar(&versionDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	dbCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(dbCmd)
+
+	var indexDestDir, indexDBPath, indexHashAlgo string
+	var indexCmd = &cobra.Command{
+		Use:   "index",
+		Short: "Record the files already in a destination without copying anything",
+		Long: `index hashes every media file in an existing destination and records it in
+the backup database, so a collection organized before you used backupbozo (or
+by another tool) counts for deduplication. Nothing is copied, moved, or renamed.
+
+Files already in the database are skipped, so index can be re-run after adding
+files by hand. Use the same --hash as your backups, or duplicates won't match.`,
+		Example: `  # Adopt an existing archive, then back up into it as usual
+  backupbozo index --dest ~/old_photo_archive
+  backupbozo --src ~/DCIM --dest ~/old_photo_archive
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if indexDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if err := engine.ValidateHashAlgorithm(indexHashAlgo); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] Invalid --hash: %v\n", err)
+				os.Exit(1)
+			}
+			if indexDBPath == "" {
+				indexDBPath = filepath.Join(indexDestDir, "backupbozo.db")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-interrupt
+				color.New(color.FgRed, color.Bold).Println("\nInterrupted. Exiting cleanly.")
+				cancel()
+			}()
+
+			if err := engine.IndexDestination(ctx, indexDestDir, indexDBPath, indexHashAlgo, forceLock); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	indexCmd.Flags().StringVarP(&indexDestDir, "dest", "d", "", "Existing backup or archive directory to index")
+	indexCmd.Flags().StringVar(&indexDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	indexCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says a backup is using it")
+	indexCmd.Flags().StringVar(&indexHashAlgo, "hash", engine.DefaultHashAlgorithm, "Content hash algorithm: md5, sha256, blake3, or xxhash (use the same one as your backups)")
+	rootCmd.AddCommand(indexCmd)
+
+	var whereDestDir, whereDBPath string
+	var whereCmd = &cobra.Command{
+		Use:   "where <hash | file>",
+		Short: "Show where a backed up file is stored and which source it came from",
+		Long: `where looks up files in the backup database by content hash (or the first
+few characters of one) and prints where each is stored, the source path it was
+copied from, and the run that copied it. Given a file instead, it hashes the
+file and finds its backed up copy.
+
+Exits with status 1 if nothing matches.`,
+		Example: `  # Trace a stored photo back to its source
+  backupbozo where 9e107d9d372bb682 --dest ~/backup_photos
+
+  # Is this photo backed up, and from where?
+  backupbozo where ~/Downloads/IMG_0001.jpg --dest ~/backup_photos
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if whereDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if whereDBPath == "" {
+				whereDBPath = filepath.Join(whereDestDir, "backupbozo.db")
+			}
+			found, err := engine.WhereBackedUp(whereDestDir, whereDBPath, args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if !found {
+				os.Exit(1)
+			}
+		},
+	}
+	whereCmd.Flags().StringVarP(&whereDestDir, "dest", "d", "", "Backup destination directory")
+	whereCmd.Flags().StringVar(&whereDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	rootCmd.AddCommand(whereCmd)
+
+	var diffDestA, diffDestB, diffDBPathA, diffDBPathB, diffReportPath string
+	var diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare two backups and list the files only one of them has",
+		Long: `diff compares the databases of two backup destinations by content hash and
+lists the files stored only in A, only in B, and in both, wherever each backup
+keeps them. Nothing is hashed or copied, so it is quick even for large backups;
+run verify first if you're unsure the databases match the files.
+
+An HTML report and a JSON report are written (by default in A's reports folder).
+Both backups must use the same --hash for their files to match.`,
+		Example: `  # What would be lost if the old drive were retired?
+  backupbozo diff --a /mnt/old_drive/photos --b ~/backup_photos
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if diffDestA == "" || diffDestB == "" {
+				log.Fatal("Both --a and --b are required")
+			}
+			if diffDBPathA == "" {
+				diffDBPathA = filepath.Join(diffDestA, "backupbozo.db")
+			}
+			if diffDBPathB == "" {
+				diffDBPathB = filepath.Join(diffDestB, "backupbozo.db")
+			}
+			if diffReportPath == "" {
+				// In A's reports folder
+				reportsDir := filepath.Join(diffDestA, "reports")
+				if err := os.MkdirAll(reportsDir, 0755); err != nil {
+					log.Fatalf("[FATAL] Could not create reports directory: %v", err)
+				}
+				diffReportPath = filepath.Join(reportsDir, fmt.Sprintf("diff_%s.html", time.Now().Format("20060102_150405")))
+			}
+			if err := engine.DiffBackups(diffDestA, diffDBPathA, diffDestB, diffDBPathB, diffReportPath); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	diffCmd.Flags().StringVar(&diffDestA, "a", "", "First backup destination directory")
+	diffCmd.Flags().StringVar(&diffDestB, "b", "", "Second backup destination directory")
+	diffCmd.Flags().StringVar(&diffDBPathA, "a-db", "", "Path to the first backup's database (default: a/backupbozo.db)")
+	diffCmd.Flags().StringVar(&diffDBPathB, "b-db", "", "Path to the second backup's database (default: b/backupbozo.db)")
+	diffCmd.Flags().StringVar(&diffReportPath, "report", "", "Path to HTML diff report; the JSON report is written next to it")
+	rootCmd.AddCommand(diffCmd)
+
+	var runsDestDir, runsDBPath string
+	var runsCmd = &cobra.Command{
+		Use:   "runs",
+		Short: "List backup runs that can be rolled back",
+		Example: `  backupbozo runs --dest ~/backup_photos
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if runsDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if runsDBPath == "" {
+				runsDBPath = filepath.Join(runsDestDir, "backupbozo.db")
+			}
+			if err := engine.ListRuns(runsDestDir, runsDBPath); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	runsCmd.Flags().StringVarP(&runsDestDir, "dest", "d", "", "Backup destination directory")
+	runsCmd.Flags().StringVar(&runsDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	rootCmd.AddCommand(runsCmd)
+
+	var rollbackDestDir, rollbackDBPath, rollbackRunID string
+	var rollbackDryRun bool
+	var rollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Undo a backup run by deleting the files it copied",
+		Long: `rollback deletes the destination files copied by one backup run and removes
+their database records. Without --run, the most recent run is rolled back.
+
+Files are kept if their content changed since the backup or if their source
+no longer exists (for example after --move), so a rollback never deletes the
+only copy of a photo. Duplicate links made with --dedupe-mode are not removed.`,
+		Example: `  # Undo the most recent backup
+  backupbozo rollback --dest ~/backup_photos
+
+  # Preview undoing a specific run (IDs come from 'backupbozo runs')
+  backupbozo rollback --dest ~/backup_photos --run 20240215_143025 --dry-run
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if rollbackDestDir == "" {
+				log.Fatal("Destination directory is required")
+			}
+			if rollbackDBPath == "" {
+				rollbackDBPath = filepath.Join(rollbackDestDir, "backupbozo.db")
+			}
+			if err := engine.RollbackRun(rollbackDestDir, rollbackDBPath, rollbackRunID, rollbackDryRun, forceLock); err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	rollbackCmd.Flags().StringVarP(&rollbackDestDir, "dest", "d", "", "Backup destination directory")
+	rollbackCmd.Flags().StringVar(&rollbackDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	rollbackCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says a backup is using it")
+	rollbackCmd.Flags().StringVar(&rollbackRunID, "run", "", "Run ID to undo (default: the most recent run)")
+	rollbackCmd.Flags().BoolVar(&rollbackDryRun, "dry-run", false, "List the files that would be removed without deleting anything")
+	rootCmd.AddCommand(rollbackCmd)
+
+	var restoreDestDir, restoreDBPath, restoreOutDir, restoreSinceStr, restoreUntilStr string
+	var restoreMirror, restoreDryRun bool
+	var restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Copy backed up files out of the backup by date",
+		Long: `restore looks up the files filed under a date range in the backup database and
+copies them to an output folder with their original modification time. Files
+are checked against their recorded hash, and files stored with --archive are
+extracted from their archive.
+
+By default every file lands directly in --out (same-named files get a hash
+suffix); --mirror keeps the backup's date folders. Files that already exist in
+--out are left alone, so an interrupted restore can simply be run again.
+
+Exits with status 1 if any file could not be restored.`,
+		Example: `  # Get back everything from summer 2023
+  backupbozo restore --dest ~/backup_photos --out ~/restored --since 2023-06-01 --until 2023-08-31
+
+  # Restore the whole backup with its date folders
+  backupbozo restore --dest ~/backup_photos --out /media/new_disk/photos --mirror
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if restoreDestDir == "" || restoreOutDir == "" {
+				log.Fatal("Destination and output directories are required")
+			}
+			since, err := parseDateFlag("since", restoreSinceStr, time.Local)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			until, err := parseDateFlag("until", restoreUntilStr, time.Local)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if !since.IsZero() && !until.IsZero() && until.Before(since) {
+				fmt.Fprintf(os.Stderr, "[FATAL] --until (%s) is before --since (%s)\n", restoreUntilStr, restoreSinceStr)
+				os.Exit(1)
+			}
+			if restoreDBPath == "" {
+				restoreDBPath = filepath.Join(restoreDestDir, "backupbozo.db")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-interrupt
+				color.New(color.FgRed, color.Bold).Println("\nInterrupted. Exiting cleanly.")
+				cancel()
+			}()
+
+			restored, err := engine.RestoreFiles(ctx, restoreDestDir, restoreDBPath, restoreOutDir, since, until, restoreMirror, restoreDryRun, forceLock)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[FATAL] %v\n", err)
+				os.Exit(1)
+			}
+			if !restored {
+				os.Exit(1)
+			}
+		},
+	}
+	restoreCmd.Flags().StringVarP(&restoreDestDir, "dest", "d", "", "Backup destination directory to restore from")
+	restoreCmd.Flags().StringVar(&restoreDBPath, "db", "", "Path to SQLite database (default: dest/backupbozo.db)")
+	restoreCmd.Flags().BoolVar(&forceLock, "force", false, "Run even if the database's lock file says a backup is using it")
+	restoreCmd.Flags().StringVarP(&restoreOutDir, "out", "o", "", "Folder to copy the restored files into")
+	restoreCmd.Flags().StringVar(&restoreSinceStr, "since", "", "Only restore files dated on or after this day (YYYY-MM-DD)")
+	restoreCmd.Flags().StringVar(&restoreUntilStr, "until", "", "Only restore files dated on or before this day (YYYY-MM-DD)")
+	restoreCmd.Flags().BoolVar(&restoreMirror, "mirror", false, "Keep the backup's folder layout instead of putting every file directly in --out")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "List the files that would be restored without copying anything")
+	rootCmd.AddCommand(restoreCmd)
+
+	// watch: keep backing up a source folder as new files arrive
+	var watchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Keep backing up new files as they appear in the source",
+		Long: `watch runs a normal backup, then waits for files to appear or change in the
+source and backs them up once they have stopped changing for --settle.
+Each batch of new files is a backup run of its own, with its own report.
+It takes every backup option; stop it with Ctrl+C.
+
+Changes are noticed through file system events. Network shares (NFS, SMB)
+don't report changes made by other machines, so they are scanned every
+--interval instead, as are sources given with --follow-symlinks or --poll.`,
+		Example: `  # Import a camera upload folder as photos arrive
+  backupbozo watch --src ~/CameraUploads --dest ~/backup_photos
+
+  # Wait for files to be unchanged for 30 seconds (for slow uploads)
+  backupbozo watch --src ~/CameraUploads --dest ~/backup_photos --settle 30s
+
+  # A share mounted in a way that isn't recognized as one: scan it every minute
+  backupbozo watch --src /mnt/share/uploads --dest ~/backup_photos --poll --interval 1m
+`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if watch.Interval <= 0 || watch.Settle < 0 {
+				fmt.Fprintln(os.Stderr, "[FATAL] --interval must be positive and --settle can't be negative")
+				os.Exit(1)
+			}
+			watching = true
+			rootCmd.Run(cmd, args)
+		},
+	}
+	// The backup flags are shared, so they set the same variables for watch as for a plain backup
+	watchCmd.Flags().AddFlagSet(rootCmd.Flags())
+	watchCmd.Flags().DurationVar(&watch.Settle, "settle", engine.DefaultWatchSettle, "How long a new file's size and modification time must stay unchanged before it is backed up")
+	watchCmd.Flags().BoolVar(&watch.Poll, "poll", false, "Scan the source every --interval instead of waiting for file system events (for network shares that aren't recognized as such)")
+	watchCmd.Flags().DurationVar(&watch.Interval, "interval", engine.DefaultWatchInterval, "How often to scan the source when it is polled (network shares, --follow-symlinks, --poll)")
+	rootCmd.AddCommand(watchCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,23 @@
+//go:build darwin || freebsd || netbsd
+
+package metadata
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileBirthTime returns when a file was created (macOS/BSD implementation)
+func fileBirthTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || (stat.Birthtimespec.Sec == 0 && stat.Birthtimespec.Nsec == 0) {
+		return time.Time{}, errors.New("filesystem does not record birth time")
+	}
+	return time.Unix(int64(stat.Birthtimespec.Sec), int64(stat.Birthtimespec.Nsec)), nil
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package metadata
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileBirthTime returns when a file was created, via statx (Linux 4.11+; needs a filesystem
+// that records it, such as ext4, btrfs, or XFS)
+func fileBirthTime(path string) (time.Time, error) {
+	var stat unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BTIME, &stat); err != nil {
+		return time.Time{}, err
+	}
+	if stat.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, errors.New("filesystem does not record birth time")
+	}
+	return time.Unix(stat.Btime.Sec, int64(stat.Btime.Nsec)), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package metadata
+
+import (
+	"errors"
+	"time"
+)
+
+// fileBirthTime is not supported where the OS doesn't expose creation times
+func fileBirthTime(path string) (time.Time, error) {
+	return time.Time{}, errors.New("birth time is not available on this system")
+}
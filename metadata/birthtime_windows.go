@@ -0,0 +1,23 @@
+//go:build windows
+
+package metadata
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileBirthTime returns when a file was created (Windows implementation)
+func fileBirthTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, errors.New("filesystem does not record birth time")
+	}
+	return time.Unix(0, attrs.CreationTime.Nanoseconds()), nil
+}
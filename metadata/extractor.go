@@ -2,11 +2,19 @@
 package metadata
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +28,18 @@ type MetadataResult struct {
 	Source     string        // Where the date came from (e.g., "EXIF DateTimeOriginal")
 	Error      error         // Any error during extraction
 	Duration   time.Duration // Time taken to extract (for performance monitoring)
+	Camera     string        // Camera or device that made the file (e.g., "Apple iPhone 12"), "" if unknown
+	Location   *Location     // Where a photo was taken, from EXIF GPS tags; nil if unknown
+	BurstID    string        // Shared by every frame of one burst (iPhone BurstUUID), "" if not a burst
+	// Floating dates are a wall-clock time recorded without a zone (EXIF, file names) rather
+	// than an instant (MP4 headers, file times): they are read as being in the registry's timezone
+	Floating bool
+}
+
+// Location is a position in decimal degrees (north and east are positive)
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 // Confidence represents how reliable the extracted date is
@@ -62,6 +82,7 @@ type MetadataExtractor interface {
 // ExtractorRegistry manages multiple metadata extractors
 type ExtractorRegistry struct {
 	extractors []MetadataExtractor
+	timezone   *time.Location // Zone dates are returned in; nil leaves them as extracted
 }
 
 // NewExtractorRegistry creates a registry with all available extractors
@@ -69,19 +90,109 @@ func NewExtractorRegistry() *ExtractorRegistry {
 	return &ExtractorRegistry{
 		extractors: []MetadataExtractor{
 			&EXIFExtractor{},
-			&VideoExtractor{},
+			&MP4Extractor{}, // Pure Go, tried before spawning ffprobe
+			&VideoExtractor{Timeout: DefaultFFprobeTimeout},
 			&PNGExtractor{},
+			&FilenameExtractor{},   // Dates embedded in names, when the file itself has none
 			&FilesystemExtractor{}, // Always last as fallback
 		},
 	}
 }
 
+// Date sources for NewExtractorRegistryFor
+const (
+	DateSourceAuto     = "auto"     // Every extractor, the most reliable date winning
+	DateSourceEXIF     = "exif"     // Photo metadata: EXIF, and PNG's own date fields
+	DateSourceFFprobe  = "ffprobe"  // Video metadata: MP4/MOV headers, then ffprobe
+	DateSourceMtime    = "mtime"    // File modification time
+	DateSourceFilename = "filename" // Dates embedded in file names
+	// File creation time, where the OS and filesystem record one. Not part of auto: copying a
+	// file usually gives it a new birth time while keeping its modification time
+	DateSourceBirthtime = "birthtime"
+)
+
+// NewExtractorRegistryFor creates a registry that only reads dates from one kind of source
+// ("auto" is NewExtractorRegistry). Files the source says nothing about get no date
+func NewExtractorRegistryFor(source string) (*ExtractorRegistry, error) {
+	var extractors []MetadataExtractor
+	switch source {
+	case DateSourceAuto:
+		return NewExtractorRegistry(), nil
+	case DateSourceEXIF:
+		extractors = []MetadataExtractor{&EXIFExtractor{}, &PNGExtractor{}}
+	case DateSourceFFprobe:
+		extractors = []MetadataExtractor{&MP4Extractor{}, &VideoExtractor{Timeout: DefaultFFprobeTimeout}}
+	case DateSourceMtime:
+		extractors = []MetadataExtractor{&FilesystemExtractor{}}
+	case DateSourceFilename:
+		extractors = []MetadataExtractor{&FilenameExtractor{}}
+	case DateSourceBirthtime:
+		extractors = []MetadataExtractor{&BirthtimeExtractor{}}
+	default:
+		return nil, fmt.Errorf("unknown date source %q (use auto, exif, ffprobe, mtime, filename, or birthtime)", source)
+	}
+	return &ExtractorRegistry{extractors: extractors}, nil
+}
+
+// NormalizeExt returns a file's extension in lower case, so IMG_0001.JPG, clip.MP4, and
+// photo.JPG.jpg all match the extension tables. Trailing spaces and dots, which some tools
+// leave on names (Windows hides them), are ignored. macOS AppleDouble files ("._IMG_0001.JPG",
+// left on FAT and exFAT drives) only hold Finder metadata, so they have no media extension
+func NormalizeExt(path string) string {
+	name := strings.TrimRight(filepath.Base(path), " .")
+	if strings.HasPrefix(name, "._") {
+		return ""
+	}
+	return strings.ToLower(filepath.Ext(name))
+}
+
+// SetFFprobeTimeout sets how long ffprobe may run on one file before it is killed (0 = no limit)
+func (r *ExtractorRegistry) SetFFprobeTimeout(timeout time.Duration) {
+	for _, extractor := range r.extractors {
+		if video, ok := extractor.(*VideoExtractor); ok {
+			video.Timeout = timeout
+		}
+	}
+}
+
+// SetTimezone makes ExtractBestDate return every date in loc, so a date's day and month are
+// the same whichever source it came from
+func (r *ExtractorRegistry) SetTimezone(loc *time.Location) {
+	r.timezone = loc
+}
+
+// InTimezone returns a date in loc: an instant is converted, while a floating date keeps its
+// wall-clock time and is taken to have happened in loc
+func InTimezone(date time.Time, floating bool, loc *time.Location) time.Time {
+	if date.IsZero() || loc == nil {
+		return date
+	}
+	if floating {
+		return time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), loc)
+	}
+	return date.In(loc)
+}
+
+// parseDate parses a date string; a layout without a zone gives a floating date
+func parseDate(layout, value string) (time.Time, bool, error) {
+	date, err := time.Parse(layout, value)
+	floating := !strings.Contains(layout, "Z07") && !strings.Contains(layout, "-07") && !strings.Contains(layout, "MST")
+	return date, floating, err
+}
+
+// ErrTimeout is wrapped by the error of an extractor that gave up on a file (e.g. ffprobe hanging
+// on a corrupt video). ExtractBestDate returns such a result as is, without trying other sources
+var ErrTimeout = errors.New("timed out")
+
 // ExtractBestDate tries all extractors and returns the best date found
 func (r *ExtractorRegistry) ExtractBestDate(path string) MetadataResult {
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := NormalizeExt(path)
 
 	var bestResult MetadataResult
 	bestResult.Confidence = ConfidenceNone
+	camera := "" // Kept from any extractor, even one whose date lost
+	var location *Location
+	burstID := ""
 
 	start := time.Now()
 	defer func() {
@@ -97,6 +208,19 @@ func (r *ExtractorRegistry) ExtractBestDate(path string) MetadataResult {
 		}
 
 		result := extractor.ExtractDate(path)
+		if errors.Is(result.Error, ErrTimeout) {
+			bestResult = result
+			return bestResult
+		}
+		if camera == "" {
+			camera = result.Camera
+		}
+		if location == nil {
+			location = result.Location
+		}
+		if burstID == "" {
+			burstID = result.BurstID
+		}
 
 		// Use this result if it's better than what we have
 		if result.Confidence > bestResult.Confidence ||
@@ -111,10 +235,35 @@ func (r *ExtractorRegistry) ExtractBestDate(path string) MetadataResult {
 	}
 
 	bestResult.Duration = time.Since(start)
+	if bestResult.Camera == "" {
+		bestResult.Camera = camera
+	}
+	if bestResult.Location == nil {
+		bestResult.Location = location
+	}
+	if bestResult.BurstID == "" {
+		bestResult.BurstID = burstID
+	}
+	bestResult.Date = InTimezone(bestResult.Date, bestResult.Floating, r.timezone)
 	return bestResult
 }
 
-// EXIFExtractor handles JPEG and HEIC files with comprehensive EXIF date extraction
+// cameraName joins a maker and model into one name, without repeating the make
+// ("Canon" + "Canon EOS 5D" is "Canon EOS 5D"); NUL padding and spaces are trimmed
+func cameraName(maker, model string) string {
+	maker = strings.TrimSpace(strings.Trim(maker, "\x00"))
+	model = strings.TrimSpace(strings.Trim(model, "\x00"))
+	switch {
+	case model == "":
+		return maker
+	case maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)):
+		return model
+	default:
+		return maker + " " + model
+	}
+}
+
+// EXIFExtractor handles JPEG, HEIC, and camera RAW files with comprehensive EXIF date extraction
 type EXIFExtractor struct{}
 
 func (e *EXIFExtractor) Name() string {
@@ -125,11 +274,69 @@ func (e *EXIFExtractor) CanHandle(extension string) bool {
 	switch extension {
 	case ".jpg", ".jpeg", ".heic", ".heif":
 		return true
+	case ".tif", ".tiff", ".webp": // TIFF is EXIF's own container; WebP carries an EXIF chunk
+		return true
+	case ".cr2", ".nef", ".arw", ".dng", ".orf", ".raf": // Camera RAW formats
+		return true
 	default:
 		return false
 	}
 }
 
+// rawEXIFReader returns a reader positioned at EXIF data that goexif can decode
+// CR2, NEF, ARW, DNG, and TIFF are plain TIFF files, but Olympus ORF uses a custom TIFF
+// magic number, Fujifilm RAF wraps a JPEG preview that carries the EXIF block, WebP stores
+// EXIF in a RIFF chunk, and HEIC/HEIF keep it as an item of their ISO base media meta box
+func rawEXIFReader(f *os.File, extension string) (io.Reader, error) {
+	switch extension {
+	case ".orf":
+		// ORF headers are "IIRO"/"IIRS" (or "MMOR"); rewrite them to standard TIFF magic
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, fmt.Errorf("failed to read ORF header: %w", err)
+		}
+		switch string(header[:2]) {
+		case "II":
+			copy(header, "II*\x00")
+		case "MM":
+			copy(header, "MM\x00*")
+		default:
+			return nil, fmt.Errorf("unrecognized ORF byte order %q", header[:2])
+		}
+		return io.MultiReader(bytes.NewReader(header), f), nil
+
+	case ".raf":
+		// RAF header stores the embedded JPEG offset and length as big-endian uint32s at byte 84
+		header := make([]byte, 92)
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, fmt.Errorf("failed to read RAF header: %w", err)
+		}
+		if !bytes.HasPrefix(header, []byte("FUJIFILMCCD-RAW")) {
+			return nil, fmt.Errorf("not a Fujifilm RAF file")
+		}
+		offset := int64(binary.BigEndian.Uint32(header[84:88]))
+		length := int64(binary.BigEndian.Uint32(header[88:92]))
+		return io.NewSectionReader(f, offset, length), nil
+
+	case ".webp":
+		data, err := webpEXIFChunk(f)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+
+	case ".heic", ".heif":
+		data, err := heicEXIFItem(f)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+
+	default:
+		return f, nil
+	}
+}
+
 func (e *EXIFExtractor) ExtractDate(path string) MetadataResult {
 	start := time.Now()
 
@@ -144,8 +351,18 @@ func (e *EXIFExtractor) ExtractDate(path string) MetadataResult {
 	}
 	defer f.Close()
 
+	r, err := rawEXIFReader(f, NormalizeExt(path))
+	if err != nil {
+		return MetadataResult{
+			Confidence: ConfidenceNone,
+			Source:     "EXIF",
+			Error:      err,
+			Duration:   time.Since(start),
+		}
+	}
+
 	// Decode EXIF data
-	x, err := exif.Decode(f)
+	x, err := exif.Decode(r)
 	if err != nil {
 		return MetadataResult{
 			Confidence: ConfidenceNone,
@@ -155,6 +372,113 @@ func (e *EXIFExtractor) ExtractDate(path string) MetadataResult {
 		}
 	}
 
+	camera, location, burstID := exifCamera(x), exifLocation(x), exifBurstID(x)
+	if date, source, floating, ok := exifDate(x); ok {
+		return MetadataResult{
+			Date:       date,
+			Confidence: ConfidenceHigh,
+			Source:     source,
+			Duration:   time.Since(start),
+			Camera:     camera,
+			Location:   location,
+			BurstID:    burstID,
+			Floating:   floating,
+		}
+	}
+
+	return MetadataResult{
+		Confidence: ConfidenceNone,
+		Source:     "EXIF",
+		Error:      fmt.Errorf("no valid date fields found in EXIF"),
+		Duration:   time.Since(start),
+		Camera:     camera,
+		Location:   location,
+		BurstID:    burstID,
+	}
+}
+
+// appleMakerNoteHeader starts the maker note iPhones write. A byte order mark follows at
+// offset 12 and the IFD at 14; offsets in the IFD count from the start of the maker note
+const appleMakerNoteHeader = "Apple iOS\x00"
+
+// appleBurstUUIDTag is the Apple maker note tag holding the identifier shared by a burst's frames
+const appleBurstUUIDTag = 0x000b
+
+// exifBurstID returns the burst identifier in an iPhone maker note, or ""
+// Other makers' bursts carry no common identifier, so they are not grouped
+func exifBurstID(x *exif.Exif) string {
+	tag, err := x.Get(exif.MakerNote)
+	if err != nil {
+		return ""
+	}
+	return appleMakerNoteString(tag.Val, appleBurstUUIDTag)
+}
+
+// appleMakerNoteString reads an ASCII tag from an Apple maker note, "" if it is missing or malformed
+func appleMakerNoteString(note []byte, id uint16) string {
+	if len(note) < 16 || !bytes.HasPrefix(note, []byte(appleMakerNoteHeader)) {
+		return ""
+	}
+	var order binary.ByteOrder
+	switch string(note[12:14]) {
+	case "MM":
+		order = binary.BigEndian
+	case "II":
+		order = binary.LittleEndian
+	default:
+		return ""
+	}
+
+	count := int(order.Uint16(note[14:16]))
+	for i := 0; i < count; i++ {
+		entry := 16 + i*12
+		if entry+12 > len(note) {
+			return ""
+		}
+		if order.Uint16(note[entry:]) != id || order.Uint16(note[entry+2:]) != 2 { // 2 = ASCII
+			continue
+		}
+		size := int64(order.Uint32(note[entry+4:]))
+		var value []byte
+		if size <= 4 {
+			value = note[entry+8 : entry+8+int(size)]
+		} else {
+			offset := int64(order.Uint32(note[entry+8:]))
+			if offset+size > int64(len(note)) {
+				return ""
+			}
+			value = note[offset : offset+size]
+		}
+		return strings.TrimSpace(strings.Trim(string(value), "\x00"))
+	}
+	return ""
+}
+
+// exifLocation returns the position in the EXIF GPS tags, or nil
+// 0,0 is what some cameras write without a GPS fix, so it counts as unknown
+func exifLocation(x *exif.Exif) *Location {
+	lat, long, err := x.LatLong()
+	if err != nil || math.IsNaN(lat) || math.IsNaN(long) || lat < -90 || lat > 90 || long < -180 || long > 180 || (lat == 0 && long == 0) {
+		return nil
+	}
+	return &Location{Latitude: lat, Longitude: long}
+}
+
+// exifCamera returns the camera named by the EXIF Make and Model tags, or ""
+func exifCamera(x *exif.Exif) string {
+	var maker, model string
+	if tag, err := x.Get(exif.Make); err == nil {
+		maker, _ = tag.StringVal()
+	}
+	if tag, err := x.Get(exif.Model); err == nil {
+		model, _ = tag.StringVal()
+	}
+	return cameraName(maker, model)
+}
+
+// exifDate returns the most reliable date in decoded EXIF data, the field it came from, and
+// whether it is floating (EXIF dates are camera clock time unless a maker note gives the zone)
+func exifDate(x *exif.Exif) (time.Time, string, bool, bool) {
 	// Try EXIF date fields in order of preference (most reliable first)
 	dateFields := []struct {
 		field  exif.FieldName
@@ -170,12 +494,7 @@ func (e *EXIFExtractor) ExtractDate(path string) MetadataResult {
 			if dateStr, err := tag.StringVal(); err == nil {
 				// Parse EXIF date format: "2006:01:02 15:04:05"
 				if date, err := time.Parse("2006:01:02 15:04:05", dateStr); err == nil {
-					return MetadataResult{
-						Date:       date,
-						Confidence: ConfidenceHigh,
-						Source:     field.source,
-						Duration:   time.Since(start),
-					}
+					return date, field.source, true, true
 				}
 			}
 		}
@@ -183,24 +502,467 @@ func (e *EXIFExtractor) ExtractDate(path string) MetadataResult {
 
 	// Try the legacy DateTime() method as fallback
 	if dt, err := x.DateTime(); err == nil {
+		zone, _ := x.TimeZone()
+		return dt, "EXIF DateTime (legacy)", zone == nil, true
+	}
+	return time.Time{}, "", false, false
+}
+
+// maxMetadataChunk caps the size of a PNG or WebP metadata chunk that is read into memory; chunk
+// lengths come from the file, and real EXIF, XMP, and text chunks are far smaller
+const maxMetadataChunk = 4 << 20
+
+// webpEXIFChunk returns the TIFF data of a WebP file's EXIF chunk
+func webpEXIFChunk(f io.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, fmt.Errorf("failed to read WebP header: %w", err)
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file")
+	}
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return nil, fmt.Errorf("no EXIF chunk in WebP file")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		size += size & 1 // Chunks are padded to an even length
+		if string(chunk[:4]) != "EXIF" {
+			if _, err := io.CopyN(io.Discard, f, size); err != nil {
+				return nil, fmt.Errorf("no EXIF chunk in WebP file")
+			}
+			continue
+		}
+		if size > maxMetadataChunk {
+			return nil, fmt.Errorf("WebP EXIF chunk too large to read (%d bytes)", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, fmt.Errorf("failed to read WebP EXIF chunk: %w", err)
+		}
+		// Some writers keep the JPEG APP1 "Exif\0\0" prefix
+		return bytes.TrimPrefix(data, []byte("Exif\x00\x00")), nil
+	}
+}
+
+// heicEXIFItem returns the TIFF data of a HEIC/HEIF file's EXIF item
+// The top-level meta box names each item in iinf and says where its bytes are in iloc; the item
+// of type "Exif" starts with the offset of the TIFF header within it
+func heicEXIFItem(f *os.File) ([]byte, error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	metaStart, metaEnd, err := findAtom(f, "meta", 0, end)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readAtomBytes(f, metaStart, metaEnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, fmt.Errorf("truncated meta box")
+	}
+	boxes := meta[4:] // After the version and flags
+
+	itemID, ok := heicEXIFItemID(childBox(boxes, "iinf"))
+	if !ok {
+		return nil, fmt.Errorf("no EXIF item in HEIC file")
+	}
+	offset, length, ok := heicItemExtent(childBox(boxes, "iloc"), itemID)
+	if !ok || length < 4 || offset > uint64(end) || length > uint64(end)-offset {
+		return nil, fmt.Errorf("invalid HEIC EXIF item location")
+	}
+	if length > 1<<20 {
+		return nil, fmt.Errorf("HEIC EXIF item too large to read (%d bytes)", length)
+	}
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read HEIC EXIF item: %w", err)
+	}
+	tiffOffset := uint64(binary.BigEndian.Uint32(data[:4]))
+	if 4+tiffOffset >= length {
+		return nil, fmt.Errorf("invalid HEIC EXIF header offset %d", tiffOffset)
+	}
+	return data[4+tiffOffset:], nil
+}
+
+// childBox returns the payload of the first box of the given type in data, or nil
+func childBox(data []byte, boxType string) []byte {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		headerLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return nil
+		}
+		if string(data[4:8]) == boxType {
+			return data[headerLen:size]
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// heicEXIFItemID finds the item of type "Exif" in an iinf payload
+func heicEXIFItemID(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:] // Version, flags, and a 16-bit entry count
+	if iinf[0] != 0 {
+		entries = iinf[8:] // The count is 32-bit from version 1
+	}
+	for len(entries) >= 8 {
+		size := binary.BigEndian.Uint32(entries[0:4])
+		if size < 8 || uint64(size) > uint64(len(entries)) {
+			return 0, false
+		}
+		boxType, infe := string(entries[4:8]), entries[8:size]
+		entries = entries[size:]
+		if boxType != "infe" {
+			continue
+		}
+		// infe version 2 has a 16-bit item ID and version 3 a 32-bit one, each followed by a
+		// 16-bit protection index and the item type; older versions have no item type
+		switch {
+		case len(infe) >= 12 && infe[0] == 2:
+			if string(infe[8:12]) == "Exif" {
+				return uint32(binary.BigEndian.Uint16(infe[4:6])), true
+			}
+		case len(infe) >= 14 && infe[0] == 3:
+			if string(infe[10:14]) == "Exif" {
+				return binary.BigEndian.Uint32(infe[4:8]), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// heicItemExtent returns the file offset and length of an item's data from an iloc payload
+// Only items stored as one extent in the file itself (construction method 0) are supported,
+// which is how HEIC writers store the EXIF item
+func heicItemExtent(iloc []byte, itemID uint32) (uint64, uint64, bool) {
+	if len(iloc) < 8 {
+		return 0, 0, false
+	}
+	version := iloc[0]
+	offsetSize, lengthSize := int(iloc[4]>>4), int(iloc[4]&0x0f)
+	baseOffsetSize, indexSize := int(iloc[5]>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0f)
+	}
+	r := &boxReader{data: iloc[6:], ok: true}
+	itemCount := r.uint(2)
+	if version == 2 {
+		itemCount = r.uint(4)
+	}
+	for i := uint64(0); i < itemCount && r.ok; i++ {
+		id := r.uint(2)
+		if version == 2 {
+			id = r.uint(4)
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0x0f
+		}
+		r.uint(2) // Data reference index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+		var offset, length uint64
+		for e := uint64(0); e < extentCount && r.ok; e++ {
+			r.uint(indexSize)
+			offset, length = r.uint(offsetSize), r.uint(lengthSize)
+		}
+		if r.ok && id == uint64(itemID) {
+			if method != 0 || extentCount != 1 {
+				return 0, 0, false
+			}
+			return baseOffset + offset, length, true
+		}
+	}
+	return 0, 0, false
+}
+
+// boxReader reads big-endian fields of a box payload; ok turns false once a field runs past the end
+type boxReader struct {
+	data []byte
+	ok   bool
+}
+
+// uint reads an unsigned field of 0, 2, 4, or 8 bytes; a 0-byte field (absent in iloc) is 0
+func (r *boxReader) uint(size int) uint64 {
+	if size != 0 && size != 2 && size != 4 && size != 8 || size > len(r.data) {
+		r.ok = false
+	}
+	if !r.ok {
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return v
+}
+
+// MP4Extractor reads the movie header (mvhd) creation time from MP4/MOV files without ffprobe
+type MP4Extractor struct{}
+
+func (m *MP4Extractor) Name() string {
+	return "MP4"
+}
+
+func (m *MP4Extractor) CanHandle(extension string) bool {
+	return extension == ".mp4" || extension == ".mov"
+}
+
+// mp4Epoch is the zero point of MP4/QuickTime timestamps
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (m *MP4Extractor) ExtractDate(path string) MetadataResult {
+	start := time.Now()
+
+	f, err := os.Open(path)
+	if err != nil {
 		return MetadataResult{
-			Date:       dt,
-			Confidence: ConfidenceHigh,
-			Source:     "EXIF DateTime (legacy)",
+			Confidence: ConfidenceNone,
+			Source:     "MP4 mvhd",
+			Error:      fmt.Errorf("failed to open video: %w", err),
 			Duration:   time.Since(start),
 		}
 	}
+	defer f.Close()
+
+	camera := readMP4Camera(f)
+	date, err := readMvhdCreationTime(f)
+	if err != nil {
+		return MetadataResult{
+			Confidence: ConfidenceNone,
+			Source:     "MP4 mvhd",
+			Error:      err,
+			Duration:   time.Since(start),
+			Camera:     camera,
+		}
+	}
 
 	return MetadataResult{
-		Confidence: ConfidenceNone,
-		Source:     "EXIF",
-		Error:      fmt.Errorf("no valid date fields found in EXIF"),
+		Date:       date,
+		Confidence: ConfidenceHigh,
+		Source:     "MP4 mvhd creation time",
 		Duration:   time.Since(start),
+		Camera:     camera,
+	}
+}
+
+// readMP4Camera returns the recording device named in a movie's metadata, or ""
+// Phones write Apple-style metadata (moov > meta > keys/ilst with com.apple.quicktime.make/model);
+// cameras often use QuickTime user data (moov > udta > ©mak/©mod)
+func readMP4Camera(r io.ReadSeeker) string {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ""
+	}
+	moovStart, moovEnd, err := findAtom(r, "moov", 0, end)
+	if err != nil {
+		return ""
+	}
+	if metaStart, metaEnd, err := findAtom(r, "meta", moovStart, moovEnd); err == nil {
+		values := readMP4MetaValues(r, metaStart, metaEnd)
+		if camera := cameraName(values["com.apple.quicktime.make"], values["com.apple.quicktime.model"]); camera != "" {
+			return camera
+		}
+	}
+	if udtaStart, udtaEnd, err := findAtom(r, "udta", moovStart, moovEnd); err == nil {
+		return cameraName(readUdtaString(r, "\xa9mak", udtaStart, udtaEnd), readUdtaString(r, "\xa9mod", udtaStart, udtaEnd))
 	}
+	return ""
 }
 
+// readMP4MetaValues reads the string values of a meta atom's keys/ilst pair, keyed by name
+func readMP4MetaValues(r io.ReadSeeker, start, end int64) map[string]string {
+	values := make(map[string]string)
+	// ISO meta atoms start with version and flags; QuickTime ones go straight to their children
+	if probe, err := readAtomBytes(r, start, start+8); err == nil && string(probe[4:8]) != "hdlr" {
+		start += 4
+	}
+	keysStart, keysEnd, err := findAtom(r, "keys", start, end)
+	if err != nil {
+		return values
+	}
+	keys, err := readAtomBytes(r, keysStart, keysEnd)
+	if err != nil || len(keys) < 8 {
+		return values
+	}
+	// keys: version/flags, entry count, then entries of size, namespace, and name
+	var names []string
+	for pos := 8; pos+8 <= len(keys); {
+		size := int(binary.BigEndian.Uint32(keys[pos : pos+4]))
+		if size < 8 || pos+size > len(keys) {
+			break
+		}
+		names = append(names, string(keys[pos+8:pos+size]))
+		pos += size
+	}
+
+	ilstStart, ilstEnd, err := findAtom(r, "ilst", start, end)
+	if err != nil {
+		return values
+	}
+	ilst, err := readAtomBytes(r, ilstStart, ilstEnd)
+	if err != nil {
+		return values
+	}
+	// ilst: one atom per value whose type is the 1-based key index, holding a data atom
+	// (size, "data", type, locale, value)
+	for pos := 0; pos+8 <= len(ilst); {
+		size := int(binary.BigEndian.Uint32(ilst[pos : pos+4]))
+		if size < 8 || pos+size > len(ilst) {
+			break
+		}
+		index := int(binary.BigEndian.Uint32(ilst[pos+4 : pos+8]))
+		item := ilst[pos+8 : pos+size]
+		if index >= 1 && index <= len(names) && len(item) >= 16 && string(item[4:8]) == "data" {
+			dataSize := int(binary.BigEndian.Uint32(item[0:4]))
+			if dataSize >= 16 && dataSize <= len(item) {
+				values[names[index-1]] = string(item[16:dataSize])
+			}
+		}
+		pos += size
+	}
+	return values
+}
+
+// readUdtaString reads a QuickTime user data text atom (length, language, text), or ""
+func readUdtaString(r io.ReadSeeker, atomType string, start, end int64) string {
+	atomStart, atomEnd, err := findAtom(r, atomType, start, end)
+	if err != nil {
+		return ""
+	}
+	data, err := readAtomBytes(r, atomStart, atomEnd)
+	if err != nil || len(data) < 4 {
+		return ""
+	}
+	length := int(binary.BigEndian.Uint16(data[0:2]))
+	if 4+length > len(data) {
+		length = len(data) - 4
+	}
+	return string(data[4 : 4+length])
+}
+
+// readAtomBytes reads an atom payload into memory; metadata atoms are small, media data is never read
+func readAtomBytes(r io.ReadSeeker, start, end int64) ([]byte, error) {
+	if end-start > 1<<20 {
+		return nil, fmt.Errorf("atom too large to read (%d bytes)", end-start)
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readMvhdCreationTime walks the atom tree (moov > mvhd) and returns the movie creation time
+// Encoders that leave the field unset write 0, which is reported as an error so ffprobe gets a try
+func readMvhdCreationTime(r io.ReadSeeker) (time.Time, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	moovStart, moovEnd, err := findAtom(r, "moov", 0, end)
+	if err != nil {
+		return time.Time{}, err
+	}
+	mvhdStart, _, err := findAtom(r, "mvhd", moovStart, moovEnd)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// mvhd: version (1 byte), flags (3 bytes), then creation time (32-bit in v0, 64-bit in v1)
+	header := make([]byte, 12)
+	if _, err := r.Seek(mvhdStart, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return time.Time{}, fmt.Errorf("truncated mvhd atom: %w", err)
+	}
+
+	var seconds uint64
+	if header[0] == 1 {
+		seconds = binary.BigEndian.Uint64(header[4:12])
+	} else {
+		seconds = uint64(binary.BigEndian.Uint32(header[4:8]))
+	}
+	if seconds == 0 {
+		return time.Time{}, fmt.Errorf("mvhd creation time not set")
+	}
+
+	date := mp4Epoch.Add(time.Duration(seconds) * time.Second)
+	if date.Year() < 1970 || date.After(time.Now().AddDate(1, 0, 0)) {
+		return time.Time{}, fmt.Errorf("implausible mvhd creation time %s", date.Format(time.RFC3339))
+	}
+	return date, nil
+}
+
+// findAtom scans sibling atoms between start and end for the given type
+// Returns the byte range of the atom's payload (after its header)
+func findAtom(r io.ReadSeeker, atomType string, start, end int64) (int64, int64, error) {
+	header := make([]byte, 16)
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return 0, 0, fmt.Errorf("failed to read atom header: %w", err)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerLen := int64(8)
+		switch size {
+		case 0:
+			size = end - pos // Atom extends to the end of its parent
+		case 1:
+			// 64-bit size follows the type
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, 0, fmt.Errorf("failed to read atom size: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || pos+size > end {
+			return 0, 0, fmt.Errorf("invalid %q atom size %d", string(header[4:8]), size)
+		}
+
+		if string(header[4:8]) == atomType {
+			return pos + headerLen, pos + size, nil
+		}
+		pos += size
+	}
+	return 0, 0, fmt.Errorf("no %s atom found", atomType)
+}
+
+// DefaultFFprobeTimeout is how long ffprobe may take on one file unless a registry sets otherwise
+const DefaultFFprobeTimeout = 30 * time.Second
+
 // VideoExtractor handles video files using ffprobe with multiple fallback strategies
-type VideoExtractor struct{}
+type VideoExtractor struct {
+	Timeout time.Duration // Kill ffprobe after this long; 0 means no limit
+}
 
 func (v *VideoExtractor) Name() string {
 	return "Video"
@@ -219,8 +981,24 @@ func (v *VideoExtractor) ExtractDate(path string) MetadataResult {
 	start := time.Now()
 
 	// Use ffprobe to extract all metadata (not just format)
-	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
+	// A corrupt file can make ffprobe hang, so it is killed once the timeout passes
+	ctx := context.Background()
+	if v.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.WaitDelay = time.Second // Don't wait on pipes held open by anything ffprobe started
 	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return MetadataResult{
+			Confidence: ConfidenceNone,
+			Source:     "ffprobe",
+			Error:      fmt.Errorf("ffprobe gave up after %s (corrupt video?): %w", v.Timeout, ErrTimeout),
+			Duration:   time.Since(start),
+		}
+	}
 	if err != nil {
 		return MetadataResult{
 			Confidence: ConfidenceNone,
@@ -272,6 +1050,16 @@ func (v *VideoExtractor) ExtractDate(path string) MetadataResult {
 		}},
 	}
 
+	// Phones and cameras name themselves in format tags (Apple, Android, and generic keys)
+	tags := data.Format.Tags
+	camera := cameraName(tags["com.apple.quicktime.make"], tags["com.apple.quicktime.model"])
+	if camera == "" {
+		camera = cameraName(tags["com.android.manufacturer"], tags["com.android.model"])
+	}
+	if camera == "" {
+		camera = cameraName(tags["make"], tags["model"])
+	}
+
 	for _, field := range dateFields {
 		dateStr := field.getter()
 		if dateStr == "" {
@@ -287,10 +1075,10 @@ func (v *VideoExtractor) ExtractDate(path string) MetadataResult {
 		}
 
 		for _, format := range formats {
-			if date, err := time.Parse(format, dateStr); err == nil {
+			if date, floating, err := parseDate(format, dateStr); err == nil {
 				confidence := ConfidenceHigh
 				// Lower confidence for some container formats
-				ext := strings.ToLower(filepath.Ext(path))
+				ext := NormalizeExt(path)
 				if ext == ".avi" || ext == ".webm" {
 					confidence = ConfidenceMedium
 				}
@@ -300,6 +1088,8 @@ func (v *VideoExtractor) ExtractDate(path string) MetadataResult {
 					Confidence: confidence,
 					Source:     fmt.Sprintf("Video %s", field.source),
 					Duration:   time.Since(start),
+					Camera:     camera,
+					Floating:   floating,
 				}
 			}
 		}
@@ -310,10 +1100,11 @@ func (v *VideoExtractor) ExtractDate(path string) MetadataResult {
 		Source:     "ffprobe",
 		Error:      fmt.Errorf("no valid creation time found in video metadata"),
 		Duration:   time.Since(start),
+		Camera:     camera,
 	}
 }
 
-// PNGExtractor handles PNG files (limited metadata support)
+// PNGExtractor handles PNG files (eXIf, XMP, and "Creation Time" text chunks)
 type PNGExtractor struct{}
 
 func (p *PNGExtractor) Name() string {
@@ -324,13 +1115,25 @@ func (p *PNGExtractor) CanHandle(extension string) bool {
 	return extension == ".png"
 }
 
+// pngTextDateLayouts are the formats seen in PNG "Creation Time" text chunks
+// (the PNG spec suggests RFC 1123; many tools write ISO 8601 or EXIF-style dates instead)
+var pngTextDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006:01:02 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// pngXMPDate matches the creation date in an XMP packet, as an attribute or an element
+var pngXMPDate = regexp.MustCompile(`(?:photoshop:DateCreated|xmp:CreateDate|exif:DateTimeOriginal)(?:="|>)([0-9T:+\-.Z ]+)`)
+
 func (p *PNGExtractor) ExtractDate(path string) MetadataResult {
 	start := time.Now()
 
-	// PNG files rarely have reliable creation date metadata
-	// Most PNGs are screenshots, edited images, or generated content
-	// We'll still try to extract any available text chunks that might contain dates
-
+	// Most PNGs are screenshots, edited images, or generated content without a date,
+	// but phones and editors can embed EXIF (eXIf), XMP, or a "Creation Time" text chunk
 	f, err := os.Open(path)
 	if err != nil {
 		return MetadataResult{
@@ -342,17 +1145,188 @@ func (p *PNGExtractor) ExtractDate(path string) MetadataResult {
 	}
 	defer f.Close()
 
-	// For now, PNG extraction is minimal since most PNGs don't have reliable dates
-	// This is a placeholder for future enhancement with PNG chunk parsing
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(f, signature); err != nil || string(signature) != "\x89PNG\r\n\x1a\n" {
+		return MetadataResult{
+			Confidence: ConfidenceNone,
+			Source:     "PNG",
+			Error:      fmt.Errorf("not a PNG file"),
+			Duration:   time.Since(start),
+		}
+	}
+
+	// EXIF wins over text chunks, so keep reading after a text date in case eXIf follows
+	var textResult MetadataResult
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:8])
+		if chunkType == "IEND" {
+			break
+		}
+		if chunkType != "eXIf" && chunkType != "tEXt" && chunkType != "iTXt" || length > maxMetadataChunk {
+			// Skip image data, other chunks, and implausibly large metadata, plus the CRC
+			if _, err := f.Seek(length+4, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(f, data); err != nil {
+			break
+		}
+		data = data[:length]
 
+		if chunkType == "eXIf" {
+			if x, err := exif.Decode(bytes.NewReader(data)); err == nil {
+				if date, source, floating, ok := exifDate(x); ok {
+					return MetadataResult{
+						Date:       date,
+						Confidence: ConfidenceHigh,
+						Source:     "PNG " + source,
+						Duration:   time.Since(start),
+						Camera:     exifCamera(x),
+						Location:   exifLocation(x),
+						Floating:   floating,
+					}
+				}
+			}
+			continue
+		}
+		if textResult.Date.IsZero() {
+			if date, source, floating, ok := pngTextDate(chunkType, data); ok {
+				textResult = MetadataResult{Date: date, Confidence: ConfidenceMedium, Source: source, Floating: floating}
+			}
+		}
+	}
+
+	if !textResult.Date.IsZero() {
+		textResult.Duration = time.Since(start)
+		return textResult
+	}
 	return MetadataResult{
 		Confidence: ConfidenceNone,
 		Source:     "PNG",
-		Error:      fmt.Errorf("PNG date extraction not implemented (PNGs rarely have reliable creation dates)"),
+		Error:      fmt.Errorf("no date in PNG metadata"),
 		Duration:   time.Since(start),
 	}
 }
 
+// pngTextDate reads a date, and whether it is floating, from an uncompressed tEXt or iTXt chunk:
+// the "Creation Time" keyword, or the creation date inside an XMP packet (compressed text is not inflated)
+func pngTextDate(chunkType string, data []byte) (time.Time, string, bool, bool) {
+	keyword, text, found := bytes.Cut(data, []byte{0})
+	if !found {
+		return time.Time{}, "", false, false
+	}
+	if chunkType == "iTXt" {
+		// Compression flag, compression method, language tag, translated keyword, then the text
+		if len(text) < 2 || text[0] != 0 {
+			return time.Time{}, "", false, false
+		}
+		parts := bytes.SplitN(text[2:], []byte{0}, 3)
+		if len(parts) != 3 {
+			return time.Time{}, "", false, false
+		}
+		text = parts[2]
+	}
+
+	switch string(keyword) {
+	case "Creation Time":
+		value := strings.TrimSpace(string(text))
+		for _, layout := range pngTextDateLayouts {
+			if date, floating, err := parseDate(layout, value); err == nil {
+				return date, "PNG Creation Time", floating, true
+			}
+		}
+	case "XML:com.adobe.xmp":
+		if match := pngXMPDate.FindSubmatch(text); match != nil {
+			value := strings.TrimSpace(string(match[1]))
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+				if date, floating, err := parseDate(layout, value); err == nil {
+					return date, "PNG XMP", floating, true
+				}
+			}
+		}
+	}
+	return time.Time{}, "", false, false
+}
+
+// filenameDatePatterns are the file naming schemes with an embedded date, most specific first
+// Each pattern captures year, month, day and optionally hour, minute, second
+var filenameDatePatterns = []*regexp.Regexp{
+	// IMG_20210704_153000.jpg, VID_20210704_153000.mp4, PXL_20210704_153000123.jpg, 20210704_153000.jpg
+	regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})(\d{2})(\d{2})[_-](\d{2})(\d{2})(\d{2})`),
+	// Screenshot_2021-07-04-15-30-00.png, Screenshot 2021-07-04 at 15.30.00.png
+	regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})-(\d{2})-(\d{2})[ _-](?:at )?(\d{2})[.\-_](\d{2})[.\-_](\d{2})`),
+	// IMG-20211225-WA0001.jpg, VID-20211225-WA0001.mp4 (WhatsApp)
+	regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})(\d{2})(\d{2})-WA\d`),
+	// 2021-07-04.jpg, Birthday 2021-07-04 (2).jpg
+	regexp.MustCompile(`(?:^|\D)((?:19|20)\d{2})-(\d{2})-(\d{2})(?:\D|$)`),
+	// IMG_20210704.jpg, Screenshot_20210704.png
+	regexp.MustCompile(`^(?i:IMG|VID|PXL|PANO|MVIMG|Screenshot)[_-]((?:19|20)\d{2})(\d{2})(\d{2})(?:\D|$)`),
+}
+
+// FilenameExtractor reads a date embedded in the file name (phone cameras, WhatsApp, screenshots)
+type FilenameExtractor struct{}
+
+func (f *FilenameExtractor) Name() string {
+	return "Filename"
+}
+
+func (f *FilenameExtractor) CanHandle(extension string) bool {
+	return true // Any file can carry a date in its name
+}
+
+func (f *FilenameExtractor) ExtractDate(path string) MetadataResult {
+	start := time.Now()
+
+	if date, ok := dateFromFilename(filepath.Base(path)); ok {
+		return MetadataResult{
+			Date:       date,
+			Confidence: ConfidenceMedium,
+			Source:     "Filename",
+			Duration:   time.Since(start),
+			Floating:   true,
+		}
+	}
+	return MetadataResult{
+		Confidence: ConfidenceNone,
+		Source:     "Filename",
+		Error:      fmt.Errorf("no date pattern in file name"),
+		Duration:   time.Since(start),
+	}
+}
+
+// dateFromFilename returns the first valid date matched by filenameDatePatterns, in local time
+// like EXIF dates; impossible dates (month 13) and dates in the future are ignored
+func dateFromFilename(name string) (time.Time, bool) {
+	for _, pattern := range filenameDatePatterns {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		fields := make([]int, 6)
+		for i, group := range match[1:] {
+			fields[i], _ = strconv.Atoi(group)
+		}
+		date := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, time.Local)
+		// time.Date normalizes out-of-range values, so a changed field means the name wasn't a date
+		if date.Year() != fields[0] || int(date.Month()) != fields[1] || date.Day() != fields[2] ||
+			date.Hour() != fields[3] || date.Minute() != fields[4] || date.Second() != fields[5] {
+			continue
+		}
+		if date.After(time.Now().Add(24 * time.Hour)) {
+			continue
+		}
+		return date, true
+	}
+	return time.Time{}, false
+}
+
 // FilesystemExtractor provides filesystem modification time as fallback
 type FilesystemExtractor struct{}
 
@@ -384,3 +1358,39 @@ func (f *FilesystemExtractor) ExtractDate(path string) MetadataResult {
 		Duration:   time.Since(start),
 	}
 }
+
+// BirthtimeExtractor dates files by when they were created on disk (see fileBirthTime)
+// It ranks above mtime, which changes with every edit, but below any metadata in the file
+type BirthtimeExtractor struct{}
+
+func (b *BirthtimeExtractor) Name() string {
+	return "Birthtime"
+}
+
+func (b *BirthtimeExtractor) CanHandle(extension string) bool {
+	return true
+}
+
+func (b *BirthtimeExtractor) ExtractDate(path string) MetadataResult {
+	start := time.Now()
+
+	date, err := fileBirthTime(path)
+	if err != nil || date.IsZero() {
+		if err == nil {
+			err = errors.New("no birth time recorded")
+		}
+		return MetadataResult{
+			Confidence: ConfidenceNone,
+			Source:     "Filesystem birth time",
+			Error:      err,
+			Duration:   time.Since(start),
+		}
+	}
+
+	return MetadataResult{
+		Date:       date,
+		Confidence: ConfidenceLow,
+		Source:     "Filesystem birth time",
+		Duration:   time.Since(start),
+	}
+}
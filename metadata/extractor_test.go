@@ -2,8 +2,13 @@
 package metadata
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -19,7 +24,7 @@ func TestExtractorRegistry(t *testing.T) {
 	}
 
 	// Verify we have the expected extractors
-	expectedExtractors := []string{"EXIF", "Video", "PNG", "Filesystem"}
+	expectedExtractors := []string{"EXIF", "MP4", "Video", "PNG", "Filename", "Filesystem"}
 	if len(registry.extractors) != len(expectedExtractors) {
 		t.Errorf("Expected %d extractors, got %d", len(expectedExtractors), len(registry.extractors))
 	}
@@ -44,7 +49,17 @@ func TestEXIFExtractorCanHandle(t *testing.T) {
 		{".jpeg", true},
 		{".heic", true}, // Critical: HEIC support for iPhone photos
 		{".heif", true}, // HEIF support
+		{".cr2", true},  // Camera RAW formats
+		{".nef", true},
+		{".arw", true},
+		{".dng", true},
+		{".orf", true},
+		{".raf", true},
+		{".tif", true}, // TIFF and WebP carry EXIF too
+		{".tiff", true},
+		{".webp", true},
 		{".png", false},
+		{".gif", false},
 		{".mp4", false},
 		{".txt", false},
 	}
@@ -196,6 +211,141 @@ func TestExtractorRegistryWithFilesystemFallback(t *testing.T) {
 	}
 }
 
+// TestExtractorRegistryFor tests that a fixed date source only reads dates from that source
+func TestExtractorRegistryFor(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "IMG_20210704_153000.jpg")
+	if err := os.WriteFile(testFile, []byte("not really a jpeg"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	mtime := time.Date(2023, 4, 20, 14, 15, 30, 0, time.UTC)
+	if err := os.Chtimes(testFile, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+	fromName := time.Date(2021, 7, 4, 15, 30, 0, 0, time.Local)
+
+	testCases := []struct {
+		source   string
+		expected time.Time // Zero when the source has no date for the file
+	}{
+		{DateSourceFilename, fromName},
+		{DateSourceMtime, mtime},
+		{DateSourceEXIF, time.Time{}},
+		{DateSourceFFprobe, time.Time{}},
+	}
+	for _, tc := range testCases {
+		registry, err := NewExtractorRegistryFor(tc.source)
+		if err != nil {
+			t.Fatalf("NewExtractorRegistryFor(%q) failed: %v", tc.source, err)
+		}
+		result := registry.ExtractBestDate(testFile)
+		if tc.expected.IsZero() {
+			if result.Error == nil && !result.Date.IsZero() {
+				t.Errorf("%s: expected no date, got %v from %s", tc.source, result.Date, result.Source)
+			}
+			continue
+		}
+		if result.Error != nil || !result.Date.Equal(tc.expected) {
+			t.Errorf("%s: expected %v, got %v from %s (error %v)", tc.source, tc.expected, result.Date, result.Source, result.Error)
+		}
+	}
+
+	if _, err := NewExtractorRegistryFor("exif-first"); err == nil {
+		t.Error("Expected an error for an unknown date source")
+	}
+}
+
+// TestBirthtimeExtractor tests that birth time, where recorded, ignores a changed mtime
+func TestBirthtimeExtractor(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "photo.jpg")
+	created := time.Now()
+	if err := os.WriteFile(testFile, []byte("not really a jpeg"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	mtime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(testFile, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set file times: %v", err)
+	}
+
+	result := (&BirthtimeExtractor{}).ExtractDate(testFile)
+	if result.Error != nil {
+		if result.Confidence != ConfidenceNone || !result.Date.IsZero() {
+			t.Errorf("Expected no date with an error, got %v (%v)", result.Date, result.Confidence)
+		}
+		t.Skipf("Birth time not available here: %v", result.Error)
+	}
+	if result.Confidence != ConfidenceLow {
+		t.Errorf("Expected low confidence, got %v", result.Confidence)
+	}
+	if diff := result.Date.Sub(created); diff < -time.Minute || diff > time.Minute {
+		t.Errorf("Expected a birth time near %v, got %v", created, result.Date)
+	}
+}
+
+// TestDateFromFilename tests the embedded filename date patterns
+func TestDateFromFilename(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected time.Time
+		found    bool
+	}{
+		{"IMG_20210704_153000.jpg", time.Date(2021, 7, 4, 15, 30, 0, 0, time.Local), true},
+		{"PXL_20210704_153000123.jpg", time.Date(2021, 7, 4, 15, 30, 0, 0, time.Local), true},
+		{"VID-20211225-WA0001.mp4", time.Date(2021, 12, 25, 0, 0, 0, 0, time.Local), true},
+		{"Screenshot 2021-07-04 at 15.30.00.png", time.Date(2021, 7, 4, 15, 30, 0, 0, time.Local), true},
+		{"Screenshot_2021-07-04-15-30-00.png", time.Date(2021, 7, 4, 15, 30, 0, 0, time.Local), true},
+		{"Birthday 2021-07-04 (2).jpg", time.Date(2021, 7, 4, 0, 0, 0, 0, time.Local), true},
+		{"IMG_20210704.jpg", time.Date(2021, 7, 4, 0, 0, 0, 0, time.Local), true},
+		{"IMG_20211304_153000.jpg", time.Time{}, false}, // Month 13
+		{"IMG_0001.jpg", time.Time{}, false},
+		{"DSC12345678.jpg", time.Time{}, false},
+	}
+
+	for _, tc := range testCases {
+		date, found := dateFromFilename(tc.name)
+		if found != tc.found {
+			t.Errorf("%s: expected found=%v, got %v", tc.name, tc.found, found)
+			continue
+		}
+		if found && !date.Equal(tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, date)
+		}
+	}
+}
+
+// TestInTimezone tests that instants are converted while floating dates keep their clock time
+func TestInTimezone(t *testing.T) {
+	tokyo := time.FixedZone("Tokyo", 9*3600)
+	// 23:30 UTC on 31 January is already 1 February in Tokyo
+	instant := time.Date(2023, 1, 31, 23, 30, 0, 0, time.UTC)
+
+	converted := InTimezone(instant, false, tokyo)
+	if !converted.Equal(instant) || converted.Month() != time.February || converted.Day() != 1 {
+		t.Errorf("instant: expected 2023-02-01 08:30 in Tokyo, got %v", converted)
+	}
+
+	floating := InTimezone(instant, true, tokyo)
+	if floating.Month() != time.January || floating.Day() != 31 || floating.Hour() != 23 || floating.Location() != tokyo {
+		t.Errorf("floating: expected 2023-01-31 23:30 in Tokyo, got %v", floating)
+	}
+
+	if got := InTimezone(time.Time{}, false, tokyo); !got.IsZero() {
+		t.Errorf("zero date: expected it to stay zero, got %v", got)
+	}
+	if got := InTimezone(instant, false, nil); got != instant {
+		t.Errorf("nil zone: expected the date unchanged, got %v", got)
+	}
+
+	registry, err := NewExtractorRegistryFor(DateSourceFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.SetTimezone(tokyo)
+	result := registry.ExtractBestDate("IMG_20230131_233000.jpg")
+	if !result.Floating || result.Date.Day() != 31 || result.Date.Hour() != 23 || result.Date.Location() != tokyo {
+		t.Errorf("filename date: expected 2023-01-31 23:30 in Tokyo, got %v (floating %v)", result.Date, result.Floating)
+	}
+}
+
 // TestConfidenceString tests confidence level string representation
 func TestConfidenceString(t *testing.T) {
 	testCases := []struct {
@@ -292,6 +442,35 @@ func TestEXIFExtractorWithInvalidFile(t *testing.T) {
 	}
 }
 
+// TestVideoExtractorTimeout tests that a hanging ffprobe is killed and the file reported as timed out
+func TestVideoExtractorTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffprobe is a shell script")
+	}
+	binDir := t.TempDir()
+	script := "#!/bin/sh\nexec sleep 10\n"
+	if err := os.WriteFile(filepath.Join(binDir, "ffprobe"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create fake ffprobe: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	testFile := filepath.Join(t.TempDir(), "corrupt.mkv")
+	if err := os.WriteFile(testFile, []byte("not a real video"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	registry := NewExtractorRegistry()
+	registry.SetFFprobeTimeout(200 * time.Millisecond)
+	start := time.Now()
+	result := registry.ExtractBestDate(testFile)
+	if !errors.Is(result.Error, ErrTimeout) {
+		t.Errorf("Expected a timeout error, got %v (date %v from %s)", result.Error, result.Date, result.Source)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ffprobe was not stopped in time: took %v", elapsed)
+	}
+}
+
 // TestVideoExtractorWithoutFFprobe tests video extractor when ffprobe is not available
 func TestVideoExtractorWithoutFFprobe(t *testing.T) {
 	extractor := &VideoExtractor{}
@@ -317,3 +496,553 @@ func TestVideoExtractorWithoutFFprobe(t *testing.T) {
 		t.Error("Duration should be measured even on error")
 	}
 }
+
+// buildTIFFWithDateTime builds a minimal little-endian TIFF whose IFD0 holds a DateTime tag
+func buildTIFFWithDateTime(magic string, date string) []byte {
+	var buf bytes.Buffer
+	value := append([]byte(date), 0)
+
+	buf.WriteString(magic)                                 // Byte order + magic number
+	binary.Write(&buf, binary.LittleEndian, uint32(8))     // Offset to IFD0
+	binary.Write(&buf, binary.LittleEndian, uint16(1))     // One tag
+	binary.Write(&buf, binary.LittleEndian, uint16(0x132)) // DateTime
+	binary.Write(&buf, binary.LittleEndian, uint16(2))     // ASCII
+	binary.Write(&buf, binary.LittleEndian, uint32(len(value)))
+	binary.Write(&buf, binary.LittleEndian, uint32(26)) // Value offset (after IFD)
+	binary.Write(&buf, binary.LittleEndian, uint32(0))  // No next IFD
+	buf.Write(value)
+	return buf.Bytes()
+}
+
+// TestEXIFExtractorRAWFormats tests date extraction from TIFF-based, ORF, RAF, WebP, and HEIC files
+func TestEXIFExtractorRAWFormats(t *testing.T) {
+	extractor := &EXIFExtractor{}
+	tempDir := t.TempDir()
+	expected := time.Date(2019, 7, 14, 10, 20, 30, 0, time.UTC)
+
+	tiffData := buildTIFFWithDateTime("II*\x00", "2019:07:14 10:20:30")
+
+	// RAF: 92-byte header pointing at an embedded JPEG whose APP1 segment holds the EXIF block
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(len(tiffData)+8))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiffData)
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	raf := make([]byte, 92)
+	copy(raf, "FUJIFILMCCD-RAW 0201")
+	binary.BigEndian.PutUint32(raf[84:88], 92)
+	binary.BigEndian.PutUint32(raf[88:92], uint32(jpeg.Len()))
+	raf = append(raf, jpeg.Bytes()...)
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"photo.dng", tiffData},
+		{"photo.nef", tiffData},
+		{"photo.orf", buildTIFFWithDateTime("IIRO", "2019:07:14 10:20:30")},
+		{"photo.raf", raf},
+		{"scan.tiff", tiffData},
+		{"photo.webp", buildWebPWithEXIF(tiffData)},
+		{"IMG_0001.heic", buildHEICWithEXIF(tiffData)},
+	}
+
+	for _, tc := range testCases {
+		testFile := filepath.Join(tempDir, tc.name)
+		if err := os.WriteFile(testFile, tc.data, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		result := extractor.ExtractDate(testFile)
+		if result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, result.Error)
+			continue
+		}
+		if result.Confidence != ConfidenceHigh {
+			t.Errorf("%s: expected high confidence, got %v", tc.name, result.Confidence)
+		}
+		if !result.Date.Equal(expected) {
+			t.Errorf("%s: expected date %v, got %v", tc.name, expected, result.Date)
+		}
+	}
+}
+
+// buildTIFFWithGPS builds a little-endian TIFF with a DateTime tag and a GPS IFD holding the
+// given degrees/minutes/seconds (as tenths of a second) and N/S, E/W references
+func buildTIFFWithGPS(date string, latRef string, lat [3]uint32, longRef string, long [3]uint32) []byte {
+	var buf bytes.Buffer
+	value := append([]byte(date), 0)
+	le := binary.LittleEndian
+
+	// Offsets: header 8, IFD0 (2 tags) 30, date 20, GPS IFD (4 tags) 54, two rationals of 24
+	const ifd0, dateAt, gpsAt, latAt, longAt = 8, 38, 58, 112, 136
+	writeTag := func(tag, kind uint16, count, value uint32) {
+		binary.Write(&buf, le, tag)
+		binary.Write(&buf, le, kind)
+		binary.Write(&buf, le, count)
+		binary.Write(&buf, le, value)
+	}
+	ref := func(s string) uint32 { return uint32(s[0]) } // One ASCII char + NUL, stored inline
+
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, le, uint32(ifd0))
+	binary.Write(&buf, le, uint16(2))
+	writeTag(0x132, 2, uint32(len(value)), dateAt) // DateTime
+	writeTag(0x8825, 4, 1, gpsAt)                  // GPS IFD pointer
+	binary.Write(&buf, le, uint32(0))
+	buf.Write(value)
+
+	binary.Write(&buf, le, uint16(4))
+	writeTag(1, 2, 2, ref(latRef))
+	writeTag(2, 5, 3, latAt)
+	writeTag(3, 2, 2, ref(longRef))
+	writeTag(4, 5, 3, longAt)
+	binary.Write(&buf, le, uint32(0))
+	for _, dms := range [][3]uint32{lat, long} {
+		binary.Write(&buf, le, []uint32{dms[0], 1, dms[1], 1, dms[2], 10})
+	}
+	return buf.Bytes()
+}
+
+// TestEXIFExtractorLocation tests reading GPS coordinates, including south and west signs
+func TestEXIFExtractorLocation(t *testing.T) {
+	extractor := &EXIFExtractor{}
+	tempDir := t.TempDir()
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		expectFound bool
+		lat, long   float64
+	}{
+		{"paris.dng", buildTIFFWithGPS("2019:07:14 10:20:30", "N", [3]uint32{48, 51, 296}, "E", [3]uint32{2, 17, 402}), true, 48.858222, 2.294500},
+		{"sydney.dng", buildTIFFWithGPS("2019:07:14 10:20:30", "S", [3]uint32{33, 52, 48}, "E", [3]uint32{151, 12, 360}), true, -33.868000, 151.210000},
+		{"nofix.dng", buildTIFFWithGPS("2019:07:14 10:20:30", "N", [3]uint32{0, 0, 0}, "W", [3]uint32{0, 0, 0}), false, 0, 0},
+		{"nogps.dng", buildTIFFWithDateTime("II*\x00", "2019:07:14 10:20:30"), false, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		testFile := filepath.Join(tempDir, tc.name)
+		if err := os.WriteFile(testFile, tc.data, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		result := extractor.ExtractDate(testFile)
+		if result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, result.Error)
+			continue
+		}
+		if !tc.expectFound {
+			if result.Location != nil {
+				t.Errorf("%s: expected no location, got %+v", tc.name, *result.Location)
+			}
+			continue
+		}
+		if result.Location == nil {
+			t.Errorf("%s: expected a location, got none", tc.name)
+			continue
+		}
+		if math.Abs(result.Location.Latitude-tc.lat) > 1e-6 || math.Abs(result.Location.Longitude-tc.long) > 1e-6 {
+			t.Errorf("%s: expected %.6f,%.6f, got %.6f,%.6f", tc.name, tc.lat, tc.long, result.Location.Latitude, result.Location.Longitude)
+		}
+	}
+}
+
+// buildWebPWithEXIF builds a WebP RIFF container with an empty image chunk and an EXIF chunk
+func buildWebPWithEXIF(tiffData []byte) []byte {
+	var chunks bytes.Buffer
+	chunks.WriteString("WEBP")
+	chunks.WriteString("VP8L")
+	binary.Write(&chunks, binary.LittleEndian, uint32(3))
+	chunks.Write([]byte{0, 0, 0, 0}) // Odd-sized chunk plus its padding byte
+	chunks.WriteString("EXIF")
+	binary.Write(&chunks, binary.LittleEndian, uint32(len(tiffData)))
+	chunks.Write(tiffData)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(chunks.Len()))
+	buf.Write(chunks.Bytes())
+	return buf.Bytes()
+}
+
+// isoBox encodes one ISO base media box; a full box's version and flags are part of payload
+func isoBox(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(8+len(body)))
+	buf.WriteString(boxType)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// buildHEICWithEXIF builds a HEIC laid out like an iPhone's: a meta box whose iinf lists an image
+// item and an Exif item (infe version 2), and an iloc (version 1) pointing both into mdat
+func buildHEICWithEXIF(tiffData []byte) []byte {
+	image := []byte("not really HEVC")
+	exifItem := append([]byte{0, 0, 0, 6}, "Exif\x00\x00"...) // TIFF header offset, then the APP1 prefix
+	exifItem = append(exifItem, tiffData...)
+
+	infe := func(id uint16, itemType string) []byte {
+		payload := []byte{2, 0, 0, 0}
+		payload = binary.BigEndian.AppendUint16(payload, id)
+		payload = append(payload, 0, 0) // Protection index
+		payload = append(payload, itemType...)
+		return isoBox("infe", append(payload, 0)) // Empty item name
+	}
+	meta := func(mdatStart uint32) []byte {
+		iloc := []byte{1, 0, 0, 0, 0x44, 0x00} // Version 1, 4-byte offsets and lengths
+		iloc = binary.BigEndian.AppendUint16(iloc, 2)
+		for i, extent := range [][]uint32{{mdatStart, uint32(len(image))}, {mdatStart + uint32(len(image)), uint32(len(exifItem))}} {
+			iloc = binary.BigEndian.AppendUint16(iloc, uint16(i+1))
+			iloc = append(iloc, 0, 0, 0, 0) // Construction method 0, data reference 0
+			iloc = binary.BigEndian.AppendUint16(iloc, 1)
+			iloc = binary.BigEndian.AppendUint32(iloc, extent[0])
+			iloc = binary.BigEndian.AppendUint32(iloc, extent[1])
+		}
+		return isoBox("meta", []byte{0, 0, 0, 0},
+			isoBox("hdlr", make([]byte, 8), []byte("pict"), make([]byte, 13)),
+			isoBox("pitm", []byte{0, 0, 0, 0, 0, 1}),
+			isoBox("iinf", []byte{0, 0, 0, 0, 0, 2}, infe(1, "hvc1"), infe(2, "Exif")),
+			isoBox("iloc", iloc))
+	}
+
+	ftyp := isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	mdatStart := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(mdatStart), isoBox("mdat", image, exifItem)}, nil)
+}
+
+// buildTIFFWithMakerNote builds a big-endian TIFF with a DateTime tag and an EXIF IFD holding
+// the given maker note, as iPhones write it
+func buildTIFFWithMakerNote(date string, note []byte) []byte {
+	var buf bytes.Buffer
+	value := append([]byte(date), 0)
+	be := binary.BigEndian
+
+	// Offsets: header 8, IFD0 (2 tags) 30, date 20, EXIF IFD (1 tag) 18, then the maker note
+	const ifd0, dateAt, exifAt, noteAt = 8, 38, 58, 76
+	buf.WriteString("MM\x00*")
+	binary.Write(&buf, be, uint32(ifd0))
+	binary.Write(&buf, be, uint16(2))
+	binary.Write(&buf, be, []uint16{0x132, 2}) // DateTime, ASCII
+	binary.Write(&buf, be, []uint32{uint32(len(value)), dateAt})
+	binary.Write(&buf, be, []uint16{0x8769, 4}) // EXIF IFD pointer, LONG
+	binary.Write(&buf, be, []uint32{1, exifAt})
+	binary.Write(&buf, be, uint32(0))
+	buf.Write(value)
+	binary.Write(&buf, be, uint16(1))
+	binary.Write(&buf, be, []uint16{0x927c, 7}) // MakerNote, UNDEFINED
+	binary.Write(&buf, be, []uint32{uint32(len(note)), noteAt})
+	binary.Write(&buf, be, uint32(0))
+	buf.Write(note)
+	return buf.Bytes()
+}
+
+// TestEXIFExtractorHEICBurst tests that a burst frame shot as HEIC gets its burst identifier
+func TestEXIFExtractorHEICBurst(t *testing.T) {
+	const burst = "6B0B2E2E-7D5F-4C43-9C3A-4E7C1A2B3C4D"
+	tiffData := buildTIFFWithMakerNote("2019:07:14 10:20:30", buildAppleMakerNote(binary.BigEndian, burst))
+	testFile := filepath.Join(t.TempDir(), "IMG_0001.HEIC")
+	if err := os.WriteFile(testFile, buildHEICWithEXIF(tiffData), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result := (&EXIFExtractor{}).ExtractDate(testFile)
+	if result.Error != nil {
+		t.Fatalf("unexpected error: %v", result.Error)
+	}
+	if expected := time.Date(2019, 7, 14, 10, 20, 30, 0, time.UTC); !result.Date.Equal(expected) {
+		t.Errorf("expected date %v, got %v", expected, result.Date)
+	}
+	if result.BurstID != burst {
+		t.Errorf("expected burst %q, got %q", burst, result.BurstID)
+	}
+}
+
+// buildPNGChunk encodes one PNG chunk (the CRC isn't checked by the extractor, so it is left zero)
+func buildPNGChunk(chunkType string, data []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(chunkType)
+	buf.Write(data)
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+// TestPNGExtractorMetadata tests PNG dates from eXIf, text, and XMP chunks
+func TestPNGExtractorMetadata(t *testing.T) {
+	extractor := &PNGExtractor{}
+	tempDir := t.TempDir()
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	idat := buildPNGChunk("IDAT", []byte{1, 2, 3})
+	iend := buildPNGChunk("IEND", nil)
+	xmp := `<x:xmpmeta><rdf:Description photoshop:DateCreated="2020-02-03T04:05:06"/></x:xmpmeta>`
+
+	testCases := []struct {
+		name       string
+		chunks     [][]byte
+		expected   time.Time
+		confidence Confidence
+	}{
+		{"exif.png", [][]byte{idat, buildPNGChunk("eXIf", buildTIFFWithDateTime("II*\x00", "2019:07:14 10:20:30")), iend},
+			time.Date(2019, 7, 14, 10, 20, 30, 0, time.UTC), ConfidenceHigh},
+		{"text.png", [][]byte{buildPNGChunk("tEXt", []byte("Creation Time\x00Mon, 02 Jan 2006 15:04:05 +0000")), idat, iend},
+			time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), ConfidenceMedium},
+		{"xmp.png", [][]byte{buildPNGChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmp)), idat, iend},
+			time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC), ConfidenceMedium},
+		{"plain.png", [][]byte{idat, iend}, time.Time{}, ConfidenceNone},
+		{"oversized.png", [][]byte{[]byte("\xff\xff\xff\xf0eXIf\x01\x02\x03")}, time.Time{}, ConfidenceNone},
+	}
+
+	for _, tc := range testCases {
+		data := append([]byte{}, signature...)
+		for _, chunk := range tc.chunks {
+			data = append(data, chunk...)
+		}
+		testFile := filepath.Join(tempDir, tc.name)
+		if err := os.WriteFile(testFile, data, 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		result := extractor.ExtractDate(testFile)
+		if result.Confidence != tc.confidence {
+			t.Errorf("%s: expected %v confidence, got %v (%v)", tc.name, tc.confidence, result.Confidence, result.Error)
+			continue
+		}
+		if !result.Date.Equal(tc.expected) {
+			t.Errorf("%s: expected date %v, got %v", tc.name, tc.expected, result.Date)
+		}
+	}
+}
+
+// buildAtom wraps a payload in an MP4 atom header
+func buildAtom(atomType string, payload []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)+8))
+	buf.WriteString(atomType)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// TestMP4ExtractorMvhd tests reading the movie creation time without ffprobe
+func TestMP4ExtractorMvhd(t *testing.T) {
+	extractor := &MP4Extractor{}
+	tempDir := t.TempDir()
+	expected := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	seconds := uint64(expected.Sub(mp4Epoch) / time.Second)
+
+	mvhdV0 := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhdV0[4:8], uint32(seconds))
+
+	mvhdV1 := make([]byte, 112)
+	mvhdV1[0] = 1
+	binary.BigEndian.PutUint64(mvhdV1[4:12], seconds)
+
+	ftyp := buildAtom("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	mdat := buildAtom("mdat", make([]byte, 64))
+
+	testCases := []struct {
+		name    string
+		data    [][]byte
+		wantErr bool
+	}{
+		{"v0.mp4", [][]byte{ftyp, buildAtom("moov", buildAtom("mvhd", mvhdV0)), mdat}, false},
+		{"v1.mov", [][]byte{ftyp, buildAtom("moov", buildAtom("mvhd", mvhdV1)), mdat}, false},
+		{"moov-last.mp4", [][]byte{ftyp, mdat, buildAtom("moov", append(buildAtom("udta", nil), buildAtom("mvhd", mvhdV0)...))}, false},
+		{"unset.mp4", [][]byte{ftyp, buildAtom("moov", buildAtom("mvhd", make([]byte, 100))), mdat}, true},
+		{"no-moov.mp4", [][]byte{ftyp, mdat}, true},
+		{"garbage.mp4", [][]byte{[]byte("not a real video")}, true},
+	}
+
+	for _, tc := range testCases {
+		testFile := filepath.Join(tempDir, tc.name)
+		if err := os.WriteFile(testFile, bytes.Join(tc.data, nil), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+
+		result := extractor.ExtractDate(testFile)
+		if tc.wantErr {
+			if result.Error == nil || result.Confidence != ConfidenceNone {
+				t.Errorf("%s: expected an error, got date %v", tc.name, result.Date)
+			}
+			continue
+		}
+		if result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, result.Error)
+			continue
+		}
+		if result.Confidence != ConfidenceHigh {
+			t.Errorf("%s: expected high confidence, got %v", tc.name, result.Confidence)
+		}
+		if !result.Date.Equal(expected) {
+			t.Errorf("%s: expected date %v, got %v", tc.name, expected, result.Date)
+		}
+	}
+}
+
+// TestCameraName tests joining EXIF/QuickTime make and model values
+func TestCameraName(t *testing.T) {
+	testCases := []struct {
+		maker, model, expected string
+	}{
+		{"Apple", "iPhone 12", "Apple iPhone 12"},
+		{"Canon", "Canon EOS 5D Mark IV", "Canon EOS 5D Mark IV"},
+		{"NIKON CORPORATION", "NIKON D750", "NIKON CORPORATION NIKON D750"},
+		{"SONY\x00\x00", " ILCE-7M3 ", "SONY ILCE-7M3"},
+		{"", "Pixel 7", "Pixel 7"},
+		{"GoPro", "", "GoPro"},
+		{"", "", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := cameraName(tc.maker, tc.model); got != tc.expected {
+			t.Errorf("cameraName(%q, %q) = %q, expected %q", tc.maker, tc.model, got, tc.expected)
+		}
+	}
+}
+
+// buildAppleMakerNote builds an iPhone maker note whose IFD holds a version tag and, when
+// burstID is set, a BurstUUID stored after the IFD
+func buildAppleMakerNote(order binary.ByteOrder, burstID string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(appleMakerNoteHeader + "\x00\x01")
+	if order == binary.BigEndian {
+		buf.WriteString("MM")
+	} else {
+		buf.WriteString("II")
+	}
+	tags := uint16(1)
+	if burstID != "" {
+		tags = 2
+	}
+	binary.Write(&buf, order, tags)
+	binary.Write(&buf, order, []uint16{0x0001, 9}) // MakerNoteVersion, SLONG
+	binary.Write(&buf, order, []uint32{1, 14})
+	if burstID != "" {
+		value := append([]byte(burstID), 0)
+		offset := uint32(16 + 2*12 + 4)
+		binary.Write(&buf, order, []uint16{appleBurstUUIDTag, 2})
+		binary.Write(&buf, order, []uint32{uint32(len(value)), offset})
+		binary.Write(&buf, order, uint32(0)) // No next IFD
+		buf.Write(value)
+	} else {
+		binary.Write(&buf, order, uint32(0))
+	}
+	return buf.Bytes()
+}
+
+// TestAppleMakerNoteString tests reading the burst identifier from iPhone maker notes
+func TestAppleMakerNoteString(t *testing.T) {
+	const burst = "6B0B2E2E-7D5F-4C43-9C3A-4E7C1A2B3C4D"
+	note := buildAppleMakerNote(binary.BigEndian, burst)
+
+	testCases := []struct {
+		name     string
+		note     []byte
+		expected string
+	}{
+		{"big-endian burst", note, burst},
+		{"little-endian burst", buildAppleMakerNote(binary.LittleEndian, burst), burst},
+		{"not a burst", buildAppleMakerNote(binary.BigEndian, ""), ""},
+		{"other maker", append([]byte("Nikon\x00\x02\x10\x00\x00"), note[10:]...), ""},
+		{"truncated", note[:len(note)-10], ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tc := range testCases {
+		if got := appleMakerNoteString(tc.note, appleBurstUUIDTag); got != tc.expected {
+			t.Errorf("%s: got %q, expected %q", tc.name, got, tc.expected)
+		}
+	}
+}
+
+// TestNormalizeExt tests extension matching across case, double extensions, and odd names
+func TestNormalizeExt(t *testing.T) {
+	testCases := []struct {
+		path, expected string
+	}{
+		{"IMG_0001.jpg", ".jpg"},
+		{"IMG_0001.JPG", ".jpg"},
+		{"clip.MP4", ".mp4"},
+		{"clip.Mp4", ".mp4"},
+		{"photo.JPG.jpg", ".jpg"},
+		{"photo.jpg.MOV", ".mov"},
+		{"IMG_0001.JPG.xmp", ".xmp"},
+		{"clip.MP4 ", ".mp4"},
+		{"IMG_0001.JPG.", ".jpg"},
+		{filepath.Join("DCIM", "100APPLE", "IMG_0001.HEIC"), ".heic"},
+		{filepath.Join("some.folder", "README"), ""},
+		{"._IMG_0001.JPG", ""},
+		{filepath.Join("DCIM", "._clip.MOV"), ""},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := NormalizeExt(tc.path); got != tc.expected {
+			t.Errorf("NormalizeExt(%q) = %q, expected %q", tc.path, got, tc.expected)
+		}
+	}
+}
+
+// TestMP4ExtractorCamera tests reading the recording device from Apple and QuickTime metadata
+func TestMP4ExtractorCamera(t *testing.T) {
+	extractor := &MP4Extractor{}
+	tempDir := t.TempDir()
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:8], uint32(time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC).Sub(mp4Epoch)/time.Second))
+
+	key := func(name string) []byte {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, uint32(len(name)+8))
+		buf.WriteString("mdta")
+		buf.WriteString(name)
+		return buf.Bytes()
+	}
+	item := func(index uint32, value string) []byte {
+		data := append(make([]byte, 8), value...)
+		binary.BigEndian.PutUint32(data[0:4], 1) // UTF-8
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)+16))
+		binary.Write(&buf, binary.BigEndian, index)
+		buf.Write(buildAtom("data", data))
+		return buf.Bytes()
+	}
+	keys := buildAtom("keys", bytes.Join([][]byte{{0, 0, 0, 0, 0, 0, 0, 3},
+		key("com.apple.quicktime.location.ISO6709"), key("com.apple.quicktime.make"), key("com.apple.quicktime.model")}, nil))
+	ilst := buildAtom("ilst", bytes.Join([][]byte{item(2, "Apple"), item(3, "iPhone 12"), item(1, "+52.0+004.0/")}, nil))
+	appleMeta := buildAtom("meta", bytes.Join([][]byte{buildAtom("hdlr", make([]byte, 24)), keys, ilst}, nil))
+
+	udtaText := func(atomType, value string) []byte {
+		data := make([]byte, 4, 4+len(value))
+		binary.BigEndian.PutUint16(data[0:2], uint16(len(value)))
+		return buildAtom(atomType, append(data, value...))
+	}
+	udta := buildAtom("udta", append(udtaText("\xa9mak", "Panasonic"), udtaText("\xa9mod", "DC-GH5")...))
+
+	testCases := []struct {
+		name     string
+		moov     []byte
+		expected string
+	}{
+		{"apple.mov", buildAtom("moov", append(buildAtom("mvhd", mvhd), appleMeta...)), "Apple iPhone 12"},
+		{"udta.mp4", buildAtom("moov", append(udta, buildAtom("mvhd", mvhd)...)), "Panasonic DC-GH5"},
+		{"none.mp4", buildAtom("moov", buildAtom("mvhd", mvhd)), ""},
+	}
+
+	for _, tc := range testCases {
+		testFile := filepath.Join(tempDir, tc.name)
+		if err := os.WriteFile(testFile, append(buildAtom("ftyp", []byte("qt  ")), tc.moov...), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		result := extractor.ExtractDate(testFile)
+		if result.Error != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, result.Error)
+		}
+		if result.Camera != tc.expected {
+			t.Errorf("%s: expected camera %q, got %q", tc.name, tc.expected, result.Camera)
+		}
+	}
+}
@@ -2,13 +2,16 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"time"
 
+	"backupbozo/engine"
+
 	"github.com/fatih/color"
 	"github.com/manifoldco/promptui"
 	"github.com/sqweek/dialog"
@@ -30,6 +33,65 @@ func printBanner() {
 	color.New(color.FgBlack, color.Bold).Println(banner)
 }
 
+// confirmThreshold is the number of files to copy above which the user must confirm the run
+// (--confirm-threshold); 0 never asks
+var confirmThreshold int
+
+// defaultInteractiveConfirmThreshold applies in interactive mode unless --confirm-threshold is given
+const defaultInteractiveConfirmThreshold = 1000
+
+// confirmLargeCopy asks before copying more files than confirmThreshold, showing where they would go
+// Without a terminal to ask on, the answer is no: an unattended run never copies past the threshold
+func confirmLargeCopy(files int, totalSize int64, srcDir, destDir string) bool {
+	fmt.Println()
+	color.New(color.FgYellow, color.Bold).Printf("⚠️  About to copy %d files (%s)\n", files, engine.FormatFileSize(totalSize))
+	color.New(color.FgYellow).Printf("   From: %s\n   To:   %s\n", srcDir, destDir)
+
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		color.New(color.FgRed).Printf("   More than --confirm-threshold %d files and no terminal to confirm on\n", confirmThreshold)
+		return false
+	}
+	prompt := promptui.Select{
+		Label: fmt.Sprintf("Copy %d files?", files),
+		Items: []string{"Yes, copy them", "No, cancel"},
+	}
+	_, answer, err := prompt.Run()
+	if err == promptui.ErrInterrupt {
+		color.New(color.FgRed, color.Bold).Println("\nInterrupted during prompt. Exiting cleanly.")
+		os.Exit(130)
+	} else if err != nil {
+		// e.g. stdin closed; an unanswered question is a no
+		color.New(color.FgRed).Printf("   Could not ask for confirmation: %v\n", err)
+		return false
+	}
+	return answer == "Yes, copy them"
+}
+
+// openReport opens the HTML report in the system's default browser (--report-open)
+// Scheduled and piped runs have nobody to look at it, so it only happens from a terminal
+func openReport(reportPath string) {
+	if !engine.StdoutIsTerminal() {
+		return
+	}
+	if _, err := os.Stat(reportPath); err != nil {
+		return // No report was written (e.g. nothing to back up)
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", reportPath)
+	case "windows":
+		// start is a cmd builtin; the empty argument is the window title
+		cmd = exec.Command("cmd", "/c", "start", "", reportPath)
+	default:
+		cmd = exec.Command("xdg-open", reportPath)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Warning: Could not open report: %v", err)
+	}
+}
+
 // isGUIAvailable checks if GUI toolkit is available without showing errors
 func isGUIAvailable() bool {
 	defer func() {
@@ -165,8 +227,9 @@ func interactivePrompt(useGUI bool) (string, string, bool) {
 	var hashCount int
 
 	if info, err := os.Stat(dbPath); err == nil && !info.IsDir() {
-		if db, err := sql.Open("sqlite", dbPath); err == nil {
-			lastBackupTime, err = getLastBackupTime(db)
+		if db, err := engine.OpenDatabaseReadOnly(dbPath); err == nil {
+			// The latest complete backup of this source, whatever it left out
+			lastBackupTime, err = engine.SourceBackupTime(db, srcDir)
 
 			// Get count of existing hashes in database
 			var count int
@@ -193,12 +256,12 @@ func interactivePrompt(useGUI bool) (string, string, bool) {
 
 				fmt.Println()
 				color.New(color.FgCyan, color.Bold).Println("📁 Backup Status")
-				color.New(color.FgGreen).Printf("   Last backup: %s (%s)\n", agoStr, lastBackupTime.Format("2006-01-02 15:04:05"))
+				color.New(color.FgGreen).Printf("   Last backup of this source: %s (%s)\n", agoStr, lastBackupTime.Format("2006-01-02 15:04:05"))
 				color.New(color.FgBlue).Printf("   Database contains: %d unique file hashes\n", hashCount)
 			} else {
 				fmt.Println()
 				color.New(color.FgCyan, color.Bold).Println("📁 Backup Status")
-				color.New(color.FgYellow).Println("   No previous backup found")
+				color.New(color.FgYellow).Println("   No previous backup of this source found")
 				if hashCount > 0 {
 					color.New(color.FgBlue).Printf("   Database contains: %d unique file hashes\n", hashCount)
 				}